@@ -5,7 +5,18 @@ resource "google_pubsub_topic" "topic" {
   message_retention_duration = "600s"
 }
 
+locals {
+  aggregated = var.organization_id != null || var.folder_id != null
+  writer_identity = coalesce(
+    one(google_logging_project_sink.log_sink[*].writer_identity),
+    one(google_logging_organization_sink.log_sink[*].writer_identity),
+    one(google_logging_folder_sink.log_sink[*].writer_identity),
+  )
+}
+
 resource "google_logging_project_sink" "log_sink" {
+  count = local.aggregated ? 0 : 1
+
   name    = var.log_sink_name
   project = var.project
 
@@ -15,12 +26,34 @@ resource "google_logging_project_sink" "log_sink" {
   unique_writer_identity = true
 }
 
+resource "google_logging_organization_sink" "log_sink" {
+  count = var.organization_id != null ? 1 : 0
+
+  name   = var.log_sink_name
+  org_id = var.organization_id
+
+  destination      = "pubsub.googleapis.com/${google_pubsub_topic.topic.id}"
+  filter           = var.log_sink_filter
+  include_children = true
+}
+
+resource "google_logging_folder_sink" "log_sink" {
+  count = var.organization_id == null && var.folder_id != null ? 1 : 0
+
+  name   = var.log_sink_name
+  folder = var.folder_id
+
+  destination      = "pubsub.googleapis.com/${google_pubsub_topic.topic.id}"
+  filter           = var.log_sink_filter
+  include_children = true
+}
+
 resource "google_pubsub_topic_iam_binding" "binding" {
   project = var.project
   topic   = google_pubsub_topic.topic.name
   role    = "roles/pubsub.publisher"
   members = [
-    google_logging_project_sink.log_sink.writer_identity,
+    local.writer_identity,
   ]
 }
 
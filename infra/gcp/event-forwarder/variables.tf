@@ -22,3 +22,15 @@ variable "log_sink_filter" {
   description = "Filter for the log sink"
   type        = string
 }
+
+variable "organization_id" {
+  description = "If set, events are forwarded from an aggregated sink covering every project in this organization"
+  type        = string
+  default     = null
+}
+
+variable "folder_id" {
+  description = "If set, events are forwarded from an aggregated sink covering every project in this folder. Ignored if organization_id is set"
+  type        = string
+  default     = null
+}
@@ -21,29 +21,81 @@
 
 locals {
   service_account_member = "serviceAccount:${var.project}.svc.id.goog[${var.kubernetes_service_account_namespace}/${var.kubernetes_service_account_name}]"
+  tracked_project_roles  = concat(["roles/compute.viewer", "roles/browser"], var.cloud_monitoring_enabled ? ["roles/monitoring.metricWriter"] : [])
+  creation_label_filter  = length(var.cluster_label_keys) > 0 ? " AND (${join(" OR ", [for k in var.cluster_label_keys : "protoPayload.request.labels.key=\"${k}\""])})" : ""
+  deletion_methods       = "\"v1.compute.instances.delete\" OR \"beta.compute.instances.delete\""
+  lifecycle_methods      = "\"compute.instances.start\" OR \"v1.compute.instances.start\" OR \"beta.compute.instances.start\" OR \"compute.instances.stop\" OR \"v1.compute.instances.stop\" OR \"beta.compute.instances.stop\""
+  termination_methods    = "\"compute.instances.preempted\" OR \"compute.instances.hostError\" OR \"compute.instances.automaticRestart\" OR \"compute.instances.terminateOnHostMaintenance\" OR \"compute.instances.maxRunDurationReached\""
+}
+
+module "compute_events" {
+  source = "./event-forwarder"
+  count  = var.unified_subscription ? 1 : 0
+
+  log_sink_filter   = "protoPayload.serviceName=\"compute.googleapis.com\" AND ((protoPayload.methodName=\"v1.compute.instances.insert\"${local.creation_label_filter}) OR protoPayload.methodName=(${local.termination_methods} OR ${local.lifecycle_methods} OR ${local.deletion_methods}))"
+  log_sink_name     = "sie-compute-sink"
+  project           = var.project
+  organization_id   = var.organization_id
+  folder_id         = var.folder_id
+  subscription_name = "sie-compute-subscription"
+  topic_name        = "sie-compute-topic"
 }
 
 module "interruption_events" {
   source = "./event-forwarder"
+  count  = var.unified_subscription ? 0 : 1
 
-  log_sink_filter   = "protoPayload.methodName=\"compute.instances.preempted\""
+  log_sink_filter   = "protoPayload.methodName=(${local.termination_methods})"
   log_sink_name     = "sie-interruption-sink"
   project           = var.project
+  organization_id   = var.organization_id
+  folder_id         = var.folder_id
   subscription_name = "sie-interruption-subscription"
   topic_name        = "sie-interruption-topic"
 }
 
 module "creation_events" {
   source = "./event-forwarder"
+  count  = var.unified_subscription ? 0 : 1
 
-  log_sink_filter   = "protoPayload.serviceName=\"compute.googleapis.com\" AND protoPayload.methodName=\"v1.compute.instances.insert\" AND protoPayload.request.labels.key=\"goog-k8s-cluster-name\""
+  log_sink_filter   = "protoPayload.serviceName=\"compute.googleapis.com\" AND protoPayload.methodName=\"v1.compute.instances.insert\"${local.creation_label_filter}"
   log_sink_name     = "sie-creation-sink"
   project           = var.project
+  organization_id   = var.organization_id
+  folder_id         = var.folder_id
   subscription_name = "sie-creation-subscription"
   topic_name        = "sie-creation-topic"
 
 }
 
+module "lifecycle_events" {
+  source = "./event-forwarder"
+  count  = var.unified_subscription ? 0 : 1
+
+  log_sink_filter   = "protoPayload.serviceName=\"compute.googleapis.com\" AND protoPayload.methodName=(${local.lifecycle_methods} OR ${local.deletion_methods})"
+  log_sink_name     = "sie-lifecycle-sink"
+  project           = var.project
+  organization_id   = var.organization_id
+  folder_id         = var.folder_id
+  subscription_name = "sie-lifecycle-subscription"
+  topic_name        = "sie-lifecycle-topic"
+}
+
+moved {
+  from = module.interruption_events
+  to   = module.interruption_events[0]
+}
+
+moved {
+  from = module.creation_events
+  to   = module.creation_events[0]
+}
+
+moved {
+  from = module.lifecycle_events
+  to   = module.lifecycle_events[0]
+}
+
 resource "google_service_account" "spot_interruption_exporter" {
   account_id   = var.service_account_id
   display_name = "Spot Interruption Exporter"
@@ -72,3 +124,29 @@ resource "google_project_iam_member" "compute_read_only" {
 
   member = google_service_account.spot_interruption_exporter.member
 }
+
+resource "google_project_iam_member" "metric_writer" {
+  count   = var.cloud_monitoring_enabled ? 1 : 0
+  project = var.project
+  role    = "roles/monitoring.metricWriter"
+
+  member = google_service_account.spot_interruption_exporter.member
+}
+
+resource "google_organization_iam_member" "compute_read_only" {
+  for_each = var.organization_id != null ? toset(local.tracked_project_roles) : toset([])
+
+  org_id = var.organization_id
+  role   = each.value
+
+  member = google_service_account.spot_interruption_exporter.member
+}
+
+resource "google_folder_iam_member" "compute_read_only" {
+  for_each = var.organization_id == null && var.folder_id != null ? toset(local.tracked_project_roles) : toset([])
+
+  folder = var.folder_id
+  role   = each.value
+
+  member = google_service_account.spot_interruption_exporter.member
+}
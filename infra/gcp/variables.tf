@@ -1,8 +1,20 @@
 variable "project" {
-  description = "The name of the project where the target clusters live."
+  description = "The name of the project where the target clusters live, and where the pubsub topics are created."
   type        = string
 }
 
+variable "organization_id" {
+  description = "If set, events from every project in this organization are forwarded via aggregated log sinks, and the app is granted read access to all of them."
+  type        = string
+  default     = null
+}
+
+variable "folder_id" {
+  description = "If set, events from every project in this folder are forwarded via aggregated log sinks, and the app is granted read access to all of them. Ignored if organization_id is set."
+  type        = string
+  default     = null
+}
+
 variable "service_account_id" {
   type        = string
   default     = "spot-interruption-exporter"
@@ -20,3 +32,21 @@ variable "kubernetes_service_account_namespace" {
   default     = "spot-interruption-exporter"
   description = "Namespace of the Kubernetes service account that will be bound to the spot-interruption-exporter pod. Will be used for workload identity."
 }
+
+variable "cluster_label_keys" {
+  type        = list(string)
+  default     = ["goog-k8s-cluster-name"]
+  description = "Instance label keys identifying the workload an instance belongs to. Only creation events of instances with one of these labels are forwarded. Set to an empty list if workloads are identified via instance metadata or names, e.g. managed instance groups."
+}
+
+variable "cloud_monitoring_enabled" {
+  type        = bool
+  default     = false
+  description = "Grants the app permission to write Cloud Monitoring custom metrics to every tracked project. Required if the app's cloud_monitoring config is set."
+}
+
+variable "unified_subscription" {
+  type        = bool
+  default     = false
+  description = "Forwards every compute event the app handles to a single sie-compute-subscription, rather than a subscription for each type of event. The app's pubsub.compute_events_subscription_name config must then be set."
+}
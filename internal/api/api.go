@@ -0,0 +1,172 @@
+// Package api serves recent interruptions over a read-only HTTP/JSON API, and streams live interruptions as server-sent events
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thought-machine/spot-interruption-exporter/internal/history"
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+	"github.com/thought-machine/spot-interruption-exporter/internal/stream"
+	"go.uber.org/zap"
+)
+
+// InterruptionsPath lists recent interruptions, filtered by the cluster, zone, machine_type, since and until query parameters,
+// and paginated by the limit and page_token query parameters
+const InterruptionsPath = "/api/v1/interruptions"
+
+// CreationsPath lists recent instance creations, filtered and paginated by the same query parameters as InterruptionsPath
+const CreationsPath = "/api/v1/creations"
+
+// NewHandlerInput defines all required fields to create the API's handler
+type NewHandlerInput struct {
+	Logger *zap.SugaredLogger
+	Store  history.Store
+	// Broadcaster, if not nil, streams live interruptions at StreamPath
+	Broadcaster stream.Broadcaster
+	// HeartbeatInterval is how often an idle stream sends a comment to keep its connection open, defaulting to 15 seconds
+	HeartbeatInterval time.Duration
+}
+
+type interruptionsResponse struct {
+	Interruptions []notify.Interruption `json:"interruptions"`
+	NextPageToken string                `json:"next_page_token,omitempty"`
+}
+
+type creationsResponse struct {
+	Creations     []history.Creation `json:"creations"`
+	NextPageToken string             `json:"next_page_token,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	log               *zap.SugaredLogger
+	store             history.Store
+	broadcaster       stream.Broadcaster
+	heartbeatInterval time.Duration
+}
+
+// NewHandler creates a handler serving the API's paths, which are all under /api/v1/
+func NewHandler(input NewHandlerInput) http.Handler {
+	h := &handler{
+		log:               input.Logger,
+		store:             input.Store,
+		broadcaster:       input.Broadcaster,
+		heartbeatInterval: input.HeartbeatInterval,
+	}
+	if h.heartbeatInterval <= 0 {
+		h.heartbeatInterval = defaultHeartbeatInterval
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(InterruptionsPath, h.listInterruptions)
+	mux.HandleFunc(CreationsPath, h.listCreations)
+	if h.broadcaster != nil {
+		mux.HandleFunc(StreamPath, h.streamInterruptions)
+	}
+	return mux
+}
+
+func (h *handler) listInterruptions(w http.ResponseWriter, r *http.Request) {
+	q, ok := h.query(w, r)
+	if !ok {
+		return
+	}
+	page, err := h.store.List(r.Context(), q)
+	if err != nil {
+		h.writeListError(w, "interruptions", err)
+		return
+	}
+	resp := interruptionsResponse{Interruptions: page.Interruptions, NextPageToken: page.NextPageToken}
+	if resp.Interruptions == nil {
+		resp.Interruptions = []notify.Interruption{}
+	}
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *handler) listCreations(w http.ResponseWriter, r *http.Request) {
+	q, ok := h.query(w, r)
+	if !ok {
+		return
+	}
+	page, err := h.store.ListCreations(r.Context(), q)
+	if err != nil {
+		h.writeListError(w, "creations", err)
+		return
+	}
+	resp := creationsResponse{Creations: page.Creations, NextPageToken: page.NextPageToken}
+	if resp.Creations == nil {
+		resp.Creations = []history.Creation{}
+	}
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// query returns the query of a listing request, or writes an error response and returns false if it is invalid
+func (h *handler) query(w http.ResponseWriter, r *http.Request) (history.Query, bool) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		h.writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "only GET is supported"})
+		return history.Query{}, false
+	}
+	q, err := parseQuery(r)
+	if err != nil {
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return history.Query{}, false
+	}
+	return q, true
+}
+
+// writeListError writes the response to listing what failed with err
+func (h *handler) writeListError(w http.ResponseWriter, what string, err error) {
+	if errors.Is(err, history.ErrInvalidPageToken) {
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	h.log.With("error", err).Warnf("failed to list %s", what)
+	h.writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "failed to list " + what})
+}
+
+func parseQuery(r *http.Request) (history.Query, error) {
+	values := r.URL.Query()
+	q := history.Query{
+		Cluster:     values.Get("cluster"),
+		Zone:        values.Get("zone"),
+		MachineType: values.Get("machine_type"),
+		PageToken:   values.Get("page_token"),
+	}
+	var err error
+	if q.Since, err = parseTime(values.Get("since")); err != nil {
+		return history.Query{}, fmt.Errorf("invalid since: %w", err)
+	}
+	if q.Until, err = parseTime(values.Get("until")); err != nil {
+		return history.Query{}, fmt.Errorf("invalid until: %w", err)
+	}
+	if limit := values.Get("limit"); len(limit) > 0 {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 1 || q.Limit > history.MaxLimit {
+			return history.Query{}, fmt.Errorf("invalid limit %q, expected 1 to %d", limit, history.MaxLimit)
+		}
+	}
+	return q, nil
+}
+
+// parseTime parses an RFC 3339 time, or returns the zero time if s is empty
+func parseTime(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func (h *handler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.log.With("error", err).Debug("failed to write response")
+	}
+}
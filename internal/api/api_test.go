@@ -0,0 +1,202 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/thought-machine/spot-interruption-exporter/internal/history"
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+	"github.com/thought-machine/spot-interruption-exporter/internal/stream"
+	"go.uber.org/zap"
+)
+
+type APITestSuite struct {
+	suite.Suite
+	server      *httptest.Server
+	broadcaster stream.Broadcaster
+}
+
+func TestAPITestSuite(t *testing.T) {
+	suite.Run(t, new(APITestSuite))
+}
+
+func (suite *APITestSuite) SetupTest() {
+	l, err := zap.NewDevelopment()
+	suite.Require().NoError(err)
+	store := history.NewRingBuffer(10)
+	for i, zone := range []string{"europe-west1-b", "europe-west1-c", "europe-west1-c"} {
+		suite.Require().NoError(store.Add(context.Background(), notify.Interruption{
+			Instance:    "node-" + string(rune('a'+i)),
+			Cluster:     "prod",
+			Zone:        zone,
+			MachineType: "e2-standard-4",
+			MessageID:   "message-" + string(rune('a'+i)),
+			Timestamp:   time.Date(2024, 5, 1, 12, i, 0, 0, time.UTC),
+		}))
+	}
+	suite.Require().NoError(store.AddCreation(context.Background(), history.Creation{
+		Instance:    "node-d",
+		Cluster:     "prod",
+		Zone:        "europe-west1-b",
+		MachineType: "n2-standard-8",
+		Timestamp:   time.Date(2024, 5, 1, 12, 3, 0, 0, time.UTC),
+	}))
+	suite.broadcaster = stream.NewBroadcaster(stream.NewBroadcasterInput{Logger: l.Sugar()})
+	suite.server = httptest.NewServer(NewHandler(NewHandlerInput{
+		Logger:            l.Sugar(),
+		Store:             store,
+		Broadcaster:       suite.broadcaster,
+		HeartbeatInterval: 10 * time.Millisecond,
+	}))
+}
+
+func (suite *APITestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *APITestSuite) get(query string) (int, interruptionsResponse, errorResponse) {
+	resp, err := http.Get(suite.server.URL + InterruptionsPath + query)
+	suite.Require().NoError(err)
+	defer resp.Body.Close()
+	suite.Equal("application/json", resp.Header.Get("Content-Type"))
+	if resp.StatusCode != http.StatusOK {
+		e := errorResponse{}
+		suite.Require().NoError(json.NewDecoder(resp.Body).Decode(&e))
+		return resp.StatusCode, interruptionsResponse{}, e
+	}
+	body := interruptionsResponse{}
+	suite.Require().NoError(json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body, errorResponse{}
+}
+
+func (suite *APITestSuite) TestListInterruptions() {
+	status, body, _ := suite.get("?zone=europe-west1-c&limit=1")
+	suite.Equal(http.StatusOK, status)
+	suite.Require().Len(body.Interruptions, 1)
+	suite.Equal("node-c", body.Interruptions[0].Instance)
+	suite.Equal("e2-standard-4", body.Interruptions[0].MachineType)
+	suite.Equal("message-c", body.Interruptions[0].MessageID)
+	suite.Require().NotEmpty(body.NextPageToken)
+
+	status, body, _ = suite.get("?zone=europe-west1-c&limit=1&page_token=" + body.NextPageToken)
+	suite.Equal(http.StatusOK, status)
+	suite.Require().Len(body.Interruptions, 1)
+	suite.Equal("node-b", body.Interruptions[0].Instance)
+	suite.Empty(body.NextPageToken)
+
+	status, body, _ = suite.get("?cluster=prod&since=2024-05-01T12:01:00Z&until=2024-05-01T12:02:00Z")
+	suite.Equal(http.StatusOK, status)
+	suite.Require().Len(body.Interruptions, 1)
+	suite.Equal("node-b", body.Interruptions[0].Instance)
+
+	// an empty result is an empty list rather than null
+	status, body, _ = suite.get("?cluster=unknown")
+	suite.Equal(http.StatusOK, status)
+	suite.NotNil(body.Interruptions)
+	suite.Empty(body.Interruptions)
+}
+
+func (suite *APITestSuite) TestListCreations() {
+	resp, err := http.Get(suite.server.URL + CreationsPath + "?machine_type=n2-standard-8")
+	suite.Require().NoError(err)
+	defer resp.Body.Close()
+	suite.Equal(http.StatusOK, resp.StatusCode)
+	body := creationsResponse{}
+	suite.Require().NoError(json.NewDecoder(resp.Body).Decode(&body))
+	suite.Require().Len(body.Creations, 1)
+	suite.Equal("node-d", body.Creations[0].Instance)
+
+	status, interruptions, _ := suite.get("?machine_type=n2-standard-8")
+	suite.Equal(http.StatusOK, status)
+	suite.Empty(interruptions.Interruptions)
+}
+
+func (suite *APITestSuite) TestListInterruptionsInvalidQueries() {
+	for _, query := range []string{"?since=yesterday", "?until=1714564800", "?limit=0", "?limit=5000", "?page_token=not-a-token"} {
+		status, _, e := suite.get(query)
+		suite.Equal(http.StatusBadRequest, status, query)
+		suite.NotEmpty(e.Error)
+	}
+
+	resp, err := http.Post(suite.server.URL+InterruptionsPath, "application/json", nil)
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func (suite *APITestSuite) TestStreamInterruptions() {
+	resp, cancel := suite.stream("?zone=europe-west1-b&zone=europe-west1-c", "")
+	defer cancel()
+	suite.Equal(http.StatusOK, resp.StatusCode)
+	suite.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	// the stream is subscribed to by the time its headers are received
+	suite.broadcaster.Publish(notify.Interruption{Instance: "node-d", Zone: "us-central1-a", MessageID: "message-d"})
+	suite.broadcaster.Publish(notify.Interruption{Instance: "node-e", Zone: "europe-west1-c", MessageID: "message-e"})
+
+	event := readEvent(suite.Require(), bufio.NewReader(resp.Body))
+	suite.Require().Len(event, 3)
+	suite.Equal("id: message-e", event[0])
+	suite.Equal("event: interruption", event[1])
+	received := notify.Interruption{}
+	suite.Require().NoError(json.Unmarshal([]byte(strings.TrimPrefix(event[2], "data: ")), &received))
+	suite.Equal("node-e", received.Instance)
+}
+
+func (suite *APITestSuite) TestStreamReplaysSinceLastEventID() {
+	resp, cancel := suite.stream("?zone=europe-west1-c", "message-a")
+	defer cancel()
+	suite.Equal(http.StatusOK, resp.StatusCode)
+
+	// interruptions handled since the last event are replayed oldest first, and are not sent again if published
+	suite.broadcaster.Publish(notify.Interruption{Instance: "node-c", Zone: "europe-west1-c", MessageID: "message-c"})
+	suite.broadcaster.Publish(notify.Interruption{Instance: "node-e", Zone: "europe-west1-c", MessageID: "message-e"})
+	reader := bufio.NewReader(resp.Body)
+	for _, id := range []string{"message-b", "message-c", "message-e"} {
+		suite.Equal("id: "+id, readEvent(suite.Require(), reader)[0])
+	}
+}
+
+// stream requests the stream with query, and the Last-Event-ID header if lastEventID is not empty
+func (suite *APITestSuite) stream(query, lastEventID string) (*http.Response, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, suite.server.URL+StreamPath+query, nil)
+	suite.Require().NoError(err)
+	if len(lastEventID) > 0 {
+		req.Header.Set(lastEventIDHeader, lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+	return resp, func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+// readEvent returns the lines of the next event read from reader, skipping heartbeats
+func readEvent(require *require.Assertions, reader *bufio.Reader) []string {
+	var event []string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(err)
+		line = strings.TrimSuffix(line, "\n")
+		// heartbeats are comments, and events end with a blank line
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		if len(line) == 0 && len(event) > 0 {
+			return event
+		}
+		if len(line) > 0 {
+			event = append(event, line)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/thought-machine/spot-interruption-exporter/internal/history"
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+	"github.com/thought-machine/spot-interruption-exporter/internal/stream"
+)
+
+// StreamPath streams interruptions as server-sent events as they are handled, filtered by any number of cluster and zone query parameters.
+// Streams requested with a Last-Event-ID header start with the interruptions handled since that event.
+const StreamPath = "/api/v1/interruptions/stream"
+
+// lastEventIDHeader is sent by clients reconnecting to a stream with the ID of the last event they received
+const lastEventIDHeader = "Last-Event-ID"
+
+// maxReplay is the most recent interruptions searched for the last event a reconnecting client received
+const maxReplay = history.MaxLimit
+
+// interruptionEvent is the type of the server-sent events carrying interruptions
+const interruptionEvent = "interruption"
+
+const defaultHeartbeatInterval = time.Second * 15
+
+func (h *handler) streamInterruptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		h.writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "only GET is supported"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "streaming is not supported"})
+		return
+	}
+	values := r.URL.Query()
+	filter := stream.Filter{Clusters: values["cluster"], Zones: values["zone"]}
+	// subscribing before listing the interruptions to replay means none are missed in between, only sent twice
+	interruptions, unsubscribe := h.broadcaster.Subscribe(filter)
+	defer unsubscribe()
+	replay := h.replay(r.Context(), r.Header.Get(lastEventIDHeader), filter)
+	replayed := make(map[string]bool, len(replay))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// stops proxies such as nginx from buffering events
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, i := range replay {
+		if !h.writeEvent(w, i) {
+			return
+		}
+		replayed[i.MessageID] = true
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case i, ok := <-interruptions:
+			// the broadcaster closes streams that fall behind, which clients reconnect to with the last event they received
+			if !ok {
+				return
+			}
+			if replayed[i.MessageID] {
+				continue
+			}
+			if !h.writeEvent(w, i) {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes i as an event, returning false if the connection failed
+func (h *handler) writeEvent(w http.ResponseWriter, i notify.Interruption) bool {
+	data, err := json.Marshal(i)
+	if err != nil {
+		h.log.With("error", err).Warn("failed to marshal interruption")
+		return true
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", i.MessageID, interruptionEvent, data)
+	return err == nil
+}
+
+// replay returns the interruptions filter selects that were handled after the one with message ID lastEventID, oldest first.
+// Nothing is replayed if lastEventID is empty, or is not among the most recent maxReplay interruptions.
+func (h *handler) replay(ctx context.Context, lastEventID string, filter stream.Filter) []notify.Interruption {
+	if len(lastEventID) == 0 {
+		return nil
+	}
+	page, err := h.store.List(ctx, history.Query{Limit: maxReplay})
+	if err != nil {
+		h.log.With("error", err, "last_event_id", lastEventID).Warn("failed to list interruptions to replay")
+		return nil
+	}
+	var replay []notify.Interruption
+	for _, i := range page.Interruptions {
+		if i.MessageID == lastEventID {
+			// interruptions are listed most recent first
+			for l, r := 0, len(replay)-1; l < r; l, r = l+1, r-1 {
+				replay[l], replay[r] = replay[r], replay[l]
+			}
+			return replay
+		}
+		if filter.Matches(i) {
+			replay = append(replay, i)
+		}
+	}
+	h.log.With("last_event_id", lastEventID).Info("last event of reconnecting stream is too old to replay")
+	return nil
+}
@@ -1,77 +1,330 @@
-// Package cache provides a simple ttl-based item cache
+// Package cache provides simple ttl-based item caches, kept in memory or shared in Redis
 package cache
 
 import (
+	"container/list"
 	"fmt"
+	"sync"
 	"time"
-
-	gocache "github.com/patrickmn/go-cache"
 )
 
 // Cache provides a simple item store
-type Cache interface {
-	// Insert the string k
-	Insert(k string, v string)
-	// Exists proves the existence or lack-thereof of the item k in the cache
-	Exists(k string) (exists bool)
+type Cache[K comparable, V any] interface {
+	// Insert the item k
+	Insert(k K, v V)
+	// InsertIfAbsent inserts the item k unless it already exists, returning whether it was inserted, or an error if the cache
+	// could not be reached
+	InsertIfAbsent(k K, v V) (inserted bool, err error)
+	// Exists proves the existence or lack-thereof of the item k in the cache, or returns an error if the cache could not be reached
+	Exists(k K) (exists bool, err error)
 	// Get returns the value of the key in the cache
-	Get(k string) (value string, err error)
+	Get(k K) (value V, err error)
 	// SetExpiration sets the expiration on the given item k without updating the value
-	SetExpiration(k string, t time.Duration) error
+	SetExpiration(k K, t time.Duration) error
+	// Delete removes the item k from the cache, doing nothing if it does not exist
+	Delete(k K)
+	// ItemCount returns the number of unexpired items in the cache
+	ItemCount() int
+	// Items returns a copy of every unexpired item in the cache
+	Items() map[K]Item[V]
+	// Restore inserts items with their expirations, skipping any that have already expired
+	Restore(items map[K]Item[V])
 }
 
-const NoExpiration = gocache.NoExpiration
+// Item is a value in a cache and the time it expires at, which is zero if it never expires
+type Item[V any] struct {
+	Value      V         `json:"value"`
+	Expiration time.Time `json:"expiration"`
+}
 
-type cache struct {
-	u *gocache.Cache
+// Recorder records the lookups and evictions of caches, e.g. a metrics.Client
+type Recorder interface {
+	IncreaseCacheLookupCounter(cache string, result string)
+	IncreaseCacheEvictionCounter(cache string, reason string)
 }
 
-func (c *cache) SetExpiration(k string, t time.Duration) error {
-	e, ok := c.u.Get(k)
-	if !ok {
-		return fmt.Errorf("cannot update expiration for item (%s) that does not exist", k)
+// NoExpiration is the ttl of items that never expire
+const NoExpiration time.Duration = -1
+
+// EvictionReason is why an item was evicted from a cache
+type EvictionReason string
+
+const (
+	// EvictionReasonExpired is the reason items that outlived their ttl are evicted
+	EvictionReasonExpired EvictionReason = "expired"
+	// EvictionReasonCapacity is the reason the least recently used item is evicted to make room in a full cache
+	EvictionReasonCapacity EvictionReason = "capacity"
+)
+
+const (
+	// cleanupInterval is how often writes also evict every expired item, which are otherwise only evicted when looked up
+	cleanupInterval = time.Minute
+
+	lookupResultHit  = "hit"
+	lookupResultMiss = "miss"
+)
+
+// NewInput defines the fields to create a Cache kept in memory, all of which are optional
+type NewInput[K comparable, V any] struct {
+	// Name identifies the cache in metrics
+	Name string
+	// TTL is how long inserted items live for, where zero or NoExpiration never expire
+	TTL time.Duration
+	// MaxSize bounds the number of items, evicting the least recently used item to insert another once reached. Zero is unbounded
+	MaxSize int
+	// Initial seeds the cache, with each item living for TTL
+	Initial map[K]V
+	// Metrics records lookups and evictions, if set
+	Metrics Recorder
+	// Now returns the current time, defaulting to time.Now, so that tests can expire items without waiting
+	Now func() time.Time
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	// expiration is zero if the entry never expires
+	expiration time.Time
+}
+
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expiration.IsZero() && !now.Before(e.expiration)
+}
+
+type cache[K comparable, V any] struct {
+	mu      sync.Mutex
+	name    string
+	ttl     time.Duration
+	maxSize int
+	metrics Recorder
+	now     func() time.Time
+	// order holds every entry, the most recently used at the front
+	order       *list.List
+	items       map[K]*list.Element
+	lastCleanup time.Time
+}
+
+// eviction is an entry removed while the cache was locked, reported once it is unlocked
+type eviction[K comparable, V any] struct {
+	entry  *entry[K, V]
+	reason EvictionReason
+}
+
+// New creates a Cache kept in memory
+func New[K comparable, V any](input NewInput[K, V]) Cache[K, V] {
+	now := input.Now
+	if now == nil {
+		now = time.Now
 	}
-	c.u.Set(k, e, t)
-	return nil
+	c := &cache[K, V]{
+		name:        input.Name,
+		ttl:         input.TTL,
+		maxSize:     input.MaxSize,
+		metrics:     input.Metrics,
+		now:         now,
+		order:       list.New(),
+		items:       make(map[K]*list.Element, len(input.Initial)),
+		lastCleanup: now(),
+	}
+	for k, v := range input.Initial {
+		c.Insert(k, v)
+	}
+	return c
 }
 
-func (c *cache) Insert(k string, v string) {
-	c.u.SetDefault(k, v)
+// NewWithTTL creates a Cache kept in memory with ttl of t
+func NewWithTTL[K comparable, V any](t time.Duration) Cache[K, V] {
+	return New(NewInput[K, V]{TTL: t})
 }
 
-func (c *cache) Exists(k string) (exists bool) {
-	_, exists = c.u.Get(k)
-	return exists
+// expiration returns when an item inserted at now and living for t expires
+func expiration(now time.Time, t time.Duration) time.Time {
+	if t <= 0 {
+		return time.Time{}
+	}
+	return now.Add(t)
 }
 
-func (c *cache) Get(k string) (value string, err error) {
-	v, ok := c.u.Get(k)
+// lookup returns the unexpired entry k, marking it as most recently used, and recording whether it was found.
+// c must be locked.
+func (c *cache[K, V]) lookup(k K, now time.Time, evicted *[]eviction[K, V]) (*entry[K, V], bool) {
+	e, ok := c.get(k, now, evicted)
+	if ok {
+		c.order.MoveToFront(c.items[k])
+		c.recordLookup(lookupResultHit)
+	} else {
+		c.recordLookup(lookupResultMiss)
+	}
+	return e, ok
+}
+
+// get returns the unexpired entry k, evicting it if it has expired. c must be locked.
+func (c *cache[K, V]) get(k K, now time.Time, evicted *[]eviction[K, V]) (*entry[K, V], bool) {
+	el, ok := c.items[k]
 	if !ok {
-		return "", fmt.Errorf("key %s not found in cache", k)
+		return nil, false
+	}
+	e := el.Value.(*entry[K, V])
+	if e.expired(now) {
+		*evicted = append(*evicted, c.remove(el, EvictionReasonExpired))
+		return nil, false
+	}
+	return e, true
+}
+
+// set inserts or replaces the entry k, evicting expired entries every cleanupInterval and the least recently used entry
+// if c is full. c must be locked.
+func (c *cache[K, V]) set(k K, v V, exp time.Time, now time.Time, evicted *[]eviction[K, V]) {
+	if now.Sub(c.lastCleanup) >= cleanupInterval {
+		c.cleanup(now, evicted)
+	}
+	if el, ok := c.items[k]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.expiration = v, exp
+		c.order.MoveToFront(el)
+		return
+	}
+	if c.maxSize > 0 && len(c.items) >= c.maxSize {
+		oldest := c.order.Back()
+		reason := EvictionReasonCapacity
+		if oldest.Value.(*entry[K, V]).expired(now) {
+			reason = EvictionReasonExpired
+		}
+		*evicted = append(*evicted, c.remove(oldest, reason))
 	}
-	value, ok = v.(string)
+	c.items[k] = c.order.PushFront(&entry[K, V]{key: k, value: v, expiration: exp})
+}
+
+// cleanup evicts every expired entry. c must be locked.
+func (c *cache[K, V]) cleanup(now time.Time, evicted *[]eviction[K, V]) {
+	c.lastCleanup = now
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*entry[K, V]).expired(now) {
+			*evicted = append(*evicted, c.remove(el, EvictionReasonExpired))
+		}
+		el = next
+	}
+}
+
+// remove removes the entry el. c must be locked.
+func (c *cache[K, V]) remove(el *list.Element, reason EvictionReason) eviction[K, V] {
+	e := c.order.Remove(el).(*entry[K, V])
+	delete(c.items, e.key)
+	return eviction[K, V]{entry: e, reason: reason}
+}
+
+// unlock unlocks c, then records evicted, so that recording does not hold up other callers
+func (c *cache[K, V]) unlock(evicted []eviction[K, V]) {
+	c.mu.Unlock()
+	if c.metrics == nil {
+		return
+	}
+	for _, ev := range evicted {
+		c.metrics.IncreaseCacheEvictionCounter(c.name, string(ev.reason))
+	}
+}
+
+func (c *cache[K, V]) recordLookup(result string) {
+	if c.metrics != nil {
+		c.metrics.IncreaseCacheLookupCounter(c.name, result)
+	}
+}
+
+func (c *cache[K, V]) SetExpiration(k K, t time.Duration) error {
+	var evicted []eviction[K, V]
+	c.mu.Lock()
+	defer func() { c.unlock(evicted) }()
+	now := c.now()
+	e, ok := c.get(k, now, &evicted)
 	if !ok {
-		return "", fmt.Errorf("unexpected value for key %s: %T, expected string", k, value)
+		return fmt.Errorf("cannot update expiration for item (%v) that does not exist", k)
+	}
+	e.expiration = expiration(now, t)
+	return nil
+}
+
+func (c *cache[K, V]) Insert(k K, v V) {
+	var evicted []eviction[K, V]
+	c.mu.Lock()
+	defer func() { c.unlock(evicted) }()
+	now := c.now()
+	c.set(k, v, expiration(now, c.ttl), now, &evicted)
+}
+
+func (c *cache[K, V]) InsertIfAbsent(k K, v V) (bool, error) {
+	var evicted []eviction[K, V]
+	c.mu.Lock()
+	defer func() { c.unlock(evicted) }()
+	now := c.now()
+	if _, ok := c.lookup(k, now, &evicted); ok {
+		return false, nil
 	}
-	return value, nil
+	c.set(k, v, expiration(now, c.ttl), now, &evicted)
+	return true, nil
 }
 
-// NewCacheWithTTL creates a new cache with ttl of t
-func NewCacheWithTTL(t time.Duration) Cache {
-	return &cache{
-		u: gocache.New(t, time.Minute*60),
+func (c *cache[K, V]) Delete(k K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[k]; ok {
+		c.remove(el, "")
 	}
 }
 
-func NewCacheWithTTLFrom(t time.Duration, defaultItems map[string]string) Cache {
-	m := make(map[string]gocache.Item, len(defaultItems))
-	for k, v := range defaultItems {
-		m[k] = gocache.Item{
-			Object:     v,
-			Expiration: t.Nanoseconds(),
+func (c *cache[K, V]) ItemCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	count := 0
+	for _, el := range c.items {
+		if !el.Value.(*entry[K, V]).expired(now) {
+			count++
 		}
 	}
-	return &cache{
-		u: gocache.NewFrom(t, time.Minute*60, m),
+	return count
+}
+
+func (c *cache[K, V]) Items() map[K]Item[V] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	m := make(map[K]Item[V], len(c.items))
+	for k, el := range c.items {
+		if e := el.Value.(*entry[K, V]); !e.expired(now) {
+			m[k] = Item[V]{Value: e.value, Expiration: e.expiration}
+		}
+	}
+	return m
+}
+
+func (c *cache[K, V]) Restore(items map[K]Item[V]) {
+	var evicted []eviction[K, V]
+	c.mu.Lock()
+	defer func() { c.unlock(evicted) }()
+	now := c.now()
+	for k, item := range items {
+		if item.Expiration.IsZero() || now.Before(item.Expiration) {
+			c.set(k, item.Value, item.Expiration, now, &evicted)
+		}
+	}
+}
+
+func (c *cache[K, V]) Exists(k K) (bool, error) {
+	var evicted []eviction[K, V]
+	c.mu.Lock()
+	defer func() { c.unlock(evicted) }()
+	_, exists := c.lookup(k, c.now(), &evicted)
+	return exists, nil
+}
+
+func (c *cache[K, V]) Get(k K) (value V, err error) {
+	var evicted []eviction[K, V]
+	c.mu.Lock()
+	defer func() { c.unlock(evicted) }()
+	e, ok := c.lookup(k, c.now(), &evicted)
+	if !ok {
+		return value, fmt.Errorf("key %v not found in cache", k)
 	}
+	return e.value, nil
 }
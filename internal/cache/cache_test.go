@@ -15,37 +15,192 @@ func TestCacheTestSuite(t *testing.T) {
 	suite.Run(t, new(CacheTestSuite))
 }
 
-func (suite *CacheTestSuite) TestNewCacheWithTTL() {
-	expirationDuration := time.Millisecond * 250
-	c := NewCacheWithTTL(expirationDuration)
-	c.Insert("key", "")
-	suite.True(c.Exists("key"))
-	suite.Eventually(func() bool {
-		return !c.Exists("key")
-	}, time.Second*2, time.Millisecond*100)
-}
-
-func (suite *CacheTestSuite) TestNewCacheWithTTLFrom() {
-	existingItemKey := "item"
-	existingItemValue := "value"
-	existing := map[string]string{
-		existingItemKey: existingItemValue,
-	}
-	c := NewCacheWithTTLFrom(NoExpiration, existing)
-	v, err := c.Get(existingItemKey)
+// clock is a time that tests move forward by hand
+type clock struct {
+	t time.Time
+}
+
+func newClock() *clock {
+	return &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+// exists returns whether k exists in c, failing the test if c could not be reached
+func exists[K comparable, V any](suite *CacheTestSuite, c Cache[K, V], k K) bool {
+	ok, err := c.Exists(k)
+	suite.Require().NoError(err)
+	return ok
+}
+
+// insertIfAbsent returns whether k was inserted into c, failing the test if c could not be reached
+func insertIfAbsent[K comparable, V any](suite *CacheTestSuite, c Cache[K, V], k K, v V) bool {
+	inserted, err := c.InsertIfAbsent(k, v)
+	suite.Require().NoError(err)
+	return inserted
+}
+
+func (suite *CacheTestSuite) TestTTL() {
+	clk := newClock()
+	c := New(NewInput[string, int]{TTL: time.Minute, Now: clk.now})
+	c.Insert("key", 1)
+	clk.advance(time.Minute - time.Nanosecond)
+	suite.True(exists(suite, c, "key"))
+	clk.advance(time.Nanosecond)
+	suite.False(exists(suite, c, "key"))
+	suite.Equal(0, c.ItemCount())
+}
+
+func (suite *CacheTestSuite) TestInitial() {
+	existing := map[string]string{"item": "value"}
+	c := New(NewInput[string, string]{TTL: NoExpiration, Initial: existing})
+	v, err := c.Get("item")
 	suite.NoError(err)
-	suite.Equal(existingItemValue, v)
+	suite.Equal("value", v)
+	_, err = c.Get("non-existent")
+	suite.Error(err)
 }
 
 func (suite *CacheTestSuite) TestSetExpiration() {
-	itemKey := "item"
-	c := NewCacheWithTTL(NoExpiration)
+	clk := newClock()
+	c := New(NewInput[string, string]{Now: clk.now})
 
 	suite.Error(c.SetExpiration("non-existent", NoExpiration))
 
-	c.Insert(itemKey, "")
-	suite.NoError(c.SetExpiration(itemKey, time.Nanosecond))
-	suite.Eventually(func() bool {
-		return !c.Exists(itemKey)
-	}, time.Second*2, time.Microsecond)
+	c.Insert("item", "")
+	suite.NoError(c.SetExpiration("item", time.Second))
+	suite.Equal(1, c.ItemCount())
+	clk.advance(time.Second)
+	// expired items are not counted before they are evicted
+	suite.Equal(0, c.ItemCount())
+	suite.False(exists(suite, c, "item"))
+
+	c.Insert("item", "")
+	suite.NoError(c.SetExpiration("item", time.Second))
+	suite.NoError(c.SetExpiration("item", NoExpiration))
+	clk.advance(time.Hour)
+	suite.True(exists(suite, c, "item"))
+}
+
+func (suite *CacheTestSuite) TestDelete() {
+	c := NewWithTTL[string, string](NoExpiration)
+	c.Delete("non-existent")
+
+	c.Insert("item", "")
+	suite.Equal(1, c.ItemCount())
+	c.Delete("item")
+	suite.False(exists(suite, c, "item"))
+	suite.Equal(0, c.ItemCount())
+}
+
+func (suite *CacheTestSuite) TestItemsAndRestore() {
+	clk := newClock()
+	c := New(NewInput[string, string]{Now: clk.now})
+	c.Insert("forever", "a")
+	c.Insert("expiring", "b")
+	suite.NoError(c.SetExpiration("expiring", time.Hour))
+
+	items := c.Items()
+	suite.Require().Len(items, 2)
+	suite.True(items["forever"].Expiration.IsZero())
+	suite.Equal(clk.now().Add(time.Hour), items["expiring"].Expiration)
+
+	items["expired"] = Item[string]{Value: "c", Expiration: clk.now().Add(-time.Minute)}
+	restored := New(NewInput[string, string]{TTL: time.Minute, Now: clk.now})
+	restored.Restore(items)
+	suite.Equal(2, restored.ItemCount())
+	v, err := restored.Get("expiring")
+	suite.NoError(err)
+	suite.Equal("b", v)
+	suite.False(exists(suite, restored, "expired"))
+	suite.Equal(items["forever"], restored.Items()["forever"])
+
+	// restored items keep their expiration rather than the ttl of the cache
+	clk.advance(time.Minute)
+	suite.True(exists(suite, restored, "expiring"))
+	clk.advance(time.Hour)
+	suite.False(exists(suite, restored, "expiring"))
+}
+
+func (suite *CacheTestSuite) TestInsertIfAbsent() {
+	c := NewWithTTL[string, string](NoExpiration)
+	suite.True(insertIfAbsent(suite, c, "item", "a"))
+	suite.False(insertIfAbsent(suite, c, "item", "b"))
+	v, err := c.Get("item")
+	suite.NoError(err)
+	suite.Equal("a", v)
+}
+
+func (suite *CacheTestSuite) TestLRUEviction() {
+	r := &recorder{}
+	c := New(NewInput[string, int]{MaxSize: 2, Metrics: r})
+	c.Insert("a", 1)
+	c.Insert("b", 2)
+	// looking up a makes b the least recently used
+	suite.True(exists(suite, c, "a"))
+	c.Insert("c", 3)
+
+	suite.Equal(2, c.ItemCount())
+	suite.True(exists(suite, c, "a"))
+	suite.False(exists(suite, c, "b"))
+	suite.True(exists(suite, c, "c"))
+	suite.Equal([]string{":capacity"}, r.evictions)
+
+	// replacing an item does not evict another
+	c.Insert("a", 4)
+	suite.Len(r.evictions, 1)
+	// nor does deleting one count as an eviction
+	c.Delete("a")
+	suite.Len(r.evictions, 1)
+}
+
+func (suite *CacheTestSuite) TestExpiredEviction() {
+	clk := newClock()
+	r := &recorder{}
+	c := New(NewInput[string, int]{TTL: time.Second, Now: clk.now, Metrics: r})
+	c.Insert("looked-up", 1)
+	c.Insert("cleaned-up", 2)
+	clk.advance(time.Second)
+	suite.False(exists(suite, c, "looked-up"))
+	suite.Equal([]string{":expired"}, r.evictions)
+
+	// expired items are cleaned up by writes every cleanupInterval, even if they are never looked up again
+	clk.advance(cleanupInterval)
+	c.Insert("new", 3)
+	suite.Equal([]string{":expired", ":expired"}, r.evictions)
+	suite.Equal(1, c.ItemCount())
+}
+
+func (suite *CacheTestSuite) TestMetrics() {
+	r := &recorder{}
+	c := New(NewInput[string, int]{Name: "test", MaxSize: 1, Metrics: r})
+
+	suite.True(insertIfAbsent(suite, c, "a", 1))
+	suite.False(insertIfAbsent(suite, c, "a", 1))
+	_, err := c.Get("a")
+	suite.NoError(err)
+	c.Insert("b", 2)
+	suite.False(exists(suite, c, "a"))
+	suite.Equal([]string{"test:miss", "test:hit", "test:hit", "test:miss"}, r.lookups)
+	suite.Equal([]string{"test:capacity"}, r.evictions)
+}
+
+// recorder records the lookups and evictions of caches as cache:result and cache:reason
+type recorder struct {
+	lookups   []string
+	evictions []string
+}
+
+func (r *recorder) IncreaseCacheLookupCounter(cache string, result string) {
+	r.lookups = append(r.lookups, cache+":"+result)
+}
+
+func (r *recorder) IncreaseCacheEvictionCounter(cache string, reason string) {
+	r.evictions = append(r.evictions, cache+":"+reason)
 }
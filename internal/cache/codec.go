@@ -0,0 +1,47 @@
+package cache
+
+import "fmt"
+
+// Codec converts values to and from the strings they are kept as outside of memory, e.g. in Redis or a snapshot
+type Codec[V any] struct {
+	Encode func(v V) string
+	Decode func(s string) (V, error)
+}
+
+// StringCodec keeps strings as they are
+var StringCodec = Codec[string]{
+	Encode: func(v string) string { return v },
+	Decode: func(s string) (string, error) { return s, nil },
+}
+
+// EncodeItems returns items with their values encoded by codec
+func EncodeItems[V any](items map[string]Item[V], codec Codec[V]) map[string]Item[string] {
+	encoded := make(map[string]Item[string], len(items))
+	for k, item := range items {
+		encoded[k] = Item[string]{Value: codec.Encode(item.Value), Expiration: item.Expiration}
+	}
+	return encoded
+}
+
+// DecodeItems returns items with their values decoded by codec. Items that fail to decode are left out, and reported in the
+// returned error along with the first failure.
+func DecodeItems[V any](items map[string]Item[string], codec Codec[V]) (map[string]Item[V], error) {
+	decoded := make(map[string]Item[V], len(items))
+	var failed int
+	var first error
+	for k, item := range items {
+		v, err := codec.Decode(item.Value)
+		if err != nil {
+			failed++
+			if first == nil {
+				first = fmt.Errorf("failed to decode key %s: %w", k, err)
+			}
+			continue
+		}
+		decoded[k] = Item[V]{Value: v, Expiration: item.Expiration}
+	}
+	if failed > 0 {
+		return decoded, fmt.Errorf("%d of %d items failed to decode: %w", failed, len(items), first)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,244 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRedisTimeout = time.Second * 5
+	// redisScanCount is how many keys each SCAN looks at
+	redisScanCount = 1000
+)
+
+// NewRedisCacheInput defines all required fields to create a Cache backed by Redis
+type NewRedisCacheInput[V any] struct {
+	Logger *zap.SugaredLogger
+	Client redis.UniversalClient
+	// Codec converts values to and from the strings kept in Redis
+	Codec Codec[V]
+	// Prefix is prepended to every key, so several caches can share a database
+	Prefix string
+	// TTL is how long inserted items live for, or NoExpiration
+	TTL time.Duration
+	// Timeout bounds each request to Redis, defaulting to 5 seconds
+	Timeout time.Duration
+}
+
+type redisCache[V any] struct {
+	log     *zap.SugaredLogger
+	client  redis.UniversalClient
+	codec   Codec[V]
+	prefix  string
+	ttl     time.Duration
+	timeout time.Duration
+}
+
+// NewRedisCache creates a Cache backed by Redis, which replicas can share. Failed requests are returned as errors where Cache
+// allows, and otherwise logged. ItemCount and Items scan every key, so should not be called per event.
+func NewRedisCache[V any](input NewRedisCacheInput[V]) Cache[string, V] {
+	timeout := input.Timeout
+	if timeout <= 0 {
+		timeout = defaultRedisTimeout
+	}
+	return &redisCache[V]{
+		log:     input.Logger.With("prefix", input.Prefix),
+		client:  input.Client,
+		codec:   input.Codec,
+		prefix:  input.Prefix,
+		ttl:     input.TTL,
+		timeout: timeout,
+	}
+}
+
+// redisExpiration converts t to the expiration Redis expects, where 0 never expires
+func redisExpiration(t time.Duration) time.Duration {
+	if t == NoExpiration {
+		return 0
+	}
+	return t
+}
+
+func (r *redisCache[V]) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), r.timeout)
+}
+
+func (r *redisCache[V]) Insert(k string, v V) {
+	ctx, cancel := r.context()
+	defer cancel()
+	if err := r.client.Set(ctx, r.prefix+k, r.codec.Encode(v), redisExpiration(r.ttl)).Err(); err != nil {
+		r.log.With("error", err, "key", k).Warn("failed to insert item into redis")
+	}
+}
+
+func (r *redisCache[V]) InsertIfAbsent(k string, v V) (bool, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	inserted, err := r.client.SetNX(ctx, r.prefix+k, r.codec.Encode(v), redisExpiration(r.ttl)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to insert key %s into redis: %w", k, err)
+	}
+	return inserted, nil
+}
+
+func (r *redisCache[V]) Exists(k string) (bool, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	n, err := r.client.Exists(ctx, r.prefix+k).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check key %s exists in redis: %w", k, err)
+	}
+	return n > 0, nil
+}
+
+func (r *redisCache[V]) Get(k string) (V, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	var value V
+	v, err := r.client.Get(ctx, r.prefix+k).Result()
+	if errors.Is(err, redis.Nil) {
+		return value, fmt.Errorf("key %s not found in cache", k)
+	}
+	if err != nil {
+		return value, fmt.Errorf("failed to get key %s from redis: %w", k, err)
+	}
+	if value, err = r.codec.Decode(v); err != nil {
+		return value, fmt.Errorf("failed to decode key %s from redis: %w", k, err)
+	}
+	return value, nil
+}
+
+func (r *redisCache[V]) SetExpiration(k string, t time.Duration) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	var updated bool
+	var err error
+	if t == NoExpiration {
+		// PERSIST returns false for items without an expiration too, so existence is checked separately
+		if err = r.client.Persist(ctx, r.prefix+k).Err(); err == nil {
+			updated, err = r.Exists(k)
+		}
+	} else {
+		updated, err = r.client.PExpire(ctx, r.prefix+k, t).Result()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update expiration of key %s in redis: %w", k, err)
+	}
+	if !updated {
+		return fmt.Errorf("cannot update expiration for item (%s) that does not exist", k)
+	}
+	return nil
+}
+
+func (r *redisCache[V]) Delete(k string) {
+	ctx, cancel := r.context()
+	defer cancel()
+	if err := r.client.Del(ctx, r.prefix+k).Err(); err != nil {
+		r.log.With("error", err, "key", k).Warn("failed to delete item from redis")
+	}
+}
+
+// keys returns every key with the cache's prefix, which SCAN may return more than once
+func (r *redisCache[V]) keys(ctx context.Context) ([]string, error) {
+	seen := map[string]bool{}
+	var keys []string
+	iter := r.client.Scan(ctx, 0, escapeGlob(r.prefix)+"*", redisScanCount).Iterator()
+	for iter.Next(ctx) {
+		if k := iter.Val(); !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys, iter.Err()
+}
+
+// escapeGlob escapes the characters of s that SCAN would otherwise match as a pattern
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// ItemCount scans every key with the cache's prefix, so is slower than other methods, and is only reported periodically
+func (r *redisCache[V]) ItemCount() int {
+	ctx, cancel := r.context()
+	defer cancel()
+	keys, err := r.keys(ctx)
+	if err != nil {
+		r.log.With("error", err).Warn("failed to count items in redis")
+	}
+	return len(keys)
+}
+
+func (r *redisCache[V]) Items() map[string]Item[V] {
+	ctx, cancel := r.context()
+	defer cancel()
+	keys, err := r.keys(ctx)
+	if err != nil {
+		r.log.With("error", err).Warn("failed to list items in redis")
+		return map[string]Item[V]{}
+	}
+	values := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	_, err = r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			values[i] = p.Get(ctx, k)
+			ttls[i] = p.PTTL(ctx, k)
+		}
+		return nil
+	})
+	// items expiring between scanning and getting them fail with redis.Nil, and are skipped
+	if err != nil && !errors.Is(err, redis.Nil) {
+		r.log.With("error", err).Warn("failed to get items from redis")
+	}
+	now := time.Now()
+	items := make(map[string]Item[string], len(keys))
+	for i, k := range keys {
+		v, err := values[i].Result()
+		if err != nil {
+			continue
+		}
+		item := Item[string]{Value: v}
+		if ttl := ttls[i].Val(); ttl > 0 {
+			item.Expiration = now.Add(ttl)
+		}
+		items[strings.TrimPrefix(k, r.prefix)] = item
+	}
+	decoded, err := DecodeItems(items, r.codec)
+	if err != nil {
+		r.log.With("error", err).Warn("failed to decode items from redis")
+	}
+	return decoded
+}
+
+func (r *redisCache[V]) Restore(items map[string]Item[V]) {
+	ctx, cancel := r.context()
+	defer cancel()
+	now := time.Now()
+	_, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for k, item := range items {
+			if item.Expiration.IsZero() {
+				p.Set(ctx, r.prefix+k, r.codec.Encode(item.Value), 0)
+				continue
+			}
+			if ttl := item.Expiration.Sub(now); ttl > 0 {
+				p.Set(ctx, r.prefix+k, r.codec.Encode(item.Value), ttl)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.log.With("error", err).Warn("failed to restore items into redis")
+	}
+}
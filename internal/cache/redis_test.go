@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// redisCache returns a Cache backed by an in-process Redis, and the Redis itself so time can be fast-forwarded
+func (suite *CacheTestSuite) redisCache(prefix string, ttl time.Duration) (Cache[string, string], *miniredis.Miniredis) {
+	server := miniredis.RunT(suite.T())
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	suite.T().Cleanup(func() { _ = client.Close() })
+	l, err := zap.NewDevelopment()
+	suite.Require().NoError(err)
+	return NewRedisCache(NewRedisCacheInput[string]{Logger: l.Sugar(), Client: client, Codec: StringCodec, Prefix: prefix, TTL: ttl}), server
+}
+
+func (suite *CacheTestSuite) TestRedisCache() {
+	c, server := suite.redisCache("mappings:", NoExpiration)
+	_, err := c.Get("missing")
+	suite.Error(err)
+	suite.Error(c.SetExpiration("missing", time.Second))
+
+	c.Insert("instance-1", "kubernetes/prod")
+	suite.True(exists(suite, c, "instance-1"))
+	v, err := c.Get("instance-1")
+	suite.NoError(err)
+	suite.Equal("kubernetes/prod", v)
+	suite.Equal(1, c.ItemCount())
+	// keys are namespaced by the prefix
+	suite.True(server.Exists("mappings:instance-1"))
+
+	suite.NoError(c.SetExpiration("instance-1", time.Second*30))
+	server.FastForward(time.Second * 31)
+	suite.False(exists(suite, c, "instance-1"))
+
+	c.Insert("instance-2", "kubernetes/prod")
+	c.Delete("instance-2")
+	suite.False(exists(suite, c, "instance-2"))
+	suite.Equal(0, c.ItemCount())
+}
+
+func (suite *CacheTestSuite) TestRedisCacheTTL() {
+	c, server := suite.redisCache("messages:", time.Minute*10)
+	suite.True(insertIfAbsent(suite, c, "message-1", ""))
+	suite.False(insertIfAbsent(suite, c, "message-1", ""))
+	server.FastForward(time.Minute * 11)
+	suite.True(insertIfAbsent(suite, c, "message-1", ""))
+
+	// items that never expire can be given an expiration and have it removed again
+	c.Insert("message-2", "")
+	suite.NoError(c.SetExpiration("message-2", NoExpiration))
+	server.FastForward(time.Hour)
+	suite.True(exists(suite, c, "message-2"))
+}
+
+func (suite *CacheTestSuite) TestRedisCacheErrors() {
+	c, server := suite.redisCache("messages:", time.Minute*10)
+	server.Close()
+
+	// callers decide how to treat an unreachable cache, rather than it being reported as missing or inserted
+	_, err := c.Exists("message-1")
+	suite.Error(err)
+	_, err = c.InsertIfAbsent("message-1", "")
+	suite.Error(err)
+}
+
+func (suite *CacheTestSuite) TestRedisCacheCodec() {
+	strings, server := suite.redisCache("counts:", NoExpiration)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	suite.T().Cleanup(func() { _ = client.Close() })
+	l, err := zap.NewDevelopment()
+	suite.Require().NoError(err)
+	ints := NewRedisCache(NewRedisCacheInput[int]{
+		Logger: l.Sugar(),
+		Client: client,
+		Codec:  Codec[int]{Encode: strconv.Itoa, Decode: strconv.Atoi},
+		Prefix: "counts:",
+		TTL:    NoExpiration,
+	})
+
+	ints.Insert("one", 1)
+	v, err := strings.Get("one")
+	suite.NoError(err)
+	suite.Equal("1", v)
+
+	// values that fail to decode are errors when looked up, and left out of items
+	strings.Insert("two", "two")
+	_, err = ints.Get("two")
+	suite.Error(err)
+	suite.Equal(map[string]Item[int]{"one": {Value: 1}}, ints.Items())
+}
+
+func (suite *CacheTestSuite) TestRedisCacheItemsAndRestore() {
+	c, _ := suite.redisCache("a:", NoExpiration)
+	c.Insert("forever", "a")
+	c.Insert("expiring", "b")
+	suite.NoError(c.SetExpiration("expiring", time.Hour))
+
+	items := c.Items()
+	suite.Require().Len(items, 2)
+	suite.True(items["forever"].Expiration.IsZero())
+	suite.WithinDuration(time.Now().Add(time.Hour), items["expiring"].Expiration, time.Minute)
+
+	restored, _ := suite.redisCache("b:", NoExpiration)
+	restored.Restore(items)
+	suite.Equal(items["forever"], restored.Items()["forever"])
+	suite.WithinDuration(items["expiring"].Expiration, restored.Items()["expiring"].Expiration, time.Second)
+}
@@ -12,52 +12,130 @@ import (
 	"google.golang.org/api/iterator"
 )
 
-var (
-	ClusterNameLabelKey = "goog-k8s-cluster-name"
-)
-
 type Client interface {
-	// ListInstancesBelongingToKubernetesCluster returns a map of all instances (key) and their corresponding Kubernetes cluster (value)
-	ListInstancesBelongingToKubernetesCluster(ctx context.Context) (map[string]string, error)
+	// ListWorkloadInstances returns a map of all instances (key) belonging to a recognised workload (value)
+	ListWorkloadInstances(ctx context.Context) (map[string]Workload, error)
+	// ListInstancesStoppedOnTermination returns a map of all instances (key) belonging to a recognised workload (value) that are stopped rather than deleted when terminated
+	ListInstancesStoppedOnTermination(ctx context.Context) (map[string]Workload, error)
 }
 
 type client struct {
 	instancesClient *compute.InstancesClient
 	log             *zap.SugaredLogger
-	projectID       string
+	projectIDs      []string
+	classifier      WorkloadClassifier
 }
 
 // NewClientInput defines all required fields to create a Client
 type NewClientInput struct {
-	Logger    *zap.SugaredLogger
-	ProjectID string
+	Logger *zap.SugaredLogger
+	// ProjectIDs are the projects whose instances are listed
+	ProjectIDs []string
+	// WorkloadClassifier determines the workload each instance belongs to, defaulting to DefaultWorkloadClassifier
+	WorkloadClassifier *WorkloadClassifier
+}
+
+// ProjectFromResourceID returns the project of a resource ID in the form projects/{project}/zones/{zone}/instances/{instance}
+func ProjectFromResourceID(resourceID string) string {
+	parts := strings.Split(resourceID, "/")
+	if len(parts) < 2 || parts[0] != "projects" {
+		return ""
+	}
+	return parts[1]
+}
+
+// ZoneFromResourceID returns the zone of a resource ID in the form projects/{project}/zones/{zone}/instances/{instance}
+func ZoneFromResourceID(resourceID string) string {
+	return resourceIDSegment(resourceID, "zones")
+}
+
+// InstanceFromResourceID returns the instance name of a resource ID in the form projects/{project}/zones/{zone}/instances/{instance}
+func InstanceFromResourceID(resourceID string) string {
+	return resourceIDSegment(resourceID, "instances")
+}
+
+// resourceIDSegment returns the segment following collection in a resource ID, e.g. the zone following zones
+func resourceIDSegment(resourceID, collection string) string {
+	parts := strings.Split(resourceID, "/")
+	for i := 0; i+1 < len(parts); i += 2 {
+		if parts[i] == collection {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// MachineTypeName returns the name of machineType, e.g. e2-standard-4, which may be a machine type name or URL
+func MachineTypeName(machineType string) string {
+	return machineType[strings.LastIndex(machineType, "/")+1:]
+}
+
+// MachineFamily returns the family of machineType, e.g. e2 for e2-standard-4, which may be a machine type name or URL.
+// Custom machine types without a family prefix are N1 machines.
+func MachineFamily(machineType string) string {
+	name := MachineTypeName(machineType)
+	if len(name) == 0 {
+		return ""
+	}
+	family, _, _ := strings.Cut(name, "-")
+	if family == "custom" {
+		return "n1"
+	}
+	return family
+}
+
+func (c *client) listInstancesWithFilter(ctx context.Context, filter string) (map[string]Workload, error) {
+	instancesToWorkload := make(map[string]Workload)
+	for _, projectID := range c.projectIDs {
+		if err := c.listProjectInstancesWithFilter(ctx, projectID, filter, instancesToWorkload); err != nil {
+			return nil, err
+		}
+	}
+	return instancesToWorkload, nil
 }
 
-func (c *client) listInstancesWithFilter(ctx context.Context, filter string) (map[string]string, error) {
-	instancesToCluster := make(map[string]string)
-	iter := c.instancesClient.AggregatedList(ctx, &computepb.AggregatedListInstancesRequest{
-		Filter:  &filter,
-		Project: c.projectID,
-	})
+func (c *client) listProjectInstancesWithFilter(ctx context.Context, projectID, filter string, instancesToWorkload map[string]Workload) error {
+	req := &computepb.AggregatedListInstancesRequest{
+		Project: projectID,
+	}
+	if len(filter) > 0 {
+		req.Filter = &filter
+	}
+	iter := c.instancesClient.AggregatedList(ctx, req)
 	for {
 		instancesInZone, err := iter.Next()
 		if errors.Is(err, iterator.Done) {
 			break
 		}
+		// the pair is nil when iterating fails
 		if err != nil {
-			return nil, fmt.Errorf("failed to iterate over compute instances in %s: %w", instancesInZone.Key, err)
+			return fmt.Errorf("failed to iterate over compute instances of project %s: %w", projectID, err)
 		}
-		for _, instance := range instancesInZone.Value.Instances {
-			// if the label didn't exist on the instance, it won't be in the list of instances
+		for _, instance := range instancesInZone.Value.GetInstances() {
+			metadata := make(map[string]string, len(instance.GetMetadata().GetItems()))
+			for _, item := range instance.GetMetadata().GetItems() {
+				metadata[item.GetKey()] = item.GetValue()
+			}
+			// the filter can match instances that do not belong to a workload, e.g. when classifying via metadata
+			workload, ok := c.classifier.Classify(instance.GetName(), instance.GetLabels(), metadata)
+			if !ok {
+				continue
+			}
+			workload.MachineType = MachineTypeName(instance.GetMachineType())
 			resourceID := strings.TrimPrefix(instance.GetSelfLink(), "https://www.googleapis.com/compute/v1/")
-			instancesToCluster[resourceID] = instance.Labels[ClusterNameLabelKey]
+			instancesToWorkload[resourceID] = workload
 		}
 	}
-	return instancesToCluster, nil
+	return nil
 }
 
-func (c *client) ListInstancesBelongingToKubernetesCluster(ctx context.Context) (map[string]string, error) {
-	queryFilter := `labels.goog-k8s-cluster-name:*`
+func (c *client) ListWorkloadInstances(ctx context.Context) (map[string]Workload, error) {
+	return c.listInstancesWithFilter(ctx, c.classifier.Filter())
+}
+
+func (c *client) ListInstancesStoppedOnTermination(ctx context.Context) (map[string]Workload, error) {
+	// instances that do not belong to a workload are dropped when classifying them
+	queryFilter := `scheduling.instanceTerminationAction = "STOP"`
 	return c.listInstancesWithFilter(ctx, queryFilter)
 }
 
@@ -66,9 +144,14 @@ func NewClient(ctx context.Context, input NewClientInput) (Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create compute client: %w", err)
 	}
+	classifier := DefaultWorkloadClassifier()
+	if input.WorkloadClassifier != nil {
+		classifier = *input.WorkloadClassifier
+	}
 	return &client{
 		instancesClient: c,
 		log:             input.Logger,
-		projectID:       input.ProjectID,
+		projectIDs:      input.ProjectIDs,
+		classifier:      classifier,
 	}, nil
 }
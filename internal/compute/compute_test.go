@@ -21,15 +21,15 @@ func TestComputeTestSuite(t *testing.T) {
 func (suite *ComputeTestSuite) SetupSuite() {
 }
 
-func (suite *ComputeTestSuite) TestGetInstancesBelongingToKubernetesClusters() {
+func (suite *ComputeTestSuite) TestListWorkloadInstances() {
 	l, err := zap.NewDevelopment()
 	suite.NoError(err)
 	logger := l.Sugar()
 	c, err := NewClient(context.Background(), NewClientInput{
-		Logger:    logger,
-		ProjectID: "generic-project",
+		Logger:     logger,
+		ProjectIDs: []string{"generic-project"},
 	})
-	res, err := c.ListInstancesBelongingToKubernetesCluster(context.Background())
+	res, err := c.ListWorkloadInstances(context.Background())
 	suite.NoError(err)
 	suite.NotEmpty(res)
 }
@@ -0,0 +1,93 @@
+package compute
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultClusterNameLabelKey is the label GKE sets on every node with the name of its cluster
+const DefaultClusterNameLabelKey = "goog-k8s-cluster-name"
+
+// ClusterIdentity resolves the Kubernetes cluster an instance belongs to.
+// Label keys are checked first and in order, followed by the metadata key and lastly the instance name pattern.
+type ClusterIdentity struct {
+	// LabelKeys are instance labels whose value is the cluster name
+	LabelKeys []string
+	// MetadataKey is an instance metadata key whose value is the cluster name
+	MetadataKey string
+	// NamePattern extracts the cluster name from the instance name, using the capture group named "cluster" if present,
+	// otherwise the first capture group
+	NamePattern *regexp.Regexp
+}
+
+// DefaultClusterIdentity resolves the cluster of GKE nodes
+func DefaultClusterIdentity() ClusterIdentity {
+	return ClusterIdentity{
+		LabelKeys: []string{DefaultClusterNameLabelKey},
+	}
+}
+
+// NewClusterIdentity creates a ClusterIdentity, compiling namePattern if it is not empty
+func NewClusterIdentity(labelKeys []string, metadataKey, namePattern string) (ClusterIdentity, error) {
+	identity := ClusterIdentity{
+		LabelKeys:   labelKeys,
+		MetadataKey: metadataKey,
+	}
+	if len(namePattern) > 0 {
+		r, err := regexp.Compile(namePattern)
+		if err != nil {
+			return ClusterIdentity{}, fmt.Errorf("failed to compile instance name pattern: %w", err)
+		}
+		if r.NumSubexp() == 0 {
+			return ClusterIdentity{}, fmt.Errorf("instance name pattern %s must contain a capture group", namePattern)
+		}
+		identity.NamePattern = r
+	}
+	if len(identity.LabelKeys) == 0 && len(identity.MetadataKey) == 0 && identity.NamePattern == nil {
+		return ClusterIdentity{}, fmt.Errorf("at least one of label keys, metadata key or instance name pattern must be set")
+	}
+	return identity, nil
+}
+
+// Resolve returns the cluster an instance with the given name, labels and metadata belongs to, and false if it belongs to none
+func (c ClusterIdentity) Resolve(name string, labels, metadata map[string]string) (string, bool) {
+	for _, k := range c.LabelKeys {
+		for labelKey, v := range labels {
+			if strings.EqualFold(labelKey, k) && len(v) > 0 {
+				return v, true
+			}
+		}
+	}
+	if len(c.MetadataKey) > 0 {
+		if v, ok := metadata[c.MetadataKey]; ok && len(v) > 0 {
+			return v, true
+		}
+	}
+	if c.NamePattern != nil {
+		match := c.NamePattern.FindStringSubmatch(name)
+		if match == nil {
+			return "", false
+		}
+		if i := c.NamePattern.SubexpIndex("cluster"); i > 0 && len(match[i]) > 0 {
+			return match[i], true
+		}
+		if len(match[1]) > 0 {
+			return match[1], true
+		}
+	}
+	return "", false
+}
+
+// Filter returns a compute API filter matching instances that may belong to a cluster.
+// Metadata and instance names cannot be combined with label filters, so if either is used all instances are matched.
+func (c ClusterIdentity) Filter() string {
+	if len(c.MetadataKey) > 0 || c.NamePattern != nil {
+		return ""
+	}
+	expressions := make([]string, 0, len(c.LabelKeys))
+	for _, k := range c.LabelKeys {
+		expressions = append(expressions, fmt.Sprintf("(labels.%s:*)", k))
+	}
+	return strings.Join(expressions, " OR ")
+}
@@ -0,0 +1,90 @@
+package compute
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type IdentityTestSuite struct {
+	suite.Suite
+}
+
+func TestIdentityTestSuite(t *testing.T) {
+	suite.Run(t, new(IdentityTestSuite))
+}
+
+func (suite *IdentityTestSuite) TestResolve() {
+	identity, err := NewClusterIdentity([]string{"cluster-name", DefaultClusterNameLabelKey}, "kube-cluster", `^(?P<cluster>[a-z]+)-node-\d+$`)
+	suite.NoError(err)
+
+	tests := map[string]struct {
+		name     string
+		labels   map[string]string
+		metadata map[string]string
+		cluster  string
+		found    bool
+	}{
+		"first label key wins": {
+			labels:  map[string]string{DefaultClusterNameLabelKey: "gke", "cluster-name": "rancher"},
+			cluster: "rancher",
+			found:   true,
+		},
+		"later label key": {
+			labels:  map[string]string{DefaultClusterNameLabelKey: "gke"},
+			cluster: "gke",
+			found:   true,
+		},
+		"labels before metadata": {
+			labels:   map[string]string{"cluster-name": "rancher"},
+			metadata: map[string]string{"kube-cluster": "kubeadm"},
+			cluster:  "rancher",
+			found:    true,
+		},
+		"metadata before name": {
+			name:     "named-node-1",
+			metadata: map[string]string{"kube-cluster": "kubeadm"},
+			cluster:  "kubeadm",
+			found:    true,
+		},
+		"name": {
+			name:    "named-node-1",
+			cluster: "named",
+			found:   true,
+		},
+		"none": {
+			name:   "standalone-vm",
+			labels: map[string]string{"team": "data"},
+		},
+	}
+	for name, tc := range tests {
+		suite.Run(name, func() {
+			cluster, found := identity.Resolve(tc.name, tc.labels, tc.metadata)
+			suite.Equal(tc.found, found)
+			suite.Equal(tc.cluster, cluster)
+		})
+	}
+}
+
+func (suite *IdentityTestSuite) TestNewClusterIdentity() {
+	_, err := NewClusterIdentity(nil, "", "")
+	suite.Error(err)
+
+	_, err = NewClusterIdentity(nil, "", "^no-capture-group$")
+	suite.Error(err)
+
+	_, err = NewClusterIdentity(nil, "", "(")
+	suite.Error(err)
+}
+
+func (suite *IdentityTestSuite) TestFilter() {
+	suite.Equal("(labels.goog-k8s-cluster-name:*)", DefaultClusterIdentity().Filter())
+
+	identity, err := NewClusterIdentity([]string{"cluster-name", DefaultClusterNameLabelKey}, "", "")
+	suite.NoError(err)
+	suite.Equal("(labels.cluster-name:*) OR (labels.goog-k8s-cluster-name:*)", identity.Filter())
+
+	identity, err = NewClusterIdentity([]string{"cluster-name"}, "kube-cluster", "")
+	suite.NoError(err)
+	suite.Empty(identity.Filter())
+}
@@ -0,0 +1,54 @@
+package compute
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ResourceIDTestSuite struct {
+	suite.Suite
+}
+
+func TestResourceIDTestSuite(t *testing.T) {
+	suite.Run(t, new(ResourceIDTestSuite))
+}
+
+func (suite *ResourceIDTestSuite) TestProjectFromResourceID() {
+	tests := map[string]struct {
+		resourceID string
+		project    string
+	}{
+		"instance":                {resourceID: "projects/mock-project/zones/europe-west1-c/instances/fake-resource", project: "mock-project"},
+		"project":                 {resourceID: "projects/mock-project", project: "mock-project"},
+		"numeric project":         {resourceID: "projects/123456789/zones/europe-west1-c/instanceGroupManagers/render-farm", project: "123456789"},
+		"empty":                   {resourceID: ""},
+		"no project":              {resourceID: "projects"},
+		"empty project":           {resourceID: "projects//zones/europe-west1-c/instances/fake-resource"},
+		"missing projects prefix": {resourceID: "zones/europe-west1-c/instances/fake-resource"},
+		"leading slash":           {resourceID: "/projects/mock-project/zones/europe-west1-c/instances/fake-resource"},
+		"self link":               {resourceID: "https://www.googleapis.com/compute/v1/projects/mock-project/zones/europe-west1-c/instances/fake-resource"},
+		"full resource name":      {resourceID: "//compute.googleapis.com/projects/mock-project/zones/europe-west1-c/instances/fake-resource"},
+	}
+	for name, tc := range tests {
+		suite.Equal(tc.project, ProjectFromResourceID(tc.resourceID), name)
+	}
+}
+
+func (suite *ResourceIDTestSuite) TestZoneAndInstanceFromResourceID() {
+	tests := map[string]struct {
+		resourceID string
+		zone       string
+		instance   string
+	}{
+		"instance":         {resourceID: "projects/mock-project/zones/europe-west1-c/instances/fake-resource", zone: "europe-west1-c", instance: "fake-resource"},
+		"zone":             {resourceID: "projects/mock-project/zones/europe-west1-c", zone: "europe-west1-c"},
+		"empty":            {resourceID: ""},
+		"truncated":        {resourceID: "projects/mock-project/zones"},
+		"collection names": {resourceID: "projects/zones/zones/instances", zone: "instances"},
+	}
+	for name, tc := range tests {
+		suite.Equal(tc.zone, ZoneFromResourceID(tc.resourceID), name)
+		suite.Equal(tc.instance, InstanceFromResourceID(tc.resourceID), name)
+	}
+}
@@ -0,0 +1,160 @@
+package compute
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WorkloadType is the kind of workload an instance is running
+type WorkloadType string
+
+const (
+	// WorkloadTypeKubernetes is a node of a Kubernetes cluster, as resolved by a ClusterIdentity
+	WorkloadTypeKubernetes WorkloadType = "kubernetes"
+	// WorkloadTypeDataproc is a node of a Dataproc cluster
+	WorkloadTypeDataproc WorkloadType = "dataproc"
+	// WorkloadTypeBatch is a VM running tasks of a Batch job
+	WorkloadTypeBatch WorkloadType = "batch"
+	// WorkloadTypeMIG is a member of a managed instance group that is none of the above
+	WorkloadTypeMIG WorkloadType = "mig"
+)
+
+const (
+	// DataprocClusterNameLabelKey is the label Dataproc sets on every node with the name of its cluster
+	DataprocClusterNameLabelKey = "goog-dataproc-cluster-name"
+	// BatchJobUIDLabelKey is the label Batch sets on every VM with the UID of the job it runs
+	BatchJobUIDLabelKey = "goog-batch-job-uid"
+	// NodePoolLabelKey is the label GKE sets on every node with the name of its node pool
+	NodePoolLabelKey = "goog-k8s-node-pool-name"
+	// CreatedByMetadataKey is the metadata key managed instance groups set on their members with the group's URL
+	CreatedByMetadataKey = "created-by"
+)
+
+// Workload identifies what an instance was running
+type Workload struct {
+	Type WorkloadType
+	Name string
+	// NodePool is the node pool of a Kubernetes node, if known
+	NodePool string
+	// MachineType is the machine type of the instance, e.g. e2-standard-4, if known
+	MachineType string
+}
+
+// String encodes the workload as type/name, followed by /node-pool and /machine-type if either is known, the inverse of ParseWorkload.
+// Machine types are encoded by name, so that no segment contains a slash.
+func (w Workload) String() string {
+	switch {
+	case len(w.MachineType) > 0:
+		return fmt.Sprintf("%s/%s/%s/%s", w.Type, w.Name, w.NodePool, MachineTypeName(w.MachineType))
+	case len(w.NodePool) > 0:
+		return fmt.Sprintf("%s/%s/%s", w.Type, w.Name, w.NodePool)
+	}
+	return fmt.Sprintf("%s/%s", w.Type, w.Name)
+}
+
+// KubernetesCluster returns the name of the Kubernetes cluster of the workload, or an empty string if it is not a Kubernetes workload
+func (w Workload) KubernetesCluster() string {
+	if w.Type != WorkloadTypeKubernetes {
+		return ""
+	}
+	return w.Name
+}
+
+// ParseWorkload decodes a workload encoded by Workload.String. Workloads encoded with a machine family rather than a machine
+// type, e.g. kubernetes/prod/pool/e2, decode to a machine type of the family, whose MachineFamily is the same.
+func ParseWorkload(s string) (Workload, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 4 {
+		return Workload{}, fmt.Errorf("invalid workload %q, expected type/name[/node-pool[/machine-type]]", s)
+	}
+	w := Workload{Type: WorkloadType(parts[0]), Name: parts[1]}
+	if len(parts) > 2 {
+		w.NodePool = parts[2]
+	}
+	if len(parts) > 3 {
+		w.MachineType = parts[3]
+	}
+	return w, nil
+}
+
+// WorkloadClassifier determines the workload an instance belongs to
+type WorkloadClassifier struct {
+	identity ClusterIdentity
+	types    map[WorkloadType]bool
+}
+
+// NewWorkloadClassifier creates a WorkloadClassifier recognising the given types, using identity to resolve Kubernetes clusters
+func NewWorkloadClassifier(identity ClusterIdentity, types ...WorkloadType) (WorkloadClassifier, error) {
+	c := WorkloadClassifier{
+		identity: identity,
+		types:    make(map[WorkloadType]bool, len(types)),
+	}
+	for _, t := range types {
+		switch t {
+		case WorkloadTypeKubernetes, WorkloadTypeDataproc, WorkloadTypeBatch, WorkloadTypeMIG:
+			c.types[t] = true
+		default:
+			return WorkloadClassifier{}, fmt.Errorf("unsupported workload type %q", t)
+		}
+	}
+	if len(c.types) == 0 {
+		return WorkloadClassifier{}, fmt.Errorf("at least one workload type must be recognised")
+	}
+	return c, nil
+}
+
+// DefaultWorkloadClassifier only recognises nodes of GKE clusters
+func DefaultWorkloadClassifier() WorkloadClassifier {
+	c, _ := NewWorkloadClassifier(DefaultClusterIdentity(), WorkloadTypeKubernetes)
+	return c
+}
+
+// Classify returns the workload an instance with the given name, labels and metadata belongs to, and false if it belongs to none.
+// As Kubernetes and Dataproc nodes are usually also members of managed instance groups, the more specific types are checked first.
+func (c WorkloadClassifier) Classify(name string, labels, metadata map[string]string) (Workload, bool) {
+	if c.types[WorkloadTypeKubernetes] {
+		if cluster, ok := c.identity.Resolve(name, labels, metadata); ok {
+			return Workload{Type: WorkloadTypeKubernetes, Name: cluster, NodePool: labels[NodePoolLabelKey]}, true
+		}
+	}
+	if c.types[WorkloadTypeDataproc] {
+		if cluster, ok := labels[DataprocClusterNameLabelKey]; ok && len(cluster) > 0 {
+			return Workload{Type: WorkloadTypeDataproc, Name: cluster}, true
+		}
+	}
+	if c.types[WorkloadTypeBatch] {
+		if job, ok := labels[BatchJobUIDLabelKey]; ok && len(job) > 0 {
+			return Workload{Type: WorkloadTypeBatch, Name: job}, true
+		}
+	}
+	if c.types[WorkloadTypeMIG] {
+		// created-by is of the form projects/{project_number}/zones/{zone}/instanceGroupManagers/{name}
+		if createdBy, ok := metadata[CreatedByMetadataKey]; ok && strings.Contains(createdBy, "/instanceGroupManagers/") {
+			return Workload{Type: WorkloadTypeMIG, Name: createdBy[strings.LastIndex(createdBy, "/")+1:]}, true
+		}
+	}
+	return Workload{}, false
+}
+
+// Filter returns a compute API filter matching instances that may belong to a recognised workload.
+// Managed instance group membership can only be determined from metadata, so if it is recognised all instances are matched.
+func (c WorkloadClassifier) Filter() string {
+	if c.types[WorkloadTypeMIG] {
+		return ""
+	}
+	var expressions []string
+	if c.types[WorkloadTypeKubernetes] {
+		identityFilter := c.identity.Filter()
+		if len(identityFilter) == 0 {
+			return ""
+		}
+		expressions = append(expressions, identityFilter)
+	}
+	if c.types[WorkloadTypeDataproc] {
+		expressions = append(expressions, fmt.Sprintf("(labels.%s:*)", DataprocClusterNameLabelKey))
+	}
+	if c.types[WorkloadTypeBatch] {
+		expressions = append(expressions, fmt.Sprintf("(labels.%s:*)", BatchJobUIDLabelKey))
+	}
+	return strings.Join(expressions, " OR ")
+}
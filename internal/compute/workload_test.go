@@ -0,0 +1,125 @@
+package compute
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type WorkloadTestSuite struct {
+	suite.Suite
+}
+
+func TestWorkloadTestSuite(t *testing.T) {
+	suite.Run(t, new(WorkloadTestSuite))
+}
+
+func (suite *WorkloadTestSuite) TestClassify() {
+	classifier, err := NewWorkloadClassifier(DefaultClusterIdentity(), WorkloadTypeKubernetes, WorkloadTypeDataproc, WorkloadTypeBatch, WorkloadTypeMIG)
+	suite.NoError(err)
+	createdBy := map[string]string{CreatedByMetadataKey: "projects/123456789/zones/europe-west1-c/instanceGroupManagers/render-farm"}
+
+	tests := map[string]struct {
+		labels   map[string]string
+		metadata map[string]string
+		workload Workload
+		found    bool
+	}{
+		"gke node in a mig": {
+			labels:   map[string]string{DefaultClusterNameLabelKey: "gke", NodePoolLabelKey: "spot-pool"},
+			metadata: createdBy,
+			workload: Workload{Type: WorkloadTypeKubernetes, Name: "gke", NodePool: "spot-pool"},
+			found:    true,
+		},
+		"dataproc node in a mig": {
+			labels:   map[string]string{DataprocClusterNameLabelKey: "analytics"},
+			metadata: createdBy,
+			workload: Workload{Type: WorkloadTypeDataproc, Name: "analytics"},
+			found:    true,
+		},
+		"batch vm": {
+			labels:   map[string]string{BatchJobUIDLabelKey: "job-1234"},
+			workload: Workload{Type: WorkloadTypeBatch, Name: "job-1234"},
+			found:    true,
+		},
+		"mig member": {
+			metadata: createdBy,
+			workload: Workload{Type: WorkloadTypeMIG, Name: "render-farm"},
+			found:    true,
+		},
+		"standalone vm": {
+			metadata: map[string]string{CreatedByMetadataKey: "someone"},
+		},
+	}
+	for name, tc := range tests {
+		suite.Run(name, func() {
+			workload, found := classifier.Classify("instance", tc.labels, tc.metadata)
+			suite.Equal(tc.found, found)
+			suite.Equal(tc.workload, workload)
+		})
+	}
+}
+
+func (suite *WorkloadTestSuite) TestClassifyOnlyRecognisedTypes() {
+	_, found := DefaultWorkloadClassifier().Classify("instance", map[string]string{DataprocClusterNameLabelKey: "analytics"}, nil)
+	suite.False(found)
+
+	_, err := NewWorkloadClassifier(DefaultClusterIdentity(), "unknown")
+	suite.Error(err)
+
+	_, err = NewWorkloadClassifier(DefaultClusterIdentity())
+	suite.Error(err)
+}
+
+func (suite *WorkloadTestSuite) TestFilter() {
+	suite.Equal("(labels.goog-k8s-cluster-name:*)", DefaultWorkloadClassifier().Filter())
+
+	classifier, err := NewWorkloadClassifier(DefaultClusterIdentity(), WorkloadTypeKubernetes, WorkloadTypeDataproc, WorkloadTypeBatch)
+	suite.NoError(err)
+	suite.Equal("(labels.goog-k8s-cluster-name:*) OR (labels.goog-dataproc-cluster-name:*) OR (labels.goog-batch-job-uid:*)", classifier.Filter())
+
+	classifier, err = NewWorkloadClassifier(DefaultClusterIdentity(), WorkloadTypeKubernetes, WorkloadTypeMIG)
+	suite.NoError(err)
+	suite.Empty(classifier.Filter())
+}
+
+func (suite *WorkloadTestSuite) TestParseWorkload() {
+	w := Workload{Type: WorkloadTypeMIG, Name: "render-farm"}
+	parsed, err := ParseWorkload(w.String())
+	suite.NoError(err)
+	suite.Equal(w, parsed)
+
+	w = Workload{Type: WorkloadTypeKubernetes, Name: "gke", NodePool: "spot-pool"}
+	suite.Equal("kubernetes/gke/spot-pool", w.String())
+	parsed, err = ParseWorkload(w.String())
+	suite.NoError(err)
+	suite.Equal(w, parsed)
+
+	w = Workload{Type: WorkloadTypeBatch, Name: "job", MachineType: "c3-standard-4"}
+	suite.Equal("batch/job//c3-standard-4", w.String())
+	parsed, err = ParseWorkload(w.String())
+	suite.NoError(err)
+	suite.Equal(w, parsed)
+
+	// machine types given as URLs are encoded by name
+	w = Workload{Type: WorkloadTypeBatch, Name: "job", MachineType: "zones/europe-west1-c/machineTypes/c3-standard-4"}
+	suite.Equal("batch/job//c3-standard-4", w.String())
+
+	// workloads encoded with their machine family keep it
+	parsed, err = ParseWorkload("kubernetes/gke/spot-pool/e2")
+	suite.NoError(err)
+	suite.Equal("e2", MachineFamily(parsed.MachineType))
+
+	_, err = ParseWorkload("no-type")
+	suite.Error(err)
+	_, err = ParseWorkload("batch/job//zones/europe-west1-c/machineTypes/c3-standard-4")
+	suite.Error(err)
+}
+
+func (suite *WorkloadTestSuite) TestMachineFamily() {
+	suite.Equal("e2", MachineFamily("https://www.googleapis.com/compute/v1/projects/p/zones/europe-west1-c/machineTypes/e2-standard-4"))
+	suite.Equal("n2d", MachineFamily("zones/europe-west1-c/machineTypes/n2d-custom-4-8192"))
+	suite.Equal("n1", MachineFamily("custom-2-4096"))
+	suite.Empty(MachineFamily(""))
+	suite.Equal("e2-standard-4", MachineTypeName("zones/europe-west1-c/machineTypes/e2-standard-4"))
+}
@@ -0,0 +1,125 @@
+// Package dedup suppresses interruption events that were already handled, which can reach the exporter more than once
+//
+//go:generate mockery --name Deduplicator
+package dedup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
+)
+
+// Strategy is what events are keyed on to tell whether they were already handled
+type Strategy string
+
+const (
+	// StrategyMessageID keys events on their pubsub message ID, which only catches pubsub delivering a message more than once
+	StrategyMessageID Strategy = "message_id"
+	// StrategyInsertID keys events on the insertId of their log entry, which also catches an entry routed through more than one sink
+	StrategyInsertID Strategy = "insert_id"
+	// StrategyOperationID keys events on the ID of the operation they belong to, which also catches several entries logged for one operation
+	StrategyOperationID Strategy = "operation_id"
+	// StrategyResourceWindow keys events on the resource and kind of the event within a time bucket of the window, which also catches
+	// replays of entries that were logged again
+	StrategyResourceWindow Strategy = "resource_window"
+)
+
+// DefaultWindow is how long events are remembered for unless configured otherwise
+const DefaultWindow = time.Minute * 10
+
+// Event identifies an interruption event in every way a Strategy can key it on
+type Event struct {
+	MessageID string
+	// InsertID is the insertId of the log entry, which may be empty
+	InsertID string
+	// OperationID is the ID of the operation the log entry belongs to, which may be empty
+	OperationID string
+	ResourceID  string
+	// Kind is what happened to the resource, e.g. preempted, so that different events for one resource are not mistaken for each other
+	Kind      string
+	Timestamp time.Time
+}
+
+// Deduplicator tells apart events that were already handled
+type Deduplicator interface {
+	// Seen records e as handled, returning true if an event with the same key already was within the window, or an error
+	// if the keys of handled events could not be reached
+	Seen(e Event) (bool, error)
+	// Strategy returns what events are keyed on
+	Strategy() Strategy
+}
+
+// NewDeduplicatorInput defines all required fields to create a Deduplicator
+type NewDeduplicatorInput struct {
+	// Strategy defaults to StrategyMessageID
+	Strategy Strategy
+	// Window is how long events are remembered for, and the size of the time buckets of StrategyResourceWindow, defaulting to DefaultWindow
+	Window time.Duration
+	// Keys holds the keys of handled events, and must expire them after Window
+	Keys cache.Cache[string, string]
+}
+
+type deduplicator struct {
+	strategy Strategy
+	window   time.Duration
+	keys     cache.Cache[string, string]
+}
+
+// NewDeduplicator creates a Deduplicator, returning an error for unsupported strategies
+func NewDeduplicator(input NewDeduplicatorInput) (Deduplicator, error) {
+	strategy := input.Strategy
+	if len(strategy) == 0 {
+		strategy = StrategyMessageID
+	}
+	switch strategy {
+	case StrategyMessageID, StrategyInsertID, StrategyOperationID, StrategyResourceWindow:
+	default:
+		return nil, fmt.Errorf("unsupported deduplication strategy %q, expected %s, %s, %s or %s", strategy, StrategyMessageID, StrategyInsertID, StrategyOperationID, StrategyResourceWindow)
+	}
+	return &deduplicator{
+		strategy: strategy,
+		window:   WindowOrDefault(input.Window),
+		keys:     input.Keys,
+	}, nil
+}
+
+// WindowOrDefault returns w, or DefaultWindow if w is not positive
+func WindowOrDefault(w time.Duration) time.Duration {
+	if w <= 0 {
+		return DefaultWindow
+	}
+	return w
+}
+
+func (d *deduplicator) Strategy() Strategy {
+	return d.strategy
+}
+
+func (d *deduplicator) Seen(e Event) (bool, error) {
+	inserted, err := d.keys.InsertIfAbsent(d.key(e), "")
+	if err != nil {
+		return false, fmt.Errorf("failed to record event as handled: %w", err)
+	}
+	return !inserted, nil
+}
+
+// key returns the key of e under the strategy, falling back to its message ID if e lacks the field the strategy keys on.
+// Message IDs are kept unprefixed, so that those already recorded by earlier versions still match.
+func (d *deduplicator) key(e Event) string {
+	switch d.strategy {
+	case StrategyInsertID:
+		if len(e.InsertID) > 0 {
+			return fmt.Sprintf("%s:%s", d.strategy, e.InsertID)
+		}
+	case StrategyOperationID:
+		if len(e.OperationID) > 0 {
+			return fmt.Sprintf("%s:%s", d.strategy, e.OperationID)
+		}
+	case StrategyResourceWindow:
+		if len(e.ResourceID) > 0 && !e.Timestamp.IsZero() {
+			return fmt.Sprintf("%s:%s:%s:%d", d.strategy, e.ResourceID, e.Kind, e.Timestamp.Truncate(d.window).Unix())
+		}
+	}
+	return e.MessageID
+}
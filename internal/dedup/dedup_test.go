@@ -0,0 +1,121 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
+)
+
+type DedupTestSuite struct {
+	suite.Suite
+	now time.Time
+}
+
+func TestDedupTestSuite(t *testing.T) {
+	suite.Run(t, new(DedupTestSuite))
+}
+
+func (suite *DedupTestSuite) SetupTest() {
+	suite.now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func (suite *DedupTestSuite) deduplicator(strategy Strategy, window time.Duration) Deduplicator {
+	keys := cache.New(cache.NewInput[string, string]{TTL: window, Now: func() time.Time { return suite.now }})
+	d, err := NewDeduplicator(NewDeduplicatorInput{Strategy: strategy, Window: window, Keys: keys})
+	suite.Require().NoError(err)
+	return d
+}
+
+// seen returns whether d has seen e, failing the test if its keys could not be reached
+func (suite *DedupTestSuite) seen(d Deduplicator, e Event) bool {
+	seen, err := d.Seen(e)
+	suite.Require().NoError(err)
+	return seen
+}
+
+func (suite *DedupTestSuite) TestUnsupportedStrategy() {
+	_, err := NewDeduplicator(NewDeduplicatorInput{Strategy: "payload", Keys: cache.NewWithTTL[string, string](DefaultWindow)})
+	suite.Error(err)
+}
+
+func (suite *DedupTestSuite) TestDefaultStrategy() {
+	d, err := NewDeduplicator(NewDeduplicatorInput{Keys: cache.NewWithTTL[string, string](DefaultWindow)})
+	suite.Require().NoError(err)
+	suite.Equal(StrategyMessageID, d.Strategy())
+}
+
+func (suite *DedupTestSuite) TestStrategies() {
+	resource := "projects/p/zones/europe-west1-c/instances/i"
+	original := Event{MessageID: "1", InsertID: "a", OperationID: "op", ResourceID: resource, Kind: "preempted", Timestamp: suite.now}
+	tests := []struct {
+		strategy Strategy
+		// duplicate is the original logged again, which only StrategyResourceWindow recognises
+		duplicate Event
+		// distinct is an event each strategy must handle
+		distinct Event
+		seen     bool
+	}{
+		{
+			strategy:  StrategyMessageID,
+			duplicate: Event{MessageID: "2", InsertID: "a", OperationID: "op", ResourceID: resource, Kind: "preempted", Timestamp: suite.now},
+			distinct:  Event{MessageID: "3", InsertID: "b", OperationID: "op2", ResourceID: resource, Kind: "preempted", Timestamp: suite.now},
+			seen:      false,
+		},
+		{
+			strategy:  StrategyInsertID,
+			duplicate: Event{MessageID: "2", InsertID: "a", OperationID: "op", ResourceID: resource, Kind: "preempted", Timestamp: suite.now},
+			distinct:  Event{MessageID: "3", InsertID: "b", OperationID: "op", ResourceID: resource, Kind: "preempted", Timestamp: suite.now},
+			seen:      true,
+		},
+		{
+			strategy:  StrategyOperationID,
+			duplicate: Event{MessageID: "2", InsertID: "b", OperationID: "op", ResourceID: resource, Kind: "preempted", Timestamp: suite.now},
+			distinct:  Event{MessageID: "3", InsertID: "c", OperationID: "op2", ResourceID: resource, Kind: "preempted", Timestamp: suite.now},
+			seen:      true,
+		},
+		{
+			strategy:  StrategyResourceWindow,
+			duplicate: Event{MessageID: "2", InsertID: "b", OperationID: "op2", ResourceID: resource, Kind: "preempted", Timestamp: suite.now.Add(time.Minute)},
+			distinct:  Event{MessageID: "3", InsertID: "c", OperationID: "op3", ResourceID: resource, Kind: "host_error", Timestamp: suite.now},
+			seen:      true,
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(string(tt.strategy), func() {
+			d := suite.deduplicator(tt.strategy, time.Minute*10)
+			suite.False(suite.seen(d, original))
+			suite.True(suite.seen(d, original), "a redelivered message is always seen")
+			suite.Equal(tt.seen, suite.seen(d, tt.duplicate))
+			suite.False(suite.seen(d, tt.distinct))
+		})
+	}
+}
+
+func (suite *DedupTestSuite) TestFallsBackToMessageID() {
+	d := suite.deduplicator(StrategyInsertID, time.Minute*10)
+	suite.False(suite.seen(d, Event{MessageID: "1"}))
+	suite.True(suite.seen(d, Event{MessageID: "1"}))
+	suite.False(suite.seen(d, Event{MessageID: "2"}))
+}
+
+func (suite *DedupTestSuite) TestWindow() {
+	d := suite.deduplicator(StrategyMessageID, time.Minute*10)
+	suite.False(suite.seen(d, Event{MessageID: "1"}))
+	suite.now = suite.now.Add(time.Minute*10 - time.Second)
+	suite.True(suite.seen(d, Event{MessageID: "1"}))
+	suite.now = suite.now.Add(time.Second)
+	suite.False(suite.seen(d, Event{MessageID: "1"}))
+}
+
+func (suite *DedupTestSuite) TestResourceWindowBuckets() {
+	d := suite.deduplicator(StrategyResourceWindow, time.Minute*10)
+	event := func(id string, t time.Time) Event {
+		return Event{MessageID: id, ResourceID: "projects/p/zones/z/instances/i", Kind: "preempted", Timestamp: t}
+	}
+	suite.False(suite.seen(d, event("1", suite.now)))
+	suite.True(suite.seen(d, event("2", suite.now.Add(time.Minute*9))))
+	// the next bucket is a separate preemption
+	suite.False(suite.seen(d, event("3", suite.now.Add(time.Minute*10))))
+}
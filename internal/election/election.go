@@ -0,0 +1,128 @@
+// Package election elects a single leader among replicas of the exporter with a Kubernetes Lease
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	defaultLeaseDuration = time.Second * 15
+	defaultRenewDeadline = time.Second * 10
+	defaultRetryPeriod   = time.Second * 2
+)
+
+// ErrLeadershipLost is returned by Run when the lease could not be renewed in time
+var ErrLeadershipLost = errors.New("leadership lost")
+
+// RunInput defines all required fields to campaign for leadership
+type RunInput struct {
+	Logger    *zap.SugaredLogger
+	Client    kubernetes.Interface
+	Namespace string
+	// LeaseName is the name of the Lease replicas campaign for, which is created if it does not exist
+	LeaseName string
+	// Identity tells replicas apart, defaulting to the hostname, which is the name of the pod
+	Identity string
+	// LeaseDuration is how long replicas wait for an unrenewed lease before taking it over, defaulting to 15 seconds
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader retries renewing the lease for before giving up leadership, defaulting to 10 seconds
+	RenewDeadline time.Duration
+	// RetryPeriod is how often replicas try to acquire or renew the lease, defaulting to 2 seconds
+	RetryPeriod time.Duration
+}
+
+// Run campaigns for leadership until ctx is done, calling lead once elected with a context that is cancelled when ctx is done or
+// leadership is lost. The lease is released as soon as lead returns, so that another replica can take over without waiting for it
+// to expire. Run returns the error lead returns, or ErrLeadershipLost if leadership was lost while ctx was not done.
+func Run(ctx context.Context, input RunInput, lead func(ctx context.Context) error) error {
+	identity := input.Identity
+	if len(identity) == 0 {
+		var err error
+		if identity, err = os.Hostname(); err != nil {
+			return fmt.Errorf("failed to determine identity: %w", err)
+		}
+	}
+	log := input.Logger.With("lease", input.Namespace+"/"+input.LeaseName, "identity", identity)
+
+	leading := make(chan context.Context, 1)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: input.LeaseName, Namespace: input.Namespace},
+			Client:     input.Client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   durationOrDefault(input.LeaseDuration, defaultLeaseDuration),
+		RenewDeadline:   durationOrDefault(input.RenewDeadline, defaultRenewDeadline),
+		RetryPeriod:     durationOrDefault(input.RetryPeriod, defaultRetryPeriod),
+		ReleaseOnCancel: true,
+		Name:            input.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				log.Info("elected leader")
+				leading <- leaderCtx
+			},
+			OnStoppedLeading: func() {
+				log.Info("stopped leading")
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.With("leader", leader).Info("following leader")
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to configure leader election: %w", err)
+	}
+
+	// the election outlives ctx until lead has returned, so the lease is only released once leading has stopped
+	electionCtx, stopElection := context.WithCancel(context.Background())
+	defer stopElection()
+	electionDone := make(chan struct{})
+	go func() {
+		defer close(electionDone)
+		elector.Run(electionCtx)
+	}()
+
+	select {
+	case <-ctx.Done():
+		stopElection()
+		<-electionDone
+		return nil
+	case <-electionDone:
+		return ErrLeadershipLost
+	case leaderCtx := <-leading:
+		leadCtx, cancelLead := context.WithCancel(leaderCtx)
+		stop := context.AfterFunc(ctx, cancelLead)
+		err := lead(leadCtx)
+		stop()
+		cancelLead()
+		lost := ctx.Err() == nil && leaderCtx.Err() != nil
+		stopElection()
+		<-electionDone
+		if err != nil {
+			return err
+		}
+		if lost {
+			return ErrLeadershipLost
+		}
+		return nil
+	}
+}
+
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
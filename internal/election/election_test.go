@@ -0,0 +1,99 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type ElectionTestSuite struct {
+	suite.Suite
+	l      *zap.SugaredLogger
+	client kubernetes.Interface
+}
+
+func TestElectionTestSuite(t *testing.T) {
+	suite.Run(t, new(ElectionTestSuite))
+}
+
+func (suite *ElectionTestSuite) SetupTest() {
+	l, err := zap.NewDevelopment()
+	suite.Require().NoError(err)
+	suite.l = l.Sugar()
+	suite.client = fake.NewSimpleClientset()
+}
+
+func (suite *ElectionTestSuite) input(identity string) RunInput {
+	return RunInput{
+		Logger:        suite.l,
+		Client:        suite.client,
+		Namespace:     "spot-interruption-exporter",
+		LeaseName:     "spot-interruption-exporter",
+		Identity:      identity,
+		LeaseDuration: time.Second * 10,
+		RenewDeadline: time.Second * 5,
+		RetryPeriod:   time.Millisecond * 50,
+	}
+}
+
+// candidate runs for leadership as identity, sending its identity to elected once it leads, and leading until ctx is done
+func (suite *ElectionTestSuite) candidate(ctx context.Context, identity string, elected chan<- string) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- Run(ctx, suite.input(identity), func(ctx context.Context) error {
+			elected <- identity
+			<-ctx.Done()
+			return nil
+		})
+	}()
+	return result
+}
+
+func (suite *ElectionTestSuite) TestFailover() {
+	elected := make(chan string, 2)
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	resultA := suite.candidate(ctxA, "replica-a", elected)
+	suite.Equal("replica-a", <-elected)
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	resultB := suite.candidate(ctxB, "replica-b", elected)
+	select {
+	case leader := <-elected:
+		suite.Failf("only one replica should lead", "%s was elected while replica-a leads", leader)
+	case <-time.After(time.Millisecond * 300):
+	}
+
+	// the lease is released as replica-a shuts down, so replica-b takes over well before it would have expired
+	cancelA()
+	suite.NoError(<-resultA)
+	select {
+	case leader := <-elected:
+		suite.Equal("replica-b", leader)
+	case <-time.After(time.Second * 5):
+		suite.Fail("replica-b was not elected after replica-a shut down")
+	}
+	cancelB()
+	suite.NoError(<-resultB)
+}
+
+func (suite *ElectionTestSuite) TestLeadError() {
+	failed := errors.New("failed to consume events")
+	err := Run(context.Background(), suite.input("replica-a"), func(context.Context) error {
+		return failed
+	})
+	suite.ErrorIs(err, failed)
+
+	// the lease was released, so another replica is elected straight away
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	suite.NoError(Run(ctx, suite.input("replica-b"), func(context.Context) error { return nil }))
+	suite.NoError(ctx.Err())
+}
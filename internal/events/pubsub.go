@@ -4,12 +4,15 @@ import (
 	"context"
 
 	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics"
 	"go.uber.org/zap"
 )
 
 type subscription struct {
-	t   *gcppubsub.Subscription
-	log *zap.SugaredLogger
+	t       *gcppubsub.Subscription
+	name    string
+	metrics metrics.Client
+	log     *zap.SugaredLogger
 }
 
 // Subscription provides a wrapper around a specific pubsub subscription
@@ -21,6 +24,7 @@ type Subscription interface {
 func (s *subscription) Receive(ctx context.Context, event chan<- *gcppubsub.Message) {
 	err := s.t.Receive(ctx, func(ctx context.Context, m *gcppubsub.Message) {
 		m.Ack()
+		s.metrics.IncreaseMessagesReceivedCounter(s.name)
 		event <- m
 	})
 	close(event)
@@ -32,6 +36,7 @@ func (s *subscription) Receive(ctx context.Context, event chan<- *gcppubsub.Mess
 // PubSubNotifierInput defines all required fields to create a PubSubNotifier
 type PubSubNotifierInput struct {
 	Logger           *zap.SugaredLogger
+	Metrics          metrics.Client
 	ProjectID        string
 	SubscriptionName string
 }
@@ -43,7 +48,9 @@ func NewPubSubNotifier(ctx context.Context, input *PubSubNotifierInput) (Subscri
 		return nil, err
 	}
 	return &subscription{
-		t:   client.Subscription(input.SubscriptionName),
-		log: input.Logger.With("subscription_name", input.SubscriptionName),
+		t:       client.Subscription(input.SubscriptionName),
+		name:    input.SubscriptionName,
+		metrics: input.Metrics,
+		log:     input.Logger.With("subscription_name", input.SubscriptionName),
 	}, nil
 }
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+	"github.com/thought-machine/spot-interruption-exporter/internal/dedup"
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics"
+	"github.com/thought-machine/spot-interruption-exporter/internal/workers"
+	"go.uber.org/zap"
+)
+
+// benchmarkInstances is how many instances are preempted, as in a zonal reclaim
+const benchmarkInstances = 1000
+
+// BenchmarkHandleInterruptionEvents compares handling interruptions on a single goroutine, as without a worker pool, with
+// handling them on pools of workers
+func BenchmarkHandleInterruptionEvents(b *testing.B) {
+	for _, size := range []int{0, 4, 16, 64} {
+		name := "single_goroutine"
+		if size > 0 {
+			name = fmt.Sprintf("pool_%d", size)
+		}
+		b.Run(name, func(b *testing.B) {
+			benchmarkHandleInterruptionEvents(b, size)
+		})
+	}
+}
+
+func benchmarkHandleInterruptionEvents(b *testing.B, poolSize int) {
+	l := zap.NewNop().Sugar()
+	m := metrics.NewClient(l, prometheus.NewRegistry())
+	initialInstances := make(map[string]compute.Workload, benchmarkInstances)
+	for i := 0; i < benchmarkInstances; i++ {
+		initialInstances[benchmarkResourceID(i)] = kubernetesWorkload("benchmark-cluster")
+	}
+	deduplicator, err := dedup.NewDeduplicator(dedup.NewDeduplicatorInput{Keys: NewMessageCache(CacheInput{}, dedup.DefaultWindow)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	p := NewInterruptionPipeline(NewInterruptionPipelineInput{
+		Logger:                     l,
+		Metrics:                    m,
+		InstanceToWorkloadMappings: NewInstanceToWorkloadMappings(initialInstances, CacheInput{}),
+		CreationTimes:              NewInstanceCreationTimes(CacheInput{}),
+		Deduplicator:               deduplicator,
+		Stopped:                    NewStoppedInstances(nil, CacheInput{}),
+	})
+	messages := make([]*gcppubsub.Message, b.N)
+	for i := range messages {
+		data := fmt.Sprintf(`{"protoPayload": {"methodName": "compute.instances.preempted", "resourceName": %q}}`, benchmarkResourceID(i%benchmarkInstances))
+		messages[i] = &gcppubsub.Message{ID: fmt.Sprint(i), Data: []byte(data)}
+	}
+
+	var pool workers.Pool
+	closePool := func(context.Context) error { return nil }
+	if poolSize > 0 {
+		pool, closePool = workers.NewPool(workers.NewPoolInput{Workers: poolSize})
+	}
+	interruptions := make(chan *gcppubsub.Message, 30)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	b.ResetTimer()
+	go HandleInterruptionEvents(interruptions, p, pool, m, wg)
+	for _, message := range messages {
+		interruptions <- message
+	}
+	close(interruptions)
+	wg.Wait()
+	if err := closePool(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func benchmarkResourceID(i int) string {
+	return fmt.Sprintf("projects/mock-project/zones/europe-west1-c/instances/benchmark-%d", i)
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/googleapis/google-cloudevents-go/cloud/auditdata"
+	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+	"github.com/thought-machine/spot-interruption-exporter/internal/history"
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics"
+	"github.com/thought-machine/spot-interruption-exporter/pkg/pipeline"
+	"go.uber.org/zap"
+)
+
+// StageTrack is the stage of the creation pipeline that tracks the workload instances belong to, which runs between decode and emit
+const StageTrack = "track"
+
+// NewCreationPipelineInput defines all required fields to create the pipeline instance creations are handled with
+type NewCreationPipelineInput struct {
+	Logger                     *zap.SugaredLogger
+	Metrics                    metrics.Client
+	Classifier                 compute.WorkloadClassifier
+	InstanceToWorkloadMappings cache.Cache[string, compute.Workload]
+	CreationTimes              cache.Cache[string, time.Time]
+	Stopped                    *StoppedInstances
+	// Store is optional
+	Store history.Store
+	// Stages run in order after the decode stage and before the track stage, e.g. to relabel instances. The exporter passes
+	// those given to exporter.Run.
+	Stages []pipeline.Stage[*pipeline.CreationEvent]
+}
+
+// CreationPipeline passes the instance creations received in pubsub messages through a pipeline of stages
+type CreationPipeline struct {
+	stages *pipeline.Pipeline[*pipeline.CreationEvent]
+	log    *zap.SugaredLogger
+}
+
+// NewCreationPipeline creates the pipeline instance creations are handled with: decode → the given stages → track → emit
+func NewCreationPipeline(input NewCreationPipelineInput) *CreationPipeline {
+	stages := []pipeline.Stage[*pipeline.CreationEvent]{
+		&creationDecodeStage{classifier: input.Classifier, metrics: input.Metrics},
+	}
+	stages = append(stages, input.Stages...)
+	stages = append(stages,
+		&trackStage{instanceToWorkloadMappings: input.InstanceToWorkloadMappings, creationTimes: input.CreationTimes, stopped: input.Stopped},
+		&creationEmitStage{store: input.Store},
+	)
+	return &CreationPipeline{
+		stages: pipeline.New(pipeline.NewInput[*pipeline.CreationEvent]{
+			Name:    creationHandlerName,
+			Stages:  stages,
+			Metrics: input.Metrics,
+		}),
+		log: input.Logger.With("pipeline", creationHandlerName),
+	}
+}
+
+// Process passes the creation received in m, which carries entry, through every stage, returning a *pipeline.StageError if one fails
+func (p *CreationPipeline) Process(ctx context.Context, m *gcppubsub.Message, entry *auditdata.LogEntryData) error {
+	return p.stages.Process(ctx, &pipeline.CreationEvent{Message: m, Entry: entry, MessageID: m.ID, Log: p.log.With("message_id", m.ID)})
+}
+
+// creationDecodeStage reads the creation from the audit log entry the pubsub message carries, and classifies the workload of the instance created
+type creationDecodeStage struct {
+	classifier compute.WorkloadClassifier
+	metrics    metrics.Client
+}
+
+func (s *creationDecodeStage) Name() string {
+	return StageDecode
+}
+
+func (s *creationDecodeStage) Process(_ context.Context, e *pipeline.CreationEvent) error {
+	decoded, err := entryToInstanceCreationEvent(e.Message, e.Entry, s.classifier)
+	if err != nil {
+		s.metrics.IncreaseParseFailureCounter(creationHandlerName, parseFailureReason(err))
+		return fmt.Errorf("failed to convert pubsub message to creation event: %w", err)
+	}
+	e.ResourceID = decoded.ResourceID
+	e.Workload = pipelineWorkload(decoded.Workload)
+	e.TerminationAction = decoded.TerminationAction
+	e.Timestamp = decoded.Timestamp
+	e.Log = e.Log.With("resource_id", e.ResourceID)
+	return nil
+}
+
+// trackStage maps the instance to its workload, so that its interruptions can be resolved, and records when it was created
+type trackStage struct {
+	instanceToWorkloadMappings cache.Cache[string, compute.Workload]
+	creationTimes              cache.Cache[string, time.Time]
+	stopped                    *StoppedInstances
+}
+
+func (s *trackStage) Name() string {
+	return StageTrack
+}
+
+func (s *trackStage) Process(_ context.Context, e *pipeline.CreationEvent) error {
+	workload := computeWorkload(e.Workload)
+	e.Log = e.Log.With("kubernetes_cluster", workload.KubernetesCluster(), "workload_type", workload.Type, "workload_name", workload.Name)
+	e.Log.Info("added")
+	s.instanceToWorkloadMappings.Insert(e.ResourceID, workload)
+	s.creationTimes.Insert(e.ResourceID, e.Timestamp)
+	if e.TerminationAction == TerminationActionStop {
+		s.stopped.StopOnTermination.Insert(e.ResourceID, workload)
+	}
+	return nil
+}
+
+// creationEmitStage records the creation in history
+type creationEmitStage struct {
+	store history.Store
+}
+
+func (s *creationEmitStage) Name() string {
+	return StageEmit
+}
+
+func (s *creationEmitStage) Process(ctx context.Context, e *pipeline.CreationEvent) error {
+	if s.store == nil {
+		return nil
+	}
+	labels := instanceLabels(e.ResourceID, computeWorkload(e.Workload))
+	c := history.Creation{
+		ResourceID:    e.ResourceID,
+		Project:       labels.Project,
+		Zone:          labels.Zone,
+		Instance:      labels.Instance,
+		Cluster:       labels.KubernetesCluster,
+		NodePool:      labels.NodePool,
+		MachineType:   labels.MachineType,
+		MachineFamily: compute.MachineFamily(labels.MachineType),
+		WorkloadType:  labels.WorkloadType,
+		WorkloadName:  labels.WorkloadName,
+		Timestamp:     e.Timestamp,
+		MessageID:     e.MessageID,
+	}
+	if err := s.store.AddCreation(ctx, c); err != nil {
+		e.Log.Warnf("failed to record creation in history: %s", err.Error())
+	}
+	return nil
+}
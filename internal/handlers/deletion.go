@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"time"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/googleapis/google-cloudevents-go/cloud/auditdata"
+	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// deletionMethods are the method names logged when an instance is deleted, which are always user initiated, so versioned
+var deletionMethods = map[string]bool{
+	"v1.compute.instances.delete":   true,
+	"beta.compute.instances.delete": true,
+}
+
+type instanceDeletionEvent struct {
+	MessageID  string
+	ResourceID string
+	Timestamp  time.Time
+}
+
+// handleDeletionEvent stops tracking an instance that was deleted, e.g. as its node pool scaled down. It remains in the
+// mapping of instances to workloads for RemovedInstanceTTL, so that interruptions logged before it was deleted can be resolved.
+func handleDeletionEvent(m *gcppubsub.Message, entry *auditdata.LogEntryData, instanceToWorkloadMappings cache.Cache[string, compute.Workload], creationTimes cache.Cache[string, time.Time], stopped *StoppedInstances, metrics metrics.Client, l *zap.SugaredLogger) {
+	e, err := entryToInstanceDeletionEvent(m, entry)
+	if err != nil {
+		l.Warnf("failed to convert pubsub message to deletion event: %s", err.Error())
+		metrics.IncreaseParseFailureCounter(deletionHandlerName, parseFailureReason(err))
+		return
+	}
+	s := l.With("message_id", e.MessageID, "resource_id", e.ResourceID)
+	// the deletion of every instance in the project is logged, so untracked instances are expected
+	if _, err := instanceToWorkloadMappings.Get(e.ResourceID); err != nil {
+		s.Debugf("ignoring deletion of untracked instance: %s", err.Error())
+		return
+	}
+	// instance names are reused, so a deletion delivered after the creation of the instance being tracked may be of a
+	// previous instance of the same name, which must have been deleted before it was created
+	if createdAt, err := creationTimes.Get(e.ResourceID); err == nil && e.Timestamp.Before(createdAt) {
+		s.Infof("ignoring deletion of a previous instance of the same name, as this one was created after it at %s", createdAt)
+		return
+	}
+	if err := instanceToWorkloadMappings.SetExpiration(e.ResourceID, RemovedInstanceTTL); err != nil {
+		s.Warnf("failed to remove instance from mapping of instances to workloads: %s", err.Error())
+	}
+	stopped.expire(e.ResourceID, RemovedInstanceTTL)
+	// instances tracked since seeding have no creation time
+	_ = creationTimes.SetExpiration(e.ResourceID, RemovedInstanceTTL)
+	s.Infof("deleted, and will no longer be tracked after %s", RemovedInstanceTTL)
+}
+
+func entryToInstanceDeletionEvent(m *gcppubsub.Message, entry *auditdata.LogEntryData) (instanceDeletionEvent, error) {
+	methodName := entry.GetProtoPayload().GetMethodName()
+	if !deletionMethods[methodName] {
+		return instanceDeletionEvent{}, newParseError(parseFailureReasonUnsupportedMethod, "unsupported deletion method %q", methodName)
+	}
+	resourceID := entry.GetProtoPayload().GetResourceName()
+	if len(resourceID) == 0 {
+		return instanceDeletionEvent{}, newParseError(parseFailureReasonMissingField, "expected resourceName not found in instance deletion, operation ID: %s", entry.GetOperation().GetId())
+	}
+	return instanceDeletionEvent{
+		MessageID:  m.ID,
+		ResourceID: resourceID,
+		Timestamp:  entryTimestamp(entry),
+	}, nil
+}
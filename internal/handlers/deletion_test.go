@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"time"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+)
+
+func (suite *HandlersTestSuite) TestHandleDeletionEvent() {
+	resourceName := "projects/mock-project/zones/europe-west1-c/instances/fake-resource"
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		resourceName: kubernetesWorkload("deletion-cluster"),
+	}, CacheInput{})
+	creationTimes := NewInstanceCreationTimes(CacheInput{})
+	creationTimes.Insert(resourceName, time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC))
+	stopped := NewStoppedInstances(map[string]compute.Workload{resourceName: kubernetesWorkload("deletion-cluster")}, CacheInput{})
+	stopped.Stopped.Insert(resourceName, time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC))
+
+	handleDeletionEvent(mockDeleteMessage, suite.entry(mockDeleteMessage), instanceToWorkloadMappings, creationTimes, stopped, suite.mockMetrics, suite.l)
+
+	// deleted instances remain tracked long enough to resolve their interruptions
+	suite.False(instanceToWorkloadMappings.Items()[resourceName].Expiration.IsZero())
+	suite.False(creationTimes.Items()[resourceName].Expiration.IsZero())
+	suite.False(stopped.StopOnTermination.Items()[resourceName].Expiration.IsZero())
+	suite.False(exists(suite, stopped.Stopped, resourceName))
+}
+
+func (suite *HandlersTestSuite) TestHandleDeletionEventOfPreviousInstance() {
+	resourceName := "projects/mock-project/zones/europe-west1-c/instances/fake-resource"
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		resourceName: kubernetesWorkload("deletion-cluster"),
+	}, CacheInput{})
+	// the instance was created under the name of one deleted before it, whose deletion is delivered late
+	creationTimes := NewInstanceCreationTimes(CacheInput{})
+	creationTimes.Insert(resourceName, time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC))
+	stopped := NewStoppedInstances(map[string]compute.Workload{resourceName: kubernetesWorkload("deletion-cluster")}, CacheInput{})
+
+	handleDeletionEvent(mockDeleteMessage, suite.entry(mockDeleteMessage), instanceToWorkloadMappings, creationTimes, stopped, suite.mockMetrics, suite.l)
+
+	suite.True(instanceToWorkloadMappings.Items()[resourceName].Expiration.IsZero())
+	suite.True(creationTimes.Items()[resourceName].Expiration.IsZero())
+	suite.True(stopped.StopOnTermination.Items()[resourceName].Expiration.IsZero())
+}
+
+func (suite *HandlersTestSuite) TestEntryToInstanceDeletionEvent() {
+	event, err := entryToInstanceDeletionEvent(mockDeleteMessage, suite.entry(mockDeleteMessage))
+	suite.NoError(err)
+	suite.Equal("projects/mock-project/zones/europe-west1-c/instances/fake-resource", event.ResourceID)
+	suite.Equal(time.Date(2024, 1, 5, 11, 0, 0, 0, time.UTC), event.Timestamp.UTC())
+
+	tests := map[string]struct {
+		data   string
+		reason string
+	}{
+		"unversioned": {
+			data:   `{"protoPayload": {"methodName": "compute.instances.delete", "resourceName": "projects/p/zones/z/instances/i"}}`,
+			reason: parseFailureReasonUnsupportedMethod,
+		},
+		"similar method": {
+			data:   `{"protoPayload": {"methodName": "v1.compute.instances.deleteAccessConfig", "resourceName": "projects/p/zones/z/instances/i"}}`,
+			reason: parseFailureReasonUnsupportedMethod,
+		},
+		"missing resource name": {
+			data:   `{"protoPayload": {"methodName": "v1.compute.instances.delete"}}`,
+			reason: parseFailureReasonMissingField,
+		},
+	}
+	for name, test := range tests {
+		suite.Run(name, func() {
+			m := &gcppubsub.Message{ID: "1", Data: []byte(test.data)}
+			_, err := entryToInstanceDeletionEvent(m, suite.entry(m))
+			suite.Error(err)
+			suite.Equal(test.reason, parseFailureReason(err))
+		})
+	}
+}
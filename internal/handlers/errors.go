@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	creationHandlerName     = "creation"
+	deletionHandlerName     = "deletion"
+	interruptionHandlerName = "interruption"
+	lifecycleHandlerName    = "lifecycle"
+	routerHandlerName       = "router"
+)
+
+const (
+	parseFailureReasonUnmarshal            = "unmarshal"
+	parseFailureReasonUnsupportedMethod    = "unsupported_method"
+	parseFailureReasonMissingField         = "missing_field"
+	parseFailureReasonUnrecognisedWorkload = "unrecognised_workload"
+	parseFailureReasonUnknown              = "unknown"
+)
+
+// parseError is returned when a pubsub message cannot be converted to an event, recording why for the parse failure metric
+type parseError struct {
+	reason string
+	err    error
+}
+
+func (e *parseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *parseError) Unwrap() error {
+	return e.err
+}
+
+func newParseError(reason string, format string, a ...any) error {
+	return &parseError{
+		reason: reason,
+		err:    fmt.Errorf(format, a...),
+	}
+}
+
+// parseFailureReason returns the reason err was returned when parsing a message
+func parseFailureReason(err error) string {
+	var pe *parseError
+	if errors.As(err, &pe) {
+		return pe.reason
+	}
+	return parseFailureReasonUnknown
+}
@@ -2,6 +2,7 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
@@ -12,120 +13,373 @@ import (
 	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
 	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
 	"github.com/thought-machine/spot-interruption-exporter/internal/metrics"
+	"github.com/thought-machine/spot-interruption-exporter/internal/workers"
+	"github.com/thought-machine/spot-interruption-exporter/pkg/pipeline"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// computeAPIPrefix prefixes the links to instances in compute API responses, which are their resource IDs otherwise
+const computeAPIPrefix = "https://www.googleapis.com/compute/v1/"
+
 type instanceInterruptionEvent struct {
-	MessageID  string
-	ResourceID string
+	MessageID       string
+	InsertID        string
+	OperationID     string
+	ResourceID      string
+	Cause           pipeline.TerminationCause
+	RemovesInstance bool
+	Timestamp       time.Time
 }
 
 type instanceCreationEvent struct {
-	MessageID   string
-	ResourceID  string
-	ClusterName string
+	MessageID         string
+	ResourceID        string
+	Workload          compute.Workload
+	TerminationAction string
+	Timestamp         time.Time
 }
 
-// HandleCreationEvents reads from additions and adds the instance ID and corresponding cluster to m
-func HandleCreationEvents(additions chan *gcppubsub.Message, instanceToClusterMappings cache.Cache, l *zap.SugaredLogger, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for addition := range additions {
-		a, err := messageToInstanceCreationEvent(addition)
-		if err != nil {
-			l.Warnf("failed to convert pubsub message to creation event: %s", err.Error())
+// The names of the caches events are handled with, which identify them in metrics, snapshots and Redis
+const (
+	InstanceToWorkloadMappingsCacheName = "instance_to_workload_mappings"
+	MessageCacheName                    = "interruption_messages"
+	StopOnTerminationCacheName          = "stop_on_termination"
+	StoppedCacheName                    = "stopped"
+	InstanceCreationTimesCacheName      = "instance_creation_times"
+)
+
+const (
+	// messageCacheMaxSize bounds the keys of handled interruption events kept. Evicting one early only risks handling a
+	// redelivery of it again, which is preferable to unbounded growth during a burst of messages
+	messageCacheMaxSize = 100000
+	// RemovedInstanceTTL is how long deleted instances remain in the mapping of instances to workloads, so that the other
+	// interruption events of their termination can still be resolved
+	RemovedInstanceTTL = time.Second * 30
+	// InstanceMappingSizeInterval is how often the size of the mapping of instances to workloads is reported
+	InstanceMappingSizeInterval = time.Second * 15
+)
+
+// CacheInput defines the optional fields the caches events are handled with are created with
+type CacheInput struct {
+	// Metrics records the lookups and evictions of each cache
+	Metrics metrics.Client
+	// Now returns the current time, defaulting to time.Now, so that tests can expire items without waiting
+	Now func() time.Time
+}
+
+func newCache[V any](input CacheInput, name string, ttl time.Duration, maxSize int, initial map[string]V) cache.Cache[string, V] {
+	return cache.New(cache.NewInput[string, V]{
+		Name:    name,
+		TTL:     ttl,
+		MaxSize: maxSize,
+		Initial: initial,
+		Metrics: input.Metrics,
+		Now:     input.Now,
+	})
+}
+
+// WorkloadCodec keeps workloads outside of memory, e.g. in Redis and snapshots, as encoded by Workload.String
+var WorkloadCodec = cache.Codec[compute.Workload]{Encode: compute.Workload.String, Decode: compute.ParseWorkload}
+
+// TimeCodec keeps times, e.g. those instances were created or stopped at, outside of memory in RFC 3339
+var TimeCodec = cache.Codec[time.Time]{
+	Encode: func(t time.Time) string { return t.Format(time.RFC3339Nano) },
+	Decode: func(s string) (time.Time, error) { return time.Parse(time.RFC3339Nano, s) },
+}
+
+// NewInstanceToWorkloadMappings creates the mapping of instance IDs to the workloads they belong to, seeded from initialInstances
+func NewInstanceToWorkloadMappings(initialInstances map[string]compute.Workload, input CacheInput) cache.Cache[string, compute.Workload] {
+	return newCache(input, InstanceToWorkloadMappingsCacheName, cache.NoExpiration, 0, initialInstances)
+}
+
+// NewInstanceCreationTimes creates the mapping of instance IDs to the time the instance of that name being tracked was created at,
+// so that events of a previous instance of the same name can be told apart from those of the current one. Creation times expire
+// along with the instance in the mapping of instances to workloads, see ReconcileInstanceCreationTimes.
+func NewInstanceCreationTimes(input CacheInput) cache.Cache[string, time.Time] {
+	return newCache[time.Time](input, InstanceCreationTimesCacheName, cache.NoExpiration, 0, nil)
+}
+
+// ReconcileInput defines the fields to reconcile a mapping of instances to workloads with the instances listed from the compute API
+type ReconcileInput struct {
+	// Listed are the instances currently belonging to workloads
+	Listed map[string]compute.Workload
+	// Tracked are the items of the mapping when listing started, and ListingStarted when it did. Events are handled while
+	// listing, so instances inserted, removed or set to expire since are left as those events left them.
+	Tracked        map[string]cache.Item[compute.Workload]
+	ListingStarted time.Time
+	// CreationTimes is optional, and leaves instances created since listing started under the name of a tracked one as they are
+	CreationTimes cache.Cache[string, time.Time]
+	// Grace is how long tracked instances that were not listed remain tracked for
+	Grace time.Duration
+}
+
+// createdSince returns whether the instance k was created after listing started
+func (input ReconcileInput) createdSince(k string) bool {
+	if input.CreationTimes == nil {
+		return false
+	}
+	createdAt, err := input.CreationTimes.Get(k)
+	return err == nil && createdAt.After(input.ListingStarted)
+}
+
+// ReconcileInstanceToWorkloadMappings inserts the instances listed into m, which may have been restored from a snapshot.
+// Instances in m that were not listed have been deleted since, and expire after the grace period rather than straight away so
+// that their interruptions still queued in pubsub can be resolved. It returns how many were not listed.
+func ReconcileInstanceToWorkloadMappings(m cache.Cache[string, compute.Workload], input ReconcileInput) int {
+	stale := 0
+	items := m.Items()
+	for k, item := range items {
+		if _, ok := input.Listed[k]; ok || !item.Expiration.IsZero() {
+			continue
+		}
+		// instances inserted while listing were created after they could be listed
+		if _, ok := input.Tracked[k]; !ok || input.createdSince(k) {
 			continue
 		}
-		l.With("message_id", a.MessageID, "resource_id", a.ResourceID, "kubernetes_cluster", a.ClusterName).Info("added")
-		instanceToClusterMappings.Insert(a.ResourceID, a.ClusterName)
+		if err := m.SetExpiration(k, input.Grace); err == nil {
+			stale++
+		}
 	}
+	for k, v := range input.Listed {
+		tracked, wasTracked := input.Tracked[k]
+		item, ok := items[k]
+		switch {
+		case wasTracked && !ok:
+			// removed while listing
+			continue
+		case ok && !wasTracked:
+			// inserted while listing, from an event at least as recent as the listing
+			continue
+		case ok && !item.Expiration.Equal(tracked.Expiration):
+			// set to expire while listing, e.g. as it was deleted
+			continue
+		case input.createdSince(k):
+			// created again under the same name while listing
+			continue
+		}
+		m.Insert(k, v)
+	}
+	return stale
 }
 
-// HandleInterruptionEvents reads from interruptions and increases the interruption event counter of metrics accordingly
-func HandleInterruptionEvents(interruptions chan *gcppubsub.Message, instanceToClusterMappings cache.Cache, metrics metrics.Client, l *zap.SugaredLogger, wg *sync.WaitGroup) {
-	defer wg.Done()
-	messageCache := cache.NewCacheWithTTL(time.Minute * 10)
-	for interruption := range interruptions {
-		e, err := messageToInstanceInterruptionEvent(interruption)
-		if err != nil {
-			l.Warnf("failed to convert pubsub message to interruption event: %s", err.Error())
+// ReconcileInstanceCreationTimes expires the creation times of instances along with the instance in m, the mapping of instances
+// to workloads, once m has been reconciled, so that those of instances deleted while the exporter was not running are not kept
+// forever. Creation times of instances m no longer tracks are removed.
+func ReconcileInstanceCreationTimes(creationTimes cache.Cache[string, time.Time], m cache.Cache[string, compute.Workload]) {
+	// creation times are listed first, as instances are inserted into m before their creation time
+	items := creationTimes.Items()
+	tracked := m.Items()
+	for k, item := range items {
+		if !item.Expiration.IsZero() {
 			continue
 		}
-		s := l.With("message_id", e.MessageID, "resource_id", e.ResourceID)
-		// this ensures we do not handle a duplicate message in the event pubsub sends it more than once
-		if exists := messageCache.Exists(e.MessageID); exists {
-			s.Debug("handled duplicate message")
+		mapped, ok := tracked[k]
+		if !ok {
+			creationTimes.Delete(k)
 			continue
 		}
-		messageCache.Insert(e.MessageID, "")
-		clusterName, err := instanceToClusterMappings.Get(e.ResourceID)
-		if err != nil {
-			s.Warnf("failed to determine cluster the instance (%s) belongs to: %s", e.ResourceID, err.Error())
+		if mapped.Expiration.IsZero() {
+			continue
+		}
+		if ttl := time.Until(mapped.Expiration); ttl > 0 {
+			_ = creationTimes.SetExpiration(k, ttl)
+		} else {
+			creationTimes.Delete(k)
+		}
+	}
+}
+
+// ReportInstanceMappingSize reports the number of instances in m every interval until ctx is done, so that the size follows
+// instances expiring once deleted as well as those being created
+func ReportInstanceMappingSize(ctx context.Context, m cache.Cache[string, compute.Workload], metrics metrics.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		metrics.SetInstanceMappingSize(m.ItemCount())
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// MergeInstanceToWorkloadMappings inserts listed, the instances currently belonging to workloads, into m unless m tracked them
+// when listing started, given by tracked. Unlike ReconcileInstanceToWorkloadMappings it expires nothing, so it is safe while
+// events are being handled, as instances m tracks that were not listed may have been created since. Instances tracked when
+// listing started are left as they are, as they are either still tracked or were removed while listing. It returns how many
+// instances were inserted, stopping at the first that fails.
+func MergeInstanceToWorkloadMappings(m cache.Cache[string, compute.Workload], listed map[string]compute.Workload, tracked map[string]cache.Item[compute.Workload]) (int, error) {
+	inserted := 0
+	for k, v := range listed {
+		if _, ok := tracked[k]; ok {
 			continue
 		}
-		expireAfter := time.Second * 30
-		err = instanceToClusterMappings.SetExpiration(e.ResourceID, expireAfter)
+		ok, err := m.InsertIfAbsent(k, v)
 		if err != nil {
-			s.Warnf("failed to remove instance from mapping of instances to clusters: %s", err.Error())
+			return inserted, fmt.Errorf("failed to merge instance %s: %w", k, err)
+		}
+		if ok {
+			inserted++
 		}
-		s.Debugf("%s will no longer be tracked after %s", e.ResourceID, expireAfter)
+	}
+	return inserted, nil
+}
+
+// NewMessageCache creates the cache of the keys of handled interruption events, which are kept for window
+func NewMessageCache(input CacheInput, window time.Duration) cache.Cache[string, string] {
+	return newCache[string](input, MessageCacheName, window, messageCacheMaxSize, nil)
+}
+
+// instanceLabels returns the metric labels of the instance resourceID belonging to w
+func instanceLabels(resourceID string, w compute.Workload) metrics.InstanceLabels {
+	return metrics.InstanceLabels{
+		KubernetesCluster: w.KubernetesCluster(),
+		Project:           compute.ProjectFromResourceID(resourceID),
+		WorkloadType:      string(w.Type),
+		WorkloadName:      w.Name,
+		Zone:              compute.ZoneFromResourceID(resourceID),
+		Instance:          compute.InstanceFromResourceID(resourceID),
+		NodePool:          w.NodePool,
+		MachineType:       w.MachineType,
+	}
+}
+
+// pipelineWorkload returns w as it is passed through pipelines
+func pipelineWorkload(w compute.Workload) pipeline.Workload {
+	return pipeline.Workload{Type: string(w.Type), Name: w.Name, NodePool: w.NodePool, MachineType: w.MachineType}
+}
+
+// computeWorkload returns the workload w passed through a pipeline as it is tracked
+func computeWorkload(w pipeline.Workload) compute.Workload {
+	return compute.Workload{Type: compute.WorkloadType(w.Type), Name: w.Name, NodePool: w.NodePool, MachineType: w.MachineType}
+}
 
-		s.With("kubernetes_cluster", clusterName).Info("interrupted")
-		metrics.IncreaseInterruptionEventCounter(clusterName)
+// decodeMessage parses the audit log entry m carries. Each message is decoded once, when it is received, and the entry passed
+// to whatever handles it.
+func decodeMessage(m *gcppubsub.Message) (*auditdata.LogEntryData, error) {
+	entry := &auditdata.LogEntryData{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(m.Data, entry); err != nil {
+		return nil, &parseError{reason: parseFailureReasonUnmarshal, err: err}
 	}
+	return entry, nil
 }
 
-func messageToInstanceInterruptionEvent(m *gcppubsub.Message) (instanceInterruptionEvent, error) {
-	entry := auditdata.LogEntryData{}
-	err := protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(m.Data, &entry)
+// decodeMessages reads from messages and passes each, along with the audit log entry it carries, to handle on pool if it is not
+// nil. Messages that cannot be decoded are logged and recorded as parse failures of handler, which names the handler in metrics.
+func decodeMessages(messages chan *gcppubsub.Message, handler string, pool workers.Pool, metrics metrics.Client, l *zap.SugaredLogger, handle func(m *gcppubsub.Message, entry *auditdata.LogEntryData)) {
+	for m := range messages {
+		entry, err := decodeMessage(m)
+		if err != nil {
+			l.With("message_id", m.ID).Warnf("failed to convert pubsub message to audit log entry: %s", err.Error())
+			metrics.IncreaseParseFailureCounter(handler, parseFailureReason(err))
+			continue
+		}
+		dispatch(pool, resourceKey(m, entry), func() {
+			start := time.Now()
+			handle(m, entry)
+			metrics.ObserveHandlerDuration(handler, time.Since(start))
+		})
+	}
+}
+
+// dispatch runs handle on pool, after every event submitted before it for the instance key, or straight away if pool is nil
+func dispatch(pool workers.Pool, key string, handle func()) {
+	if pool == nil {
+		handle()
+		return
+	}
+	pool.Submit(key, handle)
+}
+
+// resourceKey returns the ID of the instance entry, the audit log entry in m, is about, or the ID of m if it cannot be determined
+func resourceKey(m *gcppubsub.Message, entry *auditdata.LogEntryData) string {
+	if name := entry.GetProtoPayload().GetResourceName(); len(name) > 0 {
+		return name
+	}
+	// instance insertions may only identify the instance in the link to it in their response
+	if link := entry.GetProtoPayload().GetResponse().GetFields()["targetLink"].GetStringValue(); len(link) > 0 {
+		return strings.TrimPrefix(link, computeAPIPrefix)
+	}
+	return m.ID
+}
+
+// HandleCreationEvents reads from additions and passes each through p, e.g. one created by NewCreationPipeline.
+// Events are handled on pool if it is not nil.
+func HandleCreationEvents(additions chan *gcppubsub.Message, p *CreationPipeline, pool workers.Pool, metrics metrics.Client, wg *sync.WaitGroup) {
+	defer wg.Done()
+	decodeMessages(additions, creationHandlerName, pool, metrics, p.log, func(m *gcppubsub.Message, entry *auditdata.LogEntryData) {
+		// failures are logged and recorded in metrics by the pipeline
+		_ = p.Process(context.Background(), m, entry)
+	})
+}
+
+// HandleInterruptionEvents reads from interruptions and passes each through p, e.g. one created by NewInterruptionPipeline.
+// Events are handled on pool if it is not nil.
+func HandleInterruptionEvents(interruptions chan *gcppubsub.Message, p *InterruptionPipeline, pool workers.Pool, metrics metrics.Client, wg *sync.WaitGroup) {
+	defer wg.Done()
+	decodeMessages(interruptions, interruptionHandlerName, pool, metrics, p.log, func(m *gcppubsub.Message, entry *auditdata.LogEntryData) {
+		// failures are logged and recorded in metrics by the pipeline
+		_ = p.Process(context.Background(), m, entry)
+	})
+}
+
+func entryToInstanceInterruptionEvent(m *gcppubsub.Message, entry *auditdata.LogEntryData) (instanceInterruptionEvent, error) {
+	cause, err := parseTerminationCause(entry)
 	if err != nil {
 		return instanceInterruptionEvent{}, err
 	}
 	return instanceInterruptionEvent{
-		MessageID:  m.ID,
-		ResourceID: entry.ProtoPayload.ResourceName,
+		MessageID:       m.ID,
+		InsertID:        entry.GetInsertId(),
+		OperationID:     entry.GetOperation().GetId(),
+		ResourceID:      entry.ProtoPayload.ResourceName,
+		Cause:           cause.Cause,
+		RemovesInstance: cause.RemovesInstance,
+		Timestamp:       entryTimestamp(entry),
 	}, nil
 }
 
-func messageToInstanceCreationEvent(m *gcppubsub.Message) (instanceCreationEvent, error) {
-	entry := auditdata.LogEntryData{}
-	err := protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(m.Data, &entry)
-	if err != nil {
-		return instanceCreationEvent{}, err
-	}
+func entryToInstanceCreationEvent(m *gcppubsub.Message, entry *auditdata.LogEntryData, classifier compute.WorkloadClassifier) (instanceCreationEvent, error) {
 	requestFields := entry.ProtoPayload.Request.GetFields()
-
-	labels, ok := requestFields["labels"]
-	if !ok {
-		return instanceCreationEvent{}, fmt.Errorf("expected labels not found on instance creation request, operation ID: %s", entry.Operation.Id)
-	}
-
-	var clusterName string
-	var found bool
-	for _, v := range labels.GetListValue().Values {
-		label := v.GetStructValue().AsMap()
-		labelKey := label["key"].(string)
-		if strings.EqualFold(labelKey, compute.ClusterNameLabelKey) {
-			clusterName = label["value"].(string)
-			found = true
-			break
-		}
+	labels := keyValueListToMap(requestFields["labels"])
+	metadata := keyValueListToMap(requestFields["metadata"].GetStructValue().GetFields()["items"])
+	workload, found := classifier.Classify(requestFields["name"].GetStringValue(), labels, metadata)
+	if !found {
+		return instanceCreationEvent{}, newParseError(parseFailureReasonUnrecognisedWorkload, "instance creation request does not belong to a recognised workload, operation ID: %s", entry.GetOperation().GetId())
 	}
+	workload.MachineType = compute.MachineTypeName(requestFields["machineType"].GetStringValue())
 
-	if !found {
-		return instanceCreationEvent{}, fmt.Errorf("expected cluster label %s not found on instance creation request, operation ID: %s", compute.ClusterNameLabelKey, entry.Operation.Id)
+	var terminationAction string
+	if scheduling, ok := requestFields["scheduling"]; ok {
+		terminationAction = scheduling.GetStructValue().GetFields()["instanceTerminationAction"].GetStringValue()
 	}
 
 	responseFields := entry.ProtoPayload.Response.GetFields()
 	targetLink, ok := responseFields["targetLink"]
 	if !ok {
-		return instanceCreationEvent{}, fmt.Errorf("expected targetLink not found in instance creation response, operation ID: %s", entry.Operation.Id)
+		return instanceCreationEvent{}, newParseError(parseFailureReasonMissingField, "expected targetLink not found in instance creation response, operation ID: %s", entry.GetOperation().GetId())
 	}
-	resourceID := strings.TrimPrefix(targetLink.GetStringValue(), "https://www.googleapis.com/compute/v1/")
+	resourceID := strings.TrimPrefix(targetLink.GetStringValue(), computeAPIPrefix)
 
 	return instanceCreationEvent{
-		MessageID:   m.ID,
-		ResourceID:  resourceID,
-		ClusterName: clusterName,
+		MessageID:         m.ID,
+		ResourceID:        resourceID,
+		Workload:          workload,
+		TerminationAction: terminationAction,
+		Timestamp:         entryTimestamp(entry),
 	}, nil
 }
+
+// keyValueListToMap converts a list of {"key": k, "value": v} structs, as used by instance labels and metadata items, to a map
+func keyValueListToMap(v *structpb.Value) map[string]string {
+	values := v.GetListValue().GetValues()
+	m := make(map[string]string, len(values))
+	for _, item := range values {
+		fields := item.GetStructValue().GetFields()
+		m[fields["key"].GetStringValue()] = fields["value"].GetStringValue()
+	}
+	return m
+}
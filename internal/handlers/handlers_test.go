@@ -1,14 +1,24 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"testing"
+	"time"
 
 	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/googleapis/google-cloudevents-go/cloud/auditdata"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+	"github.com/thought-machine/spot-interruption-exporter/internal/dedup"
 	"github.com/thought-machine/spot-interruption-exporter/internal/handlers/test_data"
+	"github.com/thought-machine/spot-interruption-exporter/internal/history"
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics"
 	"github.com/thought-machine/spot-interruption-exporter/internal/metrics/mocks"
+	"github.com/thought-machine/spot-interruption-exporter/pkg/pipeline"
 	"go.uber.org/zap"
 )
 
@@ -27,72 +37,590 @@ var (
 		ID:   "12345",
 		Data: test_data.CreationEventJSONFile,
 	}
+	mockSpotCreationMessage = &gcppubsub.Message{
+		ID:   "12346",
+		Data: test_data.SpotCreationEventJSONFile,
+	}
+	mockKubeadmCreationMessage = &gcppubsub.Message{
+		ID:   "45678",
+		Data: test_data.KubeadmCreationEventJSONFile,
+	}
+	mockDataprocCreationMessage = &gcppubsub.Message{
+		ID:   "56789",
+		Data: test_data.DataprocCreationEventJSONFile,
+	}
+	mockHostErrorMessage = &gcppubsub.Message{
+		ID:   "67890",
+		Data: test_data.HostErrorEventJSONFile,
+	}
 )
 
+// interruptionStages are the stages of the interruption pipeline without custom stages
+var interruptionStages = []string{StageDecode, StageDedup, StageResolve, StageEnrich, StageFilter, StageEmit}
+
+// creationStages are the stages of the creation pipeline without custom stages
+var creationStages = []string{StageDecode, StageTrack, StageEmit}
+
 func (suite *HandlersTestSuite) SetupSuite() {
-	suite.mockMetrics = mocks.NewClient(suite.T())
 	l, err := zap.NewDevelopment()
 	suite.NoError(err)
 	suite.l = l.Sugar()
 }
 
+func (suite *HandlersTestSuite) SetupTest() {
+	// each test expects every metric it modifies, so that missing calls fail the test making them
+	suite.mockMetrics = mocks.NewClient(suite.T())
+}
+
+// expectHandled expects handler to finish handling n messages
+func (suite *HandlersTestSuite) expectHandled(handler string, n int) {
+	suite.mockMetrics.EXPECT().ObserveHandlerDuration(handler, mock.Anything).Times(n)
+}
+
+// expectStages expects each of stages of the pipeline name to handle n events with result
+func (suite *HandlersTestSuite) expectStages(name, result string, n int, stages ...string) {
+	for _, stage := range stages {
+		suite.mockMetrics.EXPECT().IncreaseStageEventCounter(name, stage, result).Times(n)
+	}
+}
+
+// entry decodes the audit log entry m carries, failing the test if it cannot be
+func (suite *HandlersTestSuite) entry(m *gcppubsub.Message) *auditdata.LogEntryData {
+	entry, err := decodeMessage(m)
+	suite.Require().NoError(err)
+	return entry
+}
+
+// exists returns whether k exists in c, failing the test if c could not be reached
+func exists[V any](suite *HandlersTestSuite, c cache.Cache[string, V], k string) bool {
+	exists, err := c.Exists(k)
+	suite.Require().NoError(err)
+	return exists
+}
+
+// deduplicator creates a Deduplicator keying events with strategy, which remembers them for the default window
+func (suite *HandlersTestSuite) deduplicator(strategy dedup.Strategy, input CacheInput) dedup.Deduplicator {
+	d, err := dedup.NewDeduplicator(dedup.NewDeduplicatorInput{Strategy: strategy, Keys: NewMessageCache(input, dedup.DefaultWindow)})
+	suite.Require().NoError(err)
+	return d
+}
+
+// interruptionPipeline creates the interruption pipeline with the suite's metrics and logger, deduplicating on message IDs
+// and tracking no creation times or stopped instances unless input says otherwise
+func (suite *HandlersTestSuite) interruptionPipeline(input NewInterruptionPipelineInput) *InterruptionPipeline {
+	input.Logger, input.Metrics = suite.l, suite.mockMetrics
+	if input.Deduplicator == nil {
+		input.Deduplicator = suite.deduplicator(dedup.StrategyMessageID, CacheInput{})
+	}
+	if input.CreationTimes == nil {
+		input.CreationTimes = NewInstanceCreationTimes(CacheInput{})
+	}
+	if input.Stopped == nil {
+		input.Stopped = NewStoppedInstances(nil, CacheInput{})
+	}
+	return NewInterruptionPipeline(input)
+}
+
+// creationPipeline creates the creation pipeline with the suite's metrics, logger and the default workload classifier, tracking
+// no creation times or stopped instances unless input says otherwise
+func (suite *HandlersTestSuite) creationPipeline(input NewCreationPipelineInput) *CreationPipeline {
+	input.Logger, input.Metrics, input.Classifier = suite.l, suite.mockMetrics, compute.DefaultWorkloadClassifier()
+	if input.CreationTimes == nil {
+		input.CreationTimes = NewInstanceCreationTimes(CacheInput{})
+	}
+	if input.Stopped == nil {
+		input.Stopped = NewStoppedInstances(nil, CacheInput{})
+	}
+	return NewCreationPipeline(input)
+}
+
 func TestHandlersTestSuite(t *testing.T) {
 	suite.Run(t, new(HandlersTestSuite))
 }
 
 func (suite *HandlersTestSuite) TestHandleInterruptionEvents() {
-	suite.mockMetrics.EXPECT().IncreaseInterruptionEventCounter("fake-cluster").Times(1)
-	initialInstances := map[string]string{
-		"projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65": "fake-cluster",
+	suite.mockMetrics.EXPECT().IncreaseInterruptionEventCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "fake-cluster")).Times(1)
+	suite.mockMetrics.EXPECT().IncreaseNodeTerminationCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "fake-cluster"), "preempted").Times(1)
+	suite.mockMetrics.EXPECT().IncreaseDuplicateMessageCounter("interruption", "message_id").Times(1)
+	suite.expectHandled("interruption", 2)
+	suite.expectStages("interruption", "passed", 1, interruptionStages...)
+	suite.expectStages("interruption", "passed", 1, StageDecode)
+	suite.expectStages("interruption", "skipped", 1, StageDedup)
+	initialInstances := map[string]compute.Workload{
+		"projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65": kubernetesWorkload("fake-cluster"),
 	}
-	instanceToClusterMappings := cache.NewCacheWithTTLFrom(cache.NoExpiration, initialInstances)
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(initialInstances, CacheInput{})
 	interruptions := make(chan *gcppubsub.Message)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go HandleInterruptionEvents(interruptions, instanceToClusterMappings, suite.mockMetrics, suite.l, wg)
+	go HandleInterruptionEvents(interruptions, suite.interruptionPipeline(NewInterruptionPipelineInput{InstanceToWorkloadMappings: instanceToWorkloadMappings}), nil, suite.mockMetrics, wg)
 	interruptions <- mockInterruptionMessage
 	interruptions <- mockInterruptionMessage
 	close(interruptions)
 	wg.Wait()
 }
 
+func (suite *HandlersTestSuite) TestHandleInterruptionEventsHostError() {
+	suite.mockMetrics.EXPECT().IncreaseNodeTerminationCounter(kubernetesLabels("mock-instance-3706-5b909138-hx42", "host-error-cluster"), "host_error").Times(1)
+	suite.expectHandled("interruption", 1)
+	suite.expectStages("interruption", "passed", 1, interruptionStages...)
+	resourceName := "projects/mock-project/zones/europe-west1-c/instances/mock-instance-3706-5b909138-hx42"
+	initialInstances := map[string]compute.Workload{
+		resourceName: kubernetesWorkload("host-error-cluster"),
+	}
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(initialInstances, CacheInput{})
+	interruptions := make(chan *gcppubsub.Message)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go HandleInterruptionEvents(interruptions, suite.interruptionPipeline(NewInterruptionPipelineInput{InstanceToWorkloadMappings: instanceToWorkloadMappings}), nil, suite.mockMetrics, wg)
+	interruptions <- mockHostErrorMessage
+	close(interruptions)
+	wg.Wait()
+
+	// the instance is restarted after a host error, so it must remain tracked
+	suite.True(exists(suite, instanceToWorkloadMappings, resourceName))
+}
+
+func (suite *HandlersTestSuite) TestHandleInterruptionEventsStopTerminationAction() {
+	suite.mockMetrics.EXPECT().IncreaseInterruptionEventCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "stopped-cluster")).Times(1)
+	suite.mockMetrics.EXPECT().IncreaseNodeTerminationCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "stopped-cluster"), "preempted").Times(1)
+	suite.expectHandled("interruption", 1)
+	suite.expectStages("interruption", "passed", 1, interruptionStages...)
+	resourceName := "projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65"
+	initialInstances := map[string]compute.Workload{
+		resourceName: kubernetesWorkload("stopped-cluster"),
+	}
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(initialInstances, CacheInput{})
+	stopped := NewStoppedInstances(initialInstances, CacheInput{})
+	interruptions := make(chan *gcppubsub.Message)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go HandleInterruptionEvents(interruptions, suite.interruptionPipeline(NewInterruptionPipelineInput{InstanceToWorkloadMappings: instanceToWorkloadMappings, Stopped: stopped}), nil, suite.mockMetrics, wg)
+	interruptions <- mockInterruptionMessage
+	close(interruptions)
+	wg.Wait()
+
+	// the instance is stopped rather than deleted, so it can be started again under the same ID
+	suite.True(exists(suite, instanceToWorkloadMappings, resourceName))
+	suite.True(exists(suite, stopped.Stopped, resourceName))
+}
+
+func (suite *HandlersTestSuite) TestHandleInterruptionEventsExpiry() {
+	suite.mockMetrics.EXPECT().IncreaseInterruptionEventCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "expiry-cluster")).Times(1)
+	suite.mockMetrics.EXPECT().IncreaseNodeTerminationCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "expiry-cluster"), "preempted").Times(1)
+	suite.mockMetrics.EXPECT().IncreaseDuplicateMessageCounter("interruption", "message_id").Times(1)
+	suite.mockMetrics.EXPECT().IncreaseUnknownInstanceCounter("interruption").Times(1)
+	suite.expectStages("interruption", "passed", 1, interruptionStages...)
+	suite.expectStages("interruption", "passed", 1, StageDecode, StageDecode, StageDedup)
+	suite.expectStages("interruption", "skipped", 1, StageDedup)
+	suite.expectStages("interruption", "failed", 1, StageResolve)
+	resourceName := "projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65"
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	input := CacheInput{Now: func() time.Time { return now }}
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		resourceName: kubernetesWorkload("expiry-cluster"),
+	}, input)
+	p := suite.interruptionPipeline(NewInterruptionPipelineInput{
+		InstanceToWorkloadMappings: instanceToWorkloadMappings,
+		Deduplicator:               suite.deduplicator(dedup.StrategyMessageID, input),
+		Stopped:                    NewStoppedInstances(nil, input),
+	})
+	handle := func() {
+		_ = p.Process(context.Background(), mockInterruptionMessage, suite.entry(mockInterruptionMessage))
+	}
+
+	handle()
+	// the deleted instance remains tracked for long enough to resolve other events of its termination
+	now = start.Add(RemovedInstanceTTL - time.Second)
+	suite.True(exists(suite, instanceToWorkloadMappings, resourceName))
+	now = start.Add(RemovedInstanceTTL)
+	suite.False(exists(suite, instanceToWorkloadMappings, resourceName))
+
+	// redeliveries are suppressed until the message ID expires, after which the instance is no longer known
+	now = start.Add(dedup.DefaultWindow - time.Second)
+	handle()
+	now = start.Add(dedup.DefaultWindow)
+	handle()
+}
+
+func (suite *HandlersTestSuite) TestHandleInterruptionEventsInsertIDStrategy() {
+	suite.mockMetrics.EXPECT().IncreaseInterruptionEventCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "sink-cluster")).Times(1)
+	suite.mockMetrics.EXPECT().IncreaseNodeTerminationCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "sink-cluster"), "preempted").Times(1)
+	suite.mockMetrics.EXPECT().IncreaseDuplicateMessageCounter("interruption", "insert_id").Times(1)
+	suite.expectHandled("interruption", 2)
+	suite.expectStages("interruption", "passed", 1, interruptionStages...)
+	suite.expectStages("interruption", "passed", 1, StageDecode)
+	suite.expectStages("interruption", "skipped", 1, StageDedup)
+	resourceName := "projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65"
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		resourceName: kubernetesWorkload("sink-cluster"),
+	}, CacheInput{})
+	// the same log entry, routed to the topic by two sinks
+	data := []byte(`{"insertId": "-abc123", "operation": {"id": "operation-1"}, "protoPayload": {"methodName": "compute.instances.preempted", "resourceName": "` + resourceName + `"}}`)
+	store := history.NewRingBuffer(10)
+	interruptions := make(chan *gcppubsub.Message)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go HandleInterruptionEvents(interruptions, suite.interruptionPipeline(NewInterruptionPipelineInput{
+		InstanceToWorkloadMappings: instanceToWorkloadMappings,
+		Deduplicator:               suite.deduplicator(dedup.StrategyInsertID, CacheInput{}),
+		Store:                      store,
+	}), nil, suite.mockMetrics, wg)
+	interruptions <- &gcppubsub.Message{ID: "1", Data: data}
+	interruptions <- &gcppubsub.Message{ID: "2", Data: data}
+	close(interruptions)
+	wg.Wait()
+
+	page, err := store.List(context.Background(), history.Query{})
+	suite.Require().NoError(err)
+	suite.Require().Len(page.Interruptions, 1)
+	suite.Equal("1", page.Interruptions[0].MessageID)
+}
+
 func (suite *HandlersTestSuite) TestHandleCreationEvents() {
+	suite.expectHandled("creation", 1)
+	suite.expectStages("creation", "passed", 1, creationStages...)
 	fakeClusterName := "fake-cluster"
 	fakeInstanceName := "fake-instance"
-	initialInstances := map[string]string{
-		fakeInstanceName: fakeClusterName,
+	initialInstances := map[string]compute.Workload{
+		fakeInstanceName: kubernetesWorkload(fakeClusterName),
 	}
-	instanceToClusterMappings := cache.NewCacheWithTTLFrom(cache.NoExpiration, initialInstances)
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(initialInstances, CacheInput{})
 	additions := make(chan *gcppubsub.Message)
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go HandleCreationEvents(additions, instanceToClusterMappings, suite.l, wg)
-	additions <- mockCreationMessage
+	creationTimes := NewInstanceCreationTimes(CacheInput{})
+	stopped := NewStoppedInstances(nil, CacheInput{})
+	store := history.NewRingBuffer(10)
+	go HandleCreationEvents(additions, suite.creationPipeline(NewCreationPipelineInput{
+		InstanceToWorkloadMappings: instanceToWorkloadMappings,
+		CreationTimes:              creationTimes,
+		Stopped:                    stopped,
+		Store:                      store,
+	}), nil, suite.mockMetrics, wg)
+	additions <- mockSpotCreationMessage
 	close(additions)
 	wg.Wait()
 	resourceName := "projects/mock-project/zones/europe-west1-c/instances/fake-resource"
+	suite.True(exists(suite, stopped.StopOnTermination, resourceName))
+	suite.True(exists(suite, creationTimes, resourceName))
 
-	cluster, err := instanceToClusterMappings.Get(resourceName)
+	workload, err := instanceToWorkloadMappings.Get(resourceName)
 	suite.NoError(err)
-	suite.Equal(fakeClusterName, cluster)
+	suite.Equal(compute.Workload{Type: compute.WorkloadTypeKubernetes, Name: fakeClusterName, NodePool: "spot-pool", MachineType: "e2-standard-4"}, workload)
+
+	workload, err = instanceToWorkloadMappings.Get(fakeInstanceName)
+	suite.NoError(err)
+	suite.Equal(kubernetesWorkload(fakeClusterName), workload)
+
+	page, err := store.ListCreations(context.Background(), history.Query{MachineType: "e2-standard-4"})
+	suite.Require().NoError(err)
+	suite.Require().Len(page.Creations, 1)
+	suite.Equal(resourceName, page.Creations[0].ResourceID)
+	suite.Equal("e2", page.Creations[0].MachineFamily)
+	suite.False(page.Creations[0].Timestamp.IsZero())
+}
+
+func (suite *HandlersTestSuite) TestReconcileInstanceToWorkloadMappings() {
+	// restored from a snapshot taken before node-2 was created, and node-1 and node-3 deleted
+	restored := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		"node-0": kubernetesWorkload("fake-cluster"),
+		"node-1": kubernetesWorkload("fake-cluster"),
+	}, CacheInput{})
+	restored.Insert("node-3", kubernetesWorkload("fake-cluster"))
+	suite.NoError(restored.SetExpiration("node-3", time.Second*30))
+	listed := map[string]compute.Workload{
+		"node-0": kubernetesWorkload("fake-cluster"),
+		"node-2": kubernetesWorkload("other-cluster"),
+	}
+
+	suite.Equal(1, ReconcileInstanceToWorkloadMappings(restored, ReconcileInput{Listed: listed, Tracked: restored.Items(), ListingStarted: time.Now(), Grace: time.Hour}))
+	items := restored.Items()
+	suite.Len(items, 4)
+	suite.True(items["node-0"].Expiration.IsZero())
+	suite.WithinDuration(time.Now().Add(time.Hour), items["node-1"].Expiration, time.Minute)
+	suite.True(items["node-2"].Expiration.IsZero())
+	suite.Equal(kubernetesWorkload("other-cluster"), items["node-2"].Value)
+	// instances already expiring keep their expiration
+	suite.WithinDuration(time.Now().Add(time.Second*30), items["node-3"].Expiration, time.Second*5)
+}
+
+func (suite *HandlersTestSuite) TestReconcileInstanceToWorkloadMappingsWhileHandlingEvents() {
+	m := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		"node-0": kubernetesWorkload("fake-cluster"),
+		"node-1": kubernetesWorkload("fake-cluster"),
+		"node-2": kubernetesWorkload("fake-cluster"),
+		"node-3": kubernetesWorkload("fake-cluster"),
+	}, CacheInput{})
+	creationTimes := NewInstanceCreationTimes(CacheInput{})
+	listingStarted := time.Now()
+	tracked := m.Items()
+	// while listing, node-0 is deleted, node-1 preempted and no longer tracked, node-3 deleted and created again, and node-4 created
+	suite.NoError(m.SetExpiration("node-0", RemovedInstanceTTL))
+	m.Delete("node-1")
+	m.Insert("node-3", kubernetesWorkload("other-cluster"))
+	creationTimes.Insert("node-3", listingStarted.Add(time.Second))
+	m.Insert("node-4", kubernetesWorkload("fake-cluster"))
+	creationTimes.Insert("node-4", listingStarted.Add(time.Second))
+	listed := map[string]compute.Workload{
+		"node-0": kubernetesWorkload("fake-cluster"),
+		"node-1": kubernetesWorkload("fake-cluster"),
+	}
+
+	stale := ReconcileInstanceToWorkloadMappings(m, ReconcileInput{Listed: listed, Tracked: tracked, ListingStarted: listingStarted, CreationTimes: creationTimes, Grace: time.Hour})
+	suite.Equal(1, stale)
+	items := m.Items()
+	suite.Len(items, 4)
+	// instances removed or set to expire while listing are not tracked again
+	suite.WithinDuration(time.Now().Add(RemovedInstanceTTL), items["node-0"].Expiration, time.Second*5)
+	suite.NotContains(items, "node-1")
+	// only node-2 was tracked before listing without being listed
+	suite.WithinDuration(time.Now().Add(time.Hour), items["node-2"].Expiration, time.Minute)
+	// instances created while listing are not expired
+	suite.True(items["node-3"].Expiration.IsZero())
+	suite.Equal(kubernetesWorkload("other-cluster"), items["node-3"].Value)
+	suite.True(items["node-4"].Expiration.IsZero())
+}
+
+func (suite *HandlersTestSuite) TestReconcileInstanceCreationTimes() {
+	// restored from a snapshot taken before node-1 was deleted and node-2 stopped being tracked
+	m := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		"node-0": kubernetesWorkload("fake-cluster"),
+		"node-1": kubernetesWorkload("fake-cluster"),
+	}, CacheInput{})
+	suite.NoError(m.SetExpiration("node-1", time.Hour))
+	creationTimes := NewInstanceCreationTimes(CacheInput{})
+	created := time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)
+	for _, k := range []string{"node-0", "node-1", "node-2"} {
+		creationTimes.Insert(k, created)
+	}
+
+	ReconcileInstanceCreationTimes(creationTimes, m)
+	items := creationTimes.Items()
+	suite.Len(items, 2)
+	suite.True(items["node-0"].Expiration.IsZero())
+	suite.WithinDuration(m.Items()["node-1"].Expiration, items["node-1"].Expiration, time.Second*5)
+}
 
-	cluster, err = instanceToClusterMappings.Get(fakeInstanceName)
+func (suite *HandlersTestSuite) TestReportInstanceMappingSize() {
+	now := time.Now()
+	m := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		"node-0": kubernetesWorkload("fake-cluster"),
+		"node-1": kubernetesWorkload("fake-cluster"),
+	}, CacheInput{Now: func() time.Time { return now }})
+	suite.NoError(m.SetExpiration("node-1", RemovedInstanceTTL))
+	now = now.Add(RemovedInstanceTTL)
+	suite.mockMetrics.EXPECT().SetInstanceMappingSize(1).Times(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ReportInstanceMappingSize(ctx, m, suite.mockMetrics, time.Hour)
+}
+
+func (suite *HandlersTestSuite) TestMergeInstanceToWorkloadMappings() {
+	m := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		"node-3": kubernetesWorkload("fake-cluster"),
+		"node-4": kubernetesWorkload("fake-cluster"),
+	}, CacheInput{})
+	tracked := m.Items()
+	// node-1 was created, node-3 preempted, and node-4 deleted and no longer tracked, while seeding in the background
+	m.Insert("node-1", kubernetesWorkload("fake-cluster"))
+	suite.NoError(m.SetExpiration("node-3", time.Second*30))
+	m.Delete("node-4")
+	listed := map[string]compute.Workload{
+		"node-0": kubernetesWorkload("fake-cluster"),
+		"node-3": kubernetesWorkload("fake-cluster"),
+		"node-4": kubernetesWorkload("fake-cluster"),
+	}
+
+	inserted, err := MergeInstanceToWorkloadMappings(m, listed, tracked)
 	suite.NoError(err)
-	suite.Equal(fakeClusterName, cluster)
+	suite.Equal(1, inserted)
+	items := m.Items()
+	suite.Len(items, 3)
+	suite.True(items["node-0"].Expiration.IsZero())
+	suite.True(items["node-1"].Expiration.IsZero())
+	suite.WithinDuration(time.Now().Add(time.Second*30), items["node-3"].Expiration, time.Second*5)
 }
 
-func (suite *HandlersTestSuite) TestMessageToInstanceInterruptionEvent() {
-	event, err := messageToInstanceInterruptionEvent(mockInterruptionMessage)
+func (suite *HandlersTestSuite) TestEntryToInstanceInterruptionEvent() {
+	event, err := entryToInstanceInterruptionEvent(mockInterruptionMessage, suite.entry(mockInterruptionMessage))
 	suite.NoError(err)
 	suite.Equal("projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65", event.ResourceID)
 	suite.Equal("12345", event.MessageID)
+	suite.Equal(pipeline.TerminationCausePreempted, event.Cause)
+	suite.True(event.RemovesInstance)
+
+	event, err = entryToInstanceInterruptionEvent(mockHostErrorMessage, suite.entry(mockHostErrorMessage))
+	suite.NoError(err)
+	suite.Equal(pipeline.TerminationCauseHostError, event.Cause)
+	suite.False(event.RemovesInstance)
+}
+
+func (suite *HandlersTestSuite) TestParseTerminationCause() {
+	tests := map[string]struct {
+		methodName      string
+		cause           pipeline.TerminationCause
+		removesInstance bool
+		expectErr       bool
+	}{
+		"preempted":                     {methodName: "compute.instances.preempted", cause: pipeline.TerminationCausePreempted, removesInstance: true},
+		"host error":                    {methodName: "compute.instances.hostError", cause: pipeline.TerminationCauseHostError},
+		"automatic restart":             {methodName: "compute.instances.automaticRestart", cause: pipeline.TerminationCauseAutomaticRestart},
+		"terminate on host maintenance": {methodName: "compute.instances.terminateOnHostMaintenance", cause: pipeline.TerminationCauseHostMaintenance},
+		"max run duration":              {methodName: "compute.instances.maxRunDurationReached", cause: pipeline.TerminationCauseMaxRunDuration, removesInstance: true},
+		"unsupported":                   {methodName: "v1.compute.instances.insert", expectErr: true},
+	}
+	for name, tc := range tests {
+		suite.Run(name, func() {
+			entry := &auditdata.LogEntryData{ProtoPayload: &auditdata.AuditLog{MethodName: tc.methodName}}
+			c, err := parseTerminationCause(entry)
+			if tc.expectErr {
+				suite.Error(err)
+				return
+			}
+			suite.NoError(err)
+			suite.Equal(tc.cause, c.Cause)
+			suite.Equal(tc.removesInstance, c.RemovesInstance)
+		})
+	}
 }
 
-func (suite *HandlersTestSuite) TestMessageToInstanceCreationEvent() {
-	event, err := messageToInstanceCreationEvent(mockCreationMessage)
+func (suite *HandlersTestSuite) TestEntryToInstanceCreationEvent() {
+	event, err := entryToInstanceCreationEvent(mockCreationMessage, suite.entry(mockCreationMessage), compute.DefaultWorkloadClassifier())
 	suite.NoError(err)
 	suite.Equal("projects/mock-project/zones/europe-west1-c/instances/fake-resource", event.ResourceID)
-	suite.Equal("fake-cluster", event.ClusterName)
+	suite.Equal(kubernetesWorkload("fake-cluster"), event.Workload)
 	suite.Equal("12345", event.MessageID)
+	suite.Empty(event.TerminationAction)
+
+	event, err = entryToInstanceCreationEvent(mockSpotCreationMessage, suite.entry(mockSpotCreationMessage), compute.DefaultWorkloadClassifier())
+	suite.NoError(err)
+	suite.Equal(compute.Workload{Type: compute.WorkloadTypeKubernetes, Name: "fake-cluster", NodePool: "spot-pool", MachineType: "e2-standard-4"}, event.Workload)
+	suite.Equal(TerminationActionStop, event.TerminationAction)
+
+	identity, err := compute.NewClusterIdentity([]string{"cluster-name"}, "", "")
+	suite.NoError(err)
+	classifier, err := compute.NewWorkloadClassifier(identity, compute.WorkloadTypeKubernetes)
+	suite.NoError(err)
+	_, err = entryToInstanceCreationEvent(mockCreationMessage, suite.entry(mockCreationMessage), classifier)
+	suite.Error(err)
+}
+
+func (suite *HandlersTestSuite) TestEntryToInstanceCreationEventCustomIdentity() {
+	identity, err := compute.NewClusterIdentity([]string{"cluster-name"}, "kubeadm-cluster", "^(?P<cluster>[a-z]+)-worker-")
+	suite.NoError(err)
+	classifier, err := compute.NewWorkloadClassifier(identity, compute.WorkloadTypeKubernetes)
+	suite.NoError(err)
+
+	event, err := entryToInstanceCreationEvent(mockKubeadmCreationMessage, suite.entry(mockKubeadmCreationMessage), classifier)
+	suite.NoError(err)
+	suite.Equal("projects/mock-project/zones/europe-west1-c/instances/kubeadm-worker-1", event.ResourceID)
+	suite.Equal(kubernetesWorkload("kubeadm"), event.Workload)
+}
+
+func (suite *HandlersTestSuite) TestEntryToInstanceCreationEventDataproc() {
+	classifier, err := compute.NewWorkloadClassifier(compute.DefaultClusterIdentity(), compute.WorkloadTypeKubernetes, compute.WorkloadTypeDataproc, compute.WorkloadTypeMIG)
+	suite.NoError(err)
+
+	event, err := entryToInstanceCreationEvent(mockDataprocCreationMessage, suite.entry(mockDataprocCreationMessage), classifier)
+	suite.NoError(err)
+	suite.Equal(compute.Workload{Type: compute.WorkloadTypeDataproc, Name: "analytics"}, event.Workload)
+
+	_, err = entryToInstanceCreationEvent(mockDataprocCreationMessage, suite.entry(mockDataprocCreationMessage), compute.DefaultWorkloadClassifier())
+	suite.Error(err)
+}
+
+func (suite *HandlersTestSuite) TestHandleInterruptionEventsDataproc() {
+	labels := metrics.InstanceLabels{
+		Project:      "mock-project",
+		WorkloadType: "dataproc",
+		WorkloadName: "analytics",
+		Zone:         "europe-west1-c",
+		Instance:     "mock-instance-spot-3706-5b909138-nr65",
+	}
+	suite.mockMetrics.EXPECT().IncreaseInterruptionEventCounter(labels).Times(1)
+	suite.mockMetrics.EXPECT().IncreaseNodeTerminationCounter(labels, "preempted").Times(1)
+	suite.expectHandled("interruption", 1)
+	suite.expectStages("interruption", "passed", 1, interruptionStages...)
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		"projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65": {Type: compute.WorkloadTypeDataproc, Name: "analytics"},
+	}, CacheInput{})
+	interruptions := make(chan *gcppubsub.Message)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go HandleInterruptionEvents(interruptions, suite.interruptionPipeline(NewInterruptionPipelineInput{InstanceToWorkloadMappings: instanceToWorkloadMappings}), nil, suite.mockMetrics, wg)
+	interruptions <- &gcppubsub.Message{
+		ID:   "56789",
+		Data: test_data.InterruptionEventJSONFile,
+	}
+	close(interruptions)
+	wg.Wait()
+}
+
+func (suite *HandlersTestSuite) TestHandleInterruptionEventsUnknownInstance() {
+	suite.mockMetrics.EXPECT().IncreaseUnknownInstanceCounter("interruption").Times(1)
+	suite.mockMetrics.EXPECT().IncreaseParseFailureCounter("interruption", "unsupported_method").Times(1)
+	suite.mockMetrics.EXPECT().IncreaseParseFailureCounter("interruption", "unmarshal").Times(1)
+	// messages that cannot be decoded are not handled
+	suite.expectHandled("interruption", 2)
+	suite.expectStages("interruption", "passed", 1, StageDecode, StageDedup)
+	suite.expectStages("interruption", "failed", 1, StageDecode, StageResolve)
+	interruptions := make(chan *gcppubsub.Message)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go HandleInterruptionEvents(interruptions, suite.interruptionPipeline(NewInterruptionPipelineInput{InstanceToWorkloadMappings: NewInstanceToWorkloadMappings(nil, CacheInput{})}), nil, suite.mockMetrics, wg)
+	interruptions <- &gcppubsub.Message{
+		ID:   "unknown-instance",
+		Data: test_data.InterruptionEventJSONFile,
+	}
+	interruptions <- &gcppubsub.Message{
+		ID:   "unsupported-method",
+		Data: test_data.CreationEventJSONFile,
+	}
+	interruptions <- &gcppubsub.Message{ID: "not-json", Data: []byte("not json")}
+	close(interruptions)
+	wg.Wait()
+}
+
+func (suite *HandlersTestSuite) TestParseFailureReason() {
+	_, err := decodeMessage(&gcppubsub.Message{Data: []byte("not json")})
+	suite.Equal(parseFailureReasonUnmarshal, parseFailureReason(err))
+
+	_, err = entryToInstanceCreationEvent(mockDataprocCreationMessage, suite.entry(mockDataprocCreationMessage), compute.DefaultWorkloadClassifier())
+	suite.Equal(parseFailureReasonUnrecognisedWorkload, parseFailureReason(err))
+
+	_, err = entryToInstanceLifecycleEvent(mockInterruptionMessage, suite.entry(mockInterruptionMessage))
+	suite.Equal(parseFailureReasonUnsupportedMethod, parseFailureReason(err))
+
+	suite.Equal(parseFailureReasonUnknown, parseFailureReason(errors.New("unexpected")))
+}
+
+func (suite *HandlersTestSuite) TestResourceKey() {
+	// events are keyed by the instance ID whichever field identifies it, so events for the same instance are handled in order
+	suite.Equal("projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65", resourceKey(mockInterruptionMessage, suite.entry(mockInterruptionMessage)))
+	suite.Equal("projects/mock-project/zones/europe-west1-c/instances/fake-resource", resourceKey(mockCreationMessage, suite.entry(mockCreationMessage)))
+	suite.Equal("1", resourceKey(&gcppubsub.Message{ID: "1"}, &auditdata.LogEntryData{}))
+}
+
+func kubernetesWorkload(cluster string) compute.Workload {
+	return compute.Workload{Type: compute.WorkloadTypeKubernetes, Name: cluster}
+}
+
+func kubernetesLabels(instance, cluster string) metrics.InstanceLabels {
+	return metrics.InstanceLabels{
+		KubernetesCluster: cluster,
+		Project:           "mock-project",
+		WorkloadType:      string(compute.WorkloadTypeKubernetes),
+		WorkloadName:      cluster,
+		Zone:              "europe-west1-c",
+		Instance:          instance,
+	}
 }
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/googleapis/google-cloudevents-go/cloud/auditdata"
+	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics"
+	"github.com/thought-machine/spot-interruption-exporter/internal/workers"
+	"go.uber.org/zap"
+)
+
+// TerminationActionStop is the instanceTerminationAction of spot instances that are stopped rather than deleted when interrupted
+const TerminationActionStop = "STOP"
+
+type lifecycleAction string
+
+const (
+	lifecycleActionStart lifecycleAction = "start"
+	lifecycleActionStop  lifecycleAction = "stop"
+)
+
+// lifecycleMethods maps the method names logged when an instance is started or stopped to their action. User initiated
+// events are versioned, e.g. v1.compute.instances.stop, whereas system events are not.
+var lifecycleMethods = map[string]lifecycleAction{
+	"compute.instances.start":      lifecycleActionStart,
+	"v1.compute.instances.start":   lifecycleActionStart,
+	"beta.compute.instances.start": lifecycleActionStart,
+	"compute.instances.stop":       lifecycleActionStop,
+	"v1.compute.instances.stop":    lifecycleActionStop,
+	"beta.compute.instances.stop":  lifecycleActionStop,
+}
+
+// StoppedInstances tracks instances that are stopped rather than deleted when they are terminated.
+// Such instances can be started again under the same ID, so they must stay in the mapping of instances to workloads.
+type StoppedInstances struct {
+	// StopOnTermination contains every instance configured with a STOP termination action
+	StopOnTermination cache.Cache[string, compute.Workload]
+	// Stopped maps every currently stopped instance to the time it was stopped at
+	Stopped cache.Cache[string, time.Time]
+}
+
+// NewStoppedInstances creates a StoppedInstances where stopOnTermination seeds the instances configured with a STOP termination action
+func NewStoppedInstances(stopOnTermination map[string]compute.Workload, input CacheInput) *StoppedInstances {
+	return &StoppedInstances{
+		StopOnTermination: newCache(input, StopOnTerminationCacheName, cache.NoExpiration, 0, stopOnTermination),
+		Stopped:           newCache[time.Time](input, StoppedCacheName, cache.NoExpiration, 0, nil),
+	}
+}
+
+// markStopped records that the instance k was stopped at t, returning false if it was already stopped
+func (s *StoppedInstances) markStopped(k string, t time.Time) (bool, error) {
+	inserted, err := s.Stopped.InsertIfAbsent(k, t)
+	if err != nil {
+		return false, fmt.Errorf("failed to record %s as stopped: %w", k, err)
+	}
+	return inserted, nil
+}
+
+// markStarted removes the instance k from the stopped instances, returning how long it was stopped for
+func (s *StoppedInstances) markStarted(k string, t time.Time) (time.Duration, error) {
+	stoppedAt, err := s.Stopped.Get(k)
+	if err != nil {
+		return 0, err
+	}
+	s.Stopped.Delete(k)
+	return t.Sub(stoppedAt), nil
+}
+
+// expire stops tracking the instance k as stopped on termination after ttl, as the mapping of instances to workloads does
+// for instances that no longer exist
+func (s *StoppedInstances) expire(k string, ttl time.Duration) {
+	// instances without a STOP termination action are not tracked
+	_ = s.StopOnTermination.SetExpiration(k, ttl)
+	s.Stopped.Delete(k)
+}
+
+type instanceLifecycleEvent struct {
+	MessageID  string
+	ResourceID string
+	Action     lifecycleAction
+	Timestamp  time.Time
+}
+
+// HandleLifecycleEvents reads start, stop and delete events from lifecycle, tracking how long stopped instances remain stopped
+// for, and passing deletions to the same handler as NewComputeRouter does, so that deleted instances are no longer tracked.
+// Events are handled on pool if it is not nil.
+func HandleLifecycleEvents(lifecycle chan *gcppubsub.Message, instanceToWorkloadMappings cache.Cache[string, compute.Workload], creationTimes cache.Cache[string, time.Time], stopped *StoppedInstances, pool workers.Pool, metrics metrics.Client, l *zap.SugaredLogger, wg *sync.WaitGroup) {
+	defer wg.Done()
+	decodeMessages(lifecycle, lifecycleHandlerName, pool, metrics, l, func(m *gcppubsub.Message, entry *auditdata.LogEntryData) {
+		if deletionMethods[entry.GetProtoPayload().GetMethodName()] {
+			handleDeletionEvent(m, entry, instanceToWorkloadMappings, creationTimes, stopped, metrics, l)
+			return
+		}
+		handleLifecycleEvent(m, entry, instanceToWorkloadMappings, stopped, metrics, l)
+	})
+}
+
+func handleLifecycleEvent(m *gcppubsub.Message, entry *auditdata.LogEntryData, instanceToWorkloadMappings cache.Cache[string, compute.Workload], stopped *StoppedInstances, metrics metrics.Client, l *zap.SugaredLogger) {
+	e, err := entryToInstanceLifecycleEvent(m, entry)
+	if err != nil {
+		l.Warnf("failed to convert pubsub message to lifecycle event: %s", err.Error())
+		metrics.IncreaseParseFailureCounter(lifecycleHandlerName, parseFailureReason(err))
+		return
+	}
+	s := l.With("message_id", e.MessageID, "resource_id", e.ResourceID)
+	// every instance in the project is started and stopped, so untracked instances are expected rather than counted as unknown
+	workload, err := instanceToWorkloadMappings.Get(e.ResourceID)
+	if err != nil {
+		s.Debugf("ignoring %s of untracked instance: %s", e.Action, err.Error())
+		return
+	}
+	labels := instanceLabels(e.ResourceID, workload)
+	s = s.With("kubernetes_cluster", labels.KubernetesCluster, "project", labels.Project, "workload_type", labels.WorkloadType, "workload_name", labels.WorkloadName)
+	switch e.Action {
+	case lifecycleActionStop:
+		marked, err := stopped.markStopped(e.ResourceID, e.Timestamp)
+		if err != nil {
+			s.Warn(err.Error())
+		} else if marked {
+			s.Info("stopped")
+		}
+	case lifecycleActionStart:
+		d, err := stopped.markStarted(e.ResourceID, e.Timestamp)
+		if err != nil {
+			s.Debugf("started instance was not known to be stopped: %s", err.Error())
+			return
+		}
+		s.With("stopped_duration", d).Info("started")
+		metrics.ObserveStoppedDuration(labels, d)
+	}
+}
+
+func entryToInstanceLifecycleEvent(m *gcppubsub.Message, entry *auditdata.LogEntryData) (instanceLifecycleEvent, error) {
+	methodName := entry.GetProtoPayload().GetMethodName()
+	action, ok := lifecycleMethods[methodName]
+	if !ok {
+		return instanceLifecycleEvent{}, newParseError(parseFailureReasonUnsupportedMethod, "unsupported lifecycle method %q", methodName)
+	}
+	return instanceLifecycleEvent{
+		MessageID:  m.ID,
+		ResourceID: entry.GetProtoPayload().GetResourceName(),
+		Action:     action,
+		Timestamp:  entryTimestamp(entry),
+	}, nil
+}
+
+// entryTimestamp returns the time the log entry was written at, or now if it has no timestamp
+func entryTimestamp(entry *auditdata.LogEntryData) time.Time {
+	if entry.GetTimestamp() == nil {
+		return time.Now()
+	}
+	return entry.GetTimestamp().AsTime()
+}
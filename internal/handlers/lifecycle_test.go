@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+	"github.com/thought-machine/spot-interruption-exporter/internal/handlers/test_data"
+)
+
+var (
+	mockStopMessage = &gcppubsub.Message{
+		ID:   "23456",
+		Data: test_data.StopEventJSONFile,
+	}
+	mockStartMessage = &gcppubsub.Message{
+		ID:   "34567",
+		Data: test_data.StartEventJSONFile,
+	}
+	mockDeleteMessage = &gcppubsub.Message{
+		ID:   "78901",
+		Data: test_data.DeleteEventJSONFile,
+	}
+)
+
+func (suite *HandlersTestSuite) TestHandleLifecycleEvents() {
+	suite.mockMetrics.EXPECT().ObserveStoppedDuration(kubernetesLabels("fake-resource", "lifecycle-cluster"), time.Minute*5).Times(1)
+	suite.expectHandled("lifecycle", 4)
+	resourceName := "projects/mock-project/zones/europe-west1-c/instances/fake-resource"
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		resourceName: kubernetesWorkload("lifecycle-cluster"),
+	}, CacheInput{})
+	stopped := NewStoppedInstances(nil, CacheInput{})
+	lifecycle := make(chan *gcppubsub.Message)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go HandleLifecycleEvents(lifecycle, instanceToWorkloadMappings, NewInstanceCreationTimes(CacheInput{}), stopped, nil, suite.mockMetrics, suite.l, wg)
+	lifecycle <- mockStopMessage
+	// a duplicate stop must not reset the time the instance was stopped at
+	lifecycle <- mockStopMessage
+	lifecycle <- mockStartMessage
+	// starting an instance that is not stopped is ignored
+	lifecycle <- mockStartMessage
+	close(lifecycle)
+	wg.Wait()
+
+	suite.False(exists(suite, stopped.Stopped, resourceName))
+	suite.True(exists(suite, instanceToWorkloadMappings, resourceName))
+}
+
+func (suite *HandlersTestSuite) TestHandleLifecycleEventsDeletion() {
+	suite.expectHandled("lifecycle", 2)
+	resourceName := "projects/mock-project/zones/europe-west1-c/instances/fake-resource"
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		resourceName: kubernetesWorkload("lifecycle-cluster"),
+	}, CacheInput{})
+	creationTimes := NewInstanceCreationTimes(CacheInput{})
+	creationTimes.Insert(resourceName, time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC))
+	stopped := NewStoppedInstances(map[string]compute.Workload{resourceName: kubernetesWorkload("lifecycle-cluster")}, CacheInput{})
+	lifecycle := make(chan *gcppubsub.Message)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go HandleLifecycleEvents(lifecycle, instanceToWorkloadMappings, creationTimes, stopped, nil, suite.mockMetrics, suite.l, wg)
+	lifecycle <- mockStopMessage
+	lifecycle <- mockDeleteMessage
+	close(lifecycle)
+	wg.Wait()
+
+	// deleted instances remain tracked long enough to resolve their interruptions
+	suite.False(instanceToWorkloadMappings.Items()[resourceName].Expiration.IsZero())
+	suite.False(creationTimes.Items()[resourceName].Expiration.IsZero())
+	suite.False(stopped.StopOnTermination.Items()[resourceName].Expiration.IsZero())
+	suite.False(exists(suite, stopped.Stopped, resourceName))
+}
+
+func (suite *HandlersTestSuite) TestEntryToInstanceLifecycleEvent() {
+	event, err := entryToInstanceLifecycleEvent(mockStopMessage, suite.entry(mockStopMessage))
+	suite.NoError(err)
+	suite.Equal("projects/mock-project/zones/europe-west1-c/instances/fake-resource", event.ResourceID)
+	suite.Equal(lifecycleActionStop, event.Action)
+	suite.Equal(time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC), event.Timestamp.UTC())
+
+	event, err = entryToInstanceLifecycleEvent(mockStartMessage, suite.entry(mockStartMessage))
+	suite.NoError(err)
+	suite.Equal(lifecycleActionStart, event.Action)
+
+	for _, m := range []*gcppubsub.Message{
+		mockDeleteMessage,
+		mockInterruptionMessage,
+		{ID: "1", Data: []byte(`{"protoPayload": {"methodName": "v1.compute.instances.startWithEncryptionKey"}}`)},
+	} {
+		_, err = entryToInstanceLifecycleEvent(m, suite.entry(m))
+		suite.Error(err)
+		suite.Equal(parseFailureReasonUnsupportedMethod, parseFailureReason(err))
+	}
+}
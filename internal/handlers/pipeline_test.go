@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/stretchr/testify/mock"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+	"github.com/thought-machine/spot-interruption-exporter/internal/history"
+	kubemocks "github.com/thought-machine/spot-interruption-exporter/internal/kube/mocks"
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics/mocks"
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+	notifymocks "github.com/thought-machine/spot-interruption-exporter/internal/notify/mocks"
+	stormmocks "github.com/thought-machine/spot-interruption-exporter/internal/storm/mocks"
+	"github.com/thought-machine/spot-interruption-exporter/internal/stream"
+	"github.com/thought-machine/spot-interruption-exporter/pkg/pipeline"
+)
+
+func (suite *HandlersTestSuite) TestInterruptionPipelineCustomStages() {
+	suite.mockMetrics.EXPECT().IncreaseInterruptionEventCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "relabelled-cluster")).Times(1)
+	suite.mockMetrics.EXPECT().IncreaseNodeTerminationCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "relabelled-cluster"), "preempted").Times(1)
+	suite.expectStages("interruption", "passed", 1, append(interruptionStages, "relabel", "inspect")...)
+	var stages []string
+	p := suite.interruptionPipeline(NewInterruptionPipelineInput{
+		InstanceToWorkloadMappings: NewInstanceToWorkloadMappings(map[string]compute.Workload{
+			"projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65": kubernetesWorkload("original-cluster"),
+		}, CacheInput{}),
+		CreationTimes: NewInstanceCreationTimes(CacheInput{}),
+		Stages: []pipeline.Stage[*pipeline.InterruptionEvent]{
+			pipeline.StageFunc[*pipeline.InterruptionEvent]{StageName: "relabel", Func: func(_ context.Context, e *pipeline.InterruptionEvent) error {
+				stages = append(stages, "relabel")
+				e.Labels.KubernetesCluster = "relabelled-cluster"
+				e.Labels.WorkloadName = "relabelled-cluster"
+				return nil
+			}},
+			pipeline.StageFunc[*pipeline.InterruptionEvent]{StageName: "inspect", Func: func(_ context.Context, e *pipeline.InterruptionEvent) error {
+				stages = append(stages, "inspect")
+				suite.Equal("relabelled-cluster", e.Labels.KubernetesCluster)
+				suite.Equal(pipeline.TerminationCausePreempted, e.Cause)
+				return nil
+			}},
+		},
+	})
+
+	suite.NoError(p.Process(context.Background(), mockInterruptionMessage, suite.entry(mockInterruptionMessage)))
+	suite.Equal([]string{"relabel", "inspect"}, stages)
+}
+
+func (suite *HandlersTestSuite) TestInterruptionPipelineFilter() {
+	m := mocks.NewClient(suite.T())
+	for _, stage := range []string{StageDecode, StageDedup, StageResolve, StageEnrich} {
+		m.EXPECT().IncreaseStageEventCounter("interruption", stage, "passed").Times(1)
+	}
+	m.EXPECT().IncreaseStageEventCounter("interruption", StageFilter, "skipped").Times(1)
+	p := NewInterruptionPipeline(NewInterruptionPipelineInput{
+		Logger:  suite.l,
+		Metrics: m,
+		InstanceToWorkloadMappings: NewInstanceToWorkloadMappings(map[string]compute.Workload{
+			"projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65": kubernetesWorkload("ignored-cluster"),
+		}, CacheInput{}),
+		CreationTimes: NewInstanceCreationTimes(CacheInput{}),
+		Deduplicator:  suite.deduplicator("", CacheInput{}),
+		Stopped:       NewStoppedInstances(nil, CacheInput{}),
+		Filters: []func(e *pipeline.InterruptionEvent) bool{
+			func(e *pipeline.InterruptionEvent) bool { return e.Labels.KubernetesCluster != "ignored-cluster" },
+		},
+	})
+
+	// skipped events are not failures, and are never emitted
+	suite.NoError(p.Process(context.Background(), mockInterruptionMessage, suite.entry(mockInterruptionMessage)))
+}
+
+func (suite *HandlersTestSuite) TestInterruptionPipelineStageError() {
+	m := mocks.NewClient(suite.T())
+	m.EXPECT().IncreaseParseFailureCounter("interruption", "unsupported_method").Times(1)
+	m.EXPECT().IncreaseStageEventCounter("interruption", StageDecode, "failed").Times(1)
+	p := NewInterruptionPipeline(NewInterruptionPipelineInput{
+		Logger:                     suite.l,
+		Metrics:                    m,
+		InstanceToWorkloadMappings: NewInstanceToWorkloadMappings(nil, CacheInput{}),
+		CreationTimes:              NewInstanceCreationTimes(CacheInput{}),
+		Deduplicator:               suite.deduplicator("", CacheInput{}),
+		Stopped:                    NewStoppedInstances(nil, CacheInput{}),
+	})
+
+	unsupported := &gcppubsub.Message{ID: "1", Data: []byte(`{"protoPayload": {"methodName": "compute.instances.setLabels"}}`)}
+	err := p.Process(context.Background(), unsupported, suite.entry(unsupported))
+	var stageErr *pipeline.StageError
+	suite.Require().True(errors.As(err, &stageErr))
+	suite.Equal(StageDecode, stageErr.Stage)
+}
+
+func (suite *HandlersTestSuite) TestCreationPipelineCustomStages() {
+	suite.expectStages("creation", "passed", 1, append(creationStages, "relabel")...)
+	resourceName := "projects/mock-project/zones/europe-west1-c/instances/fake-resource"
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(nil, CacheInput{})
+	p := suite.creationPipeline(NewCreationPipelineInput{
+		InstanceToWorkloadMappings: instanceToWorkloadMappings,
+		Stages: []pipeline.Stage[*pipeline.CreationEvent]{
+			pipeline.StageFunc[*pipeline.CreationEvent]{StageName: "relabel", Func: func(_ context.Context, e *pipeline.CreationEvent) error {
+				suite.Equal(resourceName, e.ResourceID)
+				e.Workload.NodePool = "relabelled-pool"
+				return nil
+			}},
+		},
+	})
+
+	suite.NoError(p.Process(context.Background(), mockSpotCreationMessage, suite.entry(mockSpotCreationMessage)))
+	workload, err := instanceToWorkloadMappings.Get(resourceName)
+	suite.Require().NoError(err)
+	suite.Equal("relabelled-pool", workload.NodePool)
+}
+
+// emitInterruption passes the preemption of a node of fake-cluster through the interruption pipeline created with input,
+// expecting it to be emitted
+func (suite *HandlersTestSuite) emitInterruption(input NewInterruptionPipelineInput) {
+	labels := kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "fake-cluster")
+	suite.mockMetrics.EXPECT().IncreaseInterruptionEventCounter(labels).Times(1)
+	suite.mockMetrics.EXPECT().IncreaseNodeTerminationCounter(labels, "preempted").Times(1)
+	suite.expectStages("interruption", "passed", 1, interruptionStages...)
+	input.InstanceToWorkloadMappings = NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		"projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65": kubernetesWorkload("fake-cluster"),
+	}, CacheInput{})
+
+	suite.NoError(suite.interruptionPipeline(input).Process(context.Background(), mockInterruptionMessage, suite.entry(mockInterruptionMessage)))
+}
+
+func (suite *HandlersTestSuite) TestInterruptionPipelineRecordsNodeInterruption() {
+	recorder := kubemocks.NewRecorder(suite.T())
+	recorder.EXPECT().RecordNodeInterruption("fake-cluster", "mock-instance-spot-3706-5b909138-nr65", "preempted").Times(1)
+
+	suite.emitInterruption(NewInterruptionPipelineInput{Recorder: recorder})
+}
+
+func (suite *HandlersTestSuite) TestInterruptionPipelineNotifies() {
+	notifier := notifymocks.NewNotifier(suite.T())
+	notifier.EXPECT().Notify(mock.MatchedBy(func(i notify.Interruption) bool {
+		return i.ResourceID == "projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65" &&
+			i.Cluster == "fake-cluster" &&
+			i.Zone == "europe-west1-c" &&
+			i.Instance == "mock-instance-spot-3706-5b909138-nr65" &&
+			i.Cause == "preempted" &&
+			!i.Timestamp.IsZero()
+	})).Times(1)
+
+	suite.emitInterruption(NewInterruptionPipelineInput{Notifier: notifier})
+}
+
+func (suite *HandlersTestSuite) TestInterruptionPipelineObservesStorms() {
+	detector := stormmocks.NewDetector(suite.T())
+	detector.EXPECT().Observe(mock.MatchedBy(func(i notify.Interruption) bool {
+		return i.Zone == "europe-west1-c" && i.Cluster == "fake-cluster"
+	})).Times(1)
+
+	suite.emitInterruption(NewInterruptionPipelineInput{Detector: detector})
+}
+
+func (suite *HandlersTestSuite) TestInterruptionPipelineStoresHistory() {
+	store := history.NewRingBuffer(10)
+
+	suite.emitInterruption(NewInterruptionPipelineInput{Store: store})
+	page, err := store.List(context.Background(), history.Query{})
+	suite.Require().NoError(err)
+	suite.Require().Len(page.Interruptions, 1)
+	suite.Equal(mockInterruptionMessage.ID, page.Interruptions[0].MessageID)
+}
+
+func (suite *HandlersTestSuite) TestInterruptionPipelineBroadcasts() {
+	broadcaster := stream.NewBroadcaster(stream.NewBroadcasterInput{Logger: suite.l})
+	live, unsubscribe := broadcaster.Subscribe(stream.Filter{Clusters: []string{"fake-cluster"}})
+	defer unsubscribe()
+
+	suite.emitInterruption(NewInterruptionPipelineInput{Broadcaster: broadcaster})
+	suite.Require().Len(live, 1)
+	suite.Equal(mockInterruptionMessage.ID, (<-live).MessageID)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/googleapis/google-cloudevents-go/cloud/auditdata"
+	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics"
+	"github.com/thought-machine/spot-interruption-exporter/internal/workers"
+	"go.uber.org/zap"
+)
+
+// Router handles the compute audit log events of a single subscription, passing each to the handler registered for its
+// method name, so that handling another type of event only takes registering a handler for it
+type Router struct {
+	routes  map[string]route
+	pool    workers.Pool
+	metrics metrics.Client
+	log     *zap.SugaredLogger
+}
+
+type route struct {
+	// handler names the handler in metrics
+	handler string
+	handle  func(m *gcppubsub.Message, entry *auditdata.LogEntryData)
+}
+
+// NewRouterInput defines all required fields to create a Router
+type NewRouterInput struct {
+	Logger  *zap.SugaredLogger
+	Metrics metrics.Client
+	// Pool is optional, and handles events on its workers rather than one at a time
+	Pool workers.Pool
+}
+
+// NewRouter creates a Router without any handlers registered
+func NewRouter(input NewRouterInput) *Router {
+	return &Router{
+		routes:  map[string]route{},
+		pool:    input.Pool,
+		metrics: input.Metrics,
+		log:     input.Logger,
+	}
+}
+
+// Register passes events whose method name is methodName to handle, along with the audit log entry they carry, replacing any
+// handler already registered for it. handler names the handler in metrics. Versioned method names, e.g.
+// v1.compute.instances.insert, are those without the version.
+func (r *Router) Register(methodName string, handler string, handle func(m *gcppubsub.Message, entry *auditdata.LogEntryData)) {
+	r.routes[unversionedMethodName(methodName)] = route{handler: handler, handle: handle}
+}
+
+// HandleEvents reads from events and passes each to the handler registered for its method name
+func (r *Router) HandleEvents(events chan *gcppubsub.Message, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for m := range events {
+		r.route(m)
+	}
+}
+
+func (r *Router) route(m *gcppubsub.Message) {
+	entry, err := decodeMessage(m)
+	if err != nil {
+		r.log.With("message_id", m.ID).Warnf("failed to convert pubsub message to audit log entry: %s", err.Error())
+		r.metrics.IncreaseParseFailureCounter(routerHandlerName, parseFailureReason(err))
+		return
+	}
+	methodName := entry.GetProtoPayload().GetMethodName()
+	rt, ok := r.routes[unversionedMethodName(methodName)]
+	if !ok {
+		// the log sink should only forward methods that are handled
+		r.log.With("message_id", m.ID).Warnf("no handler registered for method %q", methodName)
+		r.metrics.IncreaseParseFailureCounter(routerHandlerName, parseFailureReasonUnsupportedMethod)
+		return
+	}
+	dispatch(r.pool, resourceKey(m, entry), func() {
+		start := time.Now()
+		rt.handle(m, entry)
+		r.metrics.ObserveHandlerDuration(rt.handler, time.Since(start))
+	})
+}
+
+// unversionedMethodName strips the API version user initiated methods are prefixed with, e.g. v1.compute.instances.insert
+func unversionedMethodName(methodName string) string {
+	if strings.HasPrefix(methodName, "compute.") {
+		return methodName
+	}
+	if i := strings.Index(methodName, ".compute."); i >= 0 {
+		return methodName[i+1:]
+	}
+	return methodName
+}
+
+// NewComputeRouterInput defines all required fields to create a Router handling every compute event the exporter uses
+type NewComputeRouterInput struct {
+	Logger                     *zap.SugaredLogger
+	Metrics                    metrics.Client
+	InstanceToWorkloadMappings cache.Cache[string, compute.Workload]
+	CreationTimes              cache.Cache[string, time.Time]
+	Stopped                    *StoppedInstances
+	// InterruptionPipeline handles terminations, e.g. one created by NewInterruptionPipeline
+	InterruptionPipeline *InterruptionPipeline
+	// CreationPipeline handles insertions, e.g. one created by NewCreationPipeline
+	CreationPipeline *CreationPipeline
+	// Pool is optional
+	Pool workers.Pool
+}
+
+// NewComputeRouter creates a Router passing every creation through the creation pipeline and every termination through the
+// interruption pipeline, and handling the deletion, starting and stopping of instances. Further handlers can be registered on it.
+func NewComputeRouter(input NewComputeRouterInput) *Router {
+	r := NewRouter(NewRouterInput{Logger: input.Logger, Metrics: input.Metrics, Pool: input.Pool})
+	r.Register("compute.instances.insert", creationHandlerName, func(m *gcppubsub.Message, entry *auditdata.LogEntryData) {
+		// failures are logged and recorded in metrics by the pipeline
+		_ = input.CreationPipeline.Process(context.Background(), m, entry)
+	})
+	for methodName := range terminationParsers {
+		r.Register(methodName, interruptionHandlerName, func(m *gcppubsub.Message, entry *auditdata.LogEntryData) {
+			// failures are logged and recorded in metrics by the pipeline
+			_ = input.InterruptionPipeline.Process(context.Background(), m, entry)
+		})
+	}
+	r.Register("compute.instances.delete", deletionHandlerName, func(m *gcppubsub.Message, entry *auditdata.LogEntryData) {
+		handleDeletionEvent(m, entry, input.InstanceToWorkloadMappings, input.CreationTimes, input.Stopped, input.Metrics, input.Logger)
+	})
+	for _, methodName := range []string{"compute.instances.start", "compute.instances.stop"} {
+		r.Register(methodName, lifecycleHandlerName, func(m *gcppubsub.Message, entry *auditdata.LogEntryData) {
+			handleLifecycleEvent(m, entry, input.InstanceToWorkloadMappings, input.Stopped, input.Metrics, input.Logger)
+		})
+	}
+	return r
+}
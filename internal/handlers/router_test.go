@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/googleapis/google-cloudevents-go/cloud/auditdata"
+	"github.com/stretchr/testify/mock"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+)
+
+func (suite *HandlersTestSuite) TestComputeRouter() {
+	suite.mockMetrics.EXPECT().IncreaseInterruptionEventCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "fake-cluster")).Times(1)
+	suite.mockMetrics.EXPECT().IncreaseNodeTerminationCounter(kubernetesLabels("mock-instance-spot-3706-5b909138-nr65", "fake-cluster"), "preempted").Times(1)
+	suite.mockMetrics.EXPECT().ObserveStoppedDuration(mock.Anything, time.Minute*5).Times(1)
+	suite.mockMetrics.EXPECT().IncreaseParseFailureCounter("router", "unsupported_method").Times(1)
+	suite.expectHandled("creation", 1)
+	suite.expectHandled("lifecycle", 2)
+	suite.expectHandled("interruption", 1)
+	suite.expectHandled("deletion", 1)
+	suite.expectStages("creation", "passed", 1, creationStages...)
+	suite.expectStages("interruption", "passed", 1, interruptionStages...)
+	resourceName := "projects/mock-project/zones/europe-west1-c/instances/fake-resource"
+	instanceToWorkloadMappings := NewInstanceToWorkloadMappings(map[string]compute.Workload{
+		"projects/mock-project/zones/europe-west1-c/instances/mock-instance-spot-3706-5b909138-nr65": kubernetesWorkload("fake-cluster"),
+	}, CacheInput{})
+	creationTimes := NewInstanceCreationTimes(CacheInput{})
+	stopped := NewStoppedInstances(nil, CacheInput{})
+	router := NewComputeRouter(NewComputeRouterInput{
+		Logger:                     suite.l,
+		Metrics:                    suite.mockMetrics,
+		InstanceToWorkloadMappings: instanceToWorkloadMappings,
+		CreationTimes:              creationTimes,
+		Stopped:                    stopped,
+		InterruptionPipeline:       suite.interruptionPipeline(NewInterruptionPipelineInput{InstanceToWorkloadMappings: instanceToWorkloadMappings, CreationTimes: creationTimes, Stopped: stopped}),
+		CreationPipeline:           suite.creationPipeline(NewCreationPipelineInput{InstanceToWorkloadMappings: instanceToWorkloadMappings, CreationTimes: creationTimes, Stopped: stopped}),
+	})
+	computeEvents := make(chan *gcppubsub.Message)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go router.HandleEvents(computeEvents, wg)
+	computeEvents <- mockSpotCreationMessage
+	// the stop and start are of the instance just created, so are only tracked once its creation has been handled
+	computeEvents <- mockStopMessage
+	computeEvents <- mockStartMessage
+	computeEvents <- mockInterruptionMessage
+	computeEvents <- &gcppubsub.Message{ID: "1", Data: []byte(`{"protoPayload": {"methodName": "v1.compute.instances.setLabels"}}`)}
+	computeEvents <- mockDeleteMessage
+	close(computeEvents)
+	wg.Wait()
+
+	// deleted instances remain tracked long enough to resolve their interruptions
+	suite.False(instanceToWorkloadMappings.Items()[resourceName].Expiration.IsZero())
+	suite.False(stopped.StopOnTermination.Items()[resourceName].Expiration.IsZero())
+	suite.False(exists(suite, stopped.Stopped, resourceName))
+}
+
+func (suite *HandlersTestSuite) TestRouterRegister() {
+	suite.expectHandled("labels", 2)
+	router := NewRouter(NewRouterInput{Logger: suite.l, Metrics: suite.mockMetrics})
+	var routed []string
+	router.Register("compute.instances.setLabels", "labels", func(m *gcppubsub.Message, _ *auditdata.LogEntryData) {
+		routed = append(routed, m.ID)
+	})
+	computeEvents := make(chan *gcppubsub.Message)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go router.HandleEvents(computeEvents, wg)
+	computeEvents <- &gcppubsub.Message{ID: "1", Data: []byte(`{"protoPayload": {"methodName": "v1.compute.instances.setLabels"}}`)}
+	computeEvents <- &gcppubsub.Message{ID: "2", Data: []byte(`{"protoPayload": {"methodName": "beta.compute.instances.setLabels"}}`)}
+	close(computeEvents)
+	wg.Wait()
+
+	suite.Equal([]string{"1", "2"}, routed)
+}
+
+func (suite *HandlersTestSuite) TestUnversionedMethodName() {
+	suite.Equal("compute.instances.insert", unversionedMethodName("v1.compute.instances.insert"))
+	suite.Equal("compute.instances.insert", unversionedMethodName("beta.compute.instances.insert"))
+	suite.Equal("compute.instances.preempted", unversionedMethodName("compute.instances.preempted"))
+	suite.Equal("unknown", unversionedMethodName("unknown"))
+}
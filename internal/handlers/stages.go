@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/googleapis/google-cloudevents-go/cloud/auditdata"
+	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+	"github.com/thought-machine/spot-interruption-exporter/internal/dedup"
+	"github.com/thought-machine/spot-interruption-exporter/internal/history"
+	"github.com/thought-machine/spot-interruption-exporter/internal/kube"
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics"
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+	"github.com/thought-machine/spot-interruption-exporter/internal/storm"
+	"github.com/thought-machine/spot-interruption-exporter/internal/stream"
+	"github.com/thought-machine/spot-interruption-exporter/pkg/pipeline"
+	"go.uber.org/zap"
+)
+
+// The names of the stages of the interruption pipeline, in the order they run
+const (
+	StageDecode  = "decode"
+	StageDedup   = "dedup"
+	StageResolve = "resolve"
+	StageEnrich  = "enrich"
+	StageFilter  = "filter"
+	StageEmit    = "emit"
+)
+
+// NewInterruptionPipelineInput defines all required fields to create the pipeline interruptions are handled with
+type NewInterruptionPipelineInput struct {
+	Logger                     *zap.SugaredLogger
+	Metrics                    metrics.Client
+	InstanceToWorkloadMappings cache.Cache[string, compute.Workload]
+	CreationTimes              cache.Cache[string, time.Time]
+	Deduplicator               dedup.Deduplicator
+	Stopped                    *StoppedInstances
+	// Recorder, Notifier, Detector, Store and Broadcaster are optional
+	Recorder    kube.Recorder
+	Notifier    notify.Notifier
+	Detector    storm.Detector
+	Store       history.Store
+	Broadcaster stream.Broadcaster
+	// Filters skip events that any of them returns false for
+	Filters []func(e *pipeline.InterruptionEvent) bool
+	// Stages run in order after the filter stage and before the emit stage, e.g. to relabel events or notify other systems.
+	// The exporter passes those given to exporter.Run.
+	Stages []pipeline.Stage[*pipeline.InterruptionEvent]
+}
+
+// InterruptionPipeline passes the interruptions received in pubsub messages through a pipeline of stages
+type InterruptionPipeline struct {
+	stages *pipeline.Pipeline[*pipeline.InterruptionEvent]
+	log    *zap.SugaredLogger
+}
+
+// NewInterruptionPipeline creates the pipeline interruptions are handled with:
+// decode → dedup → resolve → enrich → filter → the given stages → emit
+func NewInterruptionPipeline(input NewInterruptionPipelineInput) *InterruptionPipeline {
+	stages := []pipeline.Stage[*pipeline.InterruptionEvent]{
+		&decodeStage{metrics: input.Metrics},
+		&dedupStage{deduplicator: input.Deduplicator, metrics: input.Metrics},
+		&resolveStage{instanceToWorkloadMappings: input.InstanceToWorkloadMappings, creationTimes: input.CreationTimes, stopped: input.Stopped, metrics: input.Metrics},
+		&enrichStage{},
+		&filterStage{filters: input.Filters},
+	}
+	stages = append(stages, input.Stages...)
+	stages = append(stages, &emitStage{
+		metrics:     input.Metrics,
+		recorder:    input.Recorder,
+		notifier:    input.Notifier,
+		detector:    input.Detector,
+		store:       input.Store,
+		broadcaster: input.Broadcaster,
+	})
+	return &InterruptionPipeline{
+		stages: pipeline.New(pipeline.NewInput[*pipeline.InterruptionEvent]{
+			Name:    interruptionHandlerName,
+			Stages:  stages,
+			Metrics: input.Metrics,
+		}),
+		log: input.Logger.With("pipeline", interruptionHandlerName),
+	}
+}
+
+// Process passes the interruption received in m, which carries entry, through every stage, returning a *pipeline.StageError if one fails
+func (p *InterruptionPipeline) Process(ctx context.Context, m *gcppubsub.Message, entry *auditdata.LogEntryData) error {
+	return p.stages.Process(ctx, &pipeline.InterruptionEvent{Message: m, Entry: entry, MessageID: m.ID, Log: p.log.With("message_id", m.ID)})
+}
+
+// decodeStage reads the termination from the audit log entry the pubsub message carries
+type decodeStage struct {
+	metrics metrics.Client
+}
+
+func (s *decodeStage) Name() string {
+	return StageDecode
+}
+
+func (s *decodeStage) Process(_ context.Context, e *pipeline.InterruptionEvent) error {
+	decoded, err := entryToInstanceInterruptionEvent(e.Message, e.Entry)
+	if err != nil {
+		s.metrics.IncreaseParseFailureCounter(interruptionHandlerName, parseFailureReason(err))
+		return fmt.Errorf("failed to convert pubsub message to interruption event: %w", err)
+	}
+	e.InsertID = decoded.InsertID
+	e.OperationID = decoded.OperationID
+	e.ResourceID = decoded.ResourceID
+	e.Cause = decoded.Cause
+	e.RemovesInstance = decoded.RemovesInstance
+	e.Timestamp = decoded.Timestamp
+	e.Log = e.Log.With("resource_id", e.ResourceID)
+	return nil
+}
+
+// dedupStage skips events that were already handled, e.g. when pubsub delivers a message again
+type dedupStage struct {
+	deduplicator dedup.Deduplicator
+	metrics      metrics.Client
+}
+
+func (s *dedupStage) Name() string {
+	return StageDedup
+}
+
+func (s *dedupStage) Process(_ context.Context, e *pipeline.InterruptionEvent) error {
+	seen, err := s.deduplicator.Seen(dedup.Event{
+		MessageID:   e.MessageID,
+		InsertID:    e.InsertID,
+		OperationID: e.OperationID,
+		ResourceID:  e.ResourceID,
+		Kind:        string(e.Cause),
+		Timestamp:   e.Timestamp,
+	})
+	if err != nil {
+		// handling an event twice is better than dropping it
+		e.Log.Warnf("failed to check for duplicate message, so handling it: %s", err.Error())
+		return nil
+	}
+	if seen {
+		e.Log.Debug("handled duplicate message")
+		s.metrics.IncreaseDuplicateMessageCounter(interruptionHandlerName, string(s.deduplicator.Strategy()))
+		return pipeline.ErrSkip
+	}
+	return nil
+}
+
+// resolveStage looks up the workload the instance belongs to, and stops tracking instances that the termination removed
+type resolveStage struct {
+	instanceToWorkloadMappings cache.Cache[string, compute.Workload]
+	creationTimes              cache.Cache[string, time.Time]
+	stopped                    *StoppedInstances
+	metrics                    metrics.Client
+}
+
+func (s *resolveStage) Name() string {
+	return StageResolve
+}
+
+func (s *resolveStage) Process(_ context.Context, e *pipeline.InterruptionEvent) error {
+	workload, err := s.instanceToWorkloadMappings.Get(e.ResourceID)
+	if err != nil {
+		s.metrics.IncreaseUnknownInstanceCounter(interruptionHandlerName)
+		return fmt.Errorf("failed to determine workload the instance (%s) belongs to: %w", e.ResourceID, err)
+	}
+	e.Workload = pipelineWorkload(workload)
+	// instances that survive the termination, e.g. after a host error or when stopped rather than deleted,
+	// are restarted under the same ID and must stay tracked
+	if !e.RemovesInstance {
+		return nil
+	}
+	stopOnTermination, err := s.stopped.StopOnTermination.Exists(e.ResourceID)
+	switch {
+	case err != nil:
+		// no longer tracking an instance that will be restarted would count its next interruption as unknown
+		e.Log.Warnf("failed to check whether instance is stopped on termination, so it remains tracked: %s", err.Error())
+	case stopOnTermination:
+		stopped, err := s.stopped.markStopped(e.ResourceID, e.Timestamp)
+		if err != nil {
+			e.Log.Warn(err.Error())
+		} else if stopped {
+			e.Log.Debugf("%s was stopped and will remain tracked", e.ResourceID)
+		}
+	default:
+		if err := s.instanceToWorkloadMappings.SetExpiration(e.ResourceID, RemovedInstanceTTL); err != nil {
+			e.Log.Warnf("failed to remove instance from mapping of instances to workloads: %s", err.Error())
+		}
+		// instances tracked since seeding have no creation time
+		_ = s.creationTimes.SetExpiration(e.ResourceID, RemovedInstanceTTL)
+		e.Log.Debugf("%s will no longer be tracked after %s", e.ResourceID, RemovedInstanceTTL)
+	}
+	return nil
+}
+
+// enrichStage derives the metric labels and interruption reported for the event
+type enrichStage struct{}
+
+func (s *enrichStage) Name() string {
+	return StageEnrich
+}
+
+func (s *enrichStage) Process(_ context.Context, e *pipeline.InterruptionEvent) error {
+	labels := instanceLabels(e.ResourceID, computeWorkload(e.Workload))
+	e.Labels = pipeline.Labels(labels)
+	e.Interruption = pipeline.Interruption{
+		ResourceID:    e.ResourceID,
+		Project:       labels.Project,
+		Zone:          labels.Zone,
+		Instance:      labels.Instance,
+		Cluster:       labels.KubernetesCluster,
+		NodePool:      labels.NodePool,
+		MachineType:   labels.MachineType,
+		MachineFamily: compute.MachineFamily(labels.MachineType),
+		WorkloadType:  labels.WorkloadType,
+		WorkloadName:  labels.WorkloadName,
+		Cause:         string(e.Cause),
+		Timestamp:     e.Timestamp,
+		MessageID:     e.MessageID,
+	}
+	e.Log = e.Log.With("kubernetes_cluster", labels.KubernetesCluster, "project", labels.Project, "workload_type", labels.WorkloadType, "workload_name", labels.WorkloadName, "cause", e.Cause)
+	return nil
+}
+
+// filterStage skips events that any filter returns false for
+type filterStage struct {
+	filters []func(e *pipeline.InterruptionEvent) bool
+}
+
+func (s *filterStage) Name() string {
+	return StageFilter
+}
+
+func (s *filterStage) Process(_ context.Context, e *pipeline.InterruptionEvent) error {
+	for _, f := range s.filters {
+		if !f(e) {
+			e.Log.Debug("filtered out")
+			return pipeline.ErrSkip
+		}
+	}
+	return nil
+}
+
+// emitStage reports the event to metrics and every configured destination
+type emitStage struct {
+	metrics     metrics.Client
+	recorder    kube.Recorder
+	notifier    notify.Notifier
+	detector    storm.Detector
+	store       history.Store
+	broadcaster stream.Broadcaster
+}
+
+func (s *emitStage) Name() string {
+	return StageEmit
+}
+
+func (s *emitStage) Process(ctx context.Context, e *pipeline.InterruptionEvent) error {
+	labels, interruption := metrics.InstanceLabels(e.Labels), notify.Interruption(e.Interruption)
+	s.metrics.IncreaseNodeTerminationCounter(labels, string(e.Cause))
+	if s.notifier != nil {
+		s.notifier.Notify(interruption)
+	}
+	if s.store != nil {
+		if err := s.store.Add(ctx, interruption); err != nil {
+			e.Log.Warnf("failed to record interruption in history: %s", err.Error())
+		}
+	}
+	if s.broadcaster != nil {
+		s.broadcaster.Publish(interruption)
+	}
+	if e.Cause != pipeline.TerminationCausePreempted {
+		e.Log.Info("terminated")
+		return nil
+	}
+	e.Log.Info("interrupted")
+	s.metrics.IncreaseInterruptionEventCounter(labels)
+	if s.detector != nil {
+		s.detector.Observe(interruption)
+	}
+	if s.recorder != nil && len(labels.KubernetesCluster) > 0 {
+		// GKE nodes are named after their instance
+		s.recorder.RecordNodeInterruption(labels.KubernetesCluster, labels.Instance, string(e.Cause))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"github.com/googleapis/google-cloudevents-go/cloud/auditdata"
+	"github.com/thought-machine/spot-interruption-exporter/pkg/pipeline"
+)
+
+type terminationParser func(entry *auditdata.LogEntryData) (terminationCause, error)
+
+type terminationCause struct {
+	Cause pipeline.TerminationCause
+	// RemovesInstance is true if the instance no longer exists once the termination has completed
+	RemovesInstance bool
+}
+
+// terminationParsers maps each audit log method name that signals an involuntary termination to its parser
+var terminationParsers = map[string]terminationParser{
+	"compute.instances.preempted":                  staticTerminationCause(pipeline.TerminationCausePreempted, true),
+	"compute.instances.hostError":                  staticTerminationCause(pipeline.TerminationCauseHostError, false),
+	"compute.instances.automaticRestart":           staticTerminationCause(pipeline.TerminationCauseAutomaticRestart, false),
+	"compute.instances.terminateOnHostMaintenance": staticTerminationCause(pipeline.TerminationCauseHostMaintenance, false),
+	"compute.instances.maxRunDurationReached":      staticTerminationCause(pipeline.TerminationCauseMaxRunDuration, true),
+}
+
+func staticTerminationCause(cause pipeline.TerminationCause, removesInstance bool) terminationParser {
+	return func(_ *auditdata.LogEntryData) (terminationCause, error) {
+		return terminationCause{
+			Cause:           cause,
+			RemovesInstance: removesInstance,
+		}, nil
+	}
+}
+
+func parseTerminationCause(entry *auditdata.LogEntryData) (terminationCause, error) {
+	methodName := entry.GetProtoPayload().GetMethodName()
+	parser, ok := terminationParsers[methodName]
+	if !ok {
+		return terminationCause{}, newParseError(parseFailureReasonUnsupportedMethod, "unsupported termination method %q", methodName)
+	}
+	return parser(entry)
+}
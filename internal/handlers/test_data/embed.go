@@ -7,5 +7,26 @@ import (
 //go:embed creation-event.json
 var CreationEventJSONFile []byte
 
+//go:embed spot-creation-event.json
+var SpotCreationEventJSONFile []byte
+
 //go:embed interruption-event.json
 var InterruptionEventJSONFile []byte
+
+//go:embed host-error-event.json
+var HostErrorEventJSONFile []byte
+
+//go:embed start-event.json
+var StartEventJSONFile []byte
+
+//go:embed stop-event.json
+var StopEventJSONFile []byte
+
+//go:embed kubeadm-creation-event.json
+var KubeadmCreationEventJSONFile []byte
+
+//go:embed dataproc-creation-event.json
+var DataprocCreationEventJSONFile []byte
+
+//go:embed delete-event.json
+var DeleteEventJSONFile []byte
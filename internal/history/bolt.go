@@ -0,0 +1,320 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCompactionInterval = time.Hour
+	// keyLength is the length of keys, a big-endian timestamp in nanoseconds followed by a big-endian sequence number
+	keyLength = 16
+)
+
+var (
+	interruptionsBucket = []byte("interruptions")
+	creationsBucket     = []byte("creations")
+)
+
+// NewBoltStoreInput defines all required fields to create a Store persisted to a local file
+type NewBoltStoreInput struct {
+	Logger *zap.SugaredLogger
+	// Path is the file interruptions and creations are kept in, which is created if it does not exist
+	Path string
+	// Retention is how long interruptions and creations are kept for, or forever if 0
+	Retention time.Duration
+	// CompactionInterval is how often interruptions and creations past their retention are deleted
+	// and the file is compacted, defaulting to an hour
+	CompactionInterval time.Duration
+}
+
+type boltStore struct {
+	log       *zap.SugaredLogger
+	path      string
+	retention time.Duration
+	now       func() time.Time
+
+	// mu guards db, which is reopened after compacting
+	mu sync.RWMutex
+	db *bolt.DB
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewBoltStore creates a Store keeping interruptions and creations in a bbolt file, so they survive restarts,
+// which deletes those past their retention until the returned function is called
+func NewBoltStore(input NewBoltStoreInput) (Store, func(context.Context) error, error) {
+	s, err := openBoltStore(input, time.Now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.retention > 0 {
+		interval := input.CompactionInterval
+		if interval <= 0 {
+			interval = defaultCompactionInterval
+		}
+		s.wg.Add(1)
+		go s.run(interval)
+	}
+	return s, s.close, nil
+}
+
+func openBoltStore(input NewBoltStoreInput, now func() time.Time) (*boltStore, error) {
+	s := &boltStore{
+		log:       input.Logger,
+		path:      input.Path,
+		retention: input.Retention,
+		now:       now,
+		done:      make(chan struct{}),
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the store's file, creating its buckets if they do not exist. The caller must hold mu if the store is in use.
+func (s *boltStore) open() error {
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{interruptionsBucket, creationsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return fmt.Errorf("failed to create buckets in %s: %w", s.path, err)
+	}
+	s.db = db
+	return nil
+}
+
+// timeKey returns the smallest key of anything at t
+func timeKey(t time.Time) []byte {
+	k := make([]byte, keyLength)
+	nanos := t.UnixNano()
+	if nanos < 0 {
+		nanos = 0
+	}
+	binary.BigEndian.PutUint64(k, uint64(nanos))
+	return k
+}
+
+func (s *boltStore) Add(_ context.Context, i notify.Interruption) error {
+	return s.put(interruptionsBucket, i.Timestamp, i)
+}
+
+func (s *boltStore) AddCreation(_ context.Context, c Creation) error {
+	return s.put(creationsBucket, c.Timestamp, c)
+}
+
+// put adds v at t to bucket, under a sequence number telling it apart from anything else at t
+func (s *boltStore) put(bucket []byte, t time.Time, v any) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		k := timeKey(t)
+		binary.BigEndian.PutUint64(k[8:], seq)
+		return b.Put(k, value)
+	})
+}
+
+// List pages by key, so pages are stable as further interruptions are added
+func (s *boltStore) List(_ context.Context, q Query) (Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	interruptions, next, err := list(s.db, interruptionsBucket, q, q.Matches)
+	return Page{Interruptions: interruptions, NextPageToken: next}, err
+}
+
+func (s *boltStore) ListCreations(_ context.Context, q Query) (CreationPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	creations, next, err := list(s.db, creationsBucket, q, q.MatchesCreation)
+	return CreationPage{Creations: creations, NextPageToken: next}, err
+}
+
+// list returns up to q's limit of the values in bucket q and matches select, most recent first,
+// and the page token continuing from the last value returned
+func list[T any](db *bolt.DB, bucket []byte, q Query, matches func(T) bool) ([]T, string, error) {
+	// values are listed from before the smallest of the page token and Until
+	var before []byte
+	if len(q.PageToken) > 0 {
+		token, err := hex.DecodeString(q.PageToken)
+		if err != nil || len(token) != keyLength {
+			return nil, "", fmt.Errorf("%w %q", ErrInvalidPageToken, q.PageToken)
+		}
+		before = token
+	}
+	if !q.Until.IsZero() {
+		if until := timeKey(q.Until); before == nil || bytes.Compare(until, before) < 0 {
+			before = until
+		}
+	}
+	var since []byte
+	if !q.Since.IsZero() {
+		since = timeKey(q.Since)
+	}
+	limit := q.limit()
+
+	values := []T{}
+	var next string
+	err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		k, v := c.Last()
+		if before != nil {
+			if k, v = c.Seek(before); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+		var last []byte
+		for ; k != nil; k, v = c.Prev() {
+			if since != nil && bytes.Compare(k, since) < 0 {
+				break
+			}
+			var value T
+			if err := json.Unmarshal(v, &value); err != nil {
+				return fmt.Errorf("failed to decode %x in %s: %w", k, bucket, err)
+			}
+			if !matches(value) {
+				continue
+			}
+			if len(values) == limit {
+				next = hex.EncodeToString(last)
+				return nil
+			}
+			values = append(values, value)
+			last = k
+		}
+		return nil
+	})
+	return values, next, err
+}
+
+// prune deletes interruptions and creations past their retention, returning how many were deleted
+func (s *boltStore) prune() (int, error) {
+	cutoff := timeKey(s.now().Add(-s.retention))
+	deleted := 0
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{interruptionsBucket, creationsBucket} {
+			c := tx.Bucket(bucket).Cursor()
+			// keys are ordered by time, so everything past its retention comes first
+			for k, _ := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				deleted++
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// compact rewrites the store's file without the space freed by deletions, which bbolt otherwise keeps for reuse
+func (s *boltStore) compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	compacted := s.path + ".compact"
+	dst, err := bolt.Open(compacted, 0600, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", compacted, err)
+	}
+	if err := bolt.Compact(dst, s.db, 0); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(compacted)
+		return fmt.Errorf("failed to compact %s: %w", s.path, err)
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(compacted)
+		return err
+	}
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	// the uncompacted file is reopened if it cannot be replaced
+	if err := os.Rename(compacted, s.path); err != nil {
+		s.log.With("error", err).Warnf("failed to replace %s with its compacted copy", s.path)
+	}
+	return s.open()
+}
+
+// enforceRetention deletes interruptions and creations past their retention, and compacts the file if any were deleted
+func (s *boltStore) enforceRetention() {
+	deleted, err := s.prune()
+	if err != nil {
+		s.log.With("error", err).Warn("failed to delete history past its retention")
+		return
+	}
+	if deleted == 0 {
+		return
+	}
+	if err := s.compact(); err != nil {
+		s.log.With("error", err).Warn("failed to compact history")
+		return
+	}
+	s.log.With("deleted", deleted, "retention", s.retention).Info("deleted history past its retention")
+}
+
+func (s *boltStore) run(interval time.Duration) {
+	defer s.wg.Done()
+	s.enforceRetention()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.enforceRetention()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *boltStore) close(ctx context.Context) error {
+	close(s.done)
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
@@ -0,0 +1,102 @@
+package history
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func (suite *HistoryTestSuite) openBoltStore(path string, now func() time.Time) *boltStore {
+	l, err := zap.NewDevelopment()
+	suite.Require().NoError(err)
+	s, err := openBoltStore(NewBoltStoreInput{Logger: l.Sugar(), Path: path, Retention: time.Hour}, now)
+	suite.Require().NoError(err)
+	return s
+}
+
+func (suite *HistoryTestSuite) TestBoltStoreFilters() {
+	ctx := context.Background()
+	s := suite.openBoltStore(filepath.Join(suite.T().TempDir(), "history.db"), time.Now)
+	defer s.close(ctx)
+	// interruptions are listed by time rather than the order they were added in
+	suite.NoError(s.Add(ctx, interruption(3, "", "europe-west1-c")))
+	suite.NoError(s.Add(ctx, interruption(0, "prod", "europe-west1-b")))
+	suite.NoError(s.Add(ctx, interruption(2, "staging", "europe-west1-c")))
+	n1 := interruption(1, "prod", "europe-west1-c")
+	n1.MachineType = "n2-standard-8"
+	suite.NoError(s.Add(ctx, n1))
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{name: "everything, most recent first", query: Query{}, want: []string{"node-3", "node-2", "node-1", "node-0"}},
+		{name: "cluster", query: Query{Cluster: "prod"}, want: []string{"node-1", "node-0"}},
+		{name: "zone", query: Query{Zone: "europe-west1-c"}, want: []string{"node-3", "node-2", "node-1"}},
+		{name: "machine type", query: Query{MachineType: "n2-standard-8"}, want: []string{"node-1"}},
+		{name: "time range", query: Query{Since: start.Add(time.Minute), Until: start.Add(time.Minute * 3)}, want: []string{"node-2", "node-1"}},
+		{name: "nothing", query: Query{Cluster: "unknown"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			page, err := s.List(ctx, tt.query)
+			suite.Require().NoError(err)
+			suite.Equal(tt.want, instances(page.Interruptions))
+			suite.Empty(page.NextPageToken)
+		})
+	}
+}
+
+func (suite *HistoryTestSuite) TestBoltStorePaginatesAcrossRestarts() {
+	ctx := context.Background()
+	path := filepath.Join(suite.T().TempDir(), "history.db")
+	s := suite.openBoltStore(path, time.Now)
+	for n := 0; n < 5; n++ {
+		suite.NoError(s.Add(ctx, interruption(n, "prod", "europe-west1-c")))
+	}
+	suite.NoError(s.AddCreation(ctx, Creation{Instance: "node-5", Cluster: "prod", Timestamp: start.Add(time.Minute * 5)}))
+	page, err := s.List(ctx, Query{Limit: 2, Until: start.Add(time.Minute * 4)})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"node-3", "node-2"}, instances(page.Interruptions))
+	suite.Require().NotEmpty(page.NextPageToken)
+	suite.NoError(s.close(ctx))
+
+	s = suite.openBoltStore(path, time.Now)
+	defer s.close(ctx)
+	page, err = s.List(ctx, Query{Limit: 2, Until: start.Add(time.Minute * 4), PageToken: page.NextPageToken})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"node-1", "node-0"}, instances(page.Interruptions))
+	suite.Empty(page.NextPageToken)
+
+	creations, err := s.ListCreations(ctx, Query{Cluster: "prod"})
+	suite.Require().NoError(err)
+	suite.Require().Len(creations.Creations, 1)
+	suite.Equal("node-5", creations.Creations[0].Instance)
+
+	_, err = s.List(ctx, Query{PageToken: "not-a-token"})
+	suite.ErrorIs(err, ErrInvalidPageToken)
+}
+
+func (suite *HistoryTestSuite) TestBoltStoreRetention() {
+	ctx := context.Background()
+	now := start.Add(time.Hour + time.Minute*2)
+	s := suite.openBoltStore(filepath.Join(suite.T().TempDir(), "history.db"), func() time.Time { return now })
+	defer s.close(ctx)
+	for n := 0; n < 4; n++ {
+		suite.NoError(s.Add(ctx, interruption(n, "prod", "europe-west1-c")))
+	}
+	suite.NoError(s.AddCreation(ctx, Creation{Instance: "node-0", Timestamp: start}))
+
+	// everything more than an hour old is deleted, and the store is usable after compacting
+	s.enforceRetention()
+	page, err := s.List(ctx, Query{})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"node-3", "node-2"}, instances(page.Interruptions))
+	creations, err := s.ListCreations(ctx, Query{})
+	suite.Require().NoError(err)
+	suite.Empty(creations.Creations)
+	suite.NoError(s.Add(ctx, interruption(4, "prod", "europe-west1-c")))
+}
@@ -0,0 +1,234 @@
+// Package history keeps interruptions and instance creations so they can be listed after the fact
+//
+//go:generate mockery --name Store
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+)
+
+const (
+	// DefaultLimit is how many interruptions a page holds unless a query sets its limit
+	DefaultLimit = 100
+	// MaxLimit is the most interruptions a page can hold
+	MaxLimit = 1000
+
+	defaultRingBufferSize = 10000
+)
+
+// ErrInvalidPageToken is returned when listing with a page token that was not returned by the store
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// Query selects interruptions or creations. Empty fields select every one.
+type Query struct {
+	Cluster     string
+	Zone        string
+	MachineType string
+	// Since and Until bound the time of interruptions or creations, inclusive of Since and exclusive of Until
+	Since time.Time
+	Until time.Time
+	// Limit is how many interruptions or creations a page holds, defaulting to DefaultLimit and at most MaxLimit
+	Limit int
+	// PageToken continues from the end of a previous page
+	PageToken string
+}
+
+// Matches returns whether i is selected by q, ignoring pagination
+func (q Query) Matches(i notify.Interruption) bool {
+	return q.matches(i.Cluster, i.Zone, i.MachineType, i.Timestamp)
+}
+
+// MatchesCreation returns whether c is selected by q, ignoring pagination
+func (q Query) MatchesCreation(c Creation) bool {
+	return q.matches(c.Cluster, c.Zone, c.MachineType, c.Timestamp)
+}
+
+func (q Query) matches(cluster, zone, machineType string, t time.Time) bool {
+	return (len(q.Cluster) == 0 || cluster == q.Cluster) &&
+		(len(q.Zone) == 0 || zone == q.Zone) &&
+		(len(q.MachineType) == 0 || machineType == q.MachineType) &&
+		(q.Since.IsZero() || !t.Before(q.Since)) &&
+		(q.Until.IsZero() || t.Before(q.Until))
+}
+
+// limit returns the number of interruptions or creations a page for q holds
+func (q Query) limit() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultLimit
+	case q.Limit > MaxLimit:
+		return MaxLimit
+	}
+	return q.Limit
+}
+
+// Creation is the creation of an instance belonging to a tracked workload
+type Creation struct {
+	ResourceID    string    `json:"resource_id"`
+	Project       string    `json:"project"`
+	Zone          string    `json:"zone"`
+	Instance      string    `json:"instance"`
+	Cluster       string    `json:"kubernetes_cluster,omitempty"`
+	NodePool      string    `json:"node_pool,omitempty"`
+	MachineType   string    `json:"machine_type,omitempty"`
+	MachineFamily string    `json:"machine_family,omitempty"`
+	WorkloadType  string    `json:"workload_type"`
+	WorkloadName  string    `json:"workload_name"`
+	Timestamp     time.Time `json:"timestamp"`
+	// MessageID is the ID of the pubsub message the creation was read from
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// Page is a page of interruptions, most recent first
+type Page struct {
+	Interruptions []notify.Interruption
+	// NextPageToken is empty on the last page
+	NextPageToken string
+}
+
+// CreationPage is a page of creations, most recent first
+type CreationPage struct {
+	Creations []Creation
+	// NextPageToken is empty on the last page
+	NextPageToken string
+}
+
+// Store records interruptions and creations
+type Store interface {
+	// Add records i
+	Add(ctx context.Context, i notify.Interruption) error
+	// AddCreation records c
+	AddCreation(ctx context.Context, c Creation) error
+	// List returns a page of the interruptions q selects
+	List(ctx context.Context, q Query) (Page, error)
+	// ListCreations returns a page of the creations q selects
+	ListCreations(ctx context.Context, q Query) (CreationPage, error)
+}
+
+// entry is a value in a ring, with the sequence number it was added under
+type entry[T any] struct {
+	seq   uint64
+	value T
+}
+
+// ring holds the most recently added values, overwriting the oldest once full
+type ring[T any] struct {
+	entries []entry[T]
+	// next is the index the next value is written to, and seq its sequence number
+	next int
+	seq  uint64
+	full bool
+}
+
+func newRing[T any](size int) ring[T] {
+	return ring[T]{entries: make([]entry[T], size)}
+}
+
+func (r *ring[T]) add(v T) {
+	r.seq++
+	r.entries[r.next] = entry[T]{seq: r.seq, value: v}
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns up to limit of the values matches selects, most recently added first, starting from those added
+// before the sequence number before if it is not 0, and the page token continuing from the last value returned
+func (r *ring[T]) list(before uint64, limit int, matches func(T) bool) ([]T, string) {
+	count := r.next
+	if r.full {
+		count = len(r.entries)
+	}
+	values := []T{}
+	var last uint64
+	// walk backwards from the most recently added entry
+	for n := 1; n <= count; n++ {
+		e := r.entries[(r.next-n+len(r.entries))%len(r.entries)]
+		if before != 0 && e.seq >= before {
+			continue
+		}
+		if !matches(e.value) {
+			continue
+		}
+		if len(values) == limit {
+			return values, strconv.FormatUint(last, 10)
+		}
+		values = append(values, e.value)
+		last = e.seq
+	}
+	return values, ""
+}
+
+type ringBuffer struct {
+	mu            sync.RWMutex
+	interruptions ring[notify.Interruption]
+	creations     ring[Creation]
+}
+
+// NewRingBuffer creates a Store holding the most recent size interruptions, and as many creations, in memory, defaulting to 10000
+func NewRingBuffer(size int) Store {
+	if size <= 0 {
+		size = defaultRingBufferSize
+	}
+	return &ringBuffer{
+		interruptions: newRing[notify.Interruption](size),
+		creations:     newRing[Creation](size),
+	}
+}
+
+func (r *ringBuffer) Add(_ context.Context, i notify.Interruption) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interruptions.add(i)
+	return nil
+}
+
+func (r *ringBuffer) AddCreation(_ context.Context, c Creation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.creations.add(c)
+	return nil
+}
+
+// List pages by sequence number, so pages are stable as further interruptions are added
+func (r *ringBuffer) List(_ context.Context, q Query) (Page, error) {
+	before, err := parseSequenceToken(q.PageToken)
+	if err != nil {
+		return Page{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	interruptions, next := r.interruptions.list(before, q.limit(), q.Matches)
+	return Page{Interruptions: interruptions, NextPageToken: next}, nil
+}
+
+func (r *ringBuffer) ListCreations(_ context.Context, q Query) (CreationPage, error) {
+	before, err := parseSequenceToken(q.PageToken)
+	if err != nil {
+		return CreationPage{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	creations, next := r.creations.list(before, q.limit(), q.MatchesCreation)
+	return CreationPage{Creations: creations, NextPageToken: next}, nil
+}
+
+// parseSequenceToken returns the sequence number a ring buffer's page token continues before, or 0 if it is empty
+func parseSequenceToken(token string) (uint64, error) {
+	if len(token) == 0 {
+		return 0, nil
+	}
+	before, err := strconv.ParseUint(token, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w %q", ErrInvalidPageToken, token)
+	}
+	return before, nil
+}
@@ -0,0 +1,106 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+)
+
+type HistoryTestSuite struct {
+	suite.Suite
+}
+
+func TestHistoryTestSuite(t *testing.T) {
+	suite.Run(t, new(HistoryTestSuite))
+}
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// interruption returns the nth interruption, n minutes after start
+func interruption(n int, cluster, zone string) notify.Interruption {
+	return notify.Interruption{
+		Instance:  fmt.Sprintf("node-%d", n),
+		Cluster:   cluster,
+		Zone:      zone,
+		Timestamp: start.Add(time.Duration(n) * time.Minute),
+	}
+}
+
+func instances(interruptions []notify.Interruption) []string {
+	names := make([]string, len(interruptions))
+	for i, interruption := range interruptions {
+		names[i] = interruption.Instance
+	}
+	return names
+}
+
+func (suite *HistoryTestSuite) TestRingBufferFilters() {
+	ctx := context.Background()
+	r := NewRingBuffer(10)
+	suite.NoError(r.Add(ctx, interruption(0, "prod", "europe-west1-b")))
+	suite.NoError(r.Add(ctx, interruption(1, "prod", "europe-west1-c")))
+	suite.NoError(r.Add(ctx, interruption(2, "staging", "europe-west1-c")))
+	suite.NoError(r.Add(ctx, interruption(3, "", "europe-west1-c")))
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{name: "everything, most recent first", query: Query{}, want: []string{"node-3", "node-2", "node-1", "node-0"}},
+		{name: "cluster", query: Query{Cluster: "prod"}, want: []string{"node-1", "node-0"}},
+		{name: "zone", query: Query{Zone: "europe-west1-c"}, want: []string{"node-3", "node-2", "node-1"}},
+		{name: "time range", query: Query{Since: start.Add(time.Minute), Until: start.Add(time.Minute * 3)}, want: []string{"node-2", "node-1"}},
+		{name: "nothing", query: Query{Cluster: "unknown"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			page, err := r.List(ctx, tt.query)
+			suite.Require().NoError(err)
+			suite.Equal(tt.want, instances(page.Interruptions))
+			suite.Empty(page.NextPageToken)
+		})
+	}
+}
+
+func (suite *HistoryTestSuite) TestRingBufferPaginates() {
+	ctx := context.Background()
+	r := NewRingBuffer(5)
+	// the oldest interruptions are overwritten once the buffer is full
+	for n := 0; n < 6; n++ {
+		suite.NoError(r.Add(ctx, interruption(n, "prod", "europe-west1-c")))
+	}
+
+	page, err := r.List(ctx, Query{Limit: 3})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"node-5", "node-4", "node-3"}, instances(page.Interruptions))
+	suite.Require().NotEmpty(page.NextPageToken)
+
+	// interruptions added since the first page do not shift the next one, though node-1 is overwritten
+	suite.NoError(r.Add(ctx, interruption(6, "prod", "europe-west1-c")))
+	page, err = r.List(ctx, Query{Limit: 3, PageToken: page.NextPageToken})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"node-2"}, instances(page.Interruptions))
+	suite.Empty(page.NextPageToken)
+
+	_, err = r.List(ctx, Query{PageToken: "not-a-token"})
+	suite.ErrorIs(err, ErrInvalidPageToken)
+}
+
+func (suite *HistoryTestSuite) TestRingBufferCreations() {
+	ctx := context.Background()
+	r := NewRingBuffer(10)
+	suite.NoError(r.AddCreation(ctx, Creation{Instance: "node-0", Cluster: "prod", MachineType: "e2-standard-4", Timestamp: start}))
+	suite.NoError(r.AddCreation(ctx, Creation{Instance: "node-1", Cluster: "prod", MachineType: "n2-standard-8", Timestamp: start.Add(time.Minute)}))
+	// creations are kept apart from interruptions
+	suite.NoError(r.Add(ctx, interruption(2, "prod", "europe-west1-c")))
+
+	page, err := r.ListCreations(ctx, Query{MachineType: "e2-standard-4"})
+	suite.Require().NoError(err)
+	suite.Require().Len(page.Creations, 1)
+	suite.Equal("node-0", page.Creations[0].Instance)
+}
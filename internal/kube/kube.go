@@ -0,0 +1,299 @@
+//go:generate mockery --name Recorder
+package kube
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// ReasonSpotInterrupted is the reason of events recorded against interrupted nodes and their pods
+	ReasonSpotInterrupted = "SpotInterrupted"
+
+	component = "spot-interruption-exporter"
+	// nodeEventNamespace is where events about cluster scoped objects such as nodes are created, as kubectl expects
+	nodeEventNamespace = metav1.NamespaceDefault
+
+	// recordWorkers is how many interruptions are recorded at once
+	recordWorkers = 4
+	// recordQueueSize is how many interruptions wait to be recorded before further ones are dropped
+	recordQueueSize = 100
+	// recordTimeout bounds recording the events of a single interruption
+	recordTimeout = time.Second * 30
+)
+
+// Recorder records Kubernetes events about interrupted nodes
+type Recorder interface {
+	// RecordNodeInterruption queues creating an event against node in cluster, and against every pod scheduled on it,
+	// naming cause, without blocking. Recording is best effort, so interruptions are dropped if the queue is full.
+	// Clusters without configured access are ignored.
+	RecordNodeInterruption(cluster, node, cause string)
+}
+
+// ClusterAccess defines how a cluster's API server is reached. Exactly one of Kubeconfig, Endpoint or InCluster must be set.
+type ClusterAccess struct {
+	// Kubeconfig is the path of a kubeconfig file, using its current context unless Context is set
+	Kubeconfig string
+	Context    string
+	// Endpoint is the address of a GKE cluster's API server, authenticated against with Google application default credentials,
+	// e.g. via Workload Identity. CertificateAuthorityData is the base64 encoded cluster CA certificate
+	Endpoint                 string
+	CertificateAuthorityData string
+	// InCluster uses the service account of the pod the exporter runs in
+	InCluster bool
+}
+
+// NewRecorderInput defines all required fields to create a Recorder
+type NewRecorderInput struct {
+	Logger *zap.SugaredLogger
+	// Clusters maps the name of each cluster to how it is accessed
+	Clusters map[string]ClusterAccess
+}
+
+type recorder struct {
+	log      *zap.SugaredLogger
+	clients  map[string]kubernetes.Interface
+	instance string
+
+	queue chan interruption
+	wg    sync.WaitGroup
+	// mu guards closed, so no interruptions are queued once the queue is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// interruption is a node interruption waiting to be recorded
+type interruption struct {
+	cluster, node, cause string
+}
+
+// NewRecorder creates a Recorder with a client for each of the configured clusters.
+// The returned function records queued interruptions and must be called before exiting.
+func NewRecorder(ctx context.Context, input NewRecorderInput) (Recorder, func(context.Context) error, error) {
+	clients := make(map[string]kubernetes.Interface, len(input.Clusters))
+	for cluster, access := range input.Clusters {
+		client, err := NewClient(ctx, access)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cluster %s: %w", cluster, err)
+		}
+		clients[cluster] = client
+	}
+	r := newRecorder(input.Logger, clients)
+	r.start(recordWorkers)
+	return r, r.close, nil
+}
+
+// NewClient creates a client for the cluster reached via access
+func NewClient(ctx context.Context, access ClusterAccess) (kubernetes.Interface, error) {
+	config, err := restConfig(ctx, access)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure access: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	return client, nil
+}
+
+func newRecorder(log *zap.SugaredLogger, clients map[string]kubernetes.Interface) *recorder {
+	instance, _ := os.Hostname()
+	return &recorder{
+		log:      log,
+		clients:  clients,
+		instance: instance,
+		queue:    make(chan interruption, recordQueueSize),
+	}
+}
+
+// validate returns an error unless exactly one way of reaching the API server is set, so ambiguous access is rejected
+// rather than one of them silently winning
+func (a ClusterAccess) validate() error {
+	set := 0
+	for _, ok := range []bool{len(a.Kubeconfig) > 0, len(a.Endpoint) > 0, a.InCluster} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of kubeconfig, endpoint or in_cluster must be set")
+	}
+	return nil
+}
+
+func restConfig(ctx context.Context, access ClusterAccess) (*rest.Config, error) {
+	if err := access.validate(); err != nil {
+		return nil, err
+	}
+	switch {
+	case len(access.Kubeconfig) > 0:
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: access.Kubeconfig},
+			&clientcmd.ConfigOverrides{CurrentContext: access.Context},
+		).ClientConfig()
+	case len(access.Endpoint) > 0:
+		ca, err := base64.StdEncoding.DecodeString(access.CertificateAuthorityData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode certificate authority data: %w", err)
+		}
+		tokenSource, err := google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/cloud-platform")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find google credentials: %w", err)
+		}
+		return &rest.Config{
+			Host:            access.Endpoint,
+			TLSClientConfig: rest.TLSClientConfig{CAData: ca},
+			WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+				return &oauth2.Transport{Source: tokenSource, Base: rt}
+			},
+		}, nil
+	default:
+		return rest.InClusterConfig()
+	}
+}
+
+// start records queued interruptions on workers goroutines
+func (r *recorder) start(workers int) {
+	for i := 0; i < workers; i++ {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for i := range r.queue {
+				ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+				if err := r.record(ctx, i.cluster, i.node, i.cause); err != nil {
+					r.log.With("cluster", i.cluster, "node", i.node).Warnf("failed to record kubernetes events for interrupted node: %s", err.Error())
+				}
+				cancel()
+			}
+		}()
+	}
+}
+
+func (r *recorder) RecordNodeInterruption(cluster, node, cause string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.queue <- interruption{cluster: cluster, node: node, cause: cause}:
+	default:
+		r.log.With("cluster", cluster, "node", node).Warn("kubernetes event queue is full, not recording interruption")
+	}
+}
+
+// close stops queueing interruptions, and waits for those already queued to be recorded
+func (r *recorder) close(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to record queued kubernetes events: %w", ctx.Err())
+	}
+}
+
+// record creates an event against node in cluster, and against every pod scheduled on it. Failing to create the event
+// of one pod does not stop those of the others being created.
+func (r *recorder) record(ctx context.Context, cluster, node, cause string) error {
+	client, ok := r.clients[cluster]
+	if !ok {
+		r.log.With("cluster", cluster).Debug("no access configured to cluster, not recording interruption events")
+		return nil
+	}
+
+	nodeRef := corev1.ObjectReference{Kind: "Node", APIVersion: "v1", Name: node}
+	// the node may already have been deleted, in which case the event is recorded against its name alone
+	n, err := client.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		nodeRef.UID = n.UID
+	case !apierrors.IsNotFound(err):
+		return fmt.Errorf("failed to get node %s: %w", node, err)
+	}
+	message := fmt.Sprintf("Node %s was reclaimed by the cloud provider (%s)", node, cause)
+	if err := r.createEvent(ctx, client, nodeEventNamespace, nodeRef, message); err != nil {
+		return err
+	}
+
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods scheduled on node %s: %w", node, err)
+	}
+	message = fmt.Sprintf("Pod was running on node %s, which was reclaimed by the cloud provider (%s)", node, cause)
+	var errs []error
+	for _, pod := range pods.Items {
+		// not every API server implementation, e.g. fakes, honours field selectors
+		if pod.Spec.NodeName != node {
+			continue
+		}
+		podRef := corev1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Namespace:  pod.Namespace,
+			Name:       pod.Name,
+			UID:        pod.UID,
+		}
+		if err := r.createEvent(ctx, client, pod.Namespace, podRef, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to record events for %d pods: %w", len(errs), errors.Join(errs...))
+	}
+	return nil
+}
+
+func (r *recorder) createEvent(ctx context.Context, client kubernetes.Interface, namespace string, ref corev1.ObjectReference, message string) error {
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// the same form of name as events recorded by client-go's event recorder
+			Name:      fmt.Sprintf("%s.%x", ref.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject:      ref,
+		Reason:              ReasonSpotInterrupted,
+		Message:             message,
+		Type:                corev1.EventTypeWarning,
+		Source:              corev1.EventSource{Component: component},
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		ReportingController: component,
+		ReportingInstance:   r.instance,
+	}
+	if _, err := client.CoreV1().Events(namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create event for %s %s/%s: %w", ref.Kind, ref.Namespace, ref.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type KubeTestSuite struct {
+	suite.Suite
+	l *zap.SugaredLogger
+}
+
+func TestKubeTestSuite(t *testing.T) {
+	suite.Run(t, new(KubeTestSuite))
+}
+
+func (suite *KubeTestSuite) SetupSuite() {
+	l, err := zap.NewDevelopment()
+	suite.NoError(err)
+	suite.l = l.Sugar()
+}
+
+func pod(namespace, name, node string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID("uid-" + name)},
+		Spec:       corev1.PodSpec{NodeName: node},
+	}
+}
+
+func (suite *KubeTestSuite) TestRecordNodeInterruption() {
+	client := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "spot-node", UID: "uid-spot-node"}},
+		pod("team-a", "worker", "spot-node"),
+		pod("team-b", "batch", "spot-node"),
+		pod("team-a", "elsewhere", "other-node"),
+	)
+	r := newRecorder(suite.l, map[string]kubernetes.Interface{"fake-cluster": client})
+
+	suite.Require().NoError(r.record(context.Background(), "fake-cluster", "spot-node", "preempted"))
+
+	events, err := client.CoreV1().Events(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{})
+	suite.Require().NoError(err)
+	suite.Require().Len(events.Items, 3)
+	involved := map[string]corev1.ObjectReference{}
+	for _, e := range events.Items {
+		suite.Equal(ReasonSpotInterrupted, e.Reason)
+		suite.Equal(corev1.EventTypeWarning, e.Type)
+		suite.Equal("spot-interruption-exporter", e.Source.Component)
+		suite.Contains(e.Message, "preempted")
+		if e.InvolvedObject.Kind == "Pod" {
+			suite.Equal(e.InvolvedObject.Namespace, e.Namespace)
+		}
+		involved[e.InvolvedObject.Kind+"/"+e.InvolvedObject.Name] = e.InvolvedObject
+	}
+	suite.Equal(metav1.NamespaceDefault, eventNamespace(events.Items, "Node"))
+	suite.EqualValues("uid-spot-node", involved["Node/spot-node"].UID)
+	suite.EqualValues("uid-worker", involved["Pod/worker"].UID)
+	suite.Equal("team-b", involved["Pod/batch"].Namespace)
+	suite.NotContains(involved, "Pod/elsewhere")
+}
+
+// eventNamespace returns the namespace of the first event involving an object of kind
+func eventNamespace(events []corev1.Event, kind string) string {
+	for _, e := range events {
+		if e.InvolvedObject.Kind == kind {
+			return e.Namespace
+		}
+	}
+	return ""
+}
+
+func (suite *KubeTestSuite) TestRecordNodeInterruptionDeletedNode() {
+	client := fake.NewSimpleClientset()
+	r := newRecorder(suite.l, map[string]kubernetes.Interface{"fake-cluster": client})
+
+	suite.Require().NoError(r.record(context.Background(), "fake-cluster", "deleted-node", "preempted"))
+
+	events, err := client.CoreV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	suite.Require().NoError(err)
+	suite.Require().Len(events.Items, 1)
+	suite.Equal("deleted-node", events.Items[0].InvolvedObject.Name)
+	suite.Empty(events.Items[0].InvolvedObject.UID)
+}
+
+func (suite *KubeTestSuite) TestRecordNodeInterruptionErrors() {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "events", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	r := newRecorder(suite.l, map[string]kubernetes.Interface{"fake-cluster": client})
+
+	suite.Error(r.record(context.Background(), "fake-cluster", "spot-node", "preempted"))
+	// clusters without configured access are ignored
+	suite.NoError(r.record(context.Background(), "unknown-cluster", "spot-node", "preempted"))
+}
+
+func (suite *KubeTestSuite) TestRecordNodeInterruptionPodEventErrors() {
+	client := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "spot-node"}},
+		pod("team-a", "worker", "spot-node"),
+		pod("team-b", "batch", "spot-node"),
+	)
+	client.PrependReactor("create", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "team-a" {
+			return true, nil, errors.New("forbidden")
+		}
+		return false, nil, nil
+	})
+	r := newRecorder(suite.l, map[string]kubernetes.Interface{"fake-cluster": client})
+
+	// failing to record the event of one pod does not stop those of the others being recorded
+	suite.Error(r.record(context.Background(), "fake-cluster", "spot-node", "preempted"))
+	events, err := client.CoreV1().Events("team-b").List(context.Background(), metav1.ListOptions{})
+	suite.Require().NoError(err)
+	suite.Len(events.Items, 1)
+}
+
+func (suite *KubeTestSuite) TestRecorderQueue() {
+	client := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "spot-node"}})
+	r := newRecorder(suite.l, map[string]kubernetes.Interface{"fake-cluster": client})
+	r.start(1)
+
+	r.RecordNodeInterruption("fake-cluster", "spot-node", "preempted")
+	// closing records interruptions already queued, and ignores those queued afterwards
+	suite.Require().NoError(r.close(context.Background()))
+	r.RecordNodeInterruption("fake-cluster", "spot-node", "preempted")
+
+	events, err := client.CoreV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	suite.Require().NoError(err)
+	suite.Len(events.Items, 1)
+}
+
+func (suite *KubeTestSuite) TestRestConfig() {
+	_, err := restConfig(context.Background(), ClusterAccess{})
+	suite.Error(err)
+	_, err = restConfig(context.Background(), ClusterAccess{Endpoint: "https://10.0.0.1", CertificateAuthorityData: "not base64!"})
+	suite.Error(err)
+	// ambiguous access is rejected rather than one of its ways winning
+	_, err = restConfig(context.Background(), ClusterAccess{Kubeconfig: "/etc/kubeconfig", InCluster: true})
+	suite.ErrorContains(err, "exactly one")
+	_, err = restConfig(context.Background(), ClusterAccess{Kubeconfig: "/etc/kubeconfig", Endpoint: "https://10.0.0.1"})
+	suite.ErrorContains(err, "exactly one")
+}
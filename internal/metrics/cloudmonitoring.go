@@ -0,0 +1,267 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	monitoring "google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+)
+
+const (
+	// InterruptionsMetricType is the Cloud Monitoring custom metric counting spot interruptions
+	InterruptionsMetricType = "custom.googleapis.com/spot/interruptions"
+	// NodeTerminationsMetricType is the Cloud Monitoring custom metric counting involuntary node terminations by cause
+	NodeTerminationsMetricType = "custom.googleapis.com/spot/node_terminations"
+
+	// maxTimeSeriesPerRequest is the most time series CreateTimeSeries accepts in a single request
+	maxTimeSeriesPerRequest             = 200
+	defaultCloudMonitoringWriteInterval = time.Minute
+	defaultCloudMonitoringIdleTimeout   = time.Hour
+)
+
+// CloudMonitoringInput configures a Cloud Monitoring metrics client
+type CloudMonitoringInput struct {
+	Logger *zap.SugaredLogger
+	// ClusterLocations maps Kubernetes clusters to their location, for regional clusters whose location is not their nodes' zone
+	ClusterLocations map[string]string
+	// WriteInterval is how often time series are written, defaulting to one minute. Cloud Monitoring rejects writes to a
+	// time series more often than every five seconds
+	WriteInterval time.Duration
+	// IdleTimeout is how long a series is kept after it was last increased and written, defaulting to one hour. A series
+	// increased again after being dropped starts counting from zero again, with a new start time.
+	IdleTimeout time.Duration
+	// ClientOptions are passed to the Cloud Monitoring client
+	ClientOptions []option.ClientOption
+}
+
+// NewCloudMonitoringClient creates a metrics client that periodically writes interruption and termination counts as Cloud Monitoring
+// custom metrics to the project of each instance. The REST API is used, as the gRPC client's protos conflict with those of the
+// audit log types and registering both panics. Kubernetes nodes are counted against their k8s_cluster, and other instances
+// against a generic_node, as the numeric IDs gce_instance requires are not known for instances that no longer exist.
+// Only the counters are written, so it is used through a FanOut client, which forwards other metrics to the backends recording them.
+// The returned function writes any pending counts and must be called before exiting.
+func NewCloudMonitoringClient(ctx context.Context, input CloudMonitoringInput) (Counters, func(context.Context) error, error) {
+	service, err := monitoring.NewService(ctx, input.ClientOptions...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create cloud monitoring client: %w", err)
+	}
+	interval := input.WriteInterval
+	if interval <= 0 {
+		interval = defaultCloudMonitoringWriteInterval
+	}
+	idleTimeout := input.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultCloudMonitoringIdleTimeout
+	}
+	m := &cloudMonitoring{
+		log:              input.Logger,
+		timeSeries:       monitoring.NewProjectsTimeSeriesService(service),
+		clusterLocations: input.ClusterLocations,
+		idleTimeout:      idleTimeout,
+		now:              time.Now,
+		series:           make(map[string]*cumulativeSeries),
+		done:             make(chan struct{}),
+	}
+	m.wg.Add(1)
+	go m.writeEvery(interval)
+	return m, m.shutdown, nil
+}
+
+// cumulativeSeries is a counter written as a cumulative time series starting when it was first increased
+type cumulativeSeries struct {
+	project  string
+	metric   *monitoring.Metric
+	resource *monitoring.MonitoredResource
+	value    int64
+	// written is the value last successfully written, so unchanged series are not rewritten
+	written int64
+	// start is the start time of the series, as counts are not persisted across restarts
+	start time.Time
+	// updated is when the series was last increased
+	updated time.Time
+}
+
+func (m *cloudMonitoring) writeEvery(interval time.Duration) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := m.write(ctx); err != nil {
+				m.log.With("error", err).Warn("failed to write time series to cloud monitoring, retrying next interval")
+			}
+			cancel()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *cloudMonitoring) shutdown(ctx context.Context) error {
+	close(m.done)
+	m.wg.Wait()
+	return m.write(ctx)
+}
+
+// write creates a point for every series that changed since it was last written, batched per project, and drops those
+// that have been idle for longer than the idle timeout
+func (m *cloudMonitoring) write(ctx context.Context) error {
+	type pending struct {
+		series *cumulativeSeries
+		value  int64
+	}
+	m.mu.Lock()
+	now := m.now()
+	byProject := make(map[string][]pending)
+	for k, s := range m.series {
+		switch {
+		case s.value != s.written:
+			byProject[s.project] = append(byProject[s.project], pending{series: s, value: s.value})
+		case now.Sub(s.updated) >= m.idleTimeout:
+			delete(m.series, k)
+		}
+	}
+	m.mu.Unlock()
+
+	var errs []string
+	for project, series := range byProject {
+		for i := 0; i < len(series); i += maxTimeSeriesPerRequest {
+			batch := series[i:min(i+maxTimeSeriesPerRequest, len(series))]
+			req := &monitoring.CreateTimeSeriesRequest{
+				TimeSeries: make([]*monitoring.TimeSeries, 0, len(batch)),
+			}
+			for _, p := range batch {
+				value := p.value
+				end := now
+				if !end.After(p.series.start) {
+					// the end of a cumulative interval must be after its start
+					end = p.series.start.Add(time.Millisecond)
+				}
+				req.TimeSeries = append(req.TimeSeries, &monitoring.TimeSeries{
+					Metric:     p.series.metric,
+					Resource:   p.series.resource,
+					MetricKind: "CUMULATIVE",
+					ValueType:  "INT64",
+					Points: []*monitoring.Point{{
+						Interval: &monitoring.TimeInterval{
+							StartTime: p.series.start.UTC().Format(time.RFC3339Nano),
+							EndTime:   end.UTC().Format(time.RFC3339Nano),
+						},
+						Value: &monitoring.TypedValue{Int64Value: &value},
+					}},
+				})
+			}
+			if _, err := m.timeSeries.Create("projects/"+project, req).Context(ctx).Do(); err != nil {
+				errs = append(errs, fmt.Sprintf("project %s: %s", project, err.Error()))
+				continue
+			}
+			m.mu.Lock()
+			for _, p := range batch {
+				p.series.written = p.value
+			}
+			m.mu.Unlock()
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to create time series: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// monitoredResource returns the k8s_cluster of Kubernetes nodes, and a generic_node for other instances, grouped by their workload
+func (m *cloudMonitoring) monitoredResource(labels InstanceLabels) *monitoring.MonitoredResource {
+	if len(labels.KubernetesCluster) > 0 {
+		location, ok := m.clusterLocations[labels.KubernetesCluster]
+		if !ok {
+			location = labels.Zone
+		}
+		return &monitoring.MonitoredResource{
+			Type: "k8s_cluster",
+			Labels: map[string]string{
+				"project_id":   labels.Project,
+				"location":     location,
+				"cluster_name": labels.KubernetesCluster,
+			},
+		}
+	}
+	return &monitoring.MonitoredResource{
+		Type: "generic_node",
+		Labels: map[string]string{
+			"project_id": labels.Project,
+			"location":   labels.Zone,
+			"namespace":  labels.WorkloadName,
+			"node_id":    labels.Instance,
+		},
+	}
+}
+
+// increment increases the series of metricType for the instance with labels, and any additional metric labels, by one
+func (m *cloudMonitoring) increment(metricType string, labels InstanceLabels, metricLabels map[string]string) {
+	metricLabels["workload_type"] = labels.WorkloadType
+	metricLabels["workload_name"] = labels.WorkloadName
+	resource := m.monitoredResource(labels)
+	key := seriesKey(metricType, metricLabels, resource)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	s, ok := m.series[key]
+	if !ok {
+		s = &cumulativeSeries{
+			project:  labels.Project,
+			metric:   &monitoring.Metric{Type: metricType, Labels: metricLabels},
+			resource: resource,
+			start:    now,
+		}
+		m.series[key] = s
+	}
+	s.value++
+	s.updated = now
+}
+
+// seriesKey uniquely identifies a time series by its metric type, metric labels and monitored resource
+func seriesKey(metricType string, metricLabels map[string]string, resource *monitoring.MonitoredResource) string {
+	parts := []string{metricType, resource.Type}
+	for _, labels := range []map[string]string{metricLabels, resource.Labels} {
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			parts = append(parts, k+"="+labels[k])
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *cloudMonitoring) IncreaseInterruptionEventCounter(labels InstanceLabels) {
+	m.increment(InterruptionsMetricType, labels, map[string]string{})
+}
+
+func (m *cloudMonitoring) IncreaseNodeTerminationCounter(labels InstanceLabels, cause string) {
+	m.increment(NodeTerminationsMetricType, labels, map[string]string{"cause": cause})
+}
+
+type cloudMonitoring struct {
+	log              *zap.SugaredLogger
+	timeSeries       *monitoring.ProjectsTimeSeriesService
+	clusterLocations map[string]string
+	idleTimeout      time.Duration
+	// now returns the current time, so that tests can expire series without waiting
+	now func() time.Time
+
+	mu     sync.Mutex
+	series map[string]*cumulativeSeries
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
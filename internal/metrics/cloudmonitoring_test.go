@@ -0,0 +1,251 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	monitoring "google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+)
+
+// createTimeSeriesRequest is a CreateTimeSeries request received by fakeMetricService
+type createTimeSeriesRequest struct {
+	Name string
+	*monitoring.CreateTimeSeriesRequest
+}
+
+// resourceLabels are the labels Cloud Monitoring requires of the monitored resources series are written against
+var resourceLabels = map[string][]string{
+	"k8s_cluster":  {"project_id", "location", "cluster_name"},
+	"generic_node": {"project_id", "location", "namespace", "node_id"},
+	"gce_instance": {"project_id", "zone", "instance_id"},
+}
+
+// fakeMetricService is an in-process Cloud Monitoring REST API, which the client uses instead of the gRPC API, recording every
+// CreateTimeSeries request. Like the real API, it
+// rejects requests with too many series, unknown or incomplete monitored resources, and intervals that do not end after they start.
+type fakeMetricService struct {
+	mu       sync.Mutex
+	requests []createTimeSeriesRequest
+	failures int
+}
+
+func (f *fakeMetricService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		http.Error(w, `{"error": {"code": 400, "message": "fake failure"}}`, http.StatusBadRequest)
+		return
+	}
+	req := &monitoring.CreateTimeSeriesRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCreateTimeSeries(req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": {"code": 400, "message": %q}}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	// requests are made to /v3/projects/{project}/timeSeries
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v3/"), "/timeSeries")
+	f.requests = append(f.requests, createTimeSeriesRequest{Name: name, CreateTimeSeriesRequest: req})
+	_, _ = w.Write([]byte("{}"))
+}
+
+func validateCreateTimeSeries(req *monitoring.CreateTimeSeriesRequest) error {
+	if len(req.TimeSeries) > maxTimeSeriesPerRequest {
+		return fmt.Errorf("%d time series exceeds the limit of %d", len(req.TimeSeries), maxTimeSeriesPerRequest)
+	}
+	for _, ts := range req.TimeSeries {
+		required, ok := resourceLabels[ts.Resource.Type]
+		if !ok {
+			return fmt.Errorf("unknown monitored resource %s", ts.Resource.Type)
+		}
+		for _, label := range required {
+			if len(ts.Resource.Labels[label]) == 0 {
+				return fmt.Errorf("monitored resource %s is missing label %s", ts.Resource.Type, label)
+			}
+		}
+		if _, err := strconv.ParseUint(ts.Resource.Labels["instance_id"], 10, 64); ts.Resource.Type == "gce_instance" && err != nil {
+			return fmt.Errorf("instance_id %q is not numeric", ts.Resource.Labels["instance_id"])
+		}
+		for _, p := range ts.Points {
+			if !pointTime(p.Interval.EndTime).After(pointTime(p.Interval.StartTime)) {
+				return fmt.Errorf("the end time of a cumulative point must be after its start time")
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeMetricService) received() []createTimeSeriesRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func pointTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+func (suite *MetricsTestSuite) newCloudMonitoringClient(service *fakeMetricService) (*cloudMonitoring, func(context.Context) error) {
+	server := httptest.NewServer(service)
+	suite.T().Cleanup(server.Close)
+
+	m, shutdown, err := NewCloudMonitoringClient(context.Background(), CloudMonitoringInput{
+		Logger:           suite.l,
+		ClusterLocations: map[string]string{"regional-cluster": "europe-west1"},
+		WriteInterval:    time.Hour,
+		ClientOptions: []option.ClientOption{
+			option.WithEndpoint(server.URL + "/"),
+			option.WithoutAuthentication(),
+		},
+	})
+	suite.Require().NoError(err)
+	return m.(*cloudMonitoring), shutdown
+}
+
+func (suite *MetricsTestSuite) TestCloudMonitoringClientWritesCumulativeSeries() {
+	service := &fakeMetricService{}
+	m, shutdown := suite.newCloudMonitoringClient(service)
+
+	kubernetes := InstanceLabels{
+		KubernetesCluster: "regional-cluster",
+		Project:           "mock-project",
+		WorkloadType:      "kubernetes",
+		WorkloadName:      "regional-cluster",
+		Zone:              "europe-west1-c",
+		Instance:          "node-1",
+	}
+	batch := InstanceLabels{
+		Project:      "other-project",
+		WorkloadType: "batch",
+		WorkloadName: "job",
+		Zone:         "europe-west1-b",
+		Instance:     "job-vm-1",
+	}
+	m.IncreaseInterruptionEventCounter(kubernetes)
+	// another node of the same cluster is counted against the same k8s_cluster series
+	m.IncreaseInterruptionEventCounter(InstanceLabels{
+		KubernetesCluster: "regional-cluster",
+		Project:           "mock-project",
+		WorkloadType:      "kubernetes",
+		WorkloadName:      "regional-cluster",
+		Zone:              "europe-west1-d",
+		Instance:          "node-2",
+	})
+	m.IncreaseNodeTerminationCounter(batch, "preempted")
+
+	suite.Require().NoError(m.write(context.Background()))
+	requests := service.received()
+	suite.Require().Len(requests, 2)
+	byProject := map[string]createTimeSeriesRequest{}
+	for _, req := range requests {
+		byProject[req.Name] = req
+	}
+
+	suite.Require().Len(byProject["projects/mock-project"].TimeSeries, 1)
+	interruptions := byProject["projects/mock-project"].TimeSeries[0]
+	suite.Equal(InterruptionsMetricType, interruptions.Metric.Type)
+	suite.Equal(map[string]string{"workload_type": "kubernetes", "workload_name": "regional-cluster"}, interruptions.Metric.Labels)
+	suite.Equal("k8s_cluster", interruptions.Resource.Type)
+	suite.Equal(map[string]string{
+		"project_id":   "mock-project",
+		"location":     "europe-west1",
+		"cluster_name": "regional-cluster",
+	}, interruptions.Resource.Labels)
+	suite.Equal(int64(2), *interruptions.Points[0].Value.Int64Value)
+	firstStart := pointTime(interruptions.Points[0].Interval.StartTime)
+	suite.True(pointTime(interruptions.Points[0].Interval.EndTime).After(firstStart))
+
+	terminations := byProject["projects/other-project"].TimeSeries[0]
+	suite.Equal(NodeTerminationsMetricType, terminations.Metric.Type)
+	suite.Equal("preempted", terminations.Metric.Labels["cause"])
+	suite.Equal("generic_node", terminations.Resource.Type)
+	suite.Equal(map[string]string{
+		"project_id": "other-project",
+		"location":   "europe-west1-b",
+		"namespace":  "job",
+		"node_id":    "job-vm-1",
+	}, terminations.Resource.Labels)
+
+	// unchanged series are not rewritten, and changed series keep their start time
+	m.IncreaseInterruptionEventCounter(kubernetes)
+	suite.Require().NoError(shutdown(context.Background()))
+	requests = service.received()
+	suite.Require().Len(requests, 3)
+	suite.Require().Len(requests[2].TimeSeries, 1)
+	suite.Equal(int64(3), *requests[2].TimeSeries[0].Points[0].Value.Int64Value)
+	suite.Equal(firstStart, pointTime(requests[2].TimeSeries[0].Points[0].Interval.StartTime))
+}
+
+func (suite *MetricsTestSuite) TestCloudMonitoringClientBatchesAndRetries() {
+	service := &fakeMetricService{failures: 1}
+	m, shutdown := suite.newCloudMonitoringClient(service)
+	defer shutdown(context.Background())
+
+	for i := 0; i < maxTimeSeriesPerRequest+1; i++ {
+		m.IncreaseInterruptionEventCounter(InstanceLabels{
+			Project:      "mock-project",
+			WorkloadType: "mig",
+			WorkloadName: "group",
+			Zone:         "europe-west1-c",
+			Instance:     fmt.Sprintf("instance-%d", i),
+		})
+	}
+
+	// the first batch fails, so is retried with the next write
+	suite.Error(m.write(context.Background()))
+	suite.Require().Len(service.received(), 1)
+	suite.NoError(m.write(context.Background()))
+	requests := service.received()
+	suite.Require().Len(requests, 2)
+	suite.Equal(maxTimeSeriesPerRequest+1, len(requests[0].TimeSeries)+len(requests[1].TimeSeries))
+	for _, req := range requests {
+		suite.LessOrEqual(len(req.TimeSeries), maxTimeSeriesPerRequest)
+	}
+}
+
+func (suite *MetricsTestSuite) TestCloudMonitoringClientDropsIdleSeries() {
+	service := &fakeMetricService{}
+	m, shutdown := suite.newCloudMonitoringClient(service)
+	defer shutdown(context.Background())
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	labels := InstanceLabels{
+		Project:      "mock-project",
+		WorkloadType: "mig",
+		WorkloadName: "group",
+		Zone:         "europe-west1-c",
+		Instance:     "instance-1",
+	}
+	m.IncreaseInterruptionEventCounter(labels)
+	now = now.Add(time.Second)
+	suite.Require().NoError(m.write(context.Background()))
+	suite.Len(m.series, 1)
+
+	// written series are kept until they have been idle for the idle timeout
+	now = now.Add(defaultCloudMonitoringIdleTimeout)
+	suite.Require().NoError(m.write(context.Background()))
+	suite.Empty(m.series)
+
+	// series increased again start from zero with a new start time
+	m.IncreaseInterruptionEventCounter(labels)
+	now = now.Add(time.Second)
+	suite.Require().NoError(m.write(context.Background()))
+	requests := service.received()
+	suite.Require().Len(requests, 2)
+	first, second := requests[0].TimeSeries[0].Points[0], requests[1].TimeSeries[0].Points[0]
+	suite.Equal(int64(1), *second.Value.Int64Value)
+	suite.True(pointTime(second.Interval.StartTime).After(pointTime(first.Interval.EndTime)))
+}
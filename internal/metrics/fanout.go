@@ -0,0 +1,319 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultFanOutQueueSize = 1000
+	// dropWarningInterval is how often a backend whose queue is full logs how many calls it dropped
+	dropWarningInterval = time.Minute
+)
+
+// Backend is a metrics client a FanOut client forwards calls to
+type Backend struct {
+	// Name identifies the backend in logs
+	Name string
+	// Client receives the counters, and every other metric too if it also implements Operations
+	Client Counters
+	// Shutdown is optional, and flushes any metrics the backend has yet to push
+	Shutdown func(context.Context) error
+}
+
+// FanOutInput configures a FanOut metrics client
+type FanOutInput struct {
+	Logger   *zap.SugaredLogger
+	Backends []Backend
+	// QueueSize is how many calls may be queued for a backend before further calls to it are dropped, defaulting to 1000.
+	// Dropped calls are counted as metrics_updates_dropped_total by the other backends. Interruptions and terminations are
+	// never dropped, but counted up for the backend until its queue has room for them.
+	QueueSize int
+}
+
+// NewFanOutClient creates a metrics client forwarding every call to each of the backends implementing it.
+// Each backend receives calls in order from its own queue, so a slow or panicking backend does not delay or break the others.
+// The returned function delivers queued calls, then shuts down every backend.
+func NewFanOutClient(input FanOutInput) (Client, func(context.Context) error, error) {
+	if len(input.Backends) == 0 {
+		return nil, nil, fmt.Errorf("at least one metrics backend must be configured")
+	}
+	queueSize := input.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultFanOutQueueSize
+	}
+
+	f := &fanOut{log: input.Logger}
+	for _, b := range input.Backends {
+		ops, _ := b.Client.(Operations)
+		q := &backendQueue{
+			Backend: b,
+			ops:     ops,
+			log:     input.Logger.With("backend", b.Name),
+			calls:   make(chan func(), queueSize),
+			wake:    make(chan struct{}, 1),
+			pending: map[counterKey]int{},
+		}
+		f.queues = append(f.queues, q)
+		f.wg.Add(1)
+		go q.run(&f.wg)
+	}
+	return f, f.shutdown, nil
+}
+
+// counterKey identifies an increase of the interruption counter, or of the termination counter with cause if termination is true
+type counterKey struct {
+	labels      InstanceLabels
+	termination bool
+	cause       string
+}
+
+func (k counterKey) call(c Counters) {
+	if k.termination {
+		c.IncreaseNodeTerminationCounter(k.labels, k.cause)
+		return
+	}
+	c.IncreaseInterruptionEventCounter(k.labels)
+}
+
+type backendQueue struct {
+	Backend
+	// ops is nil if the backend only records the counters
+	ops   Operations
+	log   *zap.SugaredLogger
+	calls chan func()
+	// wake is signalled when counter increases are pending
+	wake chan struct{}
+
+	// mu guards pending, the increases of each counter that did not fit in calls, and the dropped calls yet to be logged
+	mu                  sync.Mutex
+	pending             map[counterKey]int
+	droppedSinceWarning int
+	lastWarning         time.Time
+}
+
+func (q *backendQueue) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case call, ok := <-q.calls:
+			if !ok {
+				// no counter increases are pending once the queue is closed, as none are made after
+				q.flush()
+				return
+			}
+			q.invoke(call)
+		case <-q.wake:
+		}
+		q.flush()
+	}
+}
+
+func (q *backendQueue) invoke(call func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			q.log.With("panic", r).Error("metrics backend panicked")
+		}
+	}()
+	call()
+}
+
+// enqueue queues call for the backend, returning false rather than blocking the caller if the queue is full
+func (q *backendQueue) enqueue(call func()) bool {
+	select {
+	case q.calls <- call:
+		return true
+	default:
+		return false
+	}
+}
+
+// increase queues an increase of the counter k, counting it up to be made once the backend catches up if the queue is full,
+// so that the counters of interruptions and terminations are never dropped
+func (q *backendQueue) increase(k counterKey) {
+	if q.enqueue(func() { k.call(q.Client) }) {
+		return
+	}
+	q.mu.Lock()
+	q.pending[k]++
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// flush makes the counter increases pending
+func (q *backendQueue) flush() {
+	q.mu.Lock()
+	pending := q.pending
+	if len(pending) > 0 {
+		q.pending = map[counterKey]int{}
+	}
+	q.mu.Unlock()
+	for k, n := range pending {
+		for i := 0; i < n; i++ {
+			q.invoke(func() { k.call(q.Client) })
+		}
+	}
+}
+
+// warnDropped logs that a call was dropped, at most once every dropWarningInterval along with how many were dropped since
+func (q *backendQueue) warnDropped() {
+	q.mu.Lock()
+	q.droppedSinceWarning++
+	now := time.Now()
+	if now.Sub(q.lastWarning) < dropWarningInterval {
+		q.mu.Unlock()
+		return
+	}
+	dropped := q.droppedSinceWarning
+	q.droppedSinceWarning = 0
+	q.lastWarning = now
+	q.mu.Unlock()
+	q.log.With("dropped", dropped).Warn("metrics backend queue is full, dropping updates")
+}
+
+type fanOut struct {
+	log    *zap.SugaredLogger
+	queues []*backendQueue
+	wg     sync.WaitGroup
+
+	// mu guards closed, so no calls are queued once the queues are closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// forward calls call with every backend implementing Operations
+func (f *fanOut) forward(call func(Operations)) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return
+	}
+	for _, q := range f.queues {
+		if q.ops == nil {
+			continue
+		}
+		ops := q.ops
+		if !q.enqueue(func() { call(ops) }) {
+			q.warnDropped()
+			f.dropped(q)
+		}
+	}
+}
+
+// forwardIncrease increases the counter k in every backend, without dropping the increase for backends whose queue is full
+func (f *fanOut) forwardIncrease(k counterKey) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return
+	}
+	for _, q := range f.queues {
+		q.increase(k)
+	}
+}
+
+// dropped counts a call dropped for the backend of full in every other backend implementing Operations, as its own queue
+// has no room for it. Counts that the other queues have no room for either are dropped too.
+func (f *fanOut) dropped(full *backendQueue) {
+	for _, q := range f.queues {
+		if q != full && q.ops != nil {
+			ops := q.ops
+			q.enqueue(func() { ops.IncreaseDroppedUpdateCounter(full.Name) })
+		}
+	}
+}
+
+func (f *fanOut) shutdown(ctx context.Context) error {
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		for _, q := range f.queues {
+			close(q.calls)
+		}
+	}
+	f.mu.Unlock()
+	delivered := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(delivered)
+	}()
+	var errs []error
+	select {
+	case <-delivered:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("failed to deliver queued metrics: %w", ctx.Err()))
+	}
+	for _, q := range f.queues {
+		if q.Shutdown == nil {
+			continue
+		}
+		if err := q.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to shut down metrics backend %s: %w", q.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *fanOut) IncreaseInterruptionEventCounter(labels InstanceLabels) {
+	f.forwardIncrease(counterKey{labels: labels})
+}
+
+func (f *fanOut) IncreaseNodeTerminationCounter(labels InstanceLabels, cause string) {
+	f.forwardIncrease(counterKey{labels: labels, termination: true, cause: cause})
+}
+
+func (f *fanOut) ObserveStoppedDuration(labels InstanceLabels, d time.Duration) {
+	f.forward(func(o Operations) { o.ObserveStoppedDuration(labels, d) })
+}
+
+func (f *fanOut) IncreaseMessagesReceivedCounter(subscription string) {
+	f.forward(func(o Operations) { o.IncreaseMessagesReceivedCounter(subscription) })
+}
+
+func (f *fanOut) IncreaseParseFailureCounter(handler, reason string) {
+	f.forward(func(o Operations) { o.IncreaseParseFailureCounter(handler, reason) })
+}
+
+func (f *fanOut) IncreaseUnknownInstanceCounter(handler string) {
+	f.forward(func(o Operations) { o.IncreaseUnknownInstanceCounter(handler) })
+}
+
+func (f *fanOut) IncreaseDuplicateMessageCounter(handler, strategy string) {
+	f.forward(func(o Operations) { o.IncreaseDuplicateMessageCounter(handler, strategy) })
+}
+
+func (f *fanOut) IncreaseCacheLookupCounter(cache, result string) {
+	f.forward(func(o Operations) { o.IncreaseCacheLookupCounter(cache, result) })
+}
+
+func (f *fanOut) IncreaseCacheEvictionCounter(cache, reason string) {
+	f.forward(func(o Operations) { o.IncreaseCacheEvictionCounter(cache, reason) })
+}
+
+func (f *fanOut) SetInstanceMappingSize(size int) {
+	f.forward(func(o Operations) { o.SetInstanceMappingSize(size) })
+}
+
+func (f *fanOut) IncreaseDroppedUpdateCounter(backend string) {
+	f.forward(func(o Operations) { o.IncreaseDroppedUpdateCounter(backend) })
+}
+
+func (f *fanOut) SetPreemptionStormActive(scope, key string, active bool) {
+	f.forward(func(o Operations) { o.SetPreemptionStormActive(scope, key, active) })
+}
+
+func (f *fanOut) IncreaseStageEventCounter(pipeline, stage, result string) {
+	f.forward(func(o Operations) { o.IncreaseStageEventCounter(pipeline, stage, result) })
+}
+
+func (f *fanOut) ObserveHandlerDuration(handler string, d time.Duration) {
+	f.forward(func(o Operations) { o.ObserveHandlerDuration(handler, d) })
+}
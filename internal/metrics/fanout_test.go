@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// countingClient counts interruptions, terminations, messages received and dropped updates, optionally blocking on or
+// panicking for each interruption and message received
+type countingClient struct {
+	Client
+	interruptions atomic.Int64
+	terminations  atomic.Int64
+	received      atomic.Int64
+	dropped       atomic.Int64
+	block         chan struct{}
+	panics        bool
+}
+
+func (c *countingClient) IncreaseInterruptionEventCounter(_ InstanceLabels) {
+	c.wait()
+	c.interruptions.Add(1)
+}
+
+func (c *countingClient) IncreaseNodeTerminationCounter(_ InstanceLabels, _ string) {
+	c.terminations.Add(1)
+}
+
+func (c *countingClient) IncreaseMessagesReceivedCounter(_ string) {
+	c.wait()
+	c.received.Add(1)
+}
+
+func (c *countingClient) wait() {
+	if c.block != nil {
+		<-c.block
+	}
+	if c.panics {
+		panic("backend failure")
+	}
+}
+
+func (c *countingClient) IncreaseDroppedUpdateCounter(_ string) {
+	c.dropped.Add(1)
+}
+
+func (suite *MetricsTestSuite) TestFanOutClientForwardsToEveryBackend() {
+	first, second := &countingClient{}, &countingClient{}
+	shutdowns := 0
+	m, shutdown, err := NewFanOutClient(FanOutInput{
+		Logger: suite.l,
+		Backends: []Backend{
+			{Name: "first", Client: first, Shutdown: func(context.Context) error {
+				shutdowns++
+				return nil
+			}},
+			{Name: "second", Client: second},
+		},
+	})
+	suite.Require().NoError(err)
+
+	m.IncreaseInterruptionEventCounter(InstanceLabels{})
+	m.IncreaseInterruptionEventCounter(InstanceLabels{})
+	suite.NoError(shutdown(context.Background()))
+	// calls after shutting down are dropped
+	m.IncreaseInterruptionEventCounter(InstanceLabels{})
+
+	suite.Equal(int64(2), first.interruptions.Load())
+	suite.Equal(int64(2), second.interruptions.Load())
+	suite.Equal(1, shutdowns)
+}
+
+// counterOnlyClient counts interruptions and terminations, without implementing Operations
+type counterOnlyClient struct {
+	interruptions atomic.Int64
+	terminations  atomic.Int64
+}
+
+func (c *counterOnlyClient) IncreaseInterruptionEventCounter(_ InstanceLabels) {
+	c.interruptions.Add(1)
+}
+
+func (c *counterOnlyClient) IncreaseNodeTerminationCounter(_ InstanceLabels, _ string) {
+	c.terminations.Add(1)
+}
+
+func (suite *MetricsTestSuite) TestFanOutClientForwardsOperationsOnlyToBackendsImplementingThem() {
+	full, counters := &countingClient{}, &counterOnlyClient{}
+	m, shutdown, err := NewFanOutClient(FanOutInput{
+		Logger: suite.l,
+		Backends: []Backend{
+			{Name: "full", Client: full},
+			{Name: "counters", Client: counters},
+		},
+	})
+	suite.Require().NoError(err)
+
+	m.IncreaseInterruptionEventCounter(InstanceLabels{})
+	m.IncreaseNodeTerminationCounter(InstanceLabels{}, "preempted")
+	m.IncreaseMessagesReceivedCounter("sub")
+	suite.NoError(shutdown(context.Background()))
+
+	suite.Equal(int64(1), full.interruptions.Load())
+	suite.Equal(int64(1), full.terminations.Load())
+	suite.Equal(int64(1), full.received.Load())
+	suite.Equal(int64(1), counters.interruptions.Load())
+	suite.Equal(int64(1), counters.terminations.Load())
+}
+
+func (suite *MetricsTestSuite) TestFanOutClientIsolatesBackends() {
+	slow := &countingClient{block: make(chan struct{})}
+	failing := &countingClient{panics: true}
+	healthy := &countingClient{}
+	m, shutdown, err := NewFanOutClient(FanOutInput{
+		Logger: suite.l,
+		Backends: []Backend{
+			{Name: "slow", Client: slow},
+			{Name: "failing", Client: failing},
+			{Name: "healthy", Client: healthy},
+		},
+		QueueSize: 2,
+	})
+	suite.Require().NoError(err)
+
+	returned := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			m.IncreaseMessagesReceivedCounter("subscription")
+			// let the healthy backend keep up with its queue of two, which also receives counts of the slow backend's drops
+			time.Sleep(time.Millisecond * 10)
+		}
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second * 5):
+		suite.FailNow("a slow backend blocked the caller")
+	}
+	suite.Eventually(func() bool { return healthy.received.Load() == 5 }, time.Second, time.Millisecond*10)
+
+	close(slow.block)
+	suite.NoError(shutdown(context.Background()))
+	// the slow backend's full queue dropped the calls it could not keep up with, which the other backends counted
+	suite.Less(slow.received.Load(), int64(5))
+	suite.Equal(5-slow.received.Load(), healthy.dropped.Load())
+}
+
+func (suite *MetricsTestSuite) TestFanOutClientKeepsCounters() {
+	slow := &countingClient{block: make(chan struct{})}
+	healthy := &countingClient{}
+	m, shutdown, err := NewFanOutClient(FanOutInput{
+		Logger: suite.l,
+		Backends: []Backend{
+			{Name: "slow", Client: slow},
+			{Name: "healthy", Client: healthy},
+		},
+		QueueSize: 2,
+	})
+	suite.Require().NoError(err)
+
+	returned := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			m.IncreaseInterruptionEventCounter(InstanceLabels{Project: "project"})
+			m.IncreaseNodeTerminationCounter(InstanceLabels{Project: "project"}, "preempted")
+		}
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second * 5):
+		suite.FailNow("a slow backend blocked the caller")
+	}
+
+	close(slow.block)
+	suite.NoError(shutdown(context.Background()))
+	// increases the slow backend's queue had no room for were made once it caught up, rather than dropped
+	suite.Equal(int64(10), slow.interruptions.Load())
+	suite.Equal(int64(10), slow.terminations.Load())
+	suite.Equal(int64(10), healthy.interruptions.Load())
+	suite.Zero(healthy.dropped.Load())
+}
+
+func (suite *MetricsTestSuite) TestFanOutClientShutdown() {
+	slow := &countingClient{block: make(chan struct{})}
+	m, shutdown, err := NewFanOutClient(FanOutInput{
+		Logger: suite.l,
+		Backends: []Backend{
+			{Name: "slow", Client: slow, Shutdown: func(context.Context) error {
+				return errors.New("flush failed")
+			}},
+		},
+	})
+	suite.Require().NoError(err)
+	defer close(slow.block)
+
+	m.IncreaseInterruptionEventCounter(InstanceLabels{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	err = shutdown(ctx)
+	suite.ErrorIs(err, context.DeadlineExceeded)
+	suite.ErrorContains(err, "flush failed")
+
+	_, _, err = NewFanOutClient(FanOutInput{Logger: suite.l})
+	suite.Error(err)
+}
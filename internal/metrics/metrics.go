@@ -2,9 +2,8 @@
 package metrics
 
 import (
-	"fmt"
-	"log"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -12,39 +11,224 @@ import (
 	"go.uber.org/zap"
 )
 
-var (
-	interruptionEvents = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "interruption_events_total",
-		Help: "The total number of spot interruptions for a given cluster",
-	}, []string{"target_kubernetes_cluster"})
-)
+var instanceLabelNames = []string{"target_kubernetes_cluster", "project", "workload_type", "workload_name"}
+
+// InstanceLabels identify the workload an instance belonged to
+type InstanceLabels struct {
+	// KubernetesCluster is empty if the instance was not a Kubernetes node
+	KubernetesCluster string
+	Project           string
+	WorkloadType      string
+	WorkloadName      string
+	// Zone and Instance identify the instance itself. They are too high cardinality to be labels,
+	// so are only used by backends attaching metrics to monitored resources
+	Zone     string
+	Instance string
+	// NodePool is the node pool of a Kubernetes node, and MachineType that of the instance, if known.
+	// They are not labels, as they are not known for every instance
+	NodePool    string
+	MachineType string
+}
+
+func (l InstanceLabels) values() []string {
+	return []string{l.KubernetesCluster, l.Project, l.WorkloadType, l.WorkloadName}
+}
 
 // Client provides methods for modifying metrics
 type Client interface {
-	// IncreaseInterruptionEventCounter increases the interruption metric by one with a label value of cluster
-	IncreaseInterruptionEventCounter(cluster string)
-	// ServeMetrics serves metrics on the specified port and path of the given
-	ServeMetrics(path, port string)
+	Counters
+	Operations
+}
+
+// Counters provides methods for modifying the interruption and termination counters, which every metrics backend records
+type Counters interface {
+	// IncreaseInterruptionEventCounter increases the interruption metric by one with label values of labels
+	IncreaseInterruptionEventCounter(labels InstanceLabels)
+	// IncreaseNodeTerminationCounter increases the termination metric by one with label values of labels and cause
+	IncreaseNodeTerminationCounter(labels InstanceLabels, cause string)
+}
+
+// Operations provides methods for modifying the remaining metrics, mostly about the health of the exporter itself,
+// which backends only recording interruptions and terminations need not implement
+type Operations interface {
+	// ObserveStoppedDuration records how long an instance with labels was stopped for
+	ObserveStoppedDuration(labels InstanceLabels, d time.Duration)
+	// IncreaseMessagesReceivedCounter increases the number of messages received from subscription by one
+	IncreaseMessagesReceivedCounter(subscription string)
+	// IncreaseParseFailureCounter increases the number of messages handler failed to parse for reason by one
+	IncreaseParseFailureCounter(handler, reason string)
+	// IncreaseUnknownInstanceCounter increases the number of events handler received for instances missing from the mapping by one
+	IncreaseUnknownInstanceCounter(handler string)
+	// IncreaseDuplicateMessageCounter increases the number of duplicate messages handler suppressed by one, recognised by strategy
+	IncreaseDuplicateMessageCounter(handler, strategy string)
+	// IncreaseCacheLookupCounter increases the number of lookups in cache with result, e.g. hit or miss, by one
+	IncreaseCacheLookupCounter(cache, result string)
+	// IncreaseCacheEvictionCounter increases the number of items evicted from cache for reason, e.g. expired or capacity, by one
+	IncreaseCacheEvictionCounter(cache, reason string)
+	// SetInstanceMappingSize sets the number of instances tracked in the mapping of instances to workloads
+	SetInstanceMappingSize(size int)
+	// IncreaseStageEventCounter increases the number of events stage of pipeline handled with result, e.g. passed, skipped or failed, by one
+	IncreaseStageEventCounter(pipeline, stage, result string)
+	// ObserveHandlerDuration records how long handler took to process a single message
+	ObserveHandlerDuration(handler string, d time.Duration)
+	// IncreaseDroppedUpdateCounter increases the number of updates dropped for the metrics backend, e.g. statsd, by one
+	IncreaseDroppedUpdateCounter(backend string)
+	// SetPreemptionStormActive records whether a preemption storm is active in the scope, e.g. zone, with the given key, e.g. europe-west1-c
+	SetPreemptionStormActive(scope, key string, active bool)
+}
+
+func (m *metrics) IncreaseInterruptionEventCounter(labels InstanceLabels) {
+	m.interruptionEvents.WithLabelValues(labels.values()...).Inc()
+}
+
+func (m *metrics) IncreaseNodeTerminationCounter(labels InstanceLabels, cause string) {
+	m.nodeTerminations.WithLabelValues(append(labels.values(), cause)...).Inc()
+}
+
+func (m *metrics) ObserveStoppedDuration(labels InstanceLabels, d time.Duration) {
+	m.stoppedDuration.WithLabelValues(labels.values()...).Observe(d.Seconds())
 }
 
-func (m *metrics) IncreaseInterruptionEventCounter(cluster string) {
-	interruptionEvents.WithLabelValues(cluster).Inc()
+func (m *metrics) IncreaseMessagesReceivedCounter(subscription string) {
+	m.messagesReceived.WithLabelValues(subscription).Inc()
 }
 
-func (m *metrics) ServeMetrics(path, port string) {
-	http.Handle(path, promhttp.Handler())
-	go func() {
-		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
-	}()
+func (m *metrics) IncreaseParseFailureCounter(handler, reason string) {
+	m.parseFailures.WithLabelValues(handler, reason).Inc()
 }
 
-// NewClient creates a new metrics client. To actually start the metrics server, call the Client's ServeMetrics  method.
-func NewClient(log *zap.SugaredLogger) Client {
+func (m *metrics) IncreaseUnknownInstanceCounter(handler string) {
+	m.unknownInstances.WithLabelValues(handler).Inc()
+}
+
+func (m *metrics) IncreaseDuplicateMessageCounter(handler, strategy string) {
+	m.duplicateMessages.WithLabelValues(handler, strategy).Inc()
+}
+
+func (m *metrics) IncreaseCacheLookupCounter(cache, result string) {
+	m.cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+func (m *metrics) IncreaseCacheEvictionCounter(cache, reason string) {
+	m.cacheEvictions.WithLabelValues(cache, reason).Inc()
+}
+
+func (m *metrics) SetInstanceMappingSize(size int) {
+	m.instanceMappingSize.Set(float64(size))
+}
+
+func (m *metrics) IncreaseStageEventCounter(pipeline, stage, result string) {
+	m.stageEvents.WithLabelValues(pipeline, stage, result).Inc()
+}
+
+func (m *metrics) ObserveHandlerDuration(handler string, d time.Duration) {
+	m.handlerDuration.WithLabelValues(handler).Observe(d.Seconds())
+}
+
+func (m *metrics) IncreaseDroppedUpdateCounter(backend string) {
+	m.droppedUpdates.WithLabelValues(backend).Inc()
+}
+
+func (m *metrics) SetPreemptionStormActive(scope, key string, active bool) {
+	m.preemptionStormActive.WithLabelValues(scope, key).Set(boolToFloat(active))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// NewHandler serves the metrics registered with registry for scraping, e.g. those of a client created by NewClient
+func NewHandler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// NewClient creates a new metrics client that registers its metrics with registry.
+// To serve them, add the handler returned by NewHandler for registry to an HTTP server.
+func NewClient(log *zap.SugaredLogger, registry *prometheus.Registry) Client {
+	factory := promauto.With(registry)
 	return &metrics{
-		log: log,
+		log:      log,
+		registry: registry,
+		interruptionEvents: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "interruption_events_total",
+			Help: "The total number of spot interruptions for a given workload",
+		}, instanceLabelNames),
+		nodeTerminations: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "node_terminations_total",
+			Help: "The total number of involuntary node terminations for a given workload and cause",
+		}, append(instanceLabelNames, "cause")),
+		stoppedDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "stopped_instance_duration_seconds",
+			Help:    "How long instances stopped by an interruption remained stopped before being started again",
+			Buckets: prometheus.ExponentialBuckets(60, 4, 8),
+		}, instanceLabelNames),
+		messagesReceived: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "pubsub_messages_received_total",
+			Help: "The total number of messages received from a given pubsub subscription",
+		}, []string{"subscription"}),
+		parseFailures: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "message_parse_failures_total",
+			Help: "The total number of messages a given handler failed to parse, by reason",
+		}, []string{"handler", "reason"}),
+		unknownInstances: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "unknown_instance_lookups_total",
+			Help: "The total number of events a given handler received for instances missing from the mapping of instances to workloads",
+		}, []string{"handler"}),
+		duplicateMessages: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "duplicate_messages_suppressed_total",
+			Help: "The total number of duplicate messages a given handler suppressed, by the strategy that recognised them",
+		}, []string{"handler", "strategy"}),
+		cacheLookups: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_lookups_total",
+			Help: "The total number of lookups in a given cache, by whether they hit or missed",
+		}, []string{"cache", "result"}),
+		cacheEvictions: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_evictions_total",
+			Help: "The total number of items evicted from a given cache, by whether they expired or the cache was full",
+		}, []string{"cache", "reason"}),
+		instanceMappingSize: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "instance_mapping_size",
+			Help: "The number of instances tracked in the mapping of instances to workloads",
+		}),
+		stageEvents: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "pipeline_stage_events_total",
+			Help: "The total number of events a given stage of a pipeline handled, by whether they passed, were skipped or failed",
+		}, []string{"pipeline", "stage", "result"}),
+		handlerDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "handler_processing_duration_seconds",
+			Help:    "How long a given handler took to process a single message",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"handler"}),
+		droppedUpdates: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "metrics_updates_dropped_total",
+			Help: "The total number of metric updates dropped for a given backend, as it could not keep up with them",
+		}, []string{"backend"}),
+		preemptionStormActive: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "spot_preemption_storm_active",
+			Help: "Whether more preemptions than the configured threshold occurred recently in a given scope and key",
+		}, []string{"scope", "key"}),
 	}
 }
 
 type metrics struct {
-	log *zap.SugaredLogger
+	log      *zap.SugaredLogger
+	registry *prometheus.Registry
+
+	interruptionEvents    *prometheus.CounterVec
+	nodeTerminations      *prometheus.CounterVec
+	stoppedDuration       *prometheus.HistogramVec
+	messagesReceived      *prometheus.CounterVec
+	parseFailures         *prometheus.CounterVec
+	unknownInstances      *prometheus.CounterVec
+	duplicateMessages     *prometheus.CounterVec
+	cacheLookups          *prometheus.CounterVec
+	cacheEvictions        *prometheus.CounterVec
+	instanceMappingSize   prometheus.Gauge
+	stageEvents           *prometheus.CounterVec
+	handlerDuration       *prometheus.HistogramVec
+	droppedUpdates        *prometheus.CounterVec
+	preemptionStormActive *prometheus.GaugeVec
 }
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+	l *zap.SugaredLogger
+}
+
+func TestMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
+
+func (suite *MetricsTestSuite) SetupSuite() {
+	l, err := zap.NewDevelopment()
+	suite.NoError(err)
+	suite.l = l.Sugar()
+}
+
+func (suite *MetricsTestSuite) TestClientsDoNotShareState() {
+	labels := InstanceLabels{
+		KubernetesCluster: "fake-cluster",
+		Project:           "mock-project",
+		WorkloadType:      "kubernetes",
+		WorkloadName:      "fake-cluster",
+	}
+	first := NewClient(suite.l, prometheus.NewRegistry()).(*metrics)
+	second := NewClient(suite.l, prometheus.NewRegistry()).(*metrics)
+
+	first.IncreaseInterruptionEventCounter(labels)
+	first.IncreaseInterruptionEventCounter(labels)
+	second.IncreaseInterruptionEventCounter(labels)
+
+	suite.Equal(float64(2), testutil.ToFloat64(first.interruptionEvents.WithLabelValues(labels.values()...)))
+	suite.Equal(float64(1), testutil.ToFloat64(second.interruptionEvents.WithLabelValues(labels.values()...)))
+}
+
+func (suite *MetricsTestSuite) TestPipelineHealthMetrics() {
+	registry := prometheus.NewRegistry()
+	m := NewClient(suite.l, registry).(*metrics)
+
+	m.IncreaseMessagesReceivedCounter("sie-interruption-subscription")
+	m.IncreaseParseFailureCounter("interruption", "unmarshal")
+	m.IncreaseUnknownInstanceCounter("interruption")
+	m.IncreaseDuplicateMessageCounter("interruption", "message_id")
+	m.IncreaseCacheLookupCounter("instance_to_workload_mappings", "hit")
+	m.IncreaseCacheEvictionCounter("interruption_messages", "capacity")
+	m.SetInstanceMappingSize(42)
+	m.IncreaseStageEventCounter("interruption", "dedup", "skipped")
+	m.ObserveHandlerDuration("interruption", time.Millisecond)
+	m.IncreaseDroppedUpdateCounter("statsd")
+	m.SetPreemptionStormActive("zone", "europe-west1-c", true)
+
+	suite.Equal(float64(1), testutil.ToFloat64(m.messagesReceived.WithLabelValues("sie-interruption-subscription")))
+	suite.Equal(float64(1), testutil.ToFloat64(m.parseFailures.WithLabelValues("interruption", "unmarshal")))
+	suite.Equal(float64(1), testutil.ToFloat64(m.unknownInstances.WithLabelValues("interruption")))
+	suite.Equal(float64(1), testutil.ToFloat64(m.duplicateMessages.WithLabelValues("interruption", "message_id")))
+	suite.Equal(float64(1), testutil.ToFloat64(m.cacheLookups.WithLabelValues("instance_to_workload_mappings", "hit")))
+	suite.Equal(float64(1), testutil.ToFloat64(m.cacheEvictions.WithLabelValues("interruption_messages", "capacity")))
+	suite.Equal(float64(42), testutil.ToFloat64(m.instanceMappingSize))
+	suite.Equal(float64(1), testutil.ToFloat64(m.stageEvents.WithLabelValues("interruption", "dedup", "skipped")))
+	suite.Equal(float64(1), testutil.ToFloat64(m.droppedUpdates.WithLabelValues("statsd")))
+	suite.Equal(float64(1), testutil.ToFloat64(m.preemptionStormActive.WithLabelValues("zone", "europe-west1-c")))
+
+	count, err := testutil.GatherAndCount(registry, "handler_processing_duration_seconds")
+	suite.NoError(err)
+	suite.Equal(1, count)
+}
@@ -0,0 +1,323 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.uber.org/zap"
+)
+
+const (
+	// OTLPProtocolGRPC exports metrics using OTLP over gRPC
+	OTLPProtocolGRPC = "grpc"
+	// OTLPProtocolHTTP exports metrics using OTLP over HTTP with protobuf payloads
+	OTLPProtocolHTTP = "http"
+
+	// TemporalityCumulative reports counters and histograms as totals since the exporter started
+	TemporalityCumulative = "cumulative"
+	// TemporalityDelta reports counters and histograms as the change since the previous export
+	TemporalityDelta = "delta"
+
+	otelServiceName = "spot-interruption-exporter"
+	otelMeterName   = "github.com/thought-machine/spot-interruption-exporter"
+)
+
+// OpenTelemetryInput configures an OpenTelemetry metrics client
+type OpenTelemetryInput struct {
+	Logger *zap.SugaredLogger
+	// Endpoint is the host:port of the OTLP receiver
+	Endpoint string
+	// Protocol is either grpc or http, defaulting to grpc
+	Protocol string
+	// Insecure disables TLS when connecting to Endpoint
+	Insecure bool
+	// Headers are sent with every export, e.g. for authentication
+	Headers map[string]string
+	// ResourceAttributes are added to the resource describing the exporter, alongside service.name
+	ResourceAttributes map[string]string
+	// Temporality is either cumulative or delta, defaulting to cumulative
+	Temporality string
+	// ExportInterval is how often metrics are batched and exported, defaulting to one minute
+	ExportInterval time.Duration
+	// ExportTimeout bounds how long a single export may take, defaulting to 30 seconds
+	ExportTimeout time.Duration
+}
+
+// NewOpenTelemetryClient creates a metrics client that periodically pushes metrics over OTLP.
+// The returned function flushes any pending metrics and must be called before exiting.
+func NewOpenTelemetryClient(ctx context.Context, input OpenTelemetryInput) (Client, func(context.Context) error, error) {
+	selector, err := temporalitySelector(input.Temporality)
+	if err != nil {
+		return nil, nil, err
+	}
+	exporter, err := newOTLPExporter(ctx, input, selector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	attributes := []attribute.KeyValue{semconv.ServiceName(otelServiceName)}
+	for k, v := range input.ResourceAttributes {
+		attributes = append(attributes, attribute.String(k, v))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attributes...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OpenTelemetry resource: %w", err)
+	}
+
+	var readerOptions []sdkmetric.PeriodicReaderOption
+	if input.ExportInterval > 0 {
+		readerOptions = append(readerOptions, sdkmetric.WithInterval(input.ExportInterval))
+	}
+	if input.ExportTimeout > 0 {
+		readerOptions = append(readerOptions, sdkmetric.WithTimeout(input.ExportTimeout))
+	}
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, readerOptions...)),
+	)
+
+	m, err := newOTelMetrics(input.Logger, provider.Meter(otelMeterName))
+	if err != nil {
+		_ = provider.Shutdown(ctx)
+		return nil, nil, err
+	}
+	return m, provider.Shutdown, nil
+}
+
+func newOTLPExporter(ctx context.Context, input OpenTelemetryInput, selector sdkmetric.TemporalitySelector) (sdkmetric.Exporter, error) {
+	switch input.Protocol {
+	case OTLPProtocolGRPC, "":
+		options := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(input.Endpoint),
+			otlpmetricgrpc.WithHeaders(input.Headers),
+			otlpmetricgrpc.WithTemporalitySelector(selector),
+		}
+		if input.Insecure {
+			options = append(options, otlpmetricgrpc.WithInsecure())
+		}
+		return otlpmetricgrpc.New(ctx, options...)
+	case OTLPProtocolHTTP:
+		options := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpoint(input.Endpoint),
+			otlpmetrichttp.WithHeaders(input.Headers),
+			otlpmetrichttp.WithTemporalitySelector(selector),
+		}
+		if input.Insecure {
+			options = append(options, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, expected %s or %s", input.Protocol, OTLPProtocolGRPC, OTLPProtocolHTTP)
+	}
+}
+
+func temporalitySelector(temporality string) (sdkmetric.TemporalitySelector, error) {
+	switch temporality {
+	case TemporalityCumulative, "":
+		return sdkmetric.DefaultTemporalitySelector, nil
+	case TemporalityDelta:
+		return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+			// up-down counters and gauges are not monotonic, so are only meaningful as totals
+			switch kind {
+			case sdkmetric.InstrumentKindUpDownCounter, sdkmetric.InstrumentKindObservableUpDownCounter:
+				return metricdata.CumulativeTemporality
+			default:
+				return metricdata.DeltaTemporality
+			}
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported temporality %q, expected %s or %s", temporality, TemporalityCumulative, TemporalityDelta)
+	}
+}
+
+func newOTelMetrics(log *zap.SugaredLogger, meter metric.Meter) (*otelMetrics, error) {
+	m := &otelMetrics{log: log}
+	var err error
+	if m.interruptionEvents, err = meter.Int64Counter("interruption_events",
+		metric.WithDescription("The total number of spot interruptions for a given workload")); err != nil {
+		return nil, err
+	}
+	if m.nodeTerminations, err = meter.Int64Counter("node_terminations",
+		metric.WithDescription("The total number of involuntary node terminations for a given workload and cause")); err != nil {
+		return nil, err
+	}
+	if m.stoppedDuration, err = meter.Float64Histogram("stopped_instance_duration",
+		metric.WithDescription("How long instances stopped by an interruption remained stopped before being started again"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(exponentialBuckets(60, 4, 8)...)); err != nil {
+		return nil, err
+	}
+	if m.messagesReceived, err = meter.Int64Counter("pubsub_messages_received",
+		metric.WithDescription("The total number of messages received from a given pubsub subscription")); err != nil {
+		return nil, err
+	}
+	if m.parseFailures, err = meter.Int64Counter("message_parse_failures",
+		metric.WithDescription("The total number of messages a given handler failed to parse, by reason")); err != nil {
+		return nil, err
+	}
+	if m.unknownInstances, err = meter.Int64Counter("unknown_instance_lookups",
+		metric.WithDescription("The total number of events a given handler received for instances missing from the mapping of instances to workloads")); err != nil {
+		return nil, err
+	}
+	if m.duplicateMessages, err = meter.Int64Counter("duplicate_messages_suppressed",
+		metric.WithDescription("The total number of duplicate messages a given handler suppressed, by the strategy that recognised them")); err != nil {
+		return nil, err
+	}
+	if m.cacheLookups, err = meter.Int64Counter("cache_lookups",
+		metric.WithDescription("The total number of lookups in a given cache, by whether they hit or missed")); err != nil {
+		return nil, err
+	}
+	if m.cacheEvictions, err = meter.Int64Counter("cache_evictions",
+		metric.WithDescription("The total number of items evicted from a given cache, by whether they expired or the cache was full")); err != nil {
+		return nil, err
+	}
+	if m.droppedUpdates, err = meter.Int64Counter("metrics_updates_dropped",
+		metric.WithDescription("The total number of metric updates dropped for a given backend, as it could not keep up with them")); err != nil {
+		return nil, err
+	}
+	if _, err = meter.Int64ObservableGauge("instance_mapping_size",
+		metric.WithDescription("The number of instances tracked in the mapping of instances to workloads"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(m.instanceMappingSize.Load())
+			return nil
+		})); err != nil {
+		return nil, err
+	}
+	if _, err = meter.Int64ObservableGauge("spot_preemption_storm_active",
+		metric.WithDescription("Whether more preemptions than the configured threshold occurred recently in a given scope and key"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			m.storms.Range(func(k, v any) bool {
+				storm := k.(stormKey)
+				o.Observe(v.(int64), metric.WithAttributes(attribute.String("scope", storm.scope), attribute.String("key", storm.key)))
+				return true
+			})
+			return nil
+		})); err != nil {
+		return nil, err
+	}
+	if m.stageEvents, err = meter.Int64Counter("pipeline_stage_events",
+		metric.WithDescription("The total number of events a given stage of a pipeline handled, by whether they passed, were skipped or failed")); err != nil {
+		return nil, err
+	}
+	if m.handlerDuration, err = meter.Float64Histogram("handler_processing_duration",
+		metric.WithDescription("How long a given handler took to process a single message"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(exponentialBuckets(0.0001, 4, 8)...)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// exponentialBuckets mirrors prometheus.ExponentialBuckets so both backends report the same histogram boundaries
+func exponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+func instanceAttributes(labels InstanceLabels) []attribute.KeyValue {
+	values := labels.values()
+	attributes := make([]attribute.KeyValue, len(instanceLabelNames))
+	for i, name := range instanceLabelNames {
+		attributes[i] = attribute.String(name, values[i])
+	}
+	return attributes
+}
+
+func (m *otelMetrics) IncreaseInterruptionEventCounter(labels InstanceLabels) {
+	m.interruptionEvents.Add(context.Background(), 1, metric.WithAttributes(instanceAttributes(labels)...))
+}
+
+func (m *otelMetrics) IncreaseNodeTerminationCounter(labels InstanceLabels, cause string) {
+	m.nodeTerminations.Add(context.Background(), 1, metric.WithAttributes(append(instanceAttributes(labels), attribute.String("cause", cause))...))
+}
+
+func (m *otelMetrics) ObserveStoppedDuration(labels InstanceLabels, d time.Duration) {
+	m.stoppedDuration.Record(context.Background(), d.Seconds(), metric.WithAttributes(instanceAttributes(labels)...))
+}
+
+func (m *otelMetrics) IncreaseMessagesReceivedCounter(subscription string) {
+	m.messagesReceived.Add(context.Background(), 1, metric.WithAttributes(attribute.String("subscription", subscription)))
+}
+
+func (m *otelMetrics) IncreaseParseFailureCounter(handler, reason string) {
+	m.parseFailures.Add(context.Background(), 1, metric.WithAttributes(attribute.String("handler", handler), attribute.String("reason", reason)))
+}
+
+func (m *otelMetrics) IncreaseUnknownInstanceCounter(handler string) {
+	m.unknownInstances.Add(context.Background(), 1, metric.WithAttributes(attribute.String("handler", handler)))
+}
+
+func (m *otelMetrics) IncreaseDuplicateMessageCounter(handler, strategy string) {
+	m.duplicateMessages.Add(context.Background(), 1, metric.WithAttributes(attribute.String("handler", handler), attribute.String("strategy", strategy)))
+}
+
+func (m *otelMetrics) IncreaseCacheLookupCounter(cache, result string) {
+	m.cacheLookups.Add(context.Background(), 1, metric.WithAttributes(attribute.String("cache", cache), attribute.String("result", result)))
+}
+
+func (m *otelMetrics) IncreaseCacheEvictionCounter(cache, reason string) {
+	m.cacheEvictions.Add(context.Background(), 1, metric.WithAttributes(attribute.String("cache", cache), attribute.String("reason", reason)))
+}
+
+func (m *otelMetrics) IncreaseDroppedUpdateCounter(backend string) {
+	m.droppedUpdates.Add(context.Background(), 1, metric.WithAttributes(attribute.String("backend", backend)))
+}
+
+func (m *otelMetrics) SetInstanceMappingSize(size int) {
+	m.instanceMappingSize.Store(int64(size))
+}
+
+func (m *otelMetrics) IncreaseStageEventCounter(pipeline, stage, result string) {
+	m.stageEvents.Add(context.Background(), 1, metric.WithAttributes(attribute.String("pipeline", pipeline), attribute.String("stage", stage), attribute.String("result", result)))
+}
+
+func (m *otelMetrics) ObserveHandlerDuration(handler string, d time.Duration) {
+	m.handlerDuration.Record(context.Background(), d.Seconds(), metric.WithAttributes(attribute.String("handler", handler)))
+}
+
+func (m *otelMetrics) SetPreemptionStormActive(scope, key string, active bool) {
+	var v int64
+	if active {
+		v = 1
+	}
+	m.storms.Store(stormKey{scope: scope, key: key}, v)
+}
+
+type otelMetrics struct {
+	log *zap.SugaredLogger
+
+	interruptionEvents  metric.Int64Counter
+	nodeTerminations    metric.Int64Counter
+	stoppedDuration     metric.Float64Histogram
+	messagesReceived    metric.Int64Counter
+	parseFailures       metric.Int64Counter
+	unknownInstances    metric.Int64Counter
+	duplicateMessages   metric.Int64Counter
+	cacheLookups        metric.Int64Counter
+	cacheEvictions      metric.Int64Counter
+	instanceMappingSize atomic.Int64
+	stageEvents         metric.Int64Counter
+	handlerDuration     metric.Float64Histogram
+	droppedUpdates      metric.Int64Counter
+	// storms maps the stormKey of every storm seen to whether it is active, as 0 or 1
+	storms sync.Map
+}
+
+type stormKey struct {
+	scope, key string
+}
@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	collectormetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpReceiver is an in-process OTLP gRPC metrics receiver recording every export request
+type otlpReceiver struct {
+	collectormetricspb.UnimplementedMetricsServiceServer
+	requests chan *collectormetricspb.ExportMetricsServiceRequest
+}
+
+func (r *otlpReceiver) Export(_ context.Context, req *collectormetricspb.ExportMetricsServiceRequest) (*collectormetricspb.ExportMetricsServiceResponse, error) {
+	r.requests <- req
+	return &collectormetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func attributesToMap(attributes []*commonpb.KeyValue) map[string]string {
+	m := make(map[string]string, len(attributes))
+	for _, kv := range attributes {
+		m[kv.Key] = kv.Value.GetStringValue()
+	}
+	return m
+}
+
+// findMetric returns the named metric from any of the requests
+func findMetric(requests []*collectormetricspb.ExportMetricsServiceRequest, name string) *metricspb.Metric {
+	for _, req := range requests {
+		for _, rm := range req.ResourceMetrics {
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					if m.Name == name {
+						return m
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func drain(requests chan *collectormetricspb.ExportMetricsServiceRequest) []*collectormetricspb.ExportMetricsServiceRequest {
+	var received []*collectormetricspb.ExportMetricsServiceRequest
+	for {
+		select {
+		case req := <-requests:
+			received = append(received, req)
+		default:
+			return received
+		}
+	}
+}
+
+func (suite *MetricsTestSuite) TestOpenTelemetryClientExportsOverGRPC() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	receiver := &otlpReceiver{requests: make(chan *collectormetricspb.ExportMetricsServiceRequest, 10)}
+	server := grpc.NewServer()
+	collectormetricspb.RegisterMetricsServiceServer(server, receiver)
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	ctx := context.Background()
+	m, shutdown, err := NewOpenTelemetryClient(ctx, OpenTelemetryInput{
+		Logger:             suite.l,
+		Endpoint:           listener.Addr().String(),
+		Protocol:           OTLPProtocolGRPC,
+		Insecure:           true,
+		ResourceAttributes: map[string]string{"deployment.environment": "test"},
+		Temporality:        TemporalityDelta,
+		ExportInterval:     time.Hour,
+	})
+	suite.Require().NoError(err)
+
+	labels := InstanceLabels{
+		KubernetesCluster: "fake-cluster",
+		Project:           "mock-project",
+		WorkloadType:      "kubernetes",
+		WorkloadName:      "fake-cluster",
+	}
+	m.IncreaseInterruptionEventCounter(labels)
+	m.IncreaseInterruptionEventCounter(labels)
+	m.IncreaseNodeTerminationCounter(labels, "preempted")
+	m.ObserveStoppedDuration(labels, time.Minute*5)
+	m.SetInstanceMappingSize(42)
+	m.SetPreemptionStormActive("cluster", "fake-cluster", true)
+	// shutting down flushes the pending batch, regardless of the export interval
+	suite.Require().NoError(shutdown(ctx))
+
+	received := drain(receiver.requests)
+	suite.Require().NotEmpty(received)
+	resourceAttributes := attributesToMap(received[0].ResourceMetrics[0].Resource.Attributes)
+	suite.Equal("spot-interruption-exporter", resourceAttributes["service.name"])
+	suite.Equal("test", resourceAttributes["deployment.environment"])
+
+	interruptions := findMetric(received, "interruption_events")
+	suite.Require().NotNil(interruptions)
+	suite.Equal(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, interruptions.GetSum().AggregationTemporality)
+	suite.Require().Len(interruptions.GetSum().DataPoints, 1)
+	suite.Equal(int64(2), interruptions.GetSum().DataPoints[0].GetAsInt())
+	suite.Equal(map[string]string{
+		"target_kubernetes_cluster": "fake-cluster",
+		"project":                   "mock-project",
+		"workload_type":             "kubernetes",
+		"workload_name":             "fake-cluster",
+	}, attributesToMap(interruptions.GetSum().DataPoints[0].Attributes))
+
+	terminations := findMetric(received, "node_terminations")
+	suite.Require().NotNil(terminations)
+	suite.Equal("preempted", attributesToMap(terminations.GetSum().DataPoints[0].Attributes)["cause"])
+
+	stopped := findMetric(received, "stopped_instance_duration")
+	suite.Require().NotNil(stopped)
+	suite.Equal("s", stopped.Unit)
+	suite.Equal(float64(300), stopped.GetHistogram().DataPoints[0].GetSum())
+
+	mappingSize := findMetric(received, "instance_mapping_size")
+	suite.Require().NotNil(mappingSize)
+	suite.Equal(int64(42), mappingSize.GetGauge().DataPoints[0].GetAsInt())
+
+	storm := findMetric(received, "spot_preemption_storm_active")
+	suite.Require().NotNil(storm)
+	suite.Equal(int64(1), storm.GetGauge().DataPoints[0].GetAsInt())
+	suite.Equal(map[string]string{"scope": "cluster", "key": "fake-cluster"}, attributesToMap(storm.GetGauge().DataPoints[0].Attributes))
+}
+
+func (suite *MetricsTestSuite) TestOpenTelemetryClientExportsOverHTTP() {
+	requests := make(chan *collectormetricspb.ExportMetricsServiceRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("/v1/metrics", r.URL.Path)
+		suite.Equal("secret", r.Header.Get("Authorization"))
+		b, err := io.ReadAll(r.Body)
+		suite.NoError(err)
+		req := &collectormetricspb.ExportMetricsServiceRequest{}
+		suite.NoError(proto.Unmarshal(b, req))
+		requests <- req
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	m, shutdown, err := NewOpenTelemetryClient(ctx, OpenTelemetryInput{
+		Logger:         suite.l,
+		Endpoint:       strings.TrimPrefix(server.URL, "http://"),
+		Protocol:       OTLPProtocolHTTP,
+		Insecure:       true,
+		Headers:        map[string]string{"Authorization": "secret"},
+		ExportInterval: time.Hour,
+	})
+	suite.Require().NoError(err)
+
+	m.IncreaseParseFailureCounter("interruption", "unmarshal")
+	suite.Require().NoError(shutdown(ctx))
+
+	failures := findMetric(drain(requests), "message_parse_failures")
+	suite.Require().NotNil(failures)
+	suite.Equal(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, failures.GetSum().AggregationTemporality)
+	suite.Equal(map[string]string{"handler": "interruption", "reason": "unmarshal"}, attributesToMap(failures.GetSum().DataPoints[0].Attributes))
+}
+
+func (suite *MetricsTestSuite) TestOpenTelemetryClientRejectsInvalidInput() {
+	_, _, err := NewOpenTelemetryClient(context.Background(), OpenTelemetryInput{Logger: suite.l, Protocol: "udp"})
+	suite.Error(err)
+	_, _, err = NewOpenTelemetryClient(context.Background(), OpenTelemetryInput{Logger: suite.l, Temporality: "lowmemory"})
+	suite.Error(err)
+}
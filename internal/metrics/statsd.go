@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// StatsDFlavorStatsD emits plain StatsD packets, which have no tags, so label values are appended to metric names
+	StatsDFlavorStatsD = "statsd"
+	// StatsDFlavorDogStatsD emits DogStatsD packets, with labels as tags
+	StatsDFlavorDogStatsD = "dogstatsd"
+
+	// defaultStatsDMaxPacketSize keeps packets within the MTU of most networks
+	defaultStatsDMaxPacketSize = 1432
+	defaultStatsDFlushInterval = time.Second
+)
+
+// StatsDInput configures a StatsD metrics client
+type StatsDInput struct {
+	Logger *zap.SugaredLogger
+	// Address is the host:port of the StatsD server or Datadog agent
+	Address string
+	// Prefix is prepended to every metric name, separated by a dot
+	Prefix string
+	// Flavor is either statsd or dogstatsd, defaulting to dogstatsd
+	Flavor string
+	// FlushInterval is how often buffered metrics are sent, defaulting to one second
+	FlushInterval time.Duration
+	// MaxPacketSize is the largest UDP payload sent, defaulting to 1432 bytes
+	MaxPacketSize int
+}
+
+// NewStatsDClient creates a metrics client that buffers metrics and periodically sends them to a StatsD server over UDP.
+// The returned function flushes any buffered metrics and must be called before exiting.
+func NewStatsDClient(input StatsDInput) (Client, func(context.Context) error, error) {
+	var dogstatsd bool
+	switch input.Flavor {
+	case StatsDFlavorDogStatsD, "":
+		dogstatsd = true
+	case StatsDFlavorStatsD:
+	default:
+		return nil, nil, fmt.Errorf("unsupported statsd flavor %q, expected %s or %s", input.Flavor, StatsDFlavorStatsD, StatsDFlavorDogStatsD)
+	}
+	conn, err := net.Dial("udp", input.Address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to statsd server: %w", err)
+	}
+
+	m := &statsdMetrics{
+		log:           input.Logger,
+		conn:          conn,
+		prefix:        input.Prefix,
+		dogstatsd:     dogstatsd,
+		maxPacketSize: input.MaxPacketSize,
+		done:          make(chan struct{}),
+	}
+	if m.maxPacketSize <= 0 {
+		m.maxPacketSize = defaultStatsDMaxPacketSize
+	}
+	flushInterval := input.FlushInterval
+	if flushInterval <= 0 {
+		flushInterval = defaultStatsDFlushInterval
+	}
+
+	m.wg.Add(1)
+	go m.flushEvery(flushInterval)
+	return m, m.close, nil
+}
+
+func (m *statsdMetrics) flushEvery(interval time.Duration) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			m.flush()
+			m.mu.Unlock()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *statsdMetrics) close(_ context.Context) error {
+	close(m.done)
+	m.wg.Wait()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush()
+	return m.conn.Close()
+}
+
+// flush sends the buffered metrics as a single packet. The caller must hold mu.
+func (m *statsdMetrics) flush() {
+	if m.buffer.Len() == 0 {
+		return
+	}
+	if _, err := m.conn.Write(m.buffer.Bytes()); err != nil {
+		m.log.With("error", err).Warn("failed to send metrics to statsd server")
+	}
+	m.buffer.Reset()
+}
+
+type statsdTag struct {
+	key   string
+	value string
+}
+
+func instanceTags(labels InstanceLabels) []statsdTag {
+	values := labels.values()
+	tags := make([]statsdTag, len(instanceLabelNames))
+	for i, name := range instanceLabelNames {
+		tags[i] = statsdTag{key: name, value: values[i]}
+	}
+	return tags
+}
+
+// statsdReplacer strips the characters delimiting names, values, types and tags in the wire format
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_")
+
+// format encodes a single metric as a line of the configured flavor
+func (m *statsdMetrics) format(name, value, metricType string, tags []statsdTag) string {
+	var b strings.Builder
+	if len(m.prefix) > 0 {
+		b.WriteString(m.prefix)
+		b.WriteByte('.')
+	}
+	b.WriteString(name)
+	if !m.dogstatsd {
+		for _, t := range tags {
+			b.WriteByte('.')
+			if len(t.value) == 0 {
+				b.WriteString("none")
+				continue
+			}
+			b.WriteString(strings.ReplaceAll(statsdReplacer.Replace(t.value), ".", "_"))
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(metricType)
+	if m.dogstatsd && len(tags) > 0 {
+		b.WriteString("|#")
+		for i, t := range tags {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(t.key)
+			b.WriteByte(':')
+			b.WriteString(statsdReplacer.Replace(t.value))
+		}
+	}
+	return b.String()
+}
+
+// emit buffers a metric, flushing first if it would not fit in the current packet
+func (m *statsdMetrics) emit(name, value, metricType string, tags ...statsdTag) {
+	line := m.format(name, value, metricType, tags)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.buffer.Len() > 0 && m.buffer.Len()+1+len(line) > m.maxPacketSize {
+		m.flush()
+	}
+	if m.buffer.Len() > 0 {
+		m.buffer.WriteByte('\n')
+	}
+	m.buffer.WriteString(line)
+}
+
+func (m *statsdMetrics) increment(name string, tags ...statsdTag) {
+	m.emit(name, "1", "c", tags...)
+}
+
+func (m *statsdMetrics) timing(name string, d time.Duration, tags ...statsdTag) {
+	m.emit(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags...)
+}
+
+func (m *statsdMetrics) IncreaseInterruptionEventCounter(labels InstanceLabels) {
+	m.increment("interruption_events", instanceTags(labels)...)
+}
+
+func (m *statsdMetrics) IncreaseNodeTerminationCounter(labels InstanceLabels, cause string) {
+	m.increment("node_terminations", append(instanceTags(labels), statsdTag{key: "cause", value: cause})...)
+}
+
+func (m *statsdMetrics) ObserveStoppedDuration(labels InstanceLabels, d time.Duration) {
+	m.timing("stopped_instance_duration", d, instanceTags(labels)...)
+}
+
+func (m *statsdMetrics) IncreaseMessagesReceivedCounter(subscription string) {
+	m.increment("pubsub_messages_received", statsdTag{key: "subscription", value: subscription})
+}
+
+func (m *statsdMetrics) IncreaseParseFailureCounter(handler, reason string) {
+	m.increment("message_parse_failures", statsdTag{key: "handler", value: handler}, statsdTag{key: "reason", value: reason})
+}
+
+func (m *statsdMetrics) IncreaseUnknownInstanceCounter(handler string) {
+	m.increment("unknown_instance_lookups", statsdTag{key: "handler", value: handler})
+}
+
+func (m *statsdMetrics) IncreaseDuplicateMessageCounter(handler, strategy string) {
+	m.increment("duplicate_messages_suppressed", statsdTag{key: "handler", value: handler}, statsdTag{key: "strategy", value: strategy})
+}
+
+func (m *statsdMetrics) IncreaseCacheLookupCounter(cache, result string) {
+	m.increment("cache_lookups", statsdTag{key: "cache", value: cache}, statsdTag{key: "result", value: result})
+}
+
+func (m *statsdMetrics) IncreaseCacheEvictionCounter(cache, reason string) {
+	m.increment("cache_evictions", statsdTag{key: "cache", value: cache}, statsdTag{key: "reason", value: reason})
+}
+
+func (m *statsdMetrics) IncreaseDroppedUpdateCounter(backend string) {
+	m.increment("metrics_updates_dropped", statsdTag{key: "backend", value: backend})
+}
+
+func (m *statsdMetrics) SetInstanceMappingSize(size int) {
+	m.emit("instance_mapping_size", strconv.Itoa(size), "g")
+}
+
+func (m *statsdMetrics) IncreaseStageEventCounter(pipeline, stage, result string) {
+	m.increment("pipeline_stage_events", statsdTag{key: "pipeline", value: pipeline}, statsdTag{key: "stage", value: stage}, statsdTag{key: "result", value: result})
+}
+
+func (m *statsdMetrics) ObserveHandlerDuration(handler string, d time.Duration) {
+	m.timing("handler_processing_duration", d, statsdTag{key: "handler", value: handler})
+}
+
+func (m *statsdMetrics) SetPreemptionStormActive(scope, key string, active bool) {
+	value := "0"
+	if active {
+		value = "1"
+	}
+	m.emit("spot_preemption_storm_active", value, "g", statsdTag{key: "scope", value: scope}, statsdTag{key: "key", value: key})
+}
+
+type statsdMetrics struct {
+	log           *zap.SugaredLogger
+	conn          net.Conn
+	prefix        string
+	dogstatsd     bool
+	maxPacketSize int
+
+	mu     sync.Mutex
+	buffer bytes.Buffer
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
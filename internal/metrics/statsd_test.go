@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// readPackets returns every packet received by conn until none arrive for a short while
+func readPackets(conn net.PacketConn) []string {
+	var packets []string
+	buf := make([]byte, 65535)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+}
+
+func (suite *MetricsTestSuite) newStatsDListener() net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	return conn
+}
+
+func (suite *MetricsTestSuite) TestDogStatsDClientWireFormat() {
+	listener := suite.newStatsDListener()
+	defer listener.Close()
+
+	m, closeClient, err := NewStatsDClient(StatsDInput{
+		Logger:        suite.l,
+		Address:       listener.LocalAddr().String(),
+		Prefix:        "spot",
+		Flavor:        StatsDFlavorDogStatsD,
+		FlushInterval: time.Hour,
+	})
+	suite.Require().NoError(err)
+
+	labels := InstanceLabels{
+		Project:      "mock-project",
+		WorkloadType: "dataproc",
+		WorkloadName: "etl",
+	}
+	m.IncreaseInterruptionEventCounter(labels)
+	m.IncreaseNodeTerminationCounter(labels, "preempted")
+	m.ObserveStoppedDuration(labels, time.Minute)
+	m.SetInstanceMappingSize(42)
+	m.ObserveHandlerDuration("interruption", time.Microsecond*250)
+	m.SetPreemptionStormActive("zone", "europe-west1-c", false)
+	// closing flushes the buffer, regardless of the flush interval
+	suite.Require().NoError(closeClient(context.Background()))
+
+	packets := readPackets(listener)
+	suite.Require().Len(packets, 1)
+	suite.Equal([]string{
+		"spot.interruption_events:1|c|#target_kubernetes_cluster:,project:mock-project,workload_type:dataproc,workload_name:etl",
+		"spot.node_terminations:1|c|#target_kubernetes_cluster:,project:mock-project,workload_type:dataproc,workload_name:etl,cause:preempted",
+		"spot.stopped_instance_duration:60000|ms|#target_kubernetes_cluster:,project:mock-project,workload_type:dataproc,workload_name:etl",
+		"spot.instance_mapping_size:42|g",
+		"spot.handler_processing_duration:0.25|ms|#handler:interruption",
+		"spot.spot_preemption_storm_active:0|g|#scope:zone,key:europe-west1-c",
+	}, strings.Split(packets[0], "\n"))
+}
+
+func (suite *MetricsTestSuite) TestStatsDClientWireFormat() {
+	listener := suite.newStatsDListener()
+	defer listener.Close()
+
+	m, closeClient, err := NewStatsDClient(StatsDInput{
+		Logger:        suite.l,
+		Address:       listener.LocalAddr().String(),
+		Flavor:        StatsDFlavorStatsD,
+		FlushInterval: time.Hour,
+	})
+	suite.Require().NoError(err)
+
+	m.IncreaseInterruptionEventCounter(InstanceLabels{
+		KubernetesCluster: "fake-cluster",
+		Project:           "mock.project",
+		WorkloadType:      "kubernetes",
+		WorkloadName:      "fake-cluster",
+	})
+	m.IncreaseParseFailureCounter("creation", "missing_field")
+	suite.Require().NoError(closeClient(context.Background()))
+
+	suite.Equal([]string{
+		"interruption_events.fake-cluster.mock_project.kubernetes.fake-cluster:1|c\n" +
+			"message_parse_failures.creation.missing_field:1|c",
+	}, readPackets(listener))
+}
+
+func (suite *MetricsTestSuite) TestStatsDClientSplitsPackets() {
+	listener := suite.newStatsDListener()
+	defer listener.Close()
+
+	m, closeClient, err := NewStatsDClient(StatsDInput{
+		Logger:        suite.l,
+		Address:       listener.LocalAddr().String(),
+		FlushInterval: time.Hour,
+		MaxPacketSize: 110,
+	})
+	suite.Require().NoError(err)
+
+	for i := 0; i < 3; i++ {
+		m.IncreaseUnknownInstanceCounter("interruption")
+	}
+	suite.Require().NoError(closeClient(context.Background()))
+
+	packets := readPackets(listener)
+	suite.Len(packets, 2)
+	for _, p := range packets {
+		suite.LessOrEqual(len(p), 110)
+	}
+}
+
+func (suite *MetricsTestSuite) TestStatsDClientFlushesPeriodically() {
+	listener := suite.newStatsDListener()
+	defer listener.Close()
+
+	m, closeClient, err := NewStatsDClient(StatsDInput{
+		Logger:        suite.l,
+		Address:       listener.LocalAddr().String(),
+		FlushInterval: time.Millisecond * 10,
+	})
+	suite.Require().NoError(err)
+	defer closeClient(context.Background())
+
+	m.IncreaseDuplicateMessageCounter("interruption", "message_id")
+	suite.Equal([]string{"duplicate_messages_suppressed:1|c|#handler:interruption,strategy:message_id"}, readPackets(listener))
+}
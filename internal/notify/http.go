@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBackoff     = time.Second
+)
+
+// RetryPolicy defines how failed deliveries are retried
+type RetryPolicy struct {
+	// MaxAttempts is how many times a delivery is attempted in total, defaulting to 3
+	MaxAttempts int
+	// Backoff is how long to wait after the first failed attempt, doubling after each further one, defaulting to one second
+	Backoff time.Duration
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = defaultBackoff
+	}
+	return p
+}
+
+// statusError is returned when a request is answered with an unexpected status code
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.code, e.body)
+}
+
+// retryable returns whether a request answered with this error may succeed if retried
+func (e *statusError) retryable() bool {
+	return e.code == http.StatusTooManyRequests || e.code >= http.StatusInternalServerError
+}
+
+// postJSON posts body as JSON to url with headers, retrying according to policy on network errors, rate limiting and server errors
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte, headers map[string]string, policy RetryPolicy) error {
+	policy = policy.withDefaults()
+	backoff := policy.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = post(ctx, client, url, body, headers)
+		if err == nil {
+			return nil
+		}
+		if se, ok := err.(*statusError); ok && !se.retryable() {
+			return err
+		}
+		if attempt >= policy.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w, last error: %s", ctx.Err(), err.Error())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func post(ctx context.Context, client *http.Client, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{code: resp.StatusCode, body: string(respBody)}
+	}
+	return nil
+}
+
+func marshal(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,334 @@
+// Package notify delivers resolved interruptions to external sinks such as webhooks, Slack and PagerDuty
+//
+//go:generate mockery --name Notifier
+package notify
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Interruption is the termination of an instance, resolved to the workload it belonged to
+type Interruption struct {
+	ResourceID    string    `json:"resource_id"`
+	Project       string    `json:"project"`
+	Zone          string    `json:"zone"`
+	Instance      string    `json:"instance"`
+	Cluster       string    `json:"kubernetes_cluster,omitempty"`
+	NodePool      string    `json:"node_pool,omitempty"`
+	MachineType   string    `json:"machine_type,omitempty"`
+	MachineFamily string    `json:"machine_family,omitempty"`
+	WorkloadType  string    `json:"workload_type"`
+	WorkloadName  string    `json:"workload_name"`
+	Cause         string    `json:"cause"`
+	Timestamp     time.Time `json:"timestamp"`
+	// MessageID is the ID of the pubsub message the interruption was read from
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// StormScope is what preemptions are grouped by when detecting storms
+type StormScope string
+
+const (
+	StormScopeZone          StormScope = "zone"
+	StormScopeMachineFamily StormScope = "machine_family"
+	StormScopeCluster       StormScope = "cluster"
+)
+
+// StormState is whether a Storm started or ended
+type StormState string
+
+const (
+	StormStarted StormState = "started"
+	StormEnded   StormState = "ended"
+)
+
+// Storm is the start or end of a burst of preemptions in a scope, e.g. a zone
+type Storm struct {
+	// Key is the zone, machine family or cluster the storm is in
+	Scope StormScope `json:"scope"`
+	Key   string     `json:"key"`
+	State StormState `json:"state"`
+	// Preemptions is how many preemptions occurred within Window, more than Threshold when a storm starts
+	Preemptions int       `json:"preemptions"`
+	Threshold   int       `json:"threshold"`
+	Window      string    `json:"window"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// Notification is either one or more interruptions or a storm, delivered to a sink together
+type Notification struct {
+	Interruptions []Interruption `json:"interruptions,omitempty"`
+	Storm         *Storm         `json:"storm,omitempty"`
+}
+
+// Sink delivers notifications to an external system
+type Sink interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+// Notifier routes interruptions to sinks
+type Notifier interface {
+	// Notify queues i for delivery to the sinks of every matching route, without blocking on delivery
+	Notify(i Interruption)
+	// NotifyStorm queues s for delivery to the sinks of every matching route receiving storms, without blocking on delivery
+	NotifyStorm(s Storm)
+}
+
+// Match selects interruptions by glob patterns, as understood by path.Match. An interruption matches if, for every
+// non-empty list, one of its patterns matches. An empty Match matches every interruption.
+type Match struct {
+	Clusters        []string
+	Zones           []string
+	NodePools       []string
+	MachineFamilies []string
+	Causes          []string
+}
+
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns whether i is selected by m
+func (m Match) Matches(i Interruption) bool {
+	return matchesAny(m.Clusters, i.Cluster) &&
+		matchesAny(m.Zones, i.Zone) &&
+		matchesAny(m.NodePools, i.NodePool) &&
+		matchesAny(m.MachineFamilies, i.MachineFamily) &&
+		matchesAny(m.Causes, i.Cause)
+}
+
+// MatchesStorm returns whether s is selected by m. A Match without zone, machine family or cluster patterns matches every storm,
+// otherwise one of the patterns of the storm's scope must match.
+func (m Match) MatchesStorm(s Storm) bool {
+	if len(m.Zones) == 0 && len(m.MachineFamilies) == 0 && len(m.Clusters) == 0 {
+		return true
+	}
+	var patterns []string
+	switch s.Scope {
+	case StormScopeZone:
+		patterns = m.Zones
+	case StormScopeMachineFamily:
+		patterns = m.MachineFamilies
+	case StormScopeCluster:
+		patterns = m.Clusters
+	}
+	return len(patterns) > 0 && matchesAny(patterns, s.Key)
+}
+
+// DefaultCauses are the causes routes match unless they set their own, as preemptions are what spot instances are watched
+// for and other terminations would otherwise page as often. Routes match every cause with a pattern of *.
+var DefaultCauses = []string{"preempted"}
+
+// Route delivers interruptions it matches to its sinks
+type Route struct {
+	// Match matches DefaultCauses unless it sets causes
+	Match Match
+	// Sinks are names of sinks passed to NewNotifier
+	Sinks []string
+	// AggregationWindow, if set, delivers every interruption matched within the window of the first as a single notification
+	AggregationWindow time.Duration
+	// Storms delivers the start and end of storms the route matches, rather than interruptions
+	Storms bool
+}
+
+// NewNotifierInput defines all required fields to create a Notifier
+type NewNotifierInput struct {
+	Logger *zap.SugaredLogger
+	// Sinks maps names to sinks
+	Sinks  map[string]Sink
+	Routes []Route
+	// SendTimeout bounds how long a single delivery to a sink may take, including retries, defaulting to one minute
+	SendTimeout time.Duration
+	// QueueSize is how many notifications wait to be delivered to each sink before further ones are dropped, defaulting to 100
+	QueueSize int
+}
+
+// defaultSinkQueueSize is how many notifications wait to be delivered to a sink unless NewNotifierInput sets its own
+const defaultSinkQueueSize = 100
+
+// sinkQueue delivers the notifications queued for a sink one at a time, so that a slow sink holds up neither the others nor
+// the caller, and does not accumulate goroutines
+type sinkQueue struct {
+	name          string
+	sink          Sink
+	notifications chan Notification
+}
+
+type route struct {
+	Route
+	mu      sync.Mutex
+	pending []Interruption
+}
+
+type notifier struct {
+	log         *zap.SugaredLogger
+	queues      map[string]*sinkQueue
+	routes      []*route
+	sendTimeout time.Duration
+
+	// mu guards closed, so no notifications are queued once the queues are closed
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewNotifier creates a Notifier delivering to sinks according to routes.
+// The returned function delivers any aggregated interruptions and waits for queued notifications to be delivered.
+func NewNotifier(input NewNotifierInput) (Notifier, func(context.Context) error, error) {
+	n := &notifier{
+		log:         input.Logger,
+		queues:      make(map[string]*sinkQueue, len(input.Sinks)),
+		sendTimeout: input.SendTimeout,
+	}
+	if n.sendTimeout <= 0 {
+		n.sendTimeout = time.Minute
+	}
+	queueSize := input.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultSinkQueueSize
+	}
+	for _, r := range input.Routes {
+		for _, name := range r.Sinks {
+			if _, ok := input.Sinks[name]; !ok {
+				return nil, nil, fmt.Errorf("route refers to unknown sink %s", name)
+			}
+		}
+		if len(r.Match.Causes) == 0 {
+			r.Match.Causes = DefaultCauses
+		}
+		n.routes = append(n.routes, &route{Route: r})
+	}
+	for name, sink := range input.Sinks {
+		q := &sinkQueue{name: name, sink: sink, notifications: make(chan Notification, queueSize)}
+		n.queues[name] = q
+		n.wg.Add(1)
+		go n.run(q)
+	}
+	return n, n.close, nil
+}
+
+// run delivers the notifications queued for q until it is closed
+func (n *notifier) run(q *sinkQueue) {
+	defer n.wg.Done()
+	for notification := range q.notifications {
+		ctx, cancel := context.WithTimeout(context.Background(), n.sendTimeout)
+		if err := q.sink.Send(ctx, notification); err != nil {
+			n.log.With("sink", q.name, "interruptions", len(notification.Interruptions), "storm", notification.Storm != nil, "error", err).Error("failed to deliver notification")
+		}
+		cancel()
+	}
+}
+
+func (n *notifier) Notify(i Interruption) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	for _, r := range n.routes {
+		if r.Storms || !r.Match.Matches(i) {
+			continue
+		}
+		if r.AggregationWindow <= 0 {
+			n.deliver(r, Notification{Interruptions: []Interruption{i}})
+			continue
+		}
+		r.mu.Lock()
+		r.pending = append(r.pending, i)
+		// the first interruption of a burst opens the window
+		if len(r.pending) == 1 {
+			time.AfterFunc(r.AggregationWindow, func() { n.flush(r) })
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (n *notifier) NotifyStorm(s Storm) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	for _, r := range n.routes {
+		// storms are rare, and already the aggregate of many interruptions, so are delivered straight away
+		if r.Storms && r.Match.MatchesStorm(s) {
+			n.deliver(r, Notification{Storm: &s})
+		}
+	}
+}
+
+// flush delivers the interruptions aggregated by r
+func (n *notifier) flush(r *route) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	// interruptions aggregated when closing were delivered by close
+	if n.closed {
+		return
+	}
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+	if len(pending) > 0 {
+		n.deliver(r, Notification{Interruptions: pending})
+	}
+}
+
+// deliver queues notification for every sink of r, dropping it for sinks whose queue is full rather than blocking.
+// The caller must hold a read lock of mu.
+func (n *notifier) deliver(r *route, notification Notification) {
+	for _, name := range r.Sinks {
+		select {
+		case n.queues[name].notifications <- notification:
+		default:
+			n.log.With("sink", name, "interruptions", len(notification.Interruptions), "storm", notification.Storm != nil).Warn("notification queue is full, dropping notification")
+		}
+	}
+}
+
+func (n *notifier) close(ctx context.Context) error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	// deliver aggregated interruptions now rather than when their window closes
+	for _, r := range n.routes {
+		pending := r.pending
+		r.pending = nil
+		if len(pending) > 0 {
+			n.deliver(r, Notification{Interruptions: pending})
+		}
+	}
+	for _, q := range n.queues {
+		close(q.notifications)
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to deliver notifications: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,222 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type NotifyTestSuite struct {
+	suite.Suite
+	l *zap.SugaredLogger
+}
+
+func TestNotifyTestSuite(t *testing.T) {
+	suite.Run(t, new(NotifyTestSuite))
+}
+
+func (suite *NotifyTestSuite) SetupSuite() {
+	l, err := zap.NewDevelopment()
+	suite.NoError(err)
+	suite.l = l.Sugar()
+}
+
+// recordingSink records every notification sent to it, optionally blocking on block before each
+type recordingSink struct {
+	mu            sync.Mutex
+	notifications []Notification
+	err           error
+	block         chan struct{}
+}
+
+func (s *recordingSink) Send(_ context.Context, n Notification) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifications = append(s.notifications, n)
+	return s.err
+}
+
+func (s *recordingSink) received() []Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.notifications
+}
+
+func interruption(cluster, zone, nodePool, instance string) Interruption {
+	return Interruption{
+		ResourceID:   "projects/mock-project/zones/" + zone + "/instances/" + instance,
+		Project:      "mock-project",
+		Zone:         zone,
+		Instance:     instance,
+		Cluster:      cluster,
+		NodePool:     nodePool,
+		WorkloadType: "kubernetes",
+		WorkloadName: cluster,
+		Cause:        "preempted",
+		Timestamp:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func (suite *NotifyTestSuite) TestMatch() {
+	i := interruption("prod-cluster", "europe-west1-c", "spot-pool", "node-1")
+	tests := []struct {
+		name  string
+		match Match
+		want  bool
+	}{
+		{name: "empty", match: Match{}, want: true},
+		{name: "cluster", match: Match{Clusters: []string{"staging-cluster", "prod-cluster"}}, want: true},
+		{name: "zone glob", match: Match{Zones: []string{"europe-west1-*"}}, want: true},
+		{name: "zone mismatch", match: Match{Zones: []string{"us-*"}}, want: false},
+		{name: "every field", match: Match{Clusters: []string{"prod-*"}, Zones: []string{"europe-*"}, NodePools: []string{"spot-pool"}, Causes: []string{"preempted"}}, want: true},
+		{name: "node pool mismatch", match: Match{Clusters: []string{"prod-*"}, NodePools: []string{"default-pool"}}, want: false},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			suite.Equal(tt.want, tt.match.Matches(i))
+		})
+	}
+}
+
+func (suite *NotifyTestSuite) TestNotifierRoutes() {
+	all, europe, failing := &recordingSink{}, &recordingSink{}, &recordingSink{err: errors.New("unavailable")}
+	n, closeNotifier, err := NewNotifier(NewNotifierInput{
+		Logger: suite.l,
+		Sinks:  map[string]Sink{"all": all, "europe": europe, "failing": failing},
+		Routes: []Route{
+			{Sinks: []string{"all", "failing"}},
+			{Match: Match{Zones: []string{"europe-*"}}, Sinks: []string{"europe"}},
+		},
+	})
+	suite.Require().NoError(err)
+
+	n.Notify(interruption("prod-cluster", "europe-west1-c", "spot-pool", "node-1"))
+	n.Notify(interruption("prod-cluster", "us-central1-a", "spot-pool", "node-2"))
+	suite.Require().NoError(closeNotifier(context.Background()))
+
+	suite.Len(all.received(), 2)
+	suite.Len(failing.received(), 2)
+	suite.Require().Len(europe.received(), 1)
+	suite.Equal("node-1", europe.received()[0].Interruptions[0].Instance)
+
+	// interruptions after closing are dropped
+	n.Notify(interruption("prod-cluster", "europe-west1-c", "spot-pool", "node-3"))
+	suite.Len(all.received(), 2)
+}
+
+func (suite *NotifyTestSuite) TestNotifierDefaultCauses() {
+	preemptions, every := &recordingSink{}, &recordingSink{}
+	n, closeNotifier, err := NewNotifier(NewNotifierInput{
+		Logger: suite.l,
+		Sinks:  map[string]Sink{"preemptions": preemptions, "every": every},
+		Routes: []Route{
+			{Sinks: []string{"preemptions"}},
+			{Match: Match{Causes: []string{"*"}}, Sinks: []string{"every"}},
+		},
+	})
+	suite.Require().NoError(err)
+
+	hostError := interruption("prod-cluster", "europe-west1-c", "spot-pool", "node-1")
+	hostError.Cause = "host_error"
+	n.Notify(hostError)
+	n.Notify(interruption("prod-cluster", "europe-west1-c", "spot-pool", "node-2"))
+	suite.Require().NoError(closeNotifier(context.Background()))
+
+	// routes without causes only match preemptions
+	suite.Require().Len(preemptions.received(), 1)
+	suite.Equal("node-2", preemptions.received()[0].Interruptions[0].Instance)
+	suite.Len(every.received(), 2)
+}
+
+func (suite *NotifyTestSuite) TestNotifierAggregates() {
+	sink := &recordingSink{}
+	n, closeNotifier, err := NewNotifier(NewNotifierInput{
+		Logger: suite.l,
+		Sinks:  map[string]Sink{"sink": sink},
+		Routes: []Route{{Sinks: []string{"sink"}, AggregationWindow: 50 * time.Millisecond}},
+	})
+	suite.Require().NoError(err)
+
+	n.Notify(interruption("prod-cluster", "europe-west1-c", "spot-pool", "node-1"))
+	n.Notify(interruption("prod-cluster", "europe-west1-c", "spot-pool", "node-2"))
+	suite.Eventually(func() bool { return len(sink.received()) == 1 }, time.Second, 10*time.Millisecond)
+	suite.Len(sink.received()[0].Interruptions, 2)
+
+	// interruptions aggregated when closing are delivered straight away
+	n.Notify(interruption("prod-cluster", "europe-west1-c", "spot-pool", "node-3"))
+	suite.Require().NoError(closeNotifier(context.Background()))
+	suite.Require().Len(sink.received(), 2)
+	suite.Equal("node-3", sink.received()[1].Interruptions[0].Instance)
+}
+
+func (suite *NotifyTestSuite) TestNotifierQueuesPerSink() {
+	slow, fast := &recordingSink{block: make(chan struct{})}, &recordingSink{}
+	n, closeNotifier, err := NewNotifier(NewNotifierInput{
+		Logger:    suite.l,
+		Sinks:     map[string]Sink{"slow": slow, "fast": fast},
+		Routes:    []Route{{Sinks: []string{"slow", "fast"}}},
+		QueueSize: 2,
+	})
+	suite.Require().NoError(err)
+
+	for _, instance := range []string{"node-1", "node-2", "node-3", "node-4", "node-5"} {
+		n.Notify(interruption("prod-cluster", "europe-west1-c", "spot-pool", instance))
+		// let the fast sink keep up with its queue of two
+		suite.Eventually(func() bool {
+			return len(fast.received()) > 0 && fast.received()[len(fast.received())-1].Interruptions[0].Instance == instance
+		}, time.Second, time.Millisecond)
+	}
+	close(slow.block)
+	suite.Require().NoError(closeNotifier(context.Background()))
+
+	// the slow sink held up neither the caller nor the fast sink, and dropped what its queue had no room for
+	suite.Len(fast.received(), 5)
+	suite.Less(len(slow.received()), 5)
+	suite.GreaterOrEqual(len(slow.received()), 2)
+}
+
+func (suite *NotifyTestSuite) TestNewNotifierUnknownSink() {
+	_, _, err := NewNotifier(NewNotifierInput{
+		Logger: suite.l,
+		Sinks:  map[string]Sink{},
+		Routes: []Route{{Sinks: []string{"missing"}}},
+	})
+	suite.Error(err)
+}
+
+func (suite *NotifyTestSuite) TestNotifierRoutesStorms() {
+	interruptions, zones, clusters := &recordingSink{}, &recordingSink{}, &recordingSink{}
+	n, closeNotifier, err := NewNotifier(NewNotifierInput{
+		Logger: suite.l,
+		Sinks:  map[string]Sink{"interruptions": interruptions, "zones": zones, "clusters": clusters},
+		Routes: []Route{
+			{Sinks: []string{"interruptions"}},
+			// storms only match the patterns of their own scope
+			{Match: Match{Zones: []string{"europe-*"}, Clusters: []string{"staging-*"}}, Sinks: []string{"zones"}, Storms: true},
+			{Match: Match{Clusters: []string{"prod-*"}}, Sinks: []string{"clusters"}, Storms: true},
+		},
+	})
+	suite.Require().NoError(err)
+
+	n.NotifyStorm(Storm{Scope: StormScopeZone, Key: "europe-west1-c", State: StormStarted})
+	n.NotifyStorm(Storm{Scope: StormScopeZone, Key: "us-central1-a", State: StormStarted})
+	n.NotifyStorm(Storm{Scope: StormScopeCluster, Key: "prod-cluster", State: StormEnded})
+	// routes receiving storms do not receive interruptions
+	n.Notify(interruption("prod-cluster", "europe-west1-c", "spot-pool", "node-1"))
+	suite.Require().NoError(closeNotifier(context.Background()))
+
+	suite.Require().Len(interruptions.received(), 1)
+	suite.Nil(interruptions.received()[0].Storm)
+	suite.Require().Len(zones.received(), 1)
+	suite.Equal("europe-west1-c", zones.received()[0].Storm.Key)
+	suite.Require().Len(clusters.received(), 1)
+	suite.Equal(StormEnded, clusters.received()[0].Storm.State)
+}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultPagerDutyURL is the PagerDuty Events API v2 endpoint
+	DefaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+	defaultPagerDutySeverity = "warning"
+	pagerDutySource          = "spot-interruption-exporter"
+)
+
+// PagerDutyInput defines all required fields to create a PagerDuty Sink
+type PagerDutyInput struct {
+	// RoutingKey is the integration key of the PagerDuty service to trigger alerts on
+	RoutingKey string
+	// Severity is one of critical, error, warning or info, defaulting to warning
+	Severity string
+	// URL defaults to DefaultPagerDutyURL
+	URL   string
+	Retry RetryPolicy
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type pagerDuty struct {
+	input PagerDutyInput
+}
+
+type pagerDutyPayload struct {
+	Summary       string       `json:"summary"`
+	Source        string       `json:"source"`
+	Severity      string       `json:"severity"`
+	Timestamp     string       `json:"timestamp,omitempty"`
+	Component     string       `json:"component,omitempty"`
+	Group         string       `json:"group,omitempty"`
+	Class         string       `json:"class,omitempty"`
+	CustomDetails Notification `json:"custom_details"`
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string `json:"routing_key"`
+	EventAction string `json:"event_action"`
+	// DedupKey groups triggers into a single alert, and identifies the alert a storm's end resolves
+	DedupKey string            `json:"dedup_key,omitempty"`
+	Payload  *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+// NewPagerDutySink creates a Sink triggering a PagerDuty alert for each Notification, and resolving the alert of a storm when it ends
+func NewPagerDutySink(input PagerDutyInput) Sink {
+	if input.Client == nil {
+		input.Client = http.DefaultClient
+	}
+	if len(input.URL) == 0 {
+		input.URL = DefaultPagerDutyURL
+	}
+	if len(input.Severity) == 0 {
+		input.Severity = defaultPagerDutySeverity
+	}
+	return &pagerDuty{input: input}
+}
+
+func (p *pagerDuty) Send(ctx context.Context, n Notification) error {
+	body, err := marshal(p.eventFor(n))
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, p.input.Client, p.input.URL, body, nil, p.input.Retry)
+}
+
+func (p *pagerDuty) eventFor(n Notification) pagerDutyEvent {
+	if n.Storm != nil {
+		event := pagerDutyEvent{
+			RoutingKey:  p.input.RoutingKey,
+			EventAction: "trigger",
+			DedupKey:    fmt.Sprintf("%s/storm/%s/%s", pagerDutySource, n.Storm.Scope, n.Storm.Key),
+		}
+		if n.Storm.State == StormEnded {
+			event.EventAction = "resolve"
+			return event
+		}
+		event.Payload = &pagerDutyPayload{
+			Summary:       summarise(n),
+			Source:        pagerDutySource,
+			Severity:      p.input.Severity,
+			Timestamp:     n.Storm.Timestamp.UTC().Format(time.RFC3339),
+			Class:         "spot_preemption_storm",
+			CustomDetails: n,
+		}
+		return event
+	}
+	event := pagerDutyEvent{
+		RoutingKey:  p.input.RoutingKey,
+		EventAction: "trigger",
+		Payload: &pagerDutyPayload{
+			Summary:       summarise(n),
+			Source:        pagerDutySource,
+			Severity:      p.input.Severity,
+			Class:         "spot_interruption",
+			CustomDetails: n,
+		},
+	}
+	if len(n.Interruptions) > 0 {
+		first := n.Interruptions[0]
+		event.DedupKey = interruptionDedupKey(first)
+		event.Payload.Timestamp = first.Timestamp.UTC().Format(time.RFC3339)
+		event.Payload.Component = first.Zone
+		event.Payload.Group = first.Cluster
+	}
+	return event
+}
+
+// interruptionDedupKey groups the interruptions of a node pool, or of a workload outside Kubernetes, into a single alert
+// while it is open, rather than each raising its own
+func interruptionDedupKey(i Interruption) string {
+	if len(i.Cluster) > 0 {
+		return fmt.Sprintf("%s/interruption/%s/%s/%s", pagerDutySource, i.Project, i.Cluster, i.NodePool)
+	}
+	return fmt.Sprintf("%s/interruption/%s/%s/%s", pagerDutySource, i.Project, i.WorkloadType, i.WorkloadName)
+}
@@ -0,0 +1,180 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// receivedRequest is a request received by fakeReceiver
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// fakeReceiver records every request it receives, answering the first failures with statuses and the rest with status
+type fakeReceiver struct {
+	mu       sync.Mutex
+	requests []receivedRequest
+	failures []int
+	status   int
+}
+
+func (f *fakeReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, receivedRequest{header: r.Header, body: body})
+	if len(f.failures) > 0 {
+		status := f.failures[0]
+		f.failures = f.failures[1:]
+		w.WriteHeader(status)
+		return
+	}
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+	}
+}
+
+func (f *fakeReceiver) received() []receivedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func (suite *NotifyTestSuite) serve(receiver *fakeReceiver) string {
+	server := httptest.NewServer(receiver)
+	suite.T().Cleanup(server.Close)
+	return server.URL
+}
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+func (suite *NotifyTestSuite) TestWebhookSignsAndRetries() {
+	receiver := &fakeReceiver{failures: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	sink := NewWebhookSink(WebhookInput{
+		URL:     suite.serve(receiver),
+		Secret:  "shared-secret",
+		Headers: map[string]string{"Authorization": "Bearer token"},
+		Retry:   fastRetry,
+	})
+	n := Notification{Interruptions: []Interruption{interruption("prod-cluster", "europe-west1-c", "spot-pool", "node-1")}}
+
+	suite.Require().NoError(sink.Send(context.Background(), n))
+	requests := receiver.received()
+	suite.Require().Len(requests, 3)
+	last := requests[2]
+	suite.Equal("application/json", last.header.Get("Content-Type"))
+	suite.Equal("Bearer token", last.header.Get("Authorization"))
+	suite.Equal(Sign("shared-secret", last.body), last.header.Get(SignatureHeader))
+	received := Notification{}
+	suite.Require().NoError(json.Unmarshal(last.body, &received))
+	suite.Equal(n, received)
+}
+
+func (suite *NotifyTestSuite) TestWebhookFailures() {
+	// client errors are not retried
+	receiver := &fakeReceiver{status: http.StatusBadRequest}
+	sink := NewWebhookSink(WebhookInput{URL: suite.serve(receiver), Retry: fastRetry})
+	suite.Error(sink.Send(context.Background(), Notification{}))
+	suite.Len(receiver.received(), 1)
+	suite.Empty(receiver.received()[0].header.Get(SignatureHeader))
+
+	receiver = &fakeReceiver{status: http.StatusInternalServerError}
+	sink = NewWebhookSink(WebhookInput{URL: suite.serve(receiver), Retry: fastRetry})
+	suite.Error(sink.Send(context.Background(), Notification{}))
+	suite.Len(receiver.received(), fastRetry.MaxAttempts)
+}
+
+func (suite *NotifyTestSuite) TestSlack() {
+	receiver := &fakeReceiver{}
+	sink := NewSlackSink(SlackInput{WebhookURL: suite.serve(receiver), Retry: fastRetry})
+	n := Notification{}
+	for i := 0; i < maxSlackInterruptions+2; i++ {
+		zone := "europe-west1-c"
+		if i%2 == 0 {
+			zone = "europe-west1-d"
+		}
+		n.Interruptions = append(n.Interruptions, interruption("prod-cluster", zone, "spot-pool", fmt.Sprintf("node-%d", i)))
+	}
+
+	suite.Require().NoError(sink.Send(context.Background(), n))
+	suite.Require().Len(receiver.received(), 1)
+	message := slackMessage{}
+	suite.Require().NoError(json.Unmarshal(receiver.received()[0].body, &message))
+	suite.Equal("12 instances were terminated across 2 zones", message.Text)
+	suite.Require().Len(message.Blocks, 2)
+	suite.Contains(message.Blocks[1].Text.Text, "mock-project/europe-west1-d/node-0 (kubernetes prod-cluster, node pool spot-pool): preempted at 12:00:00 UTC")
+	suite.Contains(message.Blocks[1].Text.Text, "…and 2 more")
+	suite.NotContains(message.Blocks[1].Text.Text, "node-10")
+}
+
+func (suite *NotifyTestSuite) TestPagerDuty() {
+	receiver := &fakeReceiver{status: http.StatusAccepted}
+	sink := NewPagerDutySink(PagerDutyInput{RoutingKey: "routing-key", URL: suite.serve(receiver), Retry: fastRetry})
+	n := Notification{Interruptions: []Interruption{interruption("prod-cluster", "europe-west1-c", "spot-pool", "node-1")}}
+
+	suite.Require().NoError(sink.Send(context.Background(), n))
+	suite.Require().Len(receiver.received(), 1)
+	event := pagerDutyEvent{}
+	suite.Require().NoError(json.Unmarshal(receiver.received()[0].body, &event))
+	suite.Equal("routing-key", event.RoutingKey)
+	suite.Equal("trigger", event.EventAction)
+	suite.Equal("warning", event.Payload.Severity)
+	suite.Equal("Instance node-1 in europe-west1-c was terminated (preempted)", event.Payload.Summary)
+	suite.Equal("2024-05-01T12:00:00Z", event.Payload.Timestamp)
+	suite.Equal("prod-cluster", event.Payload.Group)
+	suite.Equal(n, event.Payload.CustomDetails)
+	// interruptions of the same node pool are grouped into a single alert
+	suite.Equal("spot-interruption-exporter/interruption/mock-project/prod-cluster/spot-pool", event.DedupKey)
+	other := sink.(*pagerDuty).eventFor(Notification{Interruptions: []Interruption{interruption("prod-cluster", "europe-west1-d", "spot-pool", "node-2")}})
+	suite.Equal(event.DedupKey, other.DedupKey)
+	batch := interruption("", "europe-west1-c", "", "job-vm")
+	batch.WorkloadType, batch.WorkloadName = "batch", "job"
+	suite.Equal("spot-interruption-exporter/interruption/mock-project/batch/job", sink.(*pagerDuty).eventFor(Notification{Interruptions: []Interruption{batch}}).DedupKey)
+}
+
+func (suite *NotifyTestSuite) TestStormNotifications() {
+	storm := Storm{
+		Scope:       StormScopeZone,
+		Key:         "europe-west1-c",
+		State:       StormStarted,
+		Preemptions: 12,
+		Threshold:   10,
+		Window:      "5m0s",
+		Timestamp:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	slackReceiver := &fakeReceiver{}
+	slackSink := NewSlackSink(SlackInput{WebhookURL: suite.serve(slackReceiver), Retry: fastRetry})
+	suite.Require().NoError(slackSink.Send(context.Background(), Notification{Storm: &storm}))
+	message := slackMessage{}
+	suite.Require().NoError(json.Unmarshal(slackReceiver.received()[0].body, &message))
+	suite.Equal("Preemption storm started in zone europe-west1-c", message.Text)
+	suite.Equal("12 preemptions within 5m0s, against a threshold of 10, as of 12:00:00 UTC", message.Blocks[1].Text.Text)
+
+	pagerDutyReceiver := &fakeReceiver{status: http.StatusAccepted}
+	pagerDutySink := NewPagerDutySink(PagerDutyInput{RoutingKey: "routing-key", URL: suite.serve(pagerDutyReceiver), Retry: fastRetry})
+	suite.Require().NoError(pagerDutySink.Send(context.Background(), Notification{Storm: &storm}))
+	ended := storm
+	ended.State = StormEnded
+	suite.Require().NoError(pagerDutySink.Send(context.Background(), Notification{Storm: &ended}))
+
+	requests := pagerDutyReceiver.received()
+	suite.Require().Len(requests, 2)
+	triggered, resolved := pagerDutyEvent{}, pagerDutyEvent{}
+	suite.Require().NoError(json.Unmarshal(requests[0].body, &triggered))
+	suite.Require().NoError(json.Unmarshal(requests[1].body, &resolved))
+	suite.Equal("trigger", triggered.EventAction)
+	suite.Equal("spot_preemption_storm", triggered.Payload.Class)
+	suite.Equal("resolve", resolved.EventAction)
+	suite.Nil(resolved.Payload)
+	// the end of a storm resolves the alert its start triggered
+	suite.NotEmpty(triggered.DedupKey)
+	suite.Equal(triggered.DedupKey, resolved.DedupKey)
+}
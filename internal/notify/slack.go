@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxSlackInterruptions is how many interruptions of a notification are listed in a Slack message
+const maxSlackInterruptions = 10
+
+// SlackInput defines all required fields to create a Slack Sink
+type SlackInput struct {
+	// WebhookURL is the URL of a Slack incoming webhook
+	WebhookURL string
+	Retry      RetryPolicy
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type slack struct {
+	input SlackInput
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type string     `json:"type"`
+	Text *slackText `json:"text,omitempty"`
+}
+
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// NewSlackSink creates a Sink posting each Notification as a message to a Slack incoming webhook
+func NewSlackSink(input SlackInput) Sink {
+	if input.Client == nil {
+		input.Client = http.DefaultClient
+	}
+	return &slack{input: input}
+}
+
+func (s *slack) Send(ctx context.Context, n Notification) error {
+	body, err := marshal(slackMessageFor(n))
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.input.Client, s.input.WebhookURL, body, nil, s.input.Retry)
+}
+
+func slackMessageFor(n Notification) slackMessage {
+	summary := summarise(n)
+	if n.Storm != nil {
+		return slackMessage{
+			Text: summary,
+			Blocks: []slackBlock{
+				{Type: "header", Text: &slackText{Type: "plain_text", Text: summary}},
+				{Type: "section", Text: &slackText{Type: "mrkdwn", Text: describeStorm(*n.Storm)}},
+			},
+		}
+	}
+	var lines []string
+	for i, interruption := range n.Interruptions {
+		if i == maxSlackInterruptions {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(n.Interruptions)-maxSlackInterruptions))
+			break
+		}
+		lines = append(lines, "• "+describe(interruption))
+	}
+	return slackMessage{
+		// the top level text is shown in notifications, where blocks are not rendered
+		Text: summary,
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: summary}},
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: strings.Join(lines, "\n")}},
+		},
+	}
+}
+
+// summarise returns a one line description of n
+func summarise(n Notification) string {
+	if n.Storm != nil {
+		return fmt.Sprintf("Preemption storm %s in %s %s", n.Storm.State, n.Storm.Scope, n.Storm.Key)
+	}
+	if len(n.Interruptions) == 1 {
+		i := n.Interruptions[0]
+		return fmt.Sprintf("Instance %s in %s was terminated (%s)", i.Instance, i.Zone, i.Cause)
+	}
+	zones := map[string]struct{}{}
+	for _, i := range n.Interruptions {
+		zones[i.Zone] = struct{}{}
+	}
+	return fmt.Sprintf("%d instances were terminated across %d zones", len(n.Interruptions), len(zones))
+}
+
+// describe returns a one line description of i
+func describe(i Interruption) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s/%s/%s (%s %s", i.Project, i.Zone, i.Instance, i.WorkloadType, i.WorkloadName)
+	if len(i.NodePool) > 0 {
+		fmt.Fprintf(&b, ", node pool %s", i.NodePool)
+	}
+	fmt.Fprintf(&b, "): %s at %s", i.Cause, i.Timestamp.UTC().Format("15:04:05 MST"))
+	return b.String()
+}
+
+// describeStorm returns a one line description of the preemptions in s
+func describeStorm(s Storm) string {
+	return fmt.Sprintf("%d preemptions within %s, against a threshold of %d, as of %s", s.Preemptions, s.Window, s.Threshold, s.Timestamp.UTC().Format("15:04:05 MST"))
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of webhook bodies, as "sha256=<hex digest>", when a secret is configured
+const SignatureHeader = "X-Signature-256"
+
+// WebhookInput defines all required fields to create a webhook Sink
+type WebhookInput struct {
+	URL string
+	// Secret, if set, is the key the body of each request is signed with
+	Secret string
+	// Headers are added to each request, e.g. for authentication
+	Headers map[string]string
+	Retry   RetryPolicy
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type webhook struct {
+	input WebhookInput
+}
+
+// NewWebhookSink creates a Sink posting each Notification as JSON to a URL
+func NewWebhookSink(input WebhookInput) Sink {
+	if input.Client == nil {
+		input.Client = http.DefaultClient
+	}
+	return &webhook{input: input}
+}
+
+// Sign returns the value of SignatureHeader for body signed with secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *webhook) Send(ctx context.Context, n Notification) error {
+	body, err := marshal(n)
+	if err != nil {
+		return err
+	}
+	headers := make(map[string]string, len(w.input.Headers)+1)
+	for k, v := range w.input.Headers {
+		headers[k] = v
+	}
+	if len(w.input.Secret) > 0 {
+		headers[SignatureHeader] = Sign(w.input.Secret, body)
+	}
+	return postJSON(ctx, w.input.Client, w.input.URL, body, headers, w.input.Retry)
+}
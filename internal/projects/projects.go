@@ -0,0 +1,94 @@
+// Package projects discovers the GCP projects whose instances are tracked
+package projects
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
+	"cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// Client discovers projects via the Resource Manager API
+type Client interface {
+	// ListProjectsUnder returns the IDs of all active projects beneath parent, including those in nested folders.
+	// parent must be of the form folders/{folder_id} or organizations/{organization_id}
+	ListProjectsUnder(ctx context.Context, parent string) ([]string, error)
+}
+
+type client struct {
+	projectsClient *resourcemanager.ProjectsClient
+	foldersClient  *resourcemanager.FoldersClient
+	log            *zap.SugaredLogger
+}
+
+// NewClientInput defines all required fields to create a Client
+type NewClientInput struct {
+	Logger *zap.SugaredLogger
+	// Options are optional, and configure the Resource Manager clients, e.g. to use another endpoint
+	Options []option.ClientOption
+}
+
+func (c *client) ListProjectsUnder(ctx context.Context, parent string) ([]string, error) {
+	var projectIDs []string
+	projectIter := c.projectsClient.ListProjects(ctx, &resourcemanagerpb.ListProjectsRequest{
+		Parent: parent,
+	})
+	for {
+		project, err := projectIter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over projects in %s: %w", parent, err)
+		}
+		if project.GetState() != resourcemanagerpb.Project_ACTIVE {
+			continue
+		}
+		projectIDs = append(projectIDs, project.GetProjectId())
+	}
+
+	folderIter := c.foldersClient.ListFolders(ctx, &resourcemanagerpb.ListFoldersRequest{
+		Parent: parent,
+	})
+	for {
+		folder, err := folderIter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over folders in %s: %w", parent, err)
+		}
+		if folder.GetState() != resourcemanagerpb.Folder_ACTIVE {
+			continue
+		}
+		nested, err := c.ListProjectsUnder(ctx, folder.GetName())
+		if err != nil {
+			return nil, err
+		}
+		projectIDs = append(projectIDs, nested...)
+	}
+	c.log.Debugf("discovered %d projects under %s", len(projectIDs), parent)
+	return projectIDs, nil
+}
+
+// NewClient creates a Client that discovers projects via the Resource Manager API
+func NewClient(ctx context.Context, input NewClientInput) (Client, error) {
+	p, err := resourcemanager.NewProjectsClient(ctx, input.Options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create projects client: %w", err)
+	}
+	f, err := resourcemanager.NewFoldersClient(ctx, input.Options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create folders client: %w", err)
+	}
+	return &client{
+		projectsClient: p,
+		foldersClient:  f,
+		log:            input.Logger,
+	}, nil
+}
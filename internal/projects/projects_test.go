@@ -0,0 +1,137 @@
+package projects
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// fakeResourceManager holds the projects and folders directly beneath each parent, failing to list the projects of parents in errs
+type fakeResourceManager struct {
+	projects map[string][]*resourcemanagerpb.Project
+	folders  map[string][]*resourcemanagerpb.Folder
+	errs     map[string]error
+}
+
+// fakeProjects serves the projects of a fakeResourceManager
+type fakeProjects struct {
+	resourcemanagerpb.UnimplementedProjectsServer
+	*fakeResourceManager
+}
+
+func (f *fakeProjects) ListProjects(_ context.Context, req *resourcemanagerpb.ListProjectsRequest) (*resourcemanagerpb.ListProjectsResponse, error) {
+	if err := f.errs[req.GetParent()]; err != nil {
+		return nil, err
+	}
+	return &resourcemanagerpb.ListProjectsResponse{Projects: f.projects[req.GetParent()]}, nil
+}
+
+// fakeFolders serves the folders of a fakeResourceManager
+type fakeFolders struct {
+	resourcemanagerpb.UnimplementedFoldersServer
+	*fakeResourceManager
+}
+
+func (f *fakeFolders) ListFolders(_ context.Context, req *resourcemanagerpb.ListFoldersRequest) (*resourcemanagerpb.ListFoldersResponse, error) {
+	return &resourcemanagerpb.ListFoldersResponse{Folders: f.folders[req.GetParent()]}, nil
+}
+
+type ProjectsTestSuite struct {
+	suite.Suite
+	l *zap.SugaredLogger
+}
+
+func TestProjectsTestSuite(t *testing.T) {
+	suite.Run(t, new(ProjectsTestSuite))
+}
+
+func (suite *ProjectsTestSuite) SetupSuite() {
+	l, err := zap.NewDevelopment()
+	suite.NoError(err)
+	suite.l = l.Sugar()
+}
+
+// client creates a Client listing projects from fake, served over gRPC until the test ends
+func (suite *ProjectsTestSuite) client(fake *fakeResourceManager) Client {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	srv := grpc.NewServer()
+	resourcemanagerpb.RegisterProjectsServer(srv, &fakeProjects{fakeResourceManager: fake})
+	resourcemanagerpb.RegisterFoldersServer(srv, &fakeFolders{fakeResourceManager: fake})
+	go func() { _ = srv.Serve(lis) }()
+	suite.T().Cleanup(srv.Stop)
+
+	c, err := NewClient(context.Background(), NewClientInput{
+		Logger: suite.l,
+		Options: []option.ClientOption{
+			option.WithEndpoint(lis.Addr().String()),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		},
+	})
+	suite.Require().NoError(err)
+	return c
+}
+
+func project(id string, state resourcemanagerpb.Project_State) *resourcemanagerpb.Project {
+	return &resourcemanagerpb.Project{Name: "projects/" + id, ProjectId: id, State: state}
+}
+
+func folder(name string, state resourcemanagerpb.Folder_State) *resourcemanagerpb.Folder {
+	return &resourcemanagerpb.Folder{Name: name, State: state}
+}
+
+func (suite *ProjectsTestSuite) TestListProjectsUnder() {
+	c := suite.client(&fakeResourceManager{
+		projects: map[string][]*resourcemanagerpb.Project{
+			"organizations/1": {project("org-project", resourcemanagerpb.Project_ACTIVE)},
+			"folders/team": {
+				project("team-project", resourcemanagerpb.Project_ACTIVE),
+				project("deleted-project", resourcemanagerpb.Project_DELETE_REQUESTED),
+			},
+			"folders/team-nested": {project("nested-project", resourcemanagerpb.Project_ACTIVE)},
+			"folders/deleted":     {project("deleted-folder-project", resourcemanagerpb.Project_ACTIVE)},
+		},
+		folders: map[string][]*resourcemanagerpb.Folder{
+			"organizations/1": {
+				folder("folders/team", resourcemanagerpb.Folder_ACTIVE),
+				folder("folders/deleted", resourcemanagerpb.Folder_DELETE_REQUESTED),
+			},
+			"folders/team": {folder("folders/team-nested", resourcemanagerpb.Folder_ACTIVE)},
+		},
+	})
+
+	res, err := c.ListProjectsUnder(context.Background(), "organizations/1")
+	suite.NoError(err)
+	// projects in nested folders are included, and those that are not active, or are in folders that are not, are not
+	suite.ElementsMatch([]string{"org-project", "team-project", "nested-project"}, res)
+}
+
+func (suite *ProjectsTestSuite) TestListProjectsUnderFailure() {
+	c := suite.client(&fakeResourceManager{
+		folders: map[string][]*resourcemanagerpb.Folder{
+			"organizations/1": {folder("folders/restricted", resourcemanagerpb.Folder_ACTIVE)},
+		},
+		errs: map[string]error{
+			"folders/restricted": status.Error(codes.PermissionDenied, "permission denied"),
+		},
+	})
+
+	_, err := c.ListProjectsUnder(context.Background(), "organizations/1")
+	suite.ErrorContains(err, "failed to iterate over projects in folders/restricted")
+	// the API error is wrapped, so that callers can tell why listing failed
+	var apiErr *apierror.APIError
+	suite.Require().True(errors.As(err, &apiErr))
+	suite.Equal(codes.PermissionDenied, apiErr.GRPCStatus().Code())
+}
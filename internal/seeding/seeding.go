@@ -0,0 +1,135 @@
+// Package seeding retries listing the instances to track from the compute API on startup, backing off further when
+// quotas are exceeded, and reports its progress for readiness checks
+package seeding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	defaultAttempts       = 5
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+	defaultQuotaBackoff   = time.Minute
+)
+
+// quotaReasons are the reasons of the errors GCP APIs return when a quota or rate limit is exceeded
+var quotaReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"quotaExceeded":         true,
+	"RATE_LIMIT_EXCEEDED":   true,
+}
+
+// RetryInput defines how Retry backs off between attempts
+type RetryInput struct {
+	Logger *zap.SugaredLogger
+	// Attempts is how many times to attempt before giving up, defaulting to 5. Negative attempts retry until ctx is done.
+	Attempts int
+	// InitialBackoff is how long to wait after the first failure, doubling after each further failure up to MaxBackoff.
+	// They default to 1s and 1m.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// QuotaBackoff is the least time to wait after exceeding a quota, unless the error says how long to wait, defaulting to 1m
+	QuotaBackoff time.Duration
+	// Tracker is optional, and records every attempt
+	Tracker *Tracker
+}
+
+// Retry calls fn until it succeeds, it fails with an error that retrying cannot fix, e.g. an unknown project,
+// the attempts run out or ctx is done. It returns the last error fn returned, or that of ctx.
+func Retry(ctx context.Context, input RetryInput, fn func(ctx context.Context) error) error {
+	attempts := input.Attempts
+	if attempts == 0 {
+		attempts = defaultAttempts
+	}
+	backoff := durationOrDefault(input.InitialBackoff, defaultInitialBackoff)
+	maxBackoff := durationOrDefault(input.MaxBackoff, defaultMaxBackoff)
+	quotaBackoff := durationOrDefault(input.QuotaBackoff, defaultQuotaBackoff)
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if input.Tracker != nil {
+			input.Tracker.attempted(err)
+		}
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if !retryable(err) {
+			return fmt.Errorf("failed with an error that retrying cannot fix: %w", err)
+		}
+		if attempts > 0 && attempt >= attempts {
+			return fmt.Errorf("failed after %d attempts: %w", attempt, err)
+		}
+		// half of the backoff is random, so that replicas restarting together do not retry together
+		wait := backoff/2 + rand.N(backoff/2+1)
+		if delay, ok := quotaExceeded(err); ok {
+			wait = max(delay, quotaBackoff)
+		}
+		input.Logger.With("attempt", attempt, "backoff", wait, "error", err).Warn("retrying")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// quotaExceeded returns whether err is due to exceeding a quota or rate limit, and how long it asks to wait before retrying
+func quotaExceeded(err error) (time.Duration, bool) {
+	apiErr, ok := apierror.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	delay := apiErr.Details().RetryInfo.GetRetryDelay().AsDuration()
+	if apiErr.HTTPCode() == http.StatusTooManyRequests || apiErr.GRPCStatus().Code() == codes.ResourceExhausted || quotaReasons[apiErr.Reason()] {
+		return delay, true
+	}
+	// the compute API reports exceeded rate limits as 403s, with the reason in the errors of the response
+	var httpErr *googleapi.Error
+	if errors.As(err, &httpErr) {
+		for _, item := range httpErr.Errors {
+			if quotaReasons[item.Reason] {
+				return delay, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// retryable returns false for errors that retrying cannot fix, e.g. requests for unknown projects.
+// Permission errors are retried, as permissions granted alongside deploying the exporter can take minutes to apply.
+func retryable(err error) bool {
+	apiErr, ok := apierror.FromError(err)
+	if !ok {
+		return true
+	}
+	switch apiErr.HTTPCode() {
+	case http.StatusBadRequest, http.StatusNotFound:
+		return false
+	case -1:
+		code := apiErr.GRPCStatus().Code()
+		return code != codes.InvalidArgument && code != codes.NotFound
+	}
+	return true
+}
+
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,140 @@
+package seeding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
+)
+
+type SeedingTestSuite struct {
+	suite.Suite
+	l *zap.SugaredLogger
+}
+
+func TestSeedingTestSuite(t *testing.T) {
+	suite.Run(t, new(SeedingTestSuite))
+}
+
+func (suite *SeedingTestSuite) SetupSuite() {
+	l, err := zap.NewDevelopment()
+	suite.NoError(err)
+	suite.l = l.Sugar()
+}
+
+// failing returns a function failing with errs in turn, then succeeding, and how many times it was called
+func failing(errs ...error) (func(context.Context) error, *int) {
+	calls := 0
+	return func(context.Context) error {
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	}, &calls
+}
+
+func (suite *SeedingTestSuite) TestRetryTransientErrors() {
+	tracker := NewTracker(StatusSeeding)
+	fn, calls := failing(&googleapi.Error{Code: http.StatusServiceUnavailable}, errors.New("connection reset"))
+
+	suite.NoError(Retry(context.Background(), RetryInput{Logger: suite.l, InitialBackoff: time.Millisecond, Tracker: tracker}, fn))
+	suite.Equal(3, *calls)
+	suite.Equal(3, tracker.attempts)
+	suite.Empty(tracker.lastError)
+}
+
+func (suite *SeedingTestSuite) TestRetryAttempts() {
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	fn, calls := failing(unavailable, unavailable, unavailable)
+
+	err := Retry(context.Background(), RetryInput{Logger: suite.l, Attempts: 2, InitialBackoff: time.Millisecond}, fn)
+	suite.ErrorIs(err, unavailable)
+	suite.Equal(2, *calls)
+}
+
+func (suite *SeedingTestSuite) TestRetryPermanentError() {
+	notFound := fmt.Errorf("failed to list instances: %w", &googleapi.Error{Code: http.StatusNotFound})
+	fn, calls := failing(notFound)
+
+	err := Retry(context.Background(), RetryInput{Logger: suite.l, InitialBackoff: time.Millisecond}, fn)
+	suite.ErrorIs(err, notFound)
+	suite.Equal(1, *calls)
+}
+
+func (suite *SeedingTestSuite) TestRetryQuotaExceeded() {
+	fn, calls := failing(&googleapi.Error{Code: http.StatusTooManyRequests})
+
+	start := time.Now()
+	suite.NoError(Retry(context.Background(), RetryInput{Logger: suite.l, InitialBackoff: time.Millisecond, QuotaBackoff: time.Millisecond * 100}, fn))
+	suite.Equal(2, *calls)
+	suite.GreaterOrEqual(time.Since(start), time.Millisecond*100)
+}
+
+func (suite *SeedingTestSuite) TestRetryContextDone() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	fn, _ := failing(errors.New("unavailable"), errors.New("unavailable"))
+
+	err := Retry(ctx, RetryInput{Logger: suite.l, InitialBackoff: time.Hour}, fn)
+	suite.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func (suite *SeedingTestSuite) TestQuotaExceeded() {
+	for name, tc := range map[string]struct {
+		err   error
+		quota bool
+	}{
+		"too many requests": {err: &googleapi.Error{Code: http.StatusTooManyRequests}, quota: true},
+		"rate limit": {err: fmt.Errorf("wrapped: %w", &googleapi.Error{
+			Code:   http.StatusForbidden,
+			Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}},
+		}), quota: true},
+		"permission denied": {err: &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}},
+		"unavailable":       {err: &googleapi.Error{Code: http.StatusServiceUnavailable}},
+		"not an api error":  {err: errors.New("connection reset")},
+	} {
+		_, quota := quotaExceeded(tc.err)
+		suite.Equal(tc.quota, quota, name)
+		// permissions can take minutes to apply, so are retried along with quota and transient errors
+		suite.True(retryable(tc.err), name)
+	}
+	suite.False(retryable(&googleapi.Error{Code: http.StatusBadRequest}))
+}
+
+func (suite *SeedingTestSuite) TestTrackerServeHTTP() {
+	tracker := NewTracker(StatusStandby)
+	suite.True(tracker.Ready())
+
+	tracker.SetStatus(StatusDegraded)
+	tracker.attempted(errors.New("quota exceeded"))
+	res := suite.serve(tracker)
+	suite.Equal(http.StatusServiceUnavailable, res.Code)
+	var status statusResponse
+	suite.Require().NoError(json.NewDecoder(res.Body).Decode(&status))
+	suite.Equal(statusResponse{Status: StatusDegraded, Attempts: 1, LastError: "quota exceeded"}, status)
+
+	tracker.attempted(nil)
+	tracker.SetStatus(StatusSeeded)
+	res = suite.serve(tracker)
+	suite.Equal(http.StatusOK, res.Code)
+	var seeded statusResponse
+	suite.Require().NoError(json.NewDecoder(res.Body).Decode(&seeded))
+	suite.Equal(StatusSeeded, seeded.Status)
+	suite.Empty(seeded.LastError)
+	suite.NotNil(seeded.SeededAt)
+}
+
+func (suite *SeedingTestSuite) serve(tracker *Tracker) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	tracker.ServeHTTP(res, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+	return res
+}
@@ -0,0 +1,101 @@
+package seeding
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ReadinessPath serves whether the instances to track have been seeded
+const ReadinessPath = "/readyz"
+
+// Status is the progress of seeding the instances to track
+type Status string
+
+const (
+	// StatusStandby is that of replicas waiting to lead, which do not seed until they do
+	StatusStandby Status = "standby"
+	// StatusSeeding is that of seeding before events are handled
+	StatusSeeding Status = "seeding"
+	// StatusDegraded is that of handling events while seeding is retried in the background, so interruptions of instances
+	// that were created before the exporter started cannot be resolved yet
+	StatusDegraded Status = "degraded"
+	// StatusSeeded is that of seeding having succeeded
+	StatusSeeded Status = "seeded"
+	// StatusFailed is that of seeding having failed for good
+	StatusFailed Status = "failed"
+)
+
+// Tracker records the progress of seeding, and serves it at ReadinessPath. Replicas are ready once they are on standby or seeded.
+type Tracker struct {
+	mu        sync.Mutex
+	status    Status
+	attempts  int
+	lastError string
+	seededAt  time.Time
+}
+
+type statusResponse struct {
+	Status    Status     `json:"status"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+	SeededAt  *time.Time `json:"seeded_at,omitempty"`
+}
+
+// NewTracker creates a Tracker starting at status
+func NewTracker(status Status) *Tracker {
+	return &Tracker{status: status}
+}
+
+// SetStatus records the progress of seeding
+func (t *Tracker) SetStatus(status Status) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status = status
+	if status == StatusSeeded {
+		t.seededAt = time.Now()
+	}
+}
+
+// Status returns the progress of seeding
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// Ready returns whether the replica is ready, i.e. it is on standby or has seeded the instances to track
+func (t *Tracker) Ready() bool {
+	return ready(t.Status())
+}
+
+func ready(status Status) bool {
+	return status == StatusStandby || status == StatusSeeded
+}
+
+// attempted records an attempt to seed, which failed if err is not nil
+func (t *Tracker) attempted(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.attempts++
+	t.lastError = ""
+	if err != nil {
+		t.lastError = err.Error()
+	}
+}
+
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	t.mu.Lock()
+	res := statusResponse{Status: t.status, Attempts: t.attempts, LastError: t.lastError}
+	if !t.seededAt.IsZero() {
+		seededAt := t.seededAt
+		res.SeededAt = &seededAt
+	}
+	t.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if !ready(res.Status) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,106 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// configMapKey is the binary data key snapshots are saved under in a ConfigMap
+const configMapKey = "snapshot.json.gz"
+
+type fileBackend struct {
+	path string
+}
+
+// NewFileBackend creates a Backend saving snapshots to the file at path
+func NewFileBackend(path string) Backend {
+	return &fileBackend{path: path}
+}
+
+// Save writes to a temporary file that replaces the snapshot, so a crash while saving leaves the previous snapshot intact
+func (f *fileBackend) Save(_ context.Context, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func (f *fileBackend) Load(_ context.Context) ([]byte, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// NewConfigMapBackendInput defines all required fields to create a Backend saving snapshots to a ConfigMap
+type NewConfigMapBackendInput struct {
+	Client    kubernetes.Interface
+	Namespace string
+	// Name is the name of the ConfigMap, which is created if it does not exist
+	Name string
+}
+
+type configMapBackend struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewConfigMapBackend creates a Backend saving snapshots to a ConfigMap, which holds at most 1MiB
+func NewConfigMapBackend(input NewConfigMapBackendInput) Backend {
+	return &configMapBackend{client: input.Client, namespace: input.Namespace, name: input.Name}
+}
+
+func (c *configMapBackend) Save(ctx context.Context, data []byte) error {
+	configMaps := c.client.CoreV1().ConfigMaps(c.namespace)
+	cm, err := configMaps.Get(ctx, c.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: c.name, Namespace: c.namespace},
+			BinaryData: map[string][]byte{configMapKey: data},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get configmap %s/%s: %w", c.namespace, c.name, err)
+	}
+	if cm.BinaryData == nil {
+		cm.BinaryData = map[string][]byte{}
+	}
+	cm.BinaryData[configMapKey] = data
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *configMapBackend) Load(ctx context.Context) ([]byte, error) {
+	cm, err := c.client.CoreV1().ConfigMaps(c.namespace).Get(ctx, c.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get configmap %s/%s: %w", c.namespace, c.name, err)
+	}
+	data, ok := cm.BinaryData[configMapKey]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
@@ -0,0 +1,214 @@
+// Package snapshot periodically saves the items of caches, so they can be restored after a restart
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
+	"go.uber.org/zap"
+)
+
+const defaultInterval = time.Minute
+
+// ErrNotFound is returned by backends that have not had a snapshot saved to them
+var ErrNotFound = errors.New("no snapshot found")
+
+// Snapshot is the items of named caches at a point in time
+type Snapshot struct {
+	Taken time.Time `json:"taken"`
+	// Caches maps the name of each cache to its items
+	Caches map[string]map[string]cache.Item[string] `json:"caches"`
+}
+
+// Cache is a cache whose items are saved in snapshots, with their values encoded as strings
+type Cache interface {
+	// Items returns every unexpired item with its value encoded
+	Items() map[string]cache.Item[string]
+	// Restore inserts items, returning an error for any that cannot be decoded
+	Restore(items map[string]cache.Item[string]) error
+}
+
+type encodedCache[V any] struct {
+	cache cache.Cache[string, V]
+	codec cache.Codec[V]
+}
+
+// Encoded adapts c, whose values are encoded by codec, to a Cache
+func Encoded[V any](c cache.Cache[string, V], codec cache.Codec[V]) Cache {
+	return encodedCache[V]{cache: c, codec: codec}
+}
+
+func (c encodedCache[V]) Items() map[string]cache.Item[string] {
+	return cache.EncodeItems(c.cache.Items(), c.codec)
+}
+
+func (c encodedCache[V]) Restore(items map[string]cache.Item[string]) error {
+	decoded, err := cache.DecodeItems(items, c.codec)
+	c.cache.Restore(decoded)
+	return err
+}
+
+// Backend keeps the most recently saved snapshot, which is gzipped JSON
+type Backend interface {
+	// Save replaces the saved snapshot with data
+	Save(ctx context.Context, data []byte) error
+	// Load returns the saved snapshot, or ErrNotFound if there is none
+	Load(ctx context.Context) ([]byte, error)
+}
+
+// Restore loads the snapshot saved to backend into caches, returning it. Caches missing from the snapshot are left as they are.
+// Items that cannot be decoded are skipped, and returned as an error along with the snapshot.
+func Restore(ctx context.Context, backend Backend, caches map[string]Cache) (Snapshot, error) {
+	data, err := backend.Load(ctx)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	s, err := decode(data)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	var errs []error
+	for name, c := range caches {
+		if items, ok := s.Caches[name]; ok {
+			if err := c.Restore(items); err != nil {
+				errs = append(errs, fmt.Errorf("failed to restore cache %s: %w", name, err))
+			}
+		}
+	}
+	return s, errors.Join(errs...)
+}
+
+func encode(s Snapshot) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte) (Snapshot, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to decompress snapshot: %w", err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to decompress snapshot: %w", err)
+	}
+	s := Snapshot{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	return s, nil
+}
+
+// NewSnapshotterInput defines all required fields to create a Snapshotter
+type NewSnapshotterInput struct {
+	Logger  *zap.SugaredLogger
+	Backend Backend
+	// Caches maps the name each cache is saved under to the cache
+	Caches map[string]Cache
+	// Interval is how often snapshots are saved, defaulting to a minute
+	Interval time.Duration
+}
+
+// Snapshotter saves snapshots of caches
+type Snapshotter interface {
+	// Save saves a snapshot of the caches' current items
+	Save(ctx context.Context) error
+}
+
+type snapshotter struct {
+	log     *zap.SugaredLogger
+	backend Backend
+	caches  map[string]Cache
+	now     func() time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSnapshotter creates a Snapshotter that saves a snapshot every interval, and once more when the returned function is called.
+// Caches should be restored before it is created, as they are otherwise saved over the snapshot they would be restored from.
+func NewSnapshotter(input NewSnapshotterInput) (Snapshotter, func(context.Context) error) {
+	s := newSnapshotter(input, time.Now)
+	interval := input.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	s.wg.Add(1)
+	go s.run(interval)
+	return s, s.close
+}
+
+func newSnapshotter(input NewSnapshotterInput, now func() time.Time) *snapshotter {
+	return &snapshotter{
+		log:     input.Logger,
+		backend: input.Backend,
+		caches:  input.Caches,
+		now:     now,
+		done:    make(chan struct{}),
+	}
+}
+
+func (s *snapshotter) Save(ctx context.Context) error {
+	snapshot := Snapshot{Taken: s.now(), Caches: make(map[string]map[string]cache.Item[string], len(s.caches))}
+	for name, c := range s.caches {
+		snapshot.Caches[name] = c.Items()
+	}
+	data, err := encode(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := s.backend.Save(ctx, data); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	s.log.With("bytes", len(data)).Debug("saved snapshot")
+	return nil
+}
+
+func (s *snapshotter) run(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := s.Save(ctx); err != nil {
+				s.log.With("error", err).Warn("failed to snapshot caches")
+			}
+			cancel()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// close stops saving snapshots periodically, then saves a final one
+func (s *snapshotter) close(ctx context.Context) error {
+	close(s.done)
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.Save(ctx)
+}
@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type SnapshotTestSuite struct {
+	suite.Suite
+	l *zap.SugaredLogger
+}
+
+func TestSnapshotTestSuite(t *testing.T) {
+	suite.Run(t, new(SnapshotTestSuite))
+}
+
+func (suite *SnapshotTestSuite) SetupSuite() {
+	l, err := zap.NewDevelopment()
+	suite.NoError(err)
+	suite.l = l.Sugar()
+}
+
+var intCodec = cache.Codec[int]{Encode: strconv.Itoa, Decode: strconv.Atoi}
+
+func (suite *SnapshotTestSuite) TestSaveAndRestore() {
+	backends := map[string]Backend{
+		"file": NewFileBackend(filepath.Join(suite.T().TempDir(), "snapshot.json.gz")),
+		"configmap": NewConfigMapBackend(NewConfigMapBackendInput{
+			Client:    fake.NewSimpleClientset(),
+			Namespace: "spot-interruption-exporter",
+			Name:      "spot-interruption-exporter-snapshot",
+		}),
+	}
+	for name, backend := range backends {
+		suite.Run(name, func() {
+			ctx := context.Background()
+			_, err := Restore(ctx, backend, map[string]Cache{})
+			suite.ErrorIs(err, ErrNotFound)
+
+			mappings, messages := cache.NewWithTTL[string, int](cache.NoExpiration), cache.NewWithTTL[string, string](time.Minute*10)
+			caches := map[string]Cache{"mappings": Encoded(mappings, intCodec), "messages": Encoded(messages, cache.StringCodec)}
+			taken := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			s := newSnapshotter(NewSnapshotterInput{Logger: suite.l, Backend: backend, Caches: caches}, func() time.Time { return taken })
+			mappings.Insert("projects/p/zones/z/instances/node-1", 1)
+			messages.Insert("message-1", "")
+			suite.Require().NoError(s.Save(ctx))
+			// saving again replaces the previous snapshot
+			mappings.Insert("projects/p/zones/z/instances/node-2", 2)
+			suite.Require().NoError(s.Save(ctx))
+
+			restoredMappings, restoredMessages := cache.NewWithTTL[string, int](cache.NoExpiration), cache.NewWithTTL[string, string](time.Minute*10)
+			snapshot, err := Restore(ctx, backend, map[string]Cache{
+				"mappings": Encoded(restoredMappings, intCodec),
+				"messages": Encoded(restoredMessages, cache.StringCodec),
+			})
+			suite.Require().NoError(err)
+			suite.True(taken.Equal(snapshot.Taken))
+			suite.Equal(mappings.Items(), restoredMappings.Items())
+			suite.Contains(restoredMessages.Items(), "message-1")
+			suite.Equal(messages.Items()["message-1"].Expiration.Unix(), restoredMessages.Items()["message-1"].Expiration.Unix())
+		})
+	}
+}
+
+func (suite *SnapshotTestSuite) TestCloseSaves() {
+	backend := NewFileBackend(filepath.Join(suite.T().TempDir(), "snapshot.json.gz"))
+	c := cache.NewWithTTL[string, string](cache.NoExpiration)
+	_, closeSnapshotter := NewSnapshotter(NewSnapshotterInput{Logger: suite.l, Backend: backend, Caches: map[string]Cache{"c": Encoded(c, cache.StringCodec)}, Interval: time.Hour})
+	c.Insert("k", "v")
+	suite.Require().NoError(closeSnapshotter(context.Background()))
+
+	restored := cache.NewWithTTL[string, string](cache.NoExpiration)
+	_, err := Restore(context.Background(), backend, map[string]Cache{"c": Encoded(restored, cache.StringCodec)})
+	suite.Require().NoError(err)
+	suite.Contains(restored.Items(), "k")
+}
+
+func (suite *SnapshotTestSuite) TestRestoreSkipsUndecodableItems() {
+	backend := NewFileBackend(filepath.Join(suite.T().TempDir(), "snapshot.json.gz"))
+	c := cache.NewWithTTL[string, string](cache.NoExpiration)
+	c.Insert("valid", "1")
+	c.Insert("invalid", "one")
+	s := newSnapshotter(NewSnapshotterInput{Logger: suite.l, Backend: backend, Caches: map[string]Cache{"c": Encoded(c, cache.StringCodec)}}, time.Now)
+	suite.Require().NoError(s.Save(context.Background()))
+
+	restored := cache.NewWithTTL[string, int](cache.NoExpiration)
+	snapshot, err := Restore(context.Background(), backend, map[string]Cache{"c": Encoded(restored, intCodec)})
+	suite.Error(err)
+	suite.False(snapshot.Taken.IsZero())
+	suite.Equal(map[string]cache.Item[int]{"valid": {Value: 1}}, restored.Items())
+}
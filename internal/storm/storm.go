@@ -0,0 +1,241 @@
+// Package storm detects bursts of preemptions, e.g. a zone being reclaimed, over sliding windows
+//
+//go:generate mockery --name Detector
+package storm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics"
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+	"go.uber.org/zap"
+)
+
+const defaultEvaluationInterval = time.Second * 10
+
+// Detector flags storms of preemptions
+type Detector interface {
+	// Observe records the preemption i at the time it happened, starting a storm in any scope it takes over its threshold.
+	// Preemptions older than a scope's window, e.g. redelivered after an outage, are not counted in that scope.
+	Observe(i notify.Interruption)
+}
+
+// Threshold defines when preemptions in a scope are a storm
+type Threshold struct {
+	Scope notify.StormScope
+	// Preemptions is how many preemptions within Window a storm is more than
+	Preemptions int
+	Window      time.Duration
+}
+
+// NewDetectorInput defines all required fields to create a Detector
+type NewDetectorInput struct {
+	Logger *zap.SugaredLogger
+	// Thresholds has at most one threshold per scope, and scopes without one are not watched
+	Thresholds []Threshold
+	Metrics    metrics.Client
+	// Notifier, if not nil, is notified when storms start and end
+	Notifier notify.Notifier
+	// EvaluationInterval is how often storms are checked for having ended, defaulting to 10 seconds
+	EvaluationInterval time.Duration
+}
+
+// window holds the times of recent preemptions in a scope with a given key
+type window struct {
+	preemptions []time.Time
+	active      bool
+}
+
+type detector struct {
+	log        *zap.SugaredLogger
+	thresholds []Threshold
+	metrics    metrics.Client
+	notifier   notify.Notifier
+	now        func() time.Time
+
+	mu sync.Mutex
+	// windows maps each scope, and then each key within it, to its window
+	windows map[notify.StormScope]map[string]*window
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewDetector creates a Detector for the given thresholds, which checks for storms having ended until the returned function is called
+func NewDetector(input NewDetectorInput) (Detector, func(context.Context) error, error) {
+	d, err := newDetector(input, time.Now)
+	if err != nil {
+		return nil, nil, err
+	}
+	interval := input.EvaluationInterval
+	if interval <= 0 {
+		interval = defaultEvaluationInterval
+	}
+	d.wg.Add(1)
+	go d.run(interval)
+	return d, d.close, nil
+}
+
+func newDetector(input NewDetectorInput, now func() time.Time) (*detector, error) {
+	d := &detector{
+		log:      input.Logger,
+		metrics:  input.Metrics,
+		notifier: input.Notifier,
+		now:      now,
+		windows:  make(map[notify.StormScope]map[string]*window, len(input.Thresholds)),
+		done:     make(chan struct{}),
+	}
+	for _, t := range input.Thresholds {
+		switch t.Scope {
+		case notify.StormScopeZone, notify.StormScopeMachineFamily, notify.StormScopeCluster:
+		default:
+			return nil, fmt.Errorf("unsupported storm scope %q, expected %s, %s or %s", t.Scope, notify.StormScopeZone, notify.StormScopeMachineFamily, notify.StormScopeCluster)
+		}
+		if _, ok := d.windows[t.Scope]; ok {
+			return nil, fmt.Errorf("more than one threshold for storm scope %s", t.Scope)
+		}
+		if t.Preemptions < 1 || t.Window <= 0 {
+			return nil, fmt.Errorf("threshold for storm scope %s must have a positive number of preemptions and window", t.Scope)
+		}
+		d.thresholds = append(d.thresholds, t)
+		d.windows[t.Scope] = map[string]*window{}
+	}
+	return d, nil
+}
+
+// key returns the key of i within scope, or an empty string if i is in none, e.g. the cluster of an instance that is not a Kubernetes node
+func key(scope notify.StormScope, i notify.Interruption) string {
+	switch scope {
+	case notify.StormScopeZone:
+		return i.Zone
+	case notify.StormScopeMachineFamily:
+		return i.MachineFamily
+	case notify.StormScopeCluster:
+		return i.Cluster
+	}
+	return ""
+}
+
+func (d *detector) Observe(i notify.Interruption) {
+	now := d.now()
+	// preemptions without a time, or with one ahead of the clock, are counted as happening now
+	at := i.Timestamp
+	if at.IsZero() || at.After(now) {
+		at = now
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range d.thresholds {
+		k := key(t.Scope, i)
+		if len(k) == 0 {
+			continue
+		}
+		cutoff := now.Add(-t.Window)
+		if at.Before(cutoff) {
+			d.log.With("scope", t.Scope, "key", k, "timestamp", at).Debug("ignoring preemption older than the storm window")
+			continue
+		}
+		w, ok := d.windows[t.Scope][k]
+		if !ok {
+			w = &window{}
+			d.windows[t.Scope][k] = w
+		}
+		w.prune(cutoff)
+		w.insert(at)
+		if !w.active && len(w.preemptions) > t.Preemptions {
+			w.active = true
+			d.transition(t, k, w, notify.StormStarted, now)
+		}
+	}
+}
+
+// insert adds a preemption at, keeping preemptions in time order as events can arrive out of order
+func (w *window) insert(at time.Time) {
+	i := sort.Search(len(w.preemptions), func(i int) bool { return w.preemptions[i].After(at) })
+	w.preemptions = append(w.preemptions, time.Time{})
+	copy(w.preemptions[i+1:], w.preemptions[i:])
+	w.preemptions[i] = at
+}
+
+// prune drops preemptions before cutoff, which are always the oldest
+func (w *window) prune(cutoff time.Time) {
+	i := 0
+	for i < len(w.preemptions) && w.preemptions[i].Before(cutoff) {
+		i++
+	}
+	w.preemptions = w.preemptions[i:]
+}
+
+// evaluate ends storms that have dropped back to their threshold, and forgets quiet keys
+func (d *detector) evaluate() {
+	now := d.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range d.thresholds {
+		for k, w := range d.windows[t.Scope] {
+			w.prune(now.Add(-t.Window))
+			if w.active && len(w.preemptions) <= t.Preemptions {
+				w.active = false
+				d.transition(t, k, w, notify.StormEnded, now)
+			}
+			if !w.active && len(w.preemptions) == 0 {
+				delete(d.windows[t.Scope], k)
+			}
+		}
+	}
+}
+
+// transition records a storm in t's scope with key k starting or ending. The caller must hold mu.
+func (d *detector) transition(t Threshold, k string, w *window, state notify.StormState, now time.Time) {
+	s := d.log.With("scope", t.Scope, "key", k, "preemptions", len(w.preemptions), "threshold", t.Preemptions, "window", t.Window)
+	if state == notify.StormStarted {
+		s.Warn("preemption storm started")
+	} else {
+		s.Info("preemption storm ended")
+	}
+	d.metrics.SetPreemptionStormActive(string(t.Scope), k, w.active)
+	if d.notifier != nil {
+		d.notifier.NotifyStorm(notify.Storm{
+			Scope:       t.Scope,
+			Key:         k,
+			State:       state,
+			Preemptions: len(w.preemptions),
+			Threshold:   t.Preemptions,
+			Window:      t.Window.String(),
+			Timestamp:   now,
+		})
+	}
+}
+
+func (d *detector) run(interval time.Duration) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.evaluate()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *detector) close(ctx context.Context) error {
+	close(d.done)
+	stopped := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
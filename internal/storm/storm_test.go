@@ -0,0 +1,179 @@
+package storm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics/mocks"
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+	notifymocks "github.com/thought-machine/spot-interruption-exporter/internal/notify/mocks"
+	"go.uber.org/zap"
+)
+
+type StormTestSuite struct {
+	suite.Suite
+	l *zap.SugaredLogger
+}
+
+func TestStormTestSuite(t *testing.T) {
+	suite.Run(t, new(StormTestSuite))
+}
+
+func (suite *StormTestSuite) SetupSuite() {
+	l, err := zap.NewDevelopment()
+	suite.NoError(err)
+	suite.l = l.Sugar()
+}
+
+// fakeClock is a clock that only moves when advanced
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func preemption(zone, machineFamily, cluster string) notify.Interruption {
+	return notify.Interruption{Zone: zone, MachineFamily: machineFamily, Cluster: cluster, Cause: "preempted"}
+}
+
+func (suite *StormTestSuite) TestDetectsStorms() {
+	m := mocks.NewClient(suite.T())
+	notifier := notifymocks.NewNotifier(suite.T())
+	clock := &fakeClock{t: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	d, err := newDetector(NewDetectorInput{
+		Logger: suite.l,
+		Thresholds: []Threshold{
+			{Scope: notify.StormScopeZone, Preemptions: 2, Window: time.Minute * 5},
+			{Scope: notify.StormScopeCluster, Preemptions: 10, Window: time.Minute * 5},
+		},
+		Metrics:  m,
+		Notifier: notifier,
+	}, clock.now)
+	suite.Require().NoError(err)
+
+	// preemptions outside the window do not count towards a storm
+	d.Observe(preemption("europe-west1-c", "e2", "prod"))
+	clock.advance(time.Minute * 6)
+	d.Observe(preemption("europe-west1-c", "e2", "prod"))
+	clock.advance(time.Minute)
+	d.Observe(preemption("europe-west1-c", "e2", "prod"))
+
+	m.EXPECT().SetPreemptionStormActive("zone", "europe-west1-c", true).Times(1)
+	notifier.EXPECT().NotifyStorm(mock.MatchedBy(func(s notify.Storm) bool {
+		return s.Scope == notify.StormScopeZone && s.Key == "europe-west1-c" && s.State == notify.StormStarted &&
+			s.Preemptions == 3 && s.Threshold == 2 && s.Window == "5m0s"
+	})).Times(1)
+	clock.advance(time.Minute)
+	d.Observe(preemption("europe-west1-c", "e2", "prod"))
+	// an active storm is only started once, and other zones are counted separately
+	d.Observe(preemption("europe-west1-c", "e2", "prod"))
+	d.Observe(preemption("europe-west1-b", "e2", "prod"))
+
+	// the storm continues while there are more preemptions than the threshold within the window
+	d.evaluate()
+
+	m.EXPECT().SetPreemptionStormActive("zone", "europe-west1-c", false).Times(1)
+	notifier.EXPECT().NotifyStorm(mock.MatchedBy(func(s notify.Storm) bool {
+		return s.Key == "europe-west1-c" && s.State == notify.StormEnded
+	})).Times(1)
+	clock.advance(time.Minute * 5)
+	d.evaluate()
+	// zones without recent preemptions are forgotten
+	clock.advance(time.Minute)
+	d.evaluate()
+	suite.Empty(d.windows[notify.StormScopeZone])
+}
+
+func (suite *StormTestSuite) TestCountsPreemptionsWhenTheyHappened() {
+	m := mocks.NewClient(suite.T())
+	clock := &fakeClock{t: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	d, err := newDetector(NewDetectorInput{
+		Logger:     suite.l,
+		Thresholds: []Threshold{{Scope: notify.StormScopeZone, Preemptions: 2, Window: time.Minute * 5}},
+		Metrics:    m,
+	}, clock.now)
+	suite.Require().NoError(err)
+
+	// a backlog of preemptions from before the window, e.g. delivered after an outage, is not a storm
+	for n := 0; n < 5; n++ {
+		old := preemption("europe-west1-c", "e2", "prod")
+		old.Timestamp = clock.t.Add(-time.Minute * 10)
+		d.Observe(old)
+	}
+	suite.Empty(d.windows[notify.StormScopeZone])
+
+	// preemptions arriving out of order are counted within the window they happened in
+	for _, ago := range []time.Duration{time.Minute, time.Minute * 4, time.Minute * 2} {
+		p := preemption("europe-west1-c", "e2", "prod")
+		p.Timestamp = clock.t.Add(-ago)
+		if ago == time.Minute*2 {
+			m.EXPECT().SetPreemptionStormActive("zone", "europe-west1-c", true).Times(1)
+		}
+		d.Observe(p)
+	}
+	w := d.windows[notify.StormScopeZone]["europe-west1-c"]
+	suite.True(w.active)
+	suite.Equal([]time.Time{clock.t.Add(-time.Minute * 4), clock.t.Add(-time.Minute * 2), clock.t.Add(-time.Minute)}, w.preemptions)
+
+	// the storm ends once the earliest preemption leaves the window, rather than five minutes after it was observed
+	m.EXPECT().SetPreemptionStormActive("zone", "europe-west1-c", false).Times(1)
+	clock.advance(time.Minute*1 + time.Second)
+	d.evaluate()
+	suite.False(w.active)
+}
+
+func (suite *StormTestSuite) TestIgnoresPreemptionsOutsideScope() {
+	m := mocks.NewClient(suite.T())
+	d, err := newDetector(NewDetectorInput{
+		Logger:     suite.l,
+		Thresholds: []Threshold{{Scope: notify.StormScopeCluster, Preemptions: 1, Window: time.Minute}},
+		Metrics:    m,
+	}, time.Now)
+	suite.Require().NoError(err)
+
+	// instances that are not Kubernetes nodes have no cluster, so cannot cause a cluster storm
+	d.Observe(preemption("europe-west1-c", "e2", ""))
+	d.Observe(preemption("europe-west1-c", "e2", ""))
+	suite.Empty(d.windows[notify.StormScopeCluster])
+
+	// storms are recorded without a notifier
+	m.EXPECT().SetPreemptionStormActive("cluster", "prod", true).Times(1)
+	d.Observe(preemption("europe-west1-c", "e2", "prod"))
+	d.Observe(preemption("europe-west1-c", "e2", "prod"))
+}
+
+func (suite *StormTestSuite) TestNewDetectorInvalidThresholds() {
+	tests := map[string][]Threshold{
+		"unknown scope":   {{Scope: "region", Preemptions: 1, Window: time.Minute}},
+		"duplicate scope": {{Scope: notify.StormScopeZone, Preemptions: 1, Window: time.Minute}, {Scope: notify.StormScopeZone, Preemptions: 5, Window: time.Hour}},
+		"no preemptions":  {{Scope: notify.StormScopeZone, Window: time.Minute}},
+		"no window":       {{Scope: notify.StormScopeZone, Preemptions: 1}},
+	}
+	for name, thresholds := range tests {
+		suite.Run(name, func() {
+			_, _, err := NewDetector(NewDetectorInput{Logger: suite.l, Thresholds: thresholds})
+			suite.Error(err)
+		})
+	}
+}
+
+func (suite *StormTestSuite) TestClose() {
+	d, closeDetector, err := NewDetector(NewDetectorInput{
+		Logger:             suite.l,
+		Thresholds:         []Threshold{{Scope: notify.StormScopeZone, Preemptions: 1, Window: time.Minute}},
+		Metrics:            mocks.NewClient(suite.T()),
+		EvaluationInterval: time.Millisecond,
+	})
+	suite.Require().NoError(err)
+	d.Observe(preemption("europe-west1-c", "e2", "prod"))
+	suite.NoError(closeDetector(context.Background()))
+}
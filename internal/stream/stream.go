@@ -0,0 +1,114 @@
+// Package stream pushes interruptions to subscribers as they are handled
+//
+//go:generate mockery --name Broadcaster
+package stream
+
+import (
+	"sync"
+
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+	"go.uber.org/zap"
+)
+
+const defaultBufferSize = 100
+
+// Filter selects interruptions. Empty fields select every interruption.
+type Filter struct {
+	// Clusters and Zones select interruptions in any of their clusters and zones
+	Clusters []string
+	Zones    []string
+}
+
+// Matches returns whether i is selected by f
+func (f Filter) Matches(i notify.Interruption) bool {
+	return (len(f.Clusters) == 0 || contains(f.Clusters, i.Cluster)) &&
+		(len(f.Zones) == 0 || contains(f.Zones, i.Zone))
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Broadcaster pushes every interruption published to it to the subscribers whose filter selects it
+type Broadcaster interface {
+	// Publish pushes i to subscribers without blocking. Subscribers that have fallen behind are unsubscribed and their channel closed
+	// rather than missing i, so that they can resubscribe and catch up.
+	Publish(i notify.Interruption)
+	// Subscribe returns a channel receiving the interruptions f selects, and a function unsubscribing and closing it
+	Subscribe(f Filter) (<-chan notify.Interruption, func())
+}
+
+// NewBroadcasterInput defines all required fields to create a Broadcaster
+type NewBroadcasterInput struct {
+	Logger *zap.SugaredLogger
+	// BufferSize is how many interruptions each subscriber can fall behind by before it is unsubscribed, defaulting to 100
+	BufferSize int
+}
+
+type subscriber struct {
+	filter        Filter
+	interruptions chan notify.Interruption
+}
+
+type broadcaster struct {
+	log        *zap.SugaredLogger
+	bufferSize int
+
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+// NewBroadcaster creates a Broadcaster with no subscribers
+func NewBroadcaster(input NewBroadcasterInput) Broadcaster {
+	size := input.BufferSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	return &broadcaster{
+		log:         input.Logger,
+		bufferSize:  size,
+		subscribers: map[*subscriber]struct{}{},
+	}
+}
+
+func (b *broadcaster) Publish(i notify.Interruption) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subscribers {
+		if !s.filter.Matches(i) {
+			continue
+		}
+		select {
+		case s.interruptions <- i:
+		default:
+			b.log.With("resource_id", i.ResourceID, "message_id", i.MessageID).Warn("unsubscribing subscriber that has fallen behind")
+			b.remove(s)
+		}
+	}
+}
+
+func (b *broadcaster) Subscribe(f Filter) (<-chan notify.Interruption, func()) {
+	s := &subscriber{filter: f, interruptions: make(chan notify.Interruption, b.bufferSize)}
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+	return s.interruptions, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(s)
+	}
+}
+
+// remove unsubscribes s and closes its channel, unless it has already been removed. The caller must hold mu.
+func (b *broadcaster) remove(s *subscriber) {
+	if _, ok := b.subscribers[s]; !ok {
+		return
+	}
+	delete(b.subscribers, s)
+	close(s.interruptions)
+}
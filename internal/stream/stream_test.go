@@ -0,0 +1,67 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+	"go.uber.org/zap"
+)
+
+type StreamTestSuite struct {
+	suite.Suite
+	l *zap.SugaredLogger
+}
+
+func TestStreamTestSuite(t *testing.T) {
+	suite.Run(t, new(StreamTestSuite))
+}
+
+func (suite *StreamTestSuite) SetupSuite() {
+	l, err := zap.NewDevelopment()
+	suite.NoError(err)
+	suite.l = l.Sugar()
+}
+
+func (suite *StreamTestSuite) TestFilter() {
+	i := notify.Interruption{Cluster: "prod", Zone: "europe-west1-c"}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "empty", filter: Filter{}, want: true},
+		{name: "cluster", filter: Filter{Clusters: []string{"staging", "prod"}}, want: true},
+		{name: "zone mismatch", filter: Filter{Zones: []string{"us-central1-a"}}, want: false},
+		{name: "cluster and zone", filter: Filter{Clusters: []string{"prod"}, Zones: []string{"europe-west1-c"}}, want: true},
+		{name: "cluster mismatch", filter: Filter{Clusters: []string{"staging"}, Zones: []string{"europe-west1-c"}}, want: false},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			suite.Equal(tt.want, tt.filter.Matches(i))
+		})
+	}
+}
+
+func (suite *StreamTestSuite) TestBroadcaster() {
+	b := NewBroadcaster(NewBroadcasterInput{Logger: suite.l, BufferSize: 1})
+	all, unsubscribeAll := b.Subscribe(Filter{})
+	prod, unsubscribeProd := b.Subscribe(Filter{Clusters: []string{"prod"}})
+	defer unsubscribeProd()
+
+	b.Publish(notify.Interruption{Cluster: "prod", Instance: "node-1"})
+	// subscribers that have fallen behind are closed rather than holding up publishing or silently missing interruptions
+	b.Publish(notify.Interruption{Cluster: "staging", Instance: "node-2"})
+
+	suite.Equal("node-1", (<-all).Instance)
+	_, open := <-all
+	suite.False(open)
+	suite.Equal("node-1", (<-prod).Instance)
+	suite.Empty(prod)
+
+	// unsubscribing a closed subscriber is a no-op
+	unsubscribeAll()
+	unsubscribeAll()
+	b.Publish(notify.Interruption{Cluster: "prod", Instance: "node-3"})
+	suite.Equal("node-3", (<-prod).Instance)
+}
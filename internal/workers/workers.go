@@ -0,0 +1,85 @@
+// Package workers runs tasks concurrently while keeping those with the same key, e.g. the same instance, in order
+package workers
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+const defaultQueueSize = 100
+
+// Pool runs tasks on a fixed number of workers. Tasks with the same key always run on the same worker, one at a time in
+// the order they were submitted, so that events for one resource never race.
+type Pool interface {
+	// Submit queues task to run once every task previously submitted with key has run, blocking while the queue of its worker is full
+	Submit(key string, task func())
+}
+
+// NewPoolInput defines all required fields to create a Pool
+type NewPoolInput struct {
+	// Workers is how many tasks run at once, defaulting to 1
+	Workers int
+	// QueueSize is how many tasks each worker queues before Submit blocks, defaulting to 100
+	QueueSize int
+}
+
+type pool struct {
+	queues []chan func()
+	wg     sync.WaitGroup
+}
+
+// NewPool creates a Pool, which runs tasks until the returned function is called. It stops accepting tasks, then waits
+// for those already queued to run until ctx is done. Submitting tasks once it has been called panics.
+func NewPool(input NewPoolInput) (Pool, func(context.Context) error) {
+	workers := input.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	queueSize := input.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	p := &pool{queues: make([]chan func(), workers)}
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), queueSize)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p, p.close
+}
+
+func (p *pool) work(queue <-chan func()) {
+	defer p.wg.Done()
+	for task := range queue {
+		task()
+	}
+}
+
+func (p *pool) Submit(key string, task func()) {
+	p.queues[p.worker(key)] <- task
+}
+
+// worker returns the index of the worker tasks with key run on
+func (p *pool) worker(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+func (p *pool) close(ctx context.Context) error {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,78 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type WorkersTestSuite struct {
+	suite.Suite
+}
+
+func TestWorkersTestSuite(t *testing.T) {
+	suite.Run(t, new(WorkersTestSuite))
+}
+
+func (suite *WorkersTestSuite) TestOrderedPerKey() {
+	p, closePool := NewPool(NewPoolInput{Workers: 4, QueueSize: 1})
+	var mu sync.Mutex
+	ran := map[string][]int{}
+	for i := 0; i < 100; i++ {
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			p.Submit(key, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				ran[key] = append(ran[key], i)
+			})
+		}
+	}
+	suite.NoError(closePool(context.Background()))
+
+	// every task ran, once closing returned, and in the order submitted for each key
+	suite.Len(ran, 5)
+	for key, order := range ran {
+		suite.Len(order, 100, key)
+		suite.IsIncreasing(order, key)
+	}
+}
+
+func (suite *WorkersTestSuite) TestConcurrentAcrossWorkers() {
+	p, closePool := NewPool(NewPoolInput{Workers: 2})
+	defer closePool(context.Background())
+	// find keys on each worker
+	keys := map[int]string{}
+	for i := 0; len(keys) < 2; i++ {
+		key := fmt.Sprintf("instance-%d", i)
+		keys[p.(*pool).worker(key)] = key
+	}
+
+	// the task on one worker only finishes once the task on the other has started
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	p.Submit(keys[0], func() {
+		<-started
+		close(finished)
+	})
+	p.Submit(keys[1], func() { close(started) })
+	select {
+	case <-finished:
+	case <-time.After(time.Second * 5):
+		suite.Fail("tasks on different workers did not run concurrently")
+	}
+}
+
+func (suite *WorkersTestSuite) TestCloseTimeout() {
+	p, closePool := NewPool(NewPoolInput{})
+	release := make(chan struct{})
+	defer close(release)
+	p.Submit("a", func() { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	suite.ErrorIs(closePool(ctx), context.DeadlineExceeded)
+}
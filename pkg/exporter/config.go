@@ -0,0 +1,286 @@
+package exporter
+
+import (
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type PrometheusConfig struct {
+	Path string
+	Port string
+	// Disabled stops metrics from being served for scraping, e.g. when they are only pushed over OTLP
+	Disabled bool `yaml:"disabled"`
+}
+
+// OpenTelemetryConfig defines how metrics are pushed over OTLP to an OpenTelemetry collector
+type OpenTelemetryConfig struct {
+	// Endpoint is the host:port of the collector's OTLP receiver
+	Endpoint string `yaml:"endpoint"`
+	// Protocol is either grpc or http, defaulting to grpc
+	Protocol string `yaml:"protocol"`
+	// Insecure disables TLS when connecting to the collector
+	Insecure bool              `yaml:"insecure"`
+	Headers  map[string]string `yaml:"headers"`
+	// ResourceAttributes are added to every metric's resource, e.g. deployment.environment
+	ResourceAttributes map[string]string `yaml:"resource_attributes"`
+	// Temporality is either cumulative or delta, defaulting to cumulative
+	Temporality    string        `yaml:"temporality"`
+	ExportInterval time.Duration `yaml:"export_interval"`
+	ExportTimeout  time.Duration `yaml:"export_timeout"`
+}
+
+// CloudMonitoringConfig defines how interruption counts are written to Cloud Monitoring
+type CloudMonitoringConfig struct {
+	// ClusterLocations maps Kubernetes clusters to their region, needed for regional clusters as nodes are in zones
+	ClusterLocations map[string]string `yaml:"cluster_locations"`
+	WriteInterval    time.Duration     `yaml:"write_interval"`
+	// IdleTimeout is how long series that stop being increased are kept for, e.g. those of a single instance
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+}
+
+// KubernetesEventsConfig defines the clusters Kubernetes events are recorded in when their nodes are preempted
+type KubernetesEventsConfig struct {
+	// Clusters maps the name of each cluster, as resolved by the cluster identity, to how its API server is accessed
+	Clusters map[string]KubernetesClusterAccess `yaml:"clusters"`
+}
+
+// KubernetesClusterAccess defines how a cluster's API server is accessed. Exactly one of kubeconfig, endpoint or in_cluster must be set
+type KubernetesClusterAccess struct {
+	Kubeconfig string `yaml:"kubeconfig"`
+	Context    string `yaml:"context"`
+	// Endpoint and CertificateAuthorityData are those of a GKE cluster, accessed with the app's Google credentials, e.g. via Workload Identity
+	Endpoint                 string `yaml:"endpoint"`
+	CertificateAuthorityData string `yaml:"certificate_authority_data"`
+	// InCluster uses the service account of the app's pod, for the cluster it runs in
+	InCluster bool `yaml:"in_cluster"`
+}
+
+// NotificationsConfig defines the sinks interruptions are delivered to, and which interruptions each receives
+type NotificationsConfig struct {
+	// Sinks maps the name of each sink to where it delivers
+	Sinks  map[string]NotificationSinkConfig `yaml:"sinks"`
+	Routes []NotificationRouteConfig         `yaml:"routes"`
+}
+
+// NotificationSinkConfig defines a sink of type webhook, slack or pagerduty
+type NotificationSinkConfig struct {
+	Type string `yaml:"type"`
+	// URL is where webhooks and Slack messages are posted, optional for PagerDuty
+	URL string `yaml:"url"`
+	// Secret signs webhook bodies, sent as an HMAC-SHA256 in the X-Signature-256 header
+	Secret  string            `yaml:"secret"`
+	Headers map[string]string `yaml:"headers"`
+	// RoutingKey and Severity are those of PagerDuty alerts
+	RoutingKey  string        `yaml:"routing_key"`
+	Severity    string        `yaml:"severity"`
+	MaxAttempts int           `yaml:"max_attempts"`
+	Backoff     time.Duration `yaml:"backoff"`
+}
+
+// NotificationRouteConfig delivers interruptions matching every non-empty list of glob patterns to sinks
+type NotificationRouteConfig struct {
+	Clusters  []string `yaml:"clusters"`
+	Zones     []string `yaml:"zones"`
+	NodePools []string `yaml:"node_pools"`
+	// MachineFamilies are those of the instance, e.g. e2
+	MachineFamilies []string `yaml:"machine_families"`
+	// Causes default to preempted
+	Causes []string `yaml:"causes"`
+	Sinks  []string `yaml:"sinks"`
+	// AggregationWindow, if set, delivers every interruption routed within the window as a single notification
+	AggregationWindow time.Duration `yaml:"aggregation_window"`
+	// Storms delivers the start and end of preemption storms rather than interruptions, matching the patterns of the storm's scope
+	Storms bool `yaml:"storms"`
+}
+
+// StormDetectionConfig defines when bursts of preemptions are flagged as storms
+type StormDetectionConfig struct {
+	// Thresholds has at most one threshold per scope
+	Thresholds []StormThresholdConfig `yaml:"thresholds"`
+	// EvaluationInterval is how often storms are checked for having ended
+	EvaluationInterval time.Duration `yaml:"evaluation_interval"`
+}
+
+// StormThresholdConfig flags more than Preemptions preemptions within Window in a zone, machine_family or cluster as a storm
+type StormThresholdConfig struct {
+	Scope       string        `yaml:"scope"`
+	Preemptions int           `yaml:"preemptions"`
+	Window      time.Duration `yaml:"window"`
+}
+
+// DeduplicationConfig defines how interruption events that reach the exporter more than once are recognised
+type DeduplicationConfig struct {
+	// Strategy is one of message_id, insert_id, operation_id and resource_window, defaulting to message_id
+	Strategy string `yaml:"strategy"`
+	// Window is how long events are remembered for, and the size of the time buckets of resource_window
+	Window time.Duration `yaml:"window"`
+}
+
+// WorkerPoolConfig defines the workers events are handled on concurrently, in order for each instance
+type WorkerPoolConfig struct {
+	// Workers is how many events are handled at once
+	Workers int `yaml:"workers"`
+	// QueueSize is how many events each worker queues before receiving further events waits
+	QueueSize int `yaml:"queue_size"`
+}
+
+// SeedingConfig defines how listing the instances to track from the compute API on startup is retried
+type SeedingConfig struct {
+	// Attempts is how many times listing instances is attempted before giving up, defaulting to 5
+	Attempts int `yaml:"attempts"`
+	// InitialBackoff is how long to wait after the first failure, doubling after each further failure up to MaxBackoff
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// QuotaBackoff is the least time to wait after exceeding a quota, unless the compute API says how long to wait
+	QuotaBackoff time.Duration `yaml:"quota_backoff"`
+	// Degraded handles events straight away, retrying listing instances in the background until it succeeds
+	Degraded bool `yaml:"degraded"`
+}
+
+// InterruptionsAPIConfig defines the read-only API listing recent interruptions, served alongside Prometheus metrics
+type InterruptionsAPIConfig struct {
+	// HistorySize is how many of the most recent interruptions are kept in memory, unless History persists them
+	HistorySize int `yaml:"history_size"`
+	// StreamBufferSize is how many interruptions a streaming client can fall behind by before its stream is closed
+	StreamBufferSize int `yaml:"stream_buffer_size"`
+	// StreamHeartbeatInterval is how often an idle stream sends a comment to keep its connection open
+	StreamHeartbeatInterval time.Duration `yaml:"stream_heartbeat_interval"`
+}
+
+// HistoryConfig defines the file interruptions and instance creations are recorded in
+type HistoryConfig struct {
+	Path string `yaml:"path"`
+	// Retention is how long interruptions and creations are kept for, defaulting to forever
+	Retention time.Duration `yaml:"retention"`
+	// CompactionInterval is how often those past their retention are deleted and the file is compacted
+	CompactionInterval time.Duration `yaml:"compaction_interval"`
+}
+
+// SnapshotConfig defines where the mapping of instances to workloads and the message deduplication cache are saved,
+// so they survive restarts. Exactly one of Path or ConfigMap must be set.
+type SnapshotConfig struct {
+	// Path is the file snapshots are saved to
+	Path string `yaml:"path"`
+	// ConfigMap is saved to in the cluster the exporter runs in
+	ConfigMap *SnapshotConfigMapConfig `yaml:"config_map"`
+	// Interval is how often snapshots are saved, defaulting to a minute
+	Interval time.Duration `yaml:"interval"`
+	// ReconcileGracePeriod is how long instances in a restored snapshot that no longer exist remain tracked, defaulting to an hour
+	ReconcileGracePeriod time.Duration `yaml:"reconcile_grace_period"`
+}
+
+// SnapshotConfigMapConfig names the ConfigMap snapshots are saved to
+type SnapshotConfigMapConfig struct {
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+}
+
+// RedisConfig defines the Redis that the mapping of instances to workloads and the message deduplication cache are shared in,
+// so that every replica can consume events
+type RedisConfig struct {
+	Address  string `yaml:"address"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	TLS      bool   `yaml:"tls"`
+	// KeyPrefix is prepended to every key, defaulting to spot-interruption-exporter:
+	KeyPrefix string `yaml:"key_prefix"`
+}
+
+// HighAvailabilityConfig defines the Lease replicas elect a leader with, which is the only replica consuming events
+type HighAvailabilityConfig struct {
+	Namespace string `yaml:"namespace"`
+	LeaseName string `yaml:"lease_name"`
+	// LeaseDuration is how long replicas wait for an unrenewed lease before taking it over
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+	// RenewDeadline is how long the leader retries renewing the lease for before giving up leadership
+	RenewDeadline time.Duration `yaml:"renew_deadline"`
+	// RetryPeriod is how often replicas try to acquire or renew the lease
+	RetryPeriod time.Duration `yaml:"retry_period"`
+}
+
+// StatsDConfig defines how metrics are sent to a StatsD server or Datadog agent
+type StatsDConfig struct {
+	// Address is the host:port the StatsD server listens on for UDP packets
+	Address string `yaml:"address"`
+	// Prefix is prepended to every metric name, separated by a dot
+	Prefix string `yaml:"prefix"`
+	// Flavor is either statsd or dogstatsd, defaulting to dogstatsd
+	Flavor        string        `yaml:"flavor"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+type PubSub struct {
+	// ComputeEventsSubscriptionName is optional, and replaces every other subscription with one carrying all compute events
+	ComputeEventsSubscriptionName        string `yaml:"compute_events_subscription_name"`
+	InstanceCreationSubscriptionName     string `yaml:"instance_creation_subscription_name"`
+	InstanceInterruptionSubscriptionName string `yaml:"instance_interruption_subscription_name"`
+	// InstanceLifecycleSubscriptionName is optional, and needed to track instances with a STOP termination action and to stop
+	// tracking deleted instances. It is ignored when ComputeEventsSubscriptionName is set, which carries lifecycle events too.
+	InstanceLifecycleSubscriptionName string `yaml:"instance_lifecycle_subscription_name"`
+}
+
+// ClusterIdentityConfig defines how the cluster an instance belongs to is resolved, defaulting to the GKE cluster name label
+type ClusterIdentityConfig struct {
+	// LabelKeys are instance labels whose value is the cluster name, checked in order
+	LabelKeys []string `yaml:"label_keys"`
+	// MetadataKey is an instance metadata key whose value is the cluster name
+	MetadataKey string `yaml:"metadata_key"`
+	// InstanceNamePattern is a regex extracting the cluster name from the instance name via its "cluster" or first capture group
+	InstanceNamePattern string `yaml:"instance_name_pattern"`
+}
+
+type Config struct {
+	PubSub  PubSub `yaml:"pubsub"`
+	Project string `yaml:"project_name"`
+	// Projects lists additional projects whose instances are tracked alongside those of Project
+	Projects []string `yaml:"projects"`
+	// ProjectParents lists folders and organizations, e.g. folders/123, whose projects are all tracked
+	ProjectParents []string `yaml:"project_parents"`
+	ClusterName    string   `yaml:"cluster_name"`
+	LogLevel       string   `yaml:"log_level"`
+	Prometheus     PrometheusConfig
+	// OpenTelemetry is optional, and only needed to push metrics to an OpenTelemetry collector
+	OpenTelemetry *OpenTelemetryConfig `yaml:"opentelemetry"`
+	// CloudMonitoring is optional, and only needed to write interruption counts as Cloud Monitoring custom metrics
+	CloudMonitoring *CloudMonitoringConfig `yaml:"cloud_monitoring"`
+	// StatsD is optional, and only needed to send metrics to a StatsD server or Datadog agent
+	StatsD *StatsDConfig `yaml:"statsd"`
+	// ClusterIdentity is optional, and only needed for clusters whose nodes are not GKE nodes
+	ClusterIdentity *ClusterIdentityConfig `yaml:"cluster_identity"`
+	// KubernetesEvents is optional, and only needed to record events against preempted nodes and their pods
+	KubernetesEvents *KubernetesEventsConfig `yaml:"kubernetes_events"`
+	// Notifications is optional, and only needed to deliver interruptions to webhooks, Slack or PagerDuty
+	Notifications *NotificationsConfig `yaml:"notifications"`
+	// InterruptionsAPI is optional, and only needed to list recent interruptions over HTTP
+	InterruptionsAPI *InterruptionsAPIConfig `yaml:"interruptions_api"`
+	// History is optional, and only needed to keep interruptions and creations across restarts
+	History *HistoryConfig `yaml:"history"`
+	// Snapshot is optional, and only needed to restore the instances being tracked after a restart
+	Snapshot *SnapshotConfig `yaml:"snapshot"`
+	// HighAvailability is optional, and only needed to run more than one replica with a single one consuming events
+	HighAvailability *HighAvailabilityConfig `yaml:"high_availability"`
+	// Redis is optional, and only needed to run more than one replica all consuming events
+	Redis *RedisConfig `yaml:"redis"`
+	// Deduplication is optional, and only needed to recognise duplicates beyond pubsub redelivering a message
+	Deduplication *DeduplicationConfig `yaml:"deduplication"`
+	// WorkerPool is optional, and only needed to handle events concurrently rather than one at a time for each subscription
+	WorkerPool *WorkerPoolConfig `yaml:"worker_pool"`
+	// Seeding is optional, and only needed to change how listing instances on startup is retried
+	Seeding *SeedingConfig `yaml:"seeding"`
+	// StormDetection is optional, and only needed to flag bursts of preemptions
+	StormDetection *StormDetectionConfig `yaml:"storm_detection"`
+	// WorkloadTypes lists the workloads whose instances are tracked: kubernetes, dataproc, batch and mig. Defaults to kubernetes
+	WorkloadTypes []string `yaml:"workload_types"`
+}
+
+func LoadConfig(path string) (cfg Config, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	err = yaml.Unmarshal(b, &cfg)
+	return
+}
@@ -0,0 +1,872 @@
+// Package exporter listens for interruption events from the specified InterruptionNotifier,
+// incrementing a counter every time an event is received
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/redis/go-redis/v9"
+	"github.com/thought-machine/spot-interruption-exporter/internal/api"
+	"github.com/thought-machine/spot-interruption-exporter/internal/cache"
+	"github.com/thought-machine/spot-interruption-exporter/internal/compute"
+	"github.com/thought-machine/spot-interruption-exporter/internal/dedup"
+	"github.com/thought-machine/spot-interruption-exporter/internal/election"
+	"github.com/thought-machine/spot-interruption-exporter/internal/events"
+	"github.com/thought-machine/spot-interruption-exporter/internal/handlers"
+	"github.com/thought-machine/spot-interruption-exporter/internal/history"
+	"github.com/thought-machine/spot-interruption-exporter/internal/kube"
+	"github.com/thought-machine/spot-interruption-exporter/internal/metrics"
+	"github.com/thought-machine/spot-interruption-exporter/internal/notify"
+	"github.com/thought-machine/spot-interruption-exporter/internal/projects"
+	"github.com/thought-machine/spot-interruption-exporter/internal/seeding"
+	"github.com/thought-machine/spot-interruption-exporter/internal/snapshot"
+	"github.com/thought-machine/spot-interruption-exporter/internal/storm"
+	"github.com/thought-machine/spot-interruption-exporter/internal/stream"
+	"github.com/thought-machine/spot-interruption-exporter/internal/workers"
+	"github.com/thought-machine/spot-interruption-exporter/pkg/pipeline"
+	"go.uber.org/zap"
+)
+
+// serverShutdownTimeout bounds how long requests being served are waited for as the exporter shuts down
+const serverShutdownTimeout = time.Second * 10
+
+// RunInput configures the exporter run by Run
+type RunInput struct {
+	// ConfigPath is the path of the config file
+	ConfigPath string
+	// InterruptionStages run after the filter stage of the interruption pipeline and before its events are emitted, in order
+	InterruptionStages []pipeline.Stage[*pipeline.InterruptionEvent]
+	// CreationStages run after the decode stage of the creation pipeline and before its instances are tracked, in order
+	CreationStages []pipeline.Stage[*pipeline.CreationEvent]
+}
+
+// Run consumes events until ctx is done, saving pending notifications and snapshots, and releasing the lease, before it returns
+func Run(ctx context.Context, input RunInput) error {
+	cfg, err := LoadConfig(input.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to load app configuration: %s", err.Error())
+	}
+
+	logger := configureLogger(cfg)
+	mux := http.NewServeMux()
+	m, shutdownMetrics, err := createMetricsClient(ctx, logger, cfg, mux)
+	if err != nil {
+		return fmt.Errorf("failed to init metrics client: %s", err.Error())
+	}
+	defer func() {
+		if err := shutdownMetrics(context.Background()); err != nil {
+			logger.With("error", err).Error("failed to flush metrics")
+		}
+	}()
+
+	// a single subscription carrying every compute event replaces those carrying a single type of event each
+	var computeEvents, interruptionEvents, creationEvents, lifecycleEvents events.Subscription
+	if len(cfg.PubSub.ComputeEventsSubscriptionName) > 0 {
+		if len(cfg.PubSub.InstanceLifecycleSubscriptionName) > 0 {
+			logger.With("subscription", cfg.PubSub.InstanceLifecycleSubscriptionName).Warn("ignoring instance_lifecycle_subscription_name, as lifecycle events are consumed from compute_events_subscription_name")
+		}
+		computeEvents, err = createSubscriptionClient(ctx, logger, m, cfg.Project, cfg.PubSub.ComputeEventsSubscriptionName)
+		if err != nil {
+			return fmt.Errorf("failed to init compute event subscription: %s", err.Error())
+		}
+	} else {
+		interruptionEvents, err = createSubscriptionClient(ctx, logger, m, cfg.Project, cfg.PubSub.InstanceInterruptionSubscriptionName)
+		if err != nil {
+			return fmt.Errorf("failed to init instance interruption subscription: %s", err.Error())
+		}
+
+		creationEvents, err = createSubscriptionClient(ctx, logger, m, cfg.Project, cfg.PubSub.InstanceCreationSubscriptionName)
+		if err != nil {
+			return fmt.Errorf("failed to init instance creation subscription: %s", err.Error())
+		}
+
+		// lifecycle events are only needed to track instances that are stopped rather than deleted when interrupted
+		if len(cfg.PubSub.InstanceLifecycleSubscriptionName) > 0 {
+			lifecycleEvents, err = createSubscriptionClient(ctx, logger, m, cfg.Project, cfg.PubSub.InstanceLifecycleSubscriptionName)
+			if err != nil {
+				return fmt.Errorf("failed to init instance lifecycle subscription: %s", err.Error())
+			}
+		}
+	}
+
+	projectIDs, err := resolveProjects(ctx, logger, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to determine projects to track: %s", err.Error())
+	}
+	logger.With("projects", projectIDs).Info("tracking instances in projects")
+
+	classifier, err := workloadClassifier(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure workload classification: %s", err.Error())
+	}
+
+	computeClient, err := createComputeClient(ctx, logger, projectIDs, classifier)
+	if err != nil {
+		return fmt.Errorf("failed to init compute client")
+	}
+
+	snapshotBackend, err := createSnapshotBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to init snapshots: %s", err.Error())
+	}
+
+	recorder, closeRecorder, err := createRecorder(ctx, logger, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to init kubernetes event recorder: %s", err.Error())
+	}
+	defer func() {
+		if err := closeRecorder(context.Background()); err != nil {
+			logger.With("error", err).Error("failed to record kubernetes events")
+		}
+	}()
+
+	notifier, closeNotifier, err := createNotifier(logger, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to init notifications: %s", err.Error())
+	}
+	defer func() {
+		if err := closeNotifier(context.Background()); err != nil {
+			logger.With("error", err).Error("failed to deliver notifications")
+		}
+	}()
+
+	detector, closeDetector, err := createDetector(logger, cfg, m, notifier)
+	if err != nil {
+		return fmt.Errorf("failed to init storm detection: %s", err.Error())
+	}
+	defer func() {
+		if err := closeDetector(context.Background()); err != nil {
+			logger.With("error", err).Error("failed to stop storm detection")
+		}
+	}()
+
+	store, closeStore, err := createStore(logger, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to init history: %s", err.Error())
+	}
+	defer func() {
+		if err := closeStore(context.Background()); err != nil {
+			logger.With("error", err).Error("failed to close history")
+		}
+	}()
+
+	var broadcaster stream.Broadcaster
+	if cfg.InterruptionsAPI != nil {
+		broadcaster = stream.NewBroadcaster(stream.NewBroadcasterInput{Logger: logger, BufferSize: cfg.InterruptionsAPI.StreamBufferSize})
+		mux.Handle("/api/v1/", api.NewHandler(api.NewHandlerInput{
+			Logger:            logger,
+			Store:             store,
+			Broadcaster:       broadcaster,
+			HeartbeatInterval: cfg.InterruptionsAPI.StreamHeartbeatInterval,
+		}))
+	}
+	// replicas on standby do not seed, and are ready straight away
+	readiness := seeding.NewTracker(seeding.StatusSeeding)
+	if cfg.HighAvailability != nil {
+		readiness.SetStatus(seeding.StatusStandby)
+	}
+	mux.Handle(seeding.ReadinessPath, readiness)
+	// readiness is served even if neither metrics nor the interruptions API are. Failing to serve stops the exporter, so that
+	// it shuts down as it would on SIGTERM.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", cfg.Prometheus.Port),
+		Handler: mux,
+		// streams of interruptions end as the exporter stops, rather than holding up shutting down the server
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	served := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			served <- fmt.Errorf("failed to serve on port %s: %w", cfg.Prometheus.Port, err)
+			stop()
+		}
+	}()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.With("error", err).Error("failed to shut down http server")
+		}
+	}()
+
+	e := &exporter{
+		log:                logger,
+		cfg:                cfg,
+		metrics:            m,
+		computeClient:      computeClient,
+		classifier:         classifier,
+		computeEvents:      computeEvents,
+		interruptionEvents: interruptionEvents,
+		creationEvents:     creationEvents,
+		lifecycleEvents:    lifecycleEvents,
+		snapshotBackend:    snapshotBackend,
+		recorder:           recorder,
+		notifier:           notifier,
+		detector:           detector,
+		store:              store,
+		broadcaster:        broadcaster,
+		redis:              createRedisClient(cfg),
+		readiness:          readiness,
+		interruptionStages: input.InterruptionStages,
+		creationStages:     input.CreationStages,
+	}
+	err = e.run(ctx)
+	select {
+	case serveErr := <-served:
+		return serveErr
+	default:
+		return err
+	}
+}
+
+// run consumes events until ctx is done, once elected leader if high availability is configured
+func (e *exporter) run(ctx context.Context) error {
+	if e.cfg.HighAvailability == nil {
+		return e.consume(ctx)
+	}
+	// only the leader consumes events, and a replica that loses leadership exits so that it restarts on standby
+	client, err := kube.NewClient(ctx, kube.ClusterAccess{InCluster: true})
+	if err != nil {
+		return fmt.Errorf("failed to init kubernetes client for leader election: %s", err.Error())
+	}
+	return election.Run(ctx, election.RunInput{
+		Logger:        e.log,
+		Client:        client,
+		Namespace:     e.cfg.HighAvailability.Namespace,
+		LeaseName:     e.cfg.HighAvailability.LeaseName,
+		LeaseDuration: e.cfg.HighAvailability.LeaseDuration,
+		RenewDeadline: e.cfg.HighAvailability.RenewDeadline,
+		RetryPeriod:   e.cfg.HighAvailability.RetryPeriod,
+	}, e.consume)
+}
+
+// exporter holds everything needed to consume events
+type exporter struct {
+	log           *zap.SugaredLogger
+	cfg           Config
+	metrics       metrics.Client
+	computeClient compute.Client
+	classifier    compute.WorkloadClassifier
+	// computeEvents is nil unless a single subscription carries every compute event, in which case the others are nil
+	computeEvents      events.Subscription
+	interruptionEvents events.Subscription
+	creationEvents     events.Subscription
+	// lifecycleEvents is nil unless instances stopped on termination are tracked
+	lifecycleEvents events.Subscription
+	snapshotBackend snapshot.Backend
+	recorder        kube.Recorder
+	notifier        notify.Notifier
+	detector        storm.Detector
+	store           history.Store
+	broadcaster     stream.Broadcaster
+	// redis is nil unless caches are shared in redis
+	redis     redis.UniversalClient
+	readiness *seeding.Tracker
+	// interruptionStages and creationStages are run by the pipelines in addition to the built-in stages
+	interruptionStages []pipeline.Stage[*pipeline.InterruptionEvent]
+	creationStages     []pipeline.Stage[*pipeline.CreationEvent]
+}
+
+// consume seeds the instances being tracked, then handles events until ctx is done
+func (e *exporter) consume(ctx context.Context) error {
+	logger, cfg, m := e.log, e.cfg, e.metrics
+
+	strategy, window := deduplication(cfg)
+	instanceToWorkloadMappings, creationTimes, messageCache, stoppedInstances := createCaches(logger, cfg, window, m, e.redis)
+	caches := map[string]snapshot.Cache{
+		handlers.InstanceToWorkloadMappingsCacheName: snapshot.Encoded(instanceToWorkloadMappings, handlers.WorkloadCodec),
+		handlers.InstanceCreationTimesCacheName:      snapshot.Encoded(creationTimes, handlers.TimeCodec),
+		handlers.StopOnTerminationCacheName:          snapshot.Encoded(stoppedInstances.StopOnTermination, handlers.WorkloadCodec),
+		handlers.StoppedCacheName:                    snapshot.Encoded(stoppedInstances.Stopped, handlers.TimeCodec),
+		handlers.MessageCacheName:                    snapshot.Encoded(messageCache, cache.StringCodec),
+	}
+	deduplicator, err := dedup.NewDeduplicator(dedup.NewDeduplicatorInput{Strategy: strategy, Window: window, Keys: messageCache})
+	if err != nil {
+		return fmt.Errorf("failed to configure deduplication: %w", err)
+	}
+	restored := restoreSnapshot(ctx, logger, e.snapshotBackend, caches)
+
+	// in degraded mode, or once seeding has failed with a restored snapshot to start from, seeding continues in the background
+	// while events are handled
+	retry := seedingRetryInput(logger, cfg, e.readiness)
+	degraded := cfg.Seeding != nil && cfg.Seeding.Degraded
+	if !degraded {
+		e.readiness.SetStatus(seeding.StatusSeeding)
+		err := seeding.Retry(ctx, retry, func(ctx context.Context) error {
+			return e.seed(ctx, instanceToWorkloadMappings, creationTimes, stoppedInstances, true)
+		})
+		switch {
+		case err != nil && !restored:
+			e.readiness.SetStatus(seeding.StatusFailed)
+			return fmt.Errorf("failed to seed instances to track: %w", err)
+		case err != nil:
+			logger.With("error", err).Warn("failed to seed instances to track, continuing with those in the snapshot")
+			degraded = true
+		default:
+			e.readiness.SetStatus(seeding.StatusSeeded)
+		}
+	}
+	if degraded {
+		e.readiness.SetStatus(seeding.StatusDegraded)
+		go e.seedInBackground(ctx, retry, instanceToWorkloadMappings, creationTimes, stoppedInstances, restored)
+	}
+
+	go handlers.ReportInstanceMappingSize(ctx, instanceToWorkloadMappings, m, handlers.InstanceMappingSizeInterval)
+
+	if e.snapshotBackend != nil {
+		_, closeSnapshotter := snapshot.NewSnapshotter(snapshot.NewSnapshotterInput{
+			Logger:   logger,
+			Backend:  e.snapshotBackend,
+			Caches:   caches,
+			Interval: cfg.Snapshot.Interval,
+		})
+		defer func() {
+			if err := closeSnapshotter(context.Background()); err != nil {
+				logger.With("error", err).Error("failed to save final snapshot")
+			}
+		}()
+	}
+
+	// the pool is closed before the final snapshot is saved, so that it includes every event already received
+	var pool workers.Pool
+	if cfg.WorkerPool != nil {
+		var closePool func(context.Context) error
+		pool, closePool = workers.NewPool(workers.NewPoolInput{Workers: cfg.WorkerPool.Workers, QueueSize: cfg.WorkerPool.QueueSize})
+		defer func() {
+			if err := closePool(context.Background()); err != nil {
+				logger.With("error", err).Error("failed to handle queued events")
+			}
+		}()
+	}
+
+	interruptionPipeline := handlers.NewInterruptionPipeline(handlers.NewInterruptionPipelineInput{
+		Logger:                     logger,
+		Metrics:                    m,
+		InstanceToWorkloadMappings: instanceToWorkloadMappings,
+		CreationTimes:              creationTimes,
+		Deduplicator:               deduplicator,
+		Stopped:                    stoppedInstances,
+		Recorder:                   e.recorder,
+		Notifier:                   e.notifier,
+		Detector:                   e.detector,
+		Store:                      e.store,
+		Broadcaster:                e.broadcaster,
+		Stages:                     e.interruptionStages,
+	})
+	creationPipeline := handlers.NewCreationPipeline(handlers.NewCreationPipelineInput{
+		Logger:                     logger,
+		Metrics:                    m,
+		Classifier:                 e.classifier,
+		InstanceToWorkloadMappings: instanceToWorkloadMappings,
+		CreationTimes:              creationTimes,
+		Stopped:                    stoppedInstances,
+		Store:                      e.store,
+		Stages:                     e.creationStages,
+	})
+	wg := &sync.WaitGroup{}
+	if e.computeEvents != nil {
+		computeEvents := make(chan *gcppubsub.Message, 30)
+		wg.Add(1)
+		go e.computeEvents.Receive(ctx, computeEvents)
+		router := handlers.NewComputeRouter(handlers.NewComputeRouterInput{
+			Logger:                     logger,
+			Metrics:                    m,
+			InstanceToWorkloadMappings: instanceToWorkloadMappings,
+			CreationTimes:              creationTimes,
+			Stopped:                    stoppedInstances,
+			InterruptionPipeline:       interruptionPipeline,
+			CreationPipeline:           creationPipeline,
+			Pool:                       pool,
+		})
+		go router.HandleEvents(computeEvents, wg)
+		logger.Info("handler started for compute events")
+		wg.Wait()
+		return nil
+	}
+
+	interruptions := make(chan *gcppubsub.Message, 30)
+	additions := make(chan *gcppubsub.Message, 30)
+	wg.Add(2)
+
+	go e.interruptionEvents.Receive(ctx, interruptions)
+	go e.creationEvents.Receive(ctx, additions)
+	logger.Info("listening for instance creation & interruption events")
+
+	go handlers.HandleInterruptionEvents(interruptions, interruptionPipeline, pool, m, wg)
+	go handlers.HandleCreationEvents(additions, creationPipeline, pool, m, wg)
+	logger.Info("handlers started for instance creation & interruption events")
+
+	if e.lifecycleEvents != nil {
+		lifecycle := make(chan *gcppubsub.Message, 30)
+		wg.Add(1)
+		go e.lifecycleEvents.Receive(ctx, lifecycle)
+		go handlers.HandleLifecycleEvents(lifecycle, instanceToWorkloadMappings, creationTimes, stoppedInstances, pool, m, logger, wg)
+		logger.Info("handler started for instance lifecycle events")
+	}
+	wg.Wait()
+	return nil
+}
+
+// createMetricsClient creates a client for every configured metrics backend, fanning out to them if there are several,
+// and a function flushing any metrics they have yet to push. Prometheus metrics are served from mux.
+func createMetricsClient(ctx context.Context, log *zap.SugaredLogger, cfg Config, mux *http.ServeMux) (metrics.Client, func(context.Context) error, error) {
+	var backends []metrics.Backend
+	// backends already created are shut down if a later one cannot be, so they do not keep exporting in the background
+	fail := func(err error) (metrics.Client, func(context.Context) error, error) {
+		for _, b := range backends {
+			if b.Shutdown == nil {
+				continue
+			}
+			if err := b.Shutdown(ctx); err != nil {
+				log.With("backend", b.Name, "error", err).Warn("failed to shut down metrics backend")
+			}
+		}
+		return nil, nil, err
+	}
+	if !cfg.Prometheus.Disabled {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		m := metrics.NewClient(log, registry)
+		mux.Handle(cfg.Prometheus.Path, metrics.NewHandler(registry))
+		backends = append(backends, metrics.Backend{Name: "prometheus", Client: m})
+	}
+	if cfg.OpenTelemetry != nil {
+		m, shutdown, err := metrics.NewOpenTelemetryClient(ctx, metrics.OpenTelemetryInput{
+			Logger:             log,
+			Endpoint:           cfg.OpenTelemetry.Endpoint,
+			Protocol:           cfg.OpenTelemetry.Protocol,
+			Insecure:           cfg.OpenTelemetry.Insecure,
+			Headers:            cfg.OpenTelemetry.Headers,
+			ResourceAttributes: cfg.OpenTelemetry.ResourceAttributes,
+			Temporality:        cfg.OpenTelemetry.Temporality,
+			ExportInterval:     cfg.OpenTelemetry.ExportInterval,
+			ExportTimeout:      cfg.OpenTelemetry.ExportTimeout,
+		})
+		if err != nil {
+			return fail(err)
+		}
+		backends = append(backends, metrics.Backend{Name: "opentelemetry", Client: m, Shutdown: shutdown})
+	}
+	if cfg.CloudMonitoring != nil {
+		m, shutdown, err := metrics.NewCloudMonitoringClient(ctx, metrics.CloudMonitoringInput{
+			Logger:           log,
+			ClusterLocations: cfg.CloudMonitoring.ClusterLocations,
+			WriteInterval:    cfg.CloudMonitoring.WriteInterval,
+			IdleTimeout:      cfg.CloudMonitoring.IdleTimeout,
+		})
+		if err != nil {
+			return fail(err)
+		}
+		backends = append(backends, metrics.Backend{Name: "cloud_monitoring", Client: m, Shutdown: shutdown})
+	}
+	if cfg.StatsD != nil {
+		m, shutdown, err := metrics.NewStatsDClient(metrics.StatsDInput{
+			Logger:        log,
+			Address:       cfg.StatsD.Address,
+			Prefix:        cfg.StatsD.Prefix,
+			Flavor:        cfg.StatsD.Flavor,
+			FlushInterval: cfg.StatsD.FlushInterval,
+		})
+		if err != nil {
+			return fail(err)
+		}
+		backends = append(backends, metrics.Backend{Name: "statsd", Client: m, Shutdown: shutdown})
+	}
+
+	if len(backends) == 1 {
+		// a backend only recording the counters is still fanned out to, so that the other metrics are discarded
+		b := backends[0]
+		if c, ok := b.Client.(metrics.Client); ok {
+			if b.Shutdown == nil {
+				b.Shutdown = func(context.Context) error { return nil }
+			}
+			return c, b.Shutdown, nil
+		}
+	}
+	return metrics.NewFanOutClient(metrics.FanOutInput{
+		Logger:   log,
+		Backends: backends,
+	})
+}
+
+// createRecorder returns nil unless kubernetes events are configured, and a function recording queued events
+func createRecorder(ctx context.Context, log *zap.SugaredLogger, cfg Config) (kube.Recorder, func(context.Context) error, error) {
+	if cfg.KubernetesEvents == nil {
+		return nil, func(context.Context) error { return nil }, nil
+	}
+	clusters := make(map[string]kube.ClusterAccess, len(cfg.KubernetesEvents.Clusters))
+	for name, c := range cfg.KubernetesEvents.Clusters {
+		clusters[name] = kube.ClusterAccess{
+			Kubeconfig:               c.Kubeconfig,
+			Context:                  c.Context,
+			Endpoint:                 c.Endpoint,
+			CertificateAuthorityData: c.CertificateAuthorityData,
+			InCluster:                c.InCluster,
+		}
+	}
+	return kube.NewRecorder(ctx, kube.NewRecorderInput{
+		Logger:   log,
+		Clusters: clusters,
+	})
+}
+
+// createNotifier returns a nil notifier unless notifications are configured, and a function delivering pending notifications
+func createNotifier(log *zap.SugaredLogger, cfg Config) (notify.Notifier, func(context.Context) error, error) {
+	if cfg.Notifications == nil {
+		return nil, func(context.Context) error { return nil }, nil
+	}
+	sinks := make(map[string]notify.Sink, len(cfg.Notifications.Sinks))
+	for name, c := range cfg.Notifications.Sinks {
+		retry := notify.RetryPolicy{MaxAttempts: c.MaxAttempts, Backoff: c.Backoff}
+		switch c.Type {
+		case "webhook":
+			sinks[name] = notify.NewWebhookSink(notify.WebhookInput{URL: c.URL, Secret: c.Secret, Headers: c.Headers, Retry: retry})
+		case "slack":
+			sinks[name] = notify.NewSlackSink(notify.SlackInput{WebhookURL: c.URL, Retry: retry})
+		case "pagerduty":
+			sinks[name] = notify.NewPagerDutySink(notify.PagerDutyInput{RoutingKey: c.RoutingKey, Severity: c.Severity, URL: c.URL, Retry: retry})
+		default:
+			return nil, nil, fmt.Errorf("sink %s has unknown type %q, expected webhook, slack or pagerduty", name, c.Type)
+		}
+	}
+	routes := make([]notify.Route, 0, len(cfg.Notifications.Routes))
+	for _, r := range cfg.Notifications.Routes {
+		routes = append(routes, notify.Route{
+			Match: notify.Match{
+				Clusters:        r.Clusters,
+				Zones:           r.Zones,
+				NodePools:       r.NodePools,
+				MachineFamilies: r.MachineFamilies,
+				Causes:          r.Causes,
+			},
+			Sinks:             r.Sinks,
+			AggregationWindow: r.AggregationWindow,
+			Storms:            r.Storms,
+		})
+	}
+	return notify.NewNotifier(notify.NewNotifierInput{
+		Logger: log,
+		Sinks:  sinks,
+		Routes: routes,
+	})
+}
+
+// createDetector returns a nil detector unless storm detection is configured, and a function stopping it
+func createDetector(log *zap.SugaredLogger, cfg Config, m metrics.Client, notifier notify.Notifier) (storm.Detector, func(context.Context) error, error) {
+	if cfg.StormDetection == nil {
+		return nil, func(context.Context) error { return nil }, nil
+	}
+	thresholds := make([]storm.Threshold, 0, len(cfg.StormDetection.Thresholds))
+	for _, t := range cfg.StormDetection.Thresholds {
+		thresholds = append(thresholds, storm.Threshold{
+			Scope:       notify.StormScope(t.Scope),
+			Preemptions: t.Preemptions,
+			Window:      t.Window,
+		})
+	}
+	return storm.NewDetector(storm.NewDetectorInput{
+		Logger:             log,
+		Thresholds:         thresholds,
+		Metrics:            m,
+		Notifier:           notifier,
+		EvaluationInterval: cfg.StormDetection.EvaluationInterval,
+	})
+}
+
+// createStore returns a store persisted to a file if history is configured, one in memory if only the interruptions API is,
+// and nil otherwise, along with a function closing it
+func createStore(log *zap.SugaredLogger, cfg Config) (history.Store, func(context.Context) error, error) {
+	switch {
+	case cfg.History != nil:
+		return history.NewBoltStore(history.NewBoltStoreInput{
+			Logger:             log,
+			Path:               cfg.History.Path,
+			Retention:          cfg.History.Retention,
+			CompactionInterval: cfg.History.CompactionInterval,
+		})
+	case cfg.InterruptionsAPI != nil:
+		return history.NewRingBuffer(cfg.InterruptionsAPI.HistorySize), func(context.Context) error { return nil }, nil
+	}
+	return nil, func(context.Context) error { return nil }, nil
+}
+
+// createRedisClient returns nil unless redis is configured
+func createRedisClient(cfg Config) redis.UniversalClient {
+	if cfg.Redis == nil {
+		return nil
+	}
+	opts := &redis.Options{
+		Addr:     cfg.Redis.Address,
+		Username: cfg.Redis.Username,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	}
+	if cfg.Redis.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return redis.NewClient(opts)
+}
+
+// createCaches returns the mapping of instances to workloads, the times they were created at, the cache of the keys of handled
+// interruption events, which are kept for dedupWindow, and the stopped instances, shared in client if it is not nil and
+// otherwise kept in memory
+func createCaches(log *zap.SugaredLogger, cfg Config, dedupWindow time.Duration, m metrics.Client, client redis.UniversalClient) (cache.Cache[string, compute.Workload], cache.Cache[string, time.Time], cache.Cache[string, string], *handlers.StoppedInstances) {
+	if client == nil {
+		input := handlers.CacheInput{Metrics: m}
+		return handlers.NewInstanceToWorkloadMappings(nil, input), handlers.NewInstanceCreationTimes(input), handlers.NewMessageCache(input, dedupWindow), handlers.NewStoppedInstances(nil, input)
+	}
+	prefix := cfg.Redis.KeyPrefix
+	if len(prefix) == 0 {
+		prefix = "spot-interruption-exporter:"
+	}
+	stopped := &handlers.StoppedInstances{
+		StopOnTermination: newRedisCache(log, client, prefix, handlers.StopOnTerminationCacheName, cache.NoExpiration, handlers.WorkloadCodec),
+		Stopped:           newRedisCache(log, client, prefix, handlers.StoppedCacheName, cache.NoExpiration, handlers.TimeCodec),
+	}
+	mappings := newRedisCache(log, client, prefix, handlers.InstanceToWorkloadMappingsCacheName, cache.NoExpiration, handlers.WorkloadCodec)
+	creationTimes := newRedisCache(log, client, prefix, handlers.InstanceCreationTimesCacheName, cache.NoExpiration, handlers.TimeCodec)
+	return mappings, creationTimes, newRedisCache(log, client, prefix, handlers.MessageCacheName, dedupWindow, cache.StringCodec), stopped
+}
+
+// newRedisCache returns the cache named name shared in client, whose keys are prefixed with prefix and the name
+func newRedisCache[V any](log *zap.SugaredLogger, client redis.UniversalClient, prefix, name string, ttl time.Duration, codec cache.Codec[V]) cache.Cache[string, V] {
+	return cache.NewRedisCache(cache.NewRedisCacheInput[V]{Logger: log, Client: client, Codec: codec, Prefix: prefix + name + ":", TTL: ttl})
+}
+
+// createSnapshotBackend returns nil unless snapshots are configured
+func createSnapshotBackend(ctx context.Context, cfg Config) (snapshot.Backend, error) {
+	switch {
+	case cfg.Snapshot == nil:
+		return nil, nil
+	case len(cfg.Snapshot.Path) > 0:
+		return snapshot.NewFileBackend(cfg.Snapshot.Path), nil
+	case cfg.Snapshot.ConfigMap != nil:
+		client, err := kube.NewClient(ctx, kube.ClusterAccess{InCluster: true})
+		if err != nil {
+			return nil, err
+		}
+		return snapshot.NewConfigMapBackend(snapshot.NewConfigMapBackendInput{
+			Client:    client,
+			Namespace: cfg.Snapshot.ConfigMap.Namespace,
+			Name:      cfg.Snapshot.ConfigMap.Name,
+		}), nil
+	}
+	return nil, fmt.Errorf("one of path or config_map must be set")
+}
+
+// restoreSnapshot restores caches from the snapshot saved to backend, if there is one, returning whether it was restored
+func restoreSnapshot(ctx context.Context, log *zap.SugaredLogger, backend snapshot.Backend, caches map[string]snapshot.Cache) bool {
+	if backend == nil {
+		return false
+	}
+	s, err := snapshot.Restore(ctx, backend, caches)
+	switch {
+	case errors.Is(err, snapshot.ErrNotFound):
+		log.Info("no snapshot to restore")
+		return false
+	case err != nil && s.Taken.IsZero():
+		log.With("error", err).Warn("failed to restore snapshot")
+		return false
+	case err != nil:
+		log.With("error", err).Warn("skipped items of snapshot that could not be decoded")
+	}
+	log.With("taken", s.Taken, "instances", len(s.Caches[handlers.InstanceToWorkloadMappingsCacheName])).Info("restored snapshot")
+	return true
+}
+
+// seed lists the instances to track from the compute API. If reconcile is true, tracked instances that were not listed, e.g.
+// those restored from a snapshot, were deleted since and expire after the snapshot's grace period. Otherwise listed instances
+// are merged into those tracked, as none tracked can be stale when no snapshot was restored. Either way, instances that events
+// handled while listing inserted, removed or set to expire are left as those events left them.
+func (e *exporter) seed(ctx context.Context, instanceToWorkloadMappings cache.Cache[string, compute.Workload], creationTimes cache.Cache[string, time.Time], stopped *handlers.StoppedInstances, reconcile bool) error {
+	listingStarted := time.Now()
+	tracked, trackedStopOnTermination := instanceToWorkloadMappings.Items(), stopped.StopOnTermination.Items()
+	initialInstances, err := e.computeClient.ListWorkloadInstances(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to determine initial instances belonging to workloads: %w", err)
+	}
+	stopOnTermination, err := e.computeClient.ListInstancesStoppedOnTermination(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to determine initial instances stopped on termination: %w", err)
+	}
+	if reconcile {
+		stale := handlers.ReconcileInstanceToWorkloadMappings(instanceToWorkloadMappings, handlers.ReconcileInput{
+			Listed:         initialInstances,
+			Tracked:        tracked,
+			ListingStarted: listingStarted,
+			CreationTimes:  creationTimes,
+			Grace:          snapshotGracePeriod(e.cfg),
+		})
+		handlers.ReconcileInstanceToWorkloadMappings(stopped.StopOnTermination, handlers.ReconcileInput{
+			Listed:         stopOnTermination,
+			Tracked:        trackedStopOnTermination,
+			ListingStarted: listingStarted,
+			CreationTimes:  creationTimes,
+			Grace:          snapshotGracePeriod(e.cfg),
+		})
+		handlers.ReconcileInstanceCreationTimes(creationTimes, instanceToWorkloadMappings)
+		e.log.With("instances", len(initialInstances), "deleted_since_snapshot", stale).Info("determined initial instances belonging to workloads")
+		return nil
+	}
+	added, err := handlers.MergeInstanceToWorkloadMappings(instanceToWorkloadMappings, initialInstances, tracked)
+	if err != nil {
+		return fmt.Errorf("failed to track initial instances belonging to workloads: %w", err)
+	}
+	if _, err := handlers.MergeInstanceToWorkloadMappings(stopped.StopOnTermination, stopOnTermination, trackedStopOnTermination); err != nil {
+		return fmt.Errorf("failed to track initial instances stopped on termination: %w", err)
+	}
+	e.log.With("instances", len(initialInstances), "added", added).Info("determined initial instances belonging to workloads")
+	return nil
+}
+
+// seedInBackground retries seeding until it succeeds, fails with an error that retrying cannot fix, or ctx is done. Instances
+// restored from a snapshot, if restored is true, are reconciled with those listed, so that those deleted since expire.
+func (e *exporter) seedInBackground(ctx context.Context, retry seeding.RetryInput, instanceToWorkloadMappings cache.Cache[string, compute.Workload], creationTimes cache.Cache[string, time.Time], stopped *handlers.StoppedInstances, restored bool) {
+	retry.Attempts = -1
+	err := seeding.Retry(ctx, retry, func(ctx context.Context) error {
+		return e.seed(ctx, instanceToWorkloadMappings, creationTimes, stopped, restored)
+	})
+	switch {
+	case ctx.Err() != nil:
+		// the exporter is shutting down
+	case err != nil:
+		e.readiness.SetStatus(seeding.StatusFailed)
+		e.log.With("error", err).Error("failed to seed instances to track, only instances created since starting are tracked")
+	default:
+		e.readiness.SetStatus(seeding.StatusSeeded)
+	}
+}
+
+// seedingRetryInput returns how seeding the instances to track is retried, recording attempts in readiness
+func seedingRetryInput(log *zap.SugaredLogger, cfg Config, readiness *seeding.Tracker) seeding.RetryInput {
+	input := seeding.RetryInput{Logger: log.With("operation", "seeding"), Tracker: readiness}
+	if cfg.Seeding != nil {
+		input.Attempts = cfg.Seeding.Attempts
+		input.InitialBackoff = cfg.Seeding.InitialBackoff
+		input.MaxBackoff = cfg.Seeding.MaxBackoff
+		input.QuotaBackoff = cfg.Seeding.QuotaBackoff
+	}
+	return input
+}
+
+// deduplication returns the strategy interruption events are deduplicated with, and the window they are remembered for
+func deduplication(cfg Config) (dedup.Strategy, time.Duration) {
+	if cfg.Deduplication == nil {
+		return dedup.StrategyMessageID, dedup.DefaultWindow
+	}
+	return dedup.Strategy(cfg.Deduplication.Strategy), dedup.WindowOrDefault(cfg.Deduplication.Window)
+}
+
+// snapshotGracePeriod returns how long instances restored from a snapshot that no longer exist remain tracked
+func snapshotGracePeriod(cfg Config) time.Duration {
+	if cfg.Snapshot == nil || cfg.Snapshot.ReconcileGracePeriod <= 0 {
+		return time.Hour
+	}
+	return cfg.Snapshot.ReconcileGracePeriod
+}
+
+func createComputeClient(ctx context.Context, log *zap.SugaredLogger, projectIDs []string, classifier compute.WorkloadClassifier) (compute.Client, error) {
+	return compute.NewClient(ctx, compute.NewClientInput{
+		Logger:             log,
+		ProjectIDs:         projectIDs,
+		WorkloadClassifier: &classifier,
+	})
+}
+
+func workloadClassifier(cfg Config) (compute.WorkloadClassifier, error) {
+	identity := compute.DefaultClusterIdentity()
+	if cfg.ClusterIdentity != nil {
+		var err error
+		identity, err = compute.NewClusterIdentity(cfg.ClusterIdentity.LabelKeys, cfg.ClusterIdentity.MetadataKey, cfg.ClusterIdentity.InstanceNamePattern)
+		if err != nil {
+			return compute.WorkloadClassifier{}, err
+		}
+	}
+	types := []compute.WorkloadType{compute.WorkloadTypeKubernetes}
+	if len(cfg.WorkloadTypes) > 0 {
+		types = make([]compute.WorkloadType, 0, len(cfg.WorkloadTypes))
+		for _, t := range cfg.WorkloadTypes {
+			types = append(types, compute.WorkloadType(t))
+		}
+	}
+	return compute.NewWorkloadClassifier(identity, types...)
+}
+
+// resolveProjects returns the deduplicated IDs of the configured project, any additional projects, and all projects discovered under the configured parents
+func resolveProjects(ctx context.Context, log *zap.SugaredLogger, cfg Config) ([]string, error) {
+	projectIDs := append([]string{cfg.Project}, cfg.Projects...)
+	if len(cfg.ProjectParents) > 0 {
+		projectsClient, err := projects.NewClient(ctx, projects.NewClientInput{
+			Logger: log,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range cfg.ProjectParents {
+			discovered, err := projectsClient.ListProjectsUnder(ctx, parent)
+			if err != nil {
+				return nil, err
+			}
+			projectIDs = append(projectIDs, discovered...)
+		}
+	}
+
+	seen := make(map[string]bool, len(projectIDs))
+	deduplicated := make([]string, 0, len(projectIDs))
+	for _, projectID := range projectIDs {
+		if len(projectID) == 0 || seen[projectID] {
+			continue
+		}
+		seen[projectID] = true
+		deduplicated = append(deduplicated, projectID)
+	}
+	return deduplicated, nil
+}
+
+func createSubscriptionClient(ctx context.Context, log *zap.SugaredLogger, m metrics.Client, projectID, subscriptionName string) (events.Subscription, error) {
+	return events.NewPubSubNotifier(ctx, &events.PubSubNotifierInput{
+		Logger:           log,
+		Metrics:          m,
+		ProjectID:        projectID,
+		SubscriptionName: subscriptionName,
+	})
+}
+
+func configureLogger(cfg Config) *zap.SugaredLogger {
+	loggerConfig := zap.NewProductionConfig()
+	if err := configureLogLevel(&loggerConfig, cfg.LogLevel); err != nil {
+		log.Fatalf("failed to parse log level: %s", err.Error())
+	}
+	loggerConfig.EncoderConfig.TimeKey = "time"
+	logger, err := loggerConfig.Build()
+	if err != nil {
+		log.Fatalf("failed to initialize zap logger: %v", err)
+	}
+	return logger.Sugar()
+}
+
+func configureLogLevel(lCfg *zap.Config, logLevel string) error {
+	if len(logLevel) == 0 {
+		return nil
+	}
+
+	l, err := zap.ParseAtomicLevel(logLevel)
+	if err != nil {
+		return err
+	}
+	lCfg.Level = l
+	return nil
+}
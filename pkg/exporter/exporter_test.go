@@ -0,0 +1,61 @@
+package exporter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type MainTestSuite struct {
+	suite.Suite
+	l *zap.SugaredLogger
+}
+
+func TestMainTestSuite(t *testing.T) {
+	suite.Run(t, new(MainTestSuite))
+}
+
+func (suite *MainTestSuite) SetupSuite() {
+	l, err := zap.NewDevelopment()
+	suite.NoError(err)
+	suite.l = l.Sugar()
+}
+
+func (suite *MainTestSuite) TestResolveProjects() {
+	tests := map[string]struct {
+		cfg      Config
+		projects []string
+	}{
+		"project": {
+			cfg:      Config{Project: "mock-project"},
+			projects: []string{"mock-project"},
+		},
+		"additional projects": {
+			cfg:      Config{Project: "mock-project", Projects: []string{"project-a", "project-b"}},
+			projects: []string{"mock-project", "project-a", "project-b"},
+		},
+		"only additional projects": {
+			cfg:      Config{Projects: []string{"project-a"}},
+			projects: []string{"project-a"},
+		},
+		"duplicates keep their first position": {
+			cfg:      Config{Project: "mock-project", Projects: []string{"project-a", "mock-project", "project-a"}},
+			projects: []string{"mock-project", "project-a"},
+		},
+		"empty project ids": {
+			cfg:      Config{Projects: []string{"", "project-a", ""}},
+			projects: []string{"project-a"},
+		},
+		"none": {
+			cfg:      Config{},
+			projects: []string{},
+		},
+	}
+	for name, tc := range tests {
+		projects, err := resolveProjects(context.Background(), suite.l, tc.cfg)
+		suite.NoError(err, name)
+		suite.Equal(tc.projects, projects, name)
+	}
+}
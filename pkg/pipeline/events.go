@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"time"
+
+	gcppubsub "cloud.google.com/go/pubsub"
+	"github.com/googleapis/google-cloudevents-go/cloud/auditdata"
+	"go.uber.org/zap"
+)
+
+// TerminationCause describes why an instance was involuntarily terminated
+type TerminationCause string
+
+const (
+	// TerminationCausePreempted is a spot or preemptible instance being reclaimed by GCP
+	TerminationCausePreempted TerminationCause = "preempted"
+	// TerminationCauseHostError is an instance being restarted due to a hardware or software failure on its host
+	TerminationCauseHostError TerminationCause = "host_error"
+	// TerminationCauseAutomaticRestart is an instance being restarted after a host maintenance event
+	TerminationCauseAutomaticRestart TerminationCause = "automatic_restart"
+	// TerminationCauseHostMaintenance is an instance being stopped because its host maintenance policy is TERMINATE
+	TerminationCauseHostMaintenance TerminationCause = "host_maintenance"
+	// TerminationCauseMaxRunDuration is an instance being terminated after reaching its configured maxRunDuration
+	TerminationCauseMaxRunDuration TerminationCause = "max_run_duration"
+)
+
+// Workload identifies what an instance was running
+type Workload struct {
+	// Type is one of kubernetes, dataproc, batch or mig
+	Type string
+	Name string
+	// NodePool is the node pool of a Kubernetes node, if known
+	NodePool string
+	// MachineType is the machine type of the instance, e.g. e2-standard-4, if known
+	MachineType string
+}
+
+// Labels are the labels the metrics of an instance are published with
+type Labels struct {
+	// KubernetesCluster is empty if the instance was not a Kubernetes node
+	KubernetesCluster string
+	Project           string
+	WorkloadType      string
+	WorkloadName      string
+	// Zone and Instance identify the instance itself. They are too high cardinality to be labels,
+	// so are only used by backends attaching metrics to monitored resources
+	Zone     string
+	Instance string
+	// NodePool is the node pool of a Kubernetes node, and MachineType that of the instance, if known.
+	// They are not labels, as they are not known for every instance
+	NodePool    string
+	MachineType string
+}
+
+// Interruption is the termination of an instance as it is sent to notification sinks, history and streams
+type Interruption struct {
+	ResourceID    string    `json:"resource_id"`
+	Project       string    `json:"project"`
+	Zone          string    `json:"zone"`
+	Instance      string    `json:"instance"`
+	Cluster       string    `json:"kubernetes_cluster,omitempty"`
+	NodePool      string    `json:"node_pool,omitempty"`
+	MachineType   string    `json:"machine_type,omitempty"`
+	MachineFamily string    `json:"machine_family,omitempty"`
+	WorkloadType  string    `json:"workload_type"`
+	WorkloadName  string    `json:"workload_name"`
+	Cause         string    `json:"cause"`
+	Timestamp     time.Time `json:"timestamp"`
+	// MessageID is the ID of the pubsub message the interruption was read from
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// InterruptionEvent is an interruption as it passes through the stages of the interruption pipeline, each of which fills in more of it
+type InterruptionEvent struct {
+	// Message is the pubsub message the event was received in, and Entry the audit log entry it carries, which is decoded
+	// once when the message is received
+	Message   *gcppubsub.Message
+	Entry     *auditdata.LogEntryData
+	MessageID string
+
+	// InsertID, OperationID, ResourceID, Cause, RemovesInstance and Timestamp are set from Entry by the decode stage
+	InsertID    string
+	OperationID string
+	ResourceID  string
+	Cause       TerminationCause
+	// RemovesInstance is false for instances that survive the termination, e.g. after a host error
+	RemovesInstance bool
+	Timestamp       time.Time
+
+	// Workload is set by the resolve stage
+	Workload Workload
+
+	// Labels and Interruption are set by the enrich stage, and later stages may change them, e.g. to relabel the event
+	Labels       Labels
+	Interruption Interruption
+
+	// Log is annotated with what is known of the event by each stage
+	Log *zap.SugaredLogger
+}
+
+func (e *InterruptionEvent) Logger() *zap.SugaredLogger {
+	return e.Log
+}
+
+// CreationEvent is the creation of an instance as it passes through the stages of the creation pipeline
+type CreationEvent struct {
+	// Message is the pubsub message the event was received in, and Entry the audit log entry it carries, which is decoded
+	// once when the message is received
+	Message   *gcppubsub.Message
+	Entry     *auditdata.LogEntryData
+	MessageID string
+
+	// ResourceID, Workload, TerminationAction and Timestamp are set from Entry by the decode stage. Later stages may change the
+	// workload, e.g. to relabel the instance before it is tracked.
+	ResourceID        string
+	Workload          Workload
+	TerminationAction string
+	Timestamp         time.Time
+
+	// Log is annotated with what is known of the event by each stage
+	Log *zap.SugaredLogger
+}
+
+func (e *CreationEvent) Logger() *zap.SugaredLogger {
+	return e.Log
+}
@@ -0,0 +1,113 @@
+// Package pipeline passes events through stages in order, so that handling them can be extended with further stages, e.g. to
+// relabel events or notify other systems, without changing the stages the exporter is built with
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// ErrSkip is returned by stages to stop handling an event that needs no further handling, e.g. a duplicate, without failing
+var ErrSkip = errors.New("event skipped")
+
+const (
+	stageResultPassed  = "passed"
+	stageResultSkipped = "skipped"
+	stageResultFailed  = "failed"
+)
+
+// Event is anything passed through a Pipeline
+type Event interface {
+	// Logger returns the logger annotated with what is known of the event
+	Logger() *zap.SugaredLogger
+}
+
+// Stage is a single step of handling an event
+type Stage[E Event] interface {
+	// Name identifies the stage in metrics and logs
+	Name() string
+	// Process handles e, returning ErrSkip to stop handling it without failing, or an error to stop handling it as failed
+	Process(ctx context.Context, e E) error
+}
+
+// StageFunc adapts a function to a Stage named StageName
+type StageFunc[E Event] struct {
+	StageName string
+	Func      func(ctx context.Context, e E) error
+}
+
+func (s StageFunc[E]) Name() string {
+	return s.StageName
+}
+
+func (s StageFunc[E]) Process(ctx context.Context, e E) error {
+	return s.Func(ctx, e)
+}
+
+// StageError is returned by Pipeline.Process when a stage fails to handle an event
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("stage %s failed: %s", e.Stage, e.Err.Error())
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Recorder records the result of each stage of a pipeline
+type Recorder interface {
+	// IncreaseStageEventCounter increases the number of events stage of pipeline handled with result, e.g. passed, skipped or failed, by one
+	IncreaseStageEventCounter(pipeline, stage, result string)
+}
+
+// NewInput defines all required fields to create a Pipeline
+type NewInput[E Event] struct {
+	// Name identifies the pipeline in metrics and logs
+	Name    string
+	Stages  []Stage[E]
+	Metrics Recorder
+}
+
+// Pipeline passes events through stages in order, until one skips or fails the event
+type Pipeline[E Event] struct {
+	name    string
+	stages  []Stage[E]
+	metrics Recorder
+}
+
+// New creates a Pipeline
+func New[E Event](input NewInput[E]) *Pipeline[E] {
+	return &Pipeline[E]{
+		name:    input.Name,
+		stages:  input.Stages,
+		metrics: input.Metrics,
+	}
+}
+
+// Process passes e through every stage, returning a *StageError if one fails. Failures are logged, and the result of each
+// stage is recorded in metrics.
+func (p *Pipeline[E]) Process(ctx context.Context, e E) error {
+	for _, stage := range p.stages {
+		err := stage.Process(ctx, e)
+		switch {
+		case err == nil:
+			p.metrics.IncreaseStageEventCounter(p.name, stage.Name(), stageResultPassed)
+		case errors.Is(err, ErrSkip):
+			p.metrics.IncreaseStageEventCounter(p.name, stage.Name(), stageResultSkipped)
+			return nil
+		default:
+			p.metrics.IncreaseStageEventCounter(p.name, stage.Name(), stageResultFailed)
+			err = &StageError{Stage: stage.Name(), Err: err}
+			e.Logger().Warn(err.Error())
+			return err
+		}
+	}
+	return nil
+}
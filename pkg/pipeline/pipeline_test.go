@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+type PipelineTestSuite struct {
+	suite.Suite
+}
+
+func TestPipelineTestSuite(t *testing.T) {
+	suite.Run(t, new(PipelineTestSuite))
+}
+
+// recorder records the results of each stage as "pipeline:stage:result"
+type recorder struct {
+	results []string
+}
+
+func (r *recorder) IncreaseStageEventCounter(pipeline, stage, result string) {
+	r.results = append(r.results, pipeline+":"+stage+":"+result)
+}
+
+// stage returns a stage named name that appends its name to e and returns err
+func stage(name string, err error) Stage[*CreationEvent] {
+	return StageFunc[*CreationEvent]{StageName: name, Func: func(_ context.Context, e *CreationEvent) error {
+		e.MessageID += name
+		return err
+	}}
+}
+
+func (suite *PipelineTestSuite) process(stages ...Stage[*CreationEvent]) (*CreationEvent, []string, error) {
+	r := &recorder{}
+	p := New(NewInput[*CreationEvent]{Name: "test", Stages: stages, Metrics: r})
+	e := &CreationEvent{Log: zap.NewNop().Sugar()}
+	err := p.Process(context.Background(), e)
+	return e, r.results, err
+}
+
+func (suite *PipelineTestSuite) TestProcess() {
+	e, results, err := suite.process(stage("a", nil), stage("b", nil))
+	suite.NoError(err)
+	suite.Equal("ab", e.MessageID)
+	suite.Equal([]string{"test:a:passed", "test:b:passed"}, results)
+}
+
+func (suite *PipelineTestSuite) TestProcessSkip() {
+	e, results, err := suite.process(stage("a", ErrSkip), stage("b", nil))
+	suite.NoError(err)
+	suite.Equal("a", e.MessageID)
+	suite.Equal([]string{"test:a:skipped"}, results)
+}
+
+func (suite *PipelineTestSuite) TestProcessFailure() {
+	failure := errors.New("failure")
+	e, results, err := suite.process(stage("a", nil), stage("b", failure), stage("c", nil))
+	var stageErr *StageError
+	suite.Require().True(errors.As(err, &stageErr))
+	suite.Equal("b", stageErr.Stage)
+	suite.ErrorIs(err, failure)
+	suite.Equal("ab", e.MessageID)
+	suite.Equal([]string{"test:a:passed", "test:b:failed"}, results)
+}